@@ -2,15 +2,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"cryptocypher/pkg/api"
 	"cryptocypher/pkg/blockchain"
 	"cryptocypher/pkg/contract"
 	"cryptocypher/pkg/p2p"
+	"cryptocypher/pkg/wallet"
 )
 
 func init() {
@@ -25,41 +36,130 @@ func main() {
 	// Command-line flags for P2P configuration.
 	listenAddr := flag.String("listenAddress", "localhost:8000", "Address to listen on")
 	peerAddrs := flag.String("peerAddresses", "localhost:8001", "Comma-separated list of peer addresses")
+	dataDir := flag.String("datadir", ".", "Directory holding the node's database and the keys of the demo accounts")
+	walletPassphrase := flag.String("walletPassphrase", "", "Passphrase the demo account keys in the data directory are encrypted with")
+	peerFile := flag.String("peerFile", "peers.json", "File the known peer list is kept in across restarts (empty to disable)")
+	maxInbound := flag.Int("maxInbound", p2p.DefaultMaxInboundConns, "Maximum number of concurrent inbound P2P connections (0 for unlimited)")
+	maxMessageSize := flag.Int("maxMessageSize", p2p.DefaultMaxMessageSize, "Maximum size in bytes of a P2P message; larger ones drop the connection")
+	maxChainBlocks := flag.Int("maxChainBlocks", p2p.DefaultMaxChainBlocks, "Maximum number of blocks accepted in a chain from a peer")
+	p2pPlaintext := flag.Bool("p2pPlaintext", false, "Disable Noise encryption of P2P connections (all peers must agree)")
+	apiHost := flag.String("apiHost", "", "Interface the API server binds to (empty for all)")
+	corsOrigins := flag.String("corsOrigins", "", "Comma-separated browser origins allowed to call the API, or * for any")
+	apiToken := flag.String("apiToken", "", "Bearer token for the API endpoints that change the node, such as /prune and /deployContract (empty disables them)")
+	faucet := flag.Bool("faucet", false, "Serve POST /faucet handing out test funds (test networks only, never in production)")
+	faucetAmount := flag.Float64("faucetAmount", 10, "Amount paid per faucet request")
+	faucetCooldown := flag.Duration("faucetCooldown", time.Hour, "Minimum delay between faucet payouts to one address or client IP")
 	lightClient := flag.Bool("light", false, "Run in light client mode")
+	discoveryInterval := flag.Duration("discoveryInterval", p2p.DefaultDiscoveryInterval, "Mean delay between peer discovery rounds")
+	discoveryJitter := flag.Duration("discoveryJitter", p2p.DefaultDiscoveryJitter, "Maximum random deviation from the discovery interval")
+	minPeers := flag.Int("minPeers", 0, "Connected peers required before auto-mining, unless the chain was synced from a peer (0 to mine alone)")
+	mineInterval := flag.Duration("mineInterval", 10*time.Second, "Mean delay between auto-mining checks")
+	mempoolSize := flag.Int("mempoolSize", 5000, "Maximum number of pending transactions (0 for unlimited)")
+	mempoolBytes := flag.Int("mempoolBytes", 0, "Maximum combined size in bytes of pending transactions (0 for unlimited)")
+	maxBlockTxs := flag.Int("maxBlockTxs", 1000, "Maximum number of transactions a block may carry, besides the coinbase (0 for unlimited)")
+	blockReward := flag.Float64("blockReward", blockchain.DefaultBlockReward, "Block reward before the first halving (all nodes must agree)")
+	halvingInterval := flag.Int("halvingInterval", blockchain.DefaultHalvingInterval, "Blocks between block reward halvings, 0 for a constant reward (all nodes must agree)")
+	genesisHash := flag.String("genesisHash", "", "Hash the genesis block must have; chains and headers from peers are checked against it")
+	checkpoints := flag.String("checkpoints", "", "Comma-separated height:hash pairs that blocks at those heights must match")
+	hashAlgorithm := flag.String("hash", "sha256", "Hash algorithm for blocks and transactions (sha256, sha3-256, blake2b-256)")
+	archiveMaxFiles := flag.Int("archiveMaxFiles", 0, "Maximum number of pruning archives to keep (0 for unlimited)")
+	archiveMaxBytes := flag.Int64("archiveMaxBytes", 0, "Maximum combined size in bytes of pruning archives (0 for unlimited)")
+	archiveGzip := flag.Bool("archiveGzip", false, "Gzip-compress the archives written by pruning")
+	cacheWindow := flag.Int("cacheWindow", 0, "Number of recent blocks kept in memory; older ones are read from the database (0 keeps them all)")
+	timerJitter := flag.Duration("timerJitter", 2*time.Second, "Maximum random deviation applied to the mining and pruning timers")
 	flag.Parse()
 	peers := strings.Split(*peerAddrs, ",")
 
+	// Cancelled on SIGINT or SIGTERM so the node can stop cleanly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// workers tracks the background loops that must finish before the DB closes.
+	var workers sync.WaitGroup
+
 	// Test Smart Contract Execution.
-	result, err := contract.ExecuteContract("AdditionContract", "add", map[string]interface{}{"a": 10.0, "b": 15.5})
+	result, err := contract.ExecuteContract("AdditionContract", "add", map[string]interface{}{"a": 10.0, "b": 15.5}, contract.Gas{})
 	if err != nil {
 		fmt.Println("Contract execution error:", err)
 	} else {
 		fmt.Println("Contract execution result:", result)
 	}
 
-	// Initialize blockchain.
-	var bc *blockchain.Blockchain
+	// Select the chain's hash algorithm before any block is created or loaded.
+	hasher, err := blockchain.HasherByName(*hashAlgorithm)
+	if err != nil {
+		fmt.Println("Configuration error:", err)
+		return
+	}
+	blockchain.DefaultHasher = hasher
+	cfg := blockchain.DefaultChainConfig()
+	cfg.HashAlgorithm = hasher.Name()
+	cfg.Reward = blockchain.RewardSchedule{Initial: *blockReward, HalvingInterval: *halvingInterval}
+	cfg.MaxBlockTransactions = *maxBlockTxs
+	cfg.GenesisHash = *genesisHash
+	if cfg.Checkpoints, err = parseCheckpoints(*checkpoints); err != nil {
+		fmt.Println("Configuration error:", err)
+		return
+	}
+
+	if err := os.MkdirAll(*dataDir, 0700); err != nil {
+		fmt.Println("Error creating data directory:", err)
+		return
+	}
+	// Demo wallets; blocks only accept transactions signed by their sender.
+	alice, bob, charlie, err := demoWallets(*dataDir, *walletPassphrase)
+	if err != nil {
+		fmt.Println("Error creating wallets:", err)
+		return
+	}
+	// The faucet pays its grants out of this account.
+	faucetWallet, err := demoWallet(*dataDir, "Faucet", *walletPassphrase)
+	if err != nil {
+		fmt.Println("Error creating wallets:", err)
+		return
+	}
+	// The node's P2P identity, kept so peers see the same key after a restart.
+	identity, err := p2p.LoadIdentityKey(filepath.Join(*dataDir, "node.key"))
+	if err != nil {
+		fmt.Println("Error loading node identity key:", err)
+		return
+	}
+	// The genesis block funds the demo accounts, so their balances follow
+	// from the chain on every node that replays it.
+	cfg.GenesisAlloc = blockchain.GenesisAlloc{
+		alice.Address:        100.0,
+		bob.Address:          50.0,
+		charlie.Address:      25.0,
+		faucetWallet.Address: 1000.0,
+	}
+
+	// Restore the chain saved by the previous run and persist blocks as they are added.
+	db, err := blockchain.OpenDBPath(filepath.Join(*dataDir, "blockchain.db"))
+	if err != nil {
+		fmt.Println("Error opening database:", err)
+		return
+	}
 	if *lightClient {
 		fmt.Println("Running in light client mode. Only block headers will be loaded.")
-		bc = blockchain.NewBlockchain()
-	} else {
-		bc = blockchain.NewBlockchain()
 	}
+	bc, storedHeaders, err := loadChain(db, cfg, *lightClient)
+	if err != nil {
+		fmt.Println("Error loading blockchain:", err)
+		db.Close()
+		return
+	}
+	bc.ArchiveMaxFiles = *archiveMaxFiles
+	bc.ArchiveMaxBytes = *archiveMaxBytes
+	bc.CompressArchives = *archiveGzip
+	bc.CacheWindow = *cacheWindow
 
 	// Create a transaction pool.
-	txPool := &blockchain.TransactionPool{}
-
-	// Create a ledger and initialize balances.
-	ledger := blockchain.NewLedger()
-	ledger["Alice"] = 100.0
-	ledger["Bob"] = 50.0
-	ledger["Charlie"] = 25.0
+	txPool := &blockchain.TransactionPool{MaxSize: *mempoolSize, MaxBytes: *mempoolBytes}
 
 	// Add some transactions.
-	tx1 := blockchain.NewTransaction("Alice", "Bob", 10.5, 1)
-	tx2 := blockchain.NewTransaction("Bob", "Charlie", 5.25, 1)
-	txPool.AddTransaction(tx1)
-	txPool.AddTransaction(tx2)
+	tx1 := blockchain.NewTransaction(alice.Address, bob.Address, 10.5, 1)
+	tx2 := blockchain.NewTransaction(bob.Address, charlie.Address, 5.25, 1)
+	alice.SignTransaction(tx1)
+	bob.SignTransaction(tx2)
 
 	// Define relationship and receivers.
 	relationshipType := "one-to-one"
@@ -72,34 +172,52 @@ func main() {
 
 	// Set the difficulty for PoW.
 	difficulty := 3
-	// Miner address and reward.
+	// Miner address and the reward schedule.
 	minerAddress := "Miner1"
-	reward := 12.5
-
-	// Create and add the genesis block with coinbase transaction.
-	genesis := blockchain.CreateBlock(0, "", relationshipType, receivers, textData, audioData, videoData, txPool, difficulty, minerAddress, reward)
-	bc.AddBlock(genesis)
-	fmt.Println("Genesis Block Hash:", genesis.Hash)
-	ledger.ProcessCoinbaseTransaction(minerAddress, reward)
-	txPool.Clear()
-
-	// Create a second block.
-	tx3 := blockchain.NewTransaction("Charlie", "Alice", 3.75, 1)
-	txPool.AddTransaction(tx3)
-	relationshipType = "one-to-many"
-	receivers = []string{"ReceiverA", "ReceiverB", "ReceiverC"}
-	block2 := blockchain.CreateBlock(1, genesis.Hash, relationshipType, receivers, textData, audioData, videoData, txPool, difficulty, minerAddress, reward)
-	bc.AddBlock(block2)
-	fmt.Println("Block 2 Hash:", block2.Hash)
-	ledger.ProcessCoinbaseTransaction(minerAddress, reward)
-	txPool.Clear()
-
-	// Add various sub-blocks to Block 2.
-	bc.UpdateBlockWithSubBlockEx(1, "New Text Update", "", "", "text")
-	bc.UpdateBlockWithSubBlockEx(1, "Metadata: Node updated", "", "", "metadata")
-	bc.UpdateBlockWithSubBlockEx(1, "", "Contract state changed", "", "contract_state")
-	bc.UpdateBlockWithSubBlockEx(1, "", "", "Transaction details updated", "transaction_update")
-	fmt.Println("Block 2 now has", len(bc.Blocks[1].SubBlocks), "sub-block(s).")
+	rewards := bc.Config.Reward
+
+	// The demo blocks are only created on a fresh chain of a full node; a
+	// restarted node carries on from the chain it saved, and a light client
+	// only follows headers.
+	var proposal *blockchain.Block // Put to the hybrid consensus demo below.
+	if *lightClient {
+		fmt.Printf("Loaded %d header(s) from the database.\n", len(storedHeaders))
+	} else if len(bc.Blocks) == 0 {
+		// The genesis block only carries the allocations.
+		genesis := blockchain.NewGenesisBlock(cfg.GenesisAlloc, difficulty)
+		bc.AddBlock(genesis)
+		fmt.Println("Genesis Block Hash:", genesis.Hash)
+
+		// Create a first block spending the allocations.
+		txPool.AddTransaction(tx1)
+		txPool.AddTransaction(tx2)
+		block1 := blockchain.CreateBlock(1, genesis.Hash, relationshipType, receivers, textData, audioData, videoData, txPool, difficulty, minerAddress, rewards.RewardAt(1))
+		bc.AddBlock(block1)
+		fmt.Println("Block 1 Hash:", block1.Hash)
+		txPool.Clear()
+
+		// Create a second block.
+		tx3 := blockchain.NewTransaction(charlie.Address, alice.Address, 3.75, 1)
+		charlie.SignTransaction(tx3)
+		txPool.AddTransaction(tx3)
+		relationshipType = "one-to-many"
+		receivers = []string{"ReceiverA", "ReceiverB", "ReceiverC"}
+		block2 := blockchain.CreateBlock(2, block1.Hash, relationshipType, receivers, textData, audioData, videoData, txPool, difficulty, minerAddress, rewards.RewardAt(2))
+		bc.AddBlock(block2)
+		fmt.Println("Block 2 Hash:", block2.Hash)
+		txPool.Clear()
+
+		// Add various sub-blocks to Block 2.
+		bc.UpdateBlockWithSubBlockEx(2, "New Text Update", "", "", "text")
+		bc.UpdateBlockWithSubBlockEx(2, "Metadata: Node updated", "", "", "metadata")
+		bc.UpdateBlockWithSubBlockEx(2, "", "Contract state changed", "", "contract_state")
+		bc.UpdateBlockWithSubBlockEx(2, "", "", "Transaction details updated", "transaction_update")
+		fmt.Println("Block 2 now has", len(bc.Blocks[2].SubBlocks), "sub-block(s).")
+		proposal = block2
+	} else {
+		fmt.Printf("Loaded %d block(s) from the database.\n", len(bc.Blocks))
+		proposal = bc.Tip()
+	}
 
 	// Print blockchain summary.
 	for _, blk := range bc.Blocks {
@@ -112,10 +230,11 @@ func main() {
 
 	// If running as a full node, start periodic pruning.
 	if !*lightClient {
+		workers.Add(1)
 		go func() {
-			for {
-				time.Sleep(10 * time.Second)
-				if len(bc.Blocks) > 100 {
+			defer workers.Done()
+			for sleepContext(ctx, p2p.NextInterval(10*time.Second, *timerJitter)) {
+				if len(bc.RecentBlocks()) > 100 {
 					err := bc.PruneAndArchive(50, "archive")
 					if err != nil {
 						fmt.Println("Pruning error:", err)
@@ -125,65 +244,292 @@ func main() {
 		}()
 	}
 
-	// Start auto-mining: periodically check the transaction pool and mine a new block if needed.
-	go func() {
-		ticker := time.NewTicker(10 * time.Second)
-		for range ticker.C {
-			if len(txPool.Transactions) > 0 {
-				fmt.Println("Auto-mining triggered: pending transactions detected.")
-				var prevHash string
-				if len(bc.Blocks) > 0 {
-					prevHash = bc.Blocks[len(bc.Blocks)-1].Hash
+	// Start the P2P node.
+	node := p2p.NewNode(*listenAddr, peers, bc, *peerFile)
+	node.TxPool = txPool
+	node.LightClient = *lightClient
+	if len(storedHeaders) > 0 {
+		if err := node.LoadHeaders(storedHeaders); err != nil {
+			fmt.Println("Stored headers not loaded:", err)
+		}
+	}
+	node.DiscoveryInterval = *discoveryInterval
+	node.DiscoveryJitter = *discoveryJitter
+	node.MaxInboundConns = *maxInbound
+	node.MaxMessageSize = *maxMessageSize
+	node.MaxChainBlocks = *maxChainBlocks
+	node.Plaintext = *p2pPlaintext
+	node.SetIdentityKey(identity)
+	go node.Start()
+
+	// Sharding: a beacon chain with 3 shards, whose crosslinks the miner
+	// below commits to the main chain.
+	beacon := blockchain.NewBeaconChain(3)
+
+	// Start auto-mining on a full node: periodically check the transaction
+	// pool and mine a new block if needed. Pending crosslinks go into the
+	// next block mined.
+	var minedBlocks atomic.Uint64
+	if !*lightClient {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for sleepContext(ctx, p2p.NextInterval(*mineInterval, *timerJitter)) {
+				links := beacon.PendingCrosslinks()
+				if txPool.Len() > 0 || len(links) > 0 {
+					if !node.ReadyToMine(*minPeers) {
+						fmt.Printf("Auto-mining postponed: waiting for %d connected peer(s) or an initial sync.\n", *minPeers)
+						continue
+					}
+					fmt.Println("Auto-mining triggered: pending transactions detected.")
+					// Build on one snapshot of the tip: after pruning, the
+					// blocks in memory no longer start at genesis.
+					prevHash, height := "", 0
+					if tip := bc.Tip(); tip != nil {
+						prevHash, height = tip.Hash, tip.Index+1
+					}
+					// Block assembly puts funding transactions first; those that
+					// cannot be funded yet wait in the pool.
+					balances := bc.Balances()
+					if len(links) == 0 && len(cfg.SelectTransactions(txPool.Pending(), balances)) == 0 {
+						continue
+					}
+					var newBlock *blockchain.Block
+					var err error
+					if len(links) > 0 {
+						newBlock, err = cfg.CreateCrosslinkBlockContext(ctx, height, prevHash, links,
+							txPool, balances, difficulty, minerAddress)
+					} else {
+						newBlock, err = cfg.CreateBlockContext(ctx, height, prevHash, "one-to-many",
+							[]string{"ReceiverA", "ReceiverB", "ReceiverC"}, textData, audioData, videoData,
+							txPool, balances, difficulty, minerAddress)
+					}
+					if err != nil {
+						fmt.Println("Auto-mining stopped:", err)
+						continue
+					}
+					// A block from a peer may have extended the tip while we were mining.
+					added, err := bc.AddBlockIfTip(newBlock, prevHash)
+					if err != nil {
+						fmt.Println("Auto-mined block refused:", err)
+						continue
+					}
+					if !added {
+						fmt.Println("Auto-mined block discarded: chain tip moved.")
+						continue
+					}
+					minedBlocks.Add(1)
+					fmt.Println("Auto-mined Block Hash:", newBlock.Hash)
+					txPool.Remove(newBlock.Transactions)
+					beacon.CrosslinksCommitted(links)
 				}
-				newBlock := blockchain.CreateBlock(len(bc.Blocks), prevHash, "one-to-many",
-					[]string{"ReceiverA", "ReceiverB", "ReceiverC"}, textData, audioData, videoData,
-					txPool, difficulty, minerAddress, reward)
-				bc.AddBlock(newBlock)
-				fmt.Println("Auto-mined Block Hash:", newBlock.Hash)
-				ledger.ProcessCoinbaseTransaction(minerAddress, reward)
-				txPool.Clear()
 			}
-		}
-	}()
+		}()
+	}
 
-	// Hybrid Consensus: simulate block proposal and voting.
-	hcm := blockchain.NewHybridConsensusManager()
-	hcm.Stakeholders["Miner1"] = 50.0
-	hcm.Stakeholders["Validator1"] = 30.0
-	hcm.Stakeholders["Validator2"] = 20.0
-	// Propose block2 as a candidate.
-	hcm.ProposeBlock(block2)
-	// Simulate validator votes.
-	hcm.CastVote(0, "Validator1", true)
-	hcm.CastVote(0, "Validator2", true)
-	finalizedBlock := hcm.FinalizeBlock(100) // assuming total stake of 100 (50+30+20)
-	if finalizedBlock != nil {
-		fmt.Println("Finalized Block via Hybrid Consensus:", finalizedBlock.Hash)
+	// Hybrid Consensus: simulate block proposal and voting on a full node.
+	if proposal != nil {
+		hcm := blockchain.NewHybridConsensusManager()
+		// Propose the latest block as a candidate.
+		hcm.ProposeBlock(proposal)
+		// Validators approve it by signing its hash.
+		for _, v := range []struct {
+			name  string
+			stake float64
+			votes bool
+		}{{"Miner1", 50.0, false}, {"Validator1", 30.0, true}, {"Validator2", 20.0, true}} {
+			validator, err := demoWallet(*dataDir, v.name, *walletPassphrase)
+			if err != nil {
+				fmt.Println("Error creating validator wallet:", err)
+				continue
+			}
+			hcm.AddValidator(validator.PublicKey, v.stake)
+			if !v.votes {
+				continue
+			}
+			sig, err := blockchain.SignVote(proposal, validator.PrivateKey)
+			if err == nil {
+				err = hcm.CastSignedVote(proposal.Hash, validator.PublicKey, sig)
+			}
+			if err != nil {
+				fmt.Printf("Vote from %s not counted: %v\n", v.name, err)
+			}
+		}
+		finalizedBlock := hcm.FinalizeBlock(100) // assuming total stake of 100 (50+30+20)
+		if finalizedBlock != nil {
+			fmt.Println("Finalized Block via Hybrid Consensus:", finalizedBlock.Hash)
+		}
 	}
 
 	// Dynamic Difficulty Adjustment: adjust difficulty periodically.
+	workers.Add(1)
 	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		for range ticker.C {
-			newDifficulty := blockchain.AdjustDifficulty(bc.Blocks, 10*time.Second, 2)
+		defer workers.Done()
+		for sleepContext(ctx, 30*time.Second) {
+			newDifficulty := blockchain.AdjustDifficulty(bc.RecentBlocks(), 10*time.Second, 2)
 			fmt.Println("Adjusted difficulty for next block:", newDifficulty)
 		}
 	}()
 
-	// Sharding: initialize a beacon chain with 3 shards.
-	beacon := blockchain.NewBeaconChain(3)
-	// Process a sample transaction: assign tx1 to a shard.
-	beacon.ProcessTransaction(tx1)
-
-	// Start the P2P node.
-	node := p2p.NewNode(*listenAddr, peers, bc)
-	go node.Start()
+	// Fund Alice on her shard and move tx1 to Bob, possibly across shards.
+	beacon.Shards[beacon.AssignShardForAddress(alice.Address)].Ledger[alice.Address] = bc.Balance(alice.Address)
+	if err := beacon.ProcessTransaction(tx1); err != nil {
+		fmt.Println("Sharded transaction error:", err)
+	} else if shardBlock, err := beacon.MineShard(beacon.AssignShard(tx1), minerAddress, rewards.RewardAt(0)); err != nil {
+		fmt.Println("Shard mining error:", err)
+	} else {
+		fmt.Printf("Mined shard block %s at height %d.\n", shardBlock.Hash, beacon.ShardHeight(beacon.AssignShard(tx1)))
+	}
+	// Queue the shard tips for the main chain's next block.
+	if links, err := beacon.Commit(); err != nil {
+		fmt.Println("Crosslink error:", err)
+	} else {
+		fmt.Printf("Queued %d crosslink(s) for the next main chain block.\n", len(links))
+	}
 
 	// Initialize the dynamic contract registry and start the API server.
+	// Without a working WASM runtime the node still runs, but dynamic
+	// contracts are refused.
+	if err := contract.CheckWASMSupport(); err != nil {
+		fmt.Println("Dynamic contracts disabled:", err)
+	}
 	dynamicRegistry := contract.NewDynamicRegistry()
-	apiServer := api.NewServer(bc, ledger, peers, dynamicRegistry)
+	dynamicRegistry.State = db
+	if err := dynamicRegistry.Restore(); err != nil {
+		fmt.Println("Error loading deployed contracts:", err)
+	}
+	apiServer := api.NewServer(bc, node.Peers, dynamicRegistry, *apiToken)
+	apiServer.Node = node
+	apiServer.TxPool = txPool
+	apiServer.Host = *apiHost
+	apiServer.MinedBlocks = &minedBlocks
+	if *corsOrigins != "" {
+		apiServer.CORSOrigins = strings.Split(*corsOrigins, ",")
+	}
+	if *faucet {
+		fmt.Println("WARNING: faucet enabled; anyone can draw test funds. Never enable it in production.")
+		apiServer.Faucet = api.NewFaucet(faucetWallet, *faucetAmount, *faucetCooldown)
+	}
 	go apiServer.StartServer("8080")
 
-	// Prevent main from exiting.
-	select {}
+	// Run until interrupted.
+	<-ctx.Done()
+	fmt.Println("Shutting down.")
+	if err := shutdown(node, apiServer, &workers, bc, db); err != nil {
+		fmt.Println("Shutdown error:", err)
+	}
+}
+
+// loadChain restores the chain stored in db for a chain configured by cfg.
+// A full node gets the stored blocks, with db attached to persist the blocks
+// it adds and a ledger replayed from them. A light client gets the headers of the stored blocks instead and
+// an empty chain that is not attached, so that nothing it does can
+// overwrite the stored blocks. An empty database gives an empty chain and
+// no headers.
+func loadChain(db *blockchain.DB, cfg *blockchain.ChainConfig, light bool) (*blockchain.Blockchain, []blockchain.LightBlockHeader, error) {
+	if light {
+		h, err := cfg.Hasher()
+		if err != nil {
+			return nil, nil, err
+		}
+		headers, err := db.GetAllBlockHeaders(h)
+		if err != nil {
+			return nil, nil, err
+		}
+		bc := blockchain.NewBlockchain()
+		bc.Config = cfg
+		return bc, headers, nil
+	}
+	bc, err := db.LoadBlockchainConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	ledger, err := db.RebuildLedger()
+	if err != nil {
+		return nil, nil, err
+	}
+	bc.AttachLedger(ledger)
+	bc.Store = db
+	return bc, nil, nil
+}
+
+// parseCheckpoints parses a comma-separated list of height:hash pairs.
+func parseCheckpoints(s string) (map[int]string, error) {
+	checkpoints := map[int]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		height, hash, ok := strings.Cut(pair, ":")
+		index, err := strconv.Atoi(height)
+		if !ok || err != nil || index < 0 || hash == "" {
+			return nil, fmt.Errorf("invalid checkpoint %q, want height:hash", pair)
+		}
+		checkpoints[index] = hash
+	}
+	return checkpoints, nil
+}
+
+// shutdownTimeout bounds how long in-flight API requests may take to finish.
+const shutdownTimeout = 5 * time.Second
+
+// sleepContext waits for d and reports whether ctx is still live afterwards.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// shutdown stops the P2P node and API server, waits for the background
+// workers to observe the cancelled context and closes the database last so
+// that nothing writes to it after it is closed. A full node's chain is
+// saved once more before that, since sub-blocks are only kept in memory as
+// they are added.
+func shutdown(node *p2p.Node, apiServer *api.Server, workers *sync.WaitGroup, bc *blockchain.Blockchain, db *blockchain.DB) error {
+	node.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := apiServer.Shutdown(ctx); err != nil {
+		fmt.Println("API server shutdown error:", err)
+	}
+	workers.Wait()
+	var saveErr error
+	if bc.Store != nil {
+		saveErr = db.SaveChain(bc)
+	}
+	return errors.Join(saveErr, db.Close())
+}
+
+// demoWallets returns the wallets of the demo accounts Alice, Bob and
+// Charlie, kept in dir.
+func demoWallets(dir, passphrase string) (alice, bob, charlie *wallet.Wallet, err error) {
+	var wallets [3]*wallet.Wallet
+	for i, name := range []string{"Alice", "Bob", "Charlie"} {
+		if wallets[i], err = demoWallet(dir, name, passphrase); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return wallets[0], wallets[1], wallets[2], nil
+}
+
+// demoWallet returns the wallet of the demo account name, saved in dir
+// under passphrase. The first call creates it with a random key, so that
+// only this node can spend the account's funds, and later runs load it so
+// the funds still belong to it.
+func demoWallet(dir, name, passphrase string) (*wallet.Wallet, error) {
+	path := filepath.Join(dir, "wallet-"+strings.ToLower(name)+".json")
+	w, err := wallet.LoadWallet(path, passphrase)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return w, err
+	}
+	if w, err = wallet.NewWallet(); err != nil {
+		return nil, err
+	}
+	if err := w.Save(path, passphrase); err != nil {
+		return nil, err
+	}
+	return w, nil
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"cryptocypher/pkg/api"
+	"cryptocypher/pkg/blockchain"
+	"cryptocypher/pkg/contract"
+	"cryptocypher/pkg/p2p"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestShutdownClosesDBAndStopsWorkers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blockchain.db")
+	db, err := blockchain.OpenDBPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	bc := blockchain.NewBlockchain()
+	bc.Store = db
+	bc.AddBlock(blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", blockchain.DefaultBlockReward))
+	bc.UpdateBlockWithSubBlockEx(0, "Update", "", "", "text")
+	node := p2p.NewNode(addr, nil, bc, "")
+	go node.Start()
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 50 {
+			t.Fatal("node did not start listening")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	apiServer := api.NewServer(bc, nil, contract.NewDynamicRegistry(), "")
+	go apiServer.StartServer("0")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		for sleepContext(ctx, time.Hour) {
+		}
+	}()
+
+	cancel()
+	done := make(chan error)
+	go func() { done <- shutdown(node, apiServer, &workers, bc, db) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return; a worker is still running")
+	}
+
+	if err := db.View(func(*bolt.Tx) error { return nil }); !errors.Is(err, bolt.ErrDatabaseNotOpen) {
+		t.Errorf("expected database to be closed, got %v", err)
+	}
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Error("node is still accepting connections")
+	}
+
+	// The sub-block added in memory was saved before the database closed.
+	db, err = blockchain.OpenDBPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	saved, err := db.LoadBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Blocks) != 1 || len(saved.Blocks[0].SubBlocks) != 1 {
+		t.Error("sub-block was not saved at shutdown")
+	}
+}
+
+func TestChainSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blockchain.db")
+	db, err := blockchain.OpenDBPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, _, err := loadChain(db, blockchain.DefaultChainConfig(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bc.Blocks) != 0 {
+		t.Fatalf("expected an empty chain from a new database, got %d blocks", len(bc.Blocks))
+	}
+	if bc.Store != db {
+		t.Fatal("expected a full node's chain to persist to the database")
+	}
+	prevHash := ""
+	for i := 0; i < 3; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, "Miner1", blockchain.DefaultBlockReward)
+		bc.AddBlock(b)
+		prevHash = b.Hash
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = blockchain.OpenDBPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	restored, _, err := loadChain(db, blockchain.DefaultChainConfig(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Blocks) != len(bc.Blocks) {
+		t.Fatalf("expected %d blocks after restart, got %d", len(bc.Blocks), len(restored.Blocks))
+	}
+	for i, b := range restored.Blocks {
+		if b.Hash != bc.Blocks[i].Hash {
+			t.Errorf("block %d changed across restart", i)
+		}
+	}
+	if !blockchain.IsValidChain(restored.Blocks) {
+		t.Error("restored chain is not valid")
+	}
+	if got := restored.Balance("Miner1"); got != 3*blockchain.DefaultBlockReward {
+		t.Errorf("expected the ledger replayed from the stored blocks, Miner1 has %v", got)
+	}
+
+	light, headers, err := loadChain(db, blockchain.DefaultChainConfig(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(light.Blocks) != 0 || len(headers) != 3 || headers[2].Hash != prevHash {
+		t.Errorf("expected a light client to get only the 3 headers, got %d blocks and %d headers", len(light.Blocks), len(headers))
+	}
+	// Blocks a light client adds must not overwrite the stored chain.
+	if light.Store != nil {
+		t.Error("expected a light client's chain not to be attached to the database")
+	}
+}
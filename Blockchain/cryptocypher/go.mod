@@ -5,9 +5,12 @@ go 1.23
 toolchain go1.23.6
 
 require (
+	github.com/flynn/noise v1.1.0
 	github.com/tetratelabs/wazero v1.9.0
+	github.com/tyler-smith/go-bip39 v1.1.0
 	go.etcd.io/bbolt v1.4.0
 	golang.org/x/crypto v0.33.0
+	golang.org/x/net v0.35.0
 )
 
 require golang.org/x/sys v0.30.0 // indirect
@@ -2,41 +2,87 @@
 package api
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"cryptocypher/pkg/blockchain"
 	"cryptocypher/pkg/contract"
+	"cryptocypher/pkg/p2p"
 )
 
-// Server holds references to the blockchain, ledger, and peer list.
+// Server holds references to the blockchain and peer list. Balances are
+// read from the ledger attached to the blockchain.
 type Server struct {
 	Blockchain      *blockchain.Blockchain
-	Ledger          blockchain.Ledger
-	PeerList        []string
+	Peers           *p2p.PeerStore
 	StartTime       time.Time
 	DynamicRegistry *contract.DynamicRegistry
+	Node            *p2p.Node                   // P2P node this server fronts; optional.
+	TxPool          *blockchain.TransactionPool // Pool receiving submitted transactions.
+	Faucet          *Faucet                     // Serves POST /faucet if set; test networks only.
+	AdminToken      string                      // Bearer token required by operator endpoints; empty disables them.
+	Host            string                      // Interface StartServer binds to; empty means all.
+	CORSOrigins     []string                    // Browser origins allowed to call the API; "*" allows any.
+	MinedBlocks     *atomic.Uint64              // Blocks mined by this node, counted by the miner; optional.
+
+	rejectedPoolFull   atomic.Uint64    // Submissions refused because the pool was full.
+	contractExecutions atomic.Uint64    // Calls made through /contract.
+	idempotency        idempotencyCache // Recorded /transaction results by Idempotency-Key.
+
+	mu         sync.Mutex
+	httpServer *http.Server // Set by StartServer.
+	stopped    bool         // Set by Shutdown; StartServer then returns at once.
 }
 
-// NewServer creates a new API server instance.
-func NewServer(bc *blockchain.Blockchain, ledger blockchain.Ledger, peers []string, dr *contract.DynamicRegistry) *Server {
+// poolRetryAfter is the Retry-After hint, in seconds, sent when the pool is full.
+const poolRetryAfter = "10"
+
+// NewServer creates a new API server instance. peers is usually the store of
+// the P2P node; nil gives the server a private, unpersisted store.
+// adminToken guards the endpoints that change the node, such as /prune and
+// /deployContract; with an empty token they refuse every request.
+func NewServer(bc *blockchain.Blockchain, peers *p2p.PeerStore, dr *contract.DynamicRegistry, adminToken string) *Server {
+	if peers == nil {
+		peers, _ = p2p.NewPeerStore("", "", p2p.DefaultMaxPeers)
+	}
 	return &Server{
 		Blockchain:      bc,
-		Ledger:          ledger,
-		PeerList:        peers,
+		Peers:           peers,
 		StartTime:       time.Now(),
 		DynamicRegistry: dr,
+		AdminToken:      adminToken,
 	}
 }
 
-// getChainHandler returns the full blockchain.
+// maxChainPage is the most blocks returned by one /chain range request.
+const maxChainPage = 100
+
+// maxHeadersPage is the most headers returned by one /headers range request.
+const maxHeadersPage = 500
+
+// getChainHandler returns the full blockchain, or with from (and optionally
+// to) the blocks in that inclusive index range. Ranges are capped at
+// maxChainPage blocks; when more remain, X-Next-From holds the index to
+// continue from.
+// With light=true, as for the other block endpoints, the text, audio and
+// video payloads are left out.
 func (s *Server) getChainHandler(w http.ResponseWriter, r *http.Request) {
-	chainJSON, err := json.Marshal(s.Blockchain.Blocks)
+	query := r.URL.Query()
+	if query.Has("from") || query.Has("to") {
+		s.getChainRange(w, r, query.Get("from"), query.Get("to"))
+		return
+	}
+	chainJSON, err := json.Marshal(projectBlocks(r, s.Blockchain.RecentBlocks()))
 	if err != nil {
 		http.Error(w, "Error marshalling chain", http.StatusInternalServerError)
 		return
@@ -45,8 +91,80 @@ func (s *Server) getChainHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(chainJSON)
 }
 
+// getChainRange serves a /chain request for the blocks from..to.
+func (s *Server) getChainRange(w http.ResponseWriter, r *http.Request, fromParam, toParam string) {
+	tip := s.Blockchain.Tip()
+	from, err := strconv.Atoi(fromParam)
+	if err != nil || from < 0 {
+		http.Error(w, "Invalid from parameter", http.StatusBadRequest)
+		return
+	}
+	to := from + maxChainPage - 1
+	if toParam != "" {
+		if to, err = strconv.Atoi(toParam); err != nil {
+			http.Error(w, "Invalid to parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if to < from {
+		http.Error(w, "Invalid range: to is before from", http.StatusBadRequest)
+		return
+	}
+	if tip == nil || from > tip.Index {
+		http.Error(w, "Range starts beyond the chain tip", http.StatusNotFound)
+		return
+	}
+	last := min(to, tip.Index, from+maxChainPage-1)
+
+	blocks := make([]*blockchain.Block, 0, last-from+1)
+	for i := from; i <= last; i++ {
+		b, err := s.Blockchain.GetBlockByIndex(i)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Block %d not available", i), http.StatusNotFound)
+			return
+		}
+		blocks = append(blocks, b)
+	}
+	chainJSON, err := json.Marshal(projectBlocks(r, blocks))
+	if err != nil {
+		http.Error(w, "Error marshalling chain", http.StatusInternalServerError)
+		return
+	}
+	if last < min(to, tip.Index) {
+		w.Header().Set("X-Next-From", strconv.Itoa(last+1))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(chainJSON)
+}
+
+// getBlockByIndexHandler returns the block at the height given by "index".
+func (s *Server) getBlockByIndexHandler(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if err != nil || index < 0 {
+		http.Error(w, "Invalid index parameter", http.StatusBadRequest)
+		return
+	}
+	block, err := s.Blockchain.GetBlockByIndex(index)
+	if err != nil {
+		http.Error(w, "Block not found", http.StatusNotFound)
+		return
+	}
+	blockJSON, err := json.Marshal(projectBlock(r, block))
+	if err != nil {
+		http.Error(w, "Error marshalling block", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(blockJSON)
+}
+
 // getHeadersHandler returns only the block headers.
 func (s *Server) getHeadersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Has("from") || query.Has("count") {
+		s.getHeadersRange(w, query.Get("from"), query.Get("count"))
+		return
+	}
 	headers := s.Blockchain.ExtractHeaders()
 	headersJSON, err := json.Marshal(headers)
 	if err != nil {
@@ -57,14 +175,57 @@ func (s *Server) getHeadersHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(headersJSON)
 }
 
+// getHeadersRange serves a /headers request for count headers starting at
+// height from. count defaults to and is capped at maxHeadersPage.
+func (s *Server) getHeadersRange(w http.ResponseWriter, fromParam, countParam string) {
+	tip := s.Blockchain.Tip()
+	from, err := strconv.Atoi(fromParam)
+	if err != nil || from < 0 {
+		http.Error(w, "Invalid from parameter", http.StatusBadRequest)
+		return
+	}
+	count := maxHeadersPage
+	if countParam != "" {
+		if count, err = strconv.Atoi(countParam); err != nil || count <= 0 {
+			http.Error(w, "Invalid count parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if tip == nil || from > tip.Index {
+		http.Error(w, "Range starts beyond the chain tip", http.StatusNotFound)
+		return
+	}
+	last := min(from+min(count, maxHeadersPage)-1, tip.Index)
+
+	headers := make([]blockchain.LightBlockHeader, 0, last-from+1)
+	for i := from; i <= last; i++ {
+		b, err := s.Blockchain.GetBlockByIndex(i)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Block %d not available", i), http.StatusNotFound)
+			return
+		}
+		headers = append(headers, s.Blockchain.Header(b))
+	}
+	headersJSON, err := json.Marshal(headers)
+	if err != nil {
+		http.Error(w, "Error marshalling headers", http.StatusInternalServerError)
+		return
+	}
+	if last < min(from+count-1, tip.Index) {
+		w.Header().Set("X-Next-From", strconv.Itoa(last+1))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(headersJSON)
+}
+
 // getLatestBlockHandler returns the most recent block.
 func (s *Server) getLatestBlockHandler(w http.ResponseWriter, r *http.Request) {
-	if len(s.Blockchain.Blocks) == 0 {
+	latest := s.Blockchain.Tip()
+	if latest == nil {
 		http.Error(w, "Blockchain is empty", http.StatusNotFound)
 		return
 	}
-	latest := s.Blockchain.Blocks[len(s.Blockchain.Blocks)-1]
-	blockJSON, err := json.Marshal(latest)
+	blockJSON, err := json.Marshal(projectBlock(r, latest))
 	if err != nil {
 		http.Error(w, "Error marshalling block", http.StatusInternalServerError)
 		return
@@ -85,7 +246,7 @@ func (s *Server) getBlockHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Block not found", http.StatusNotFound)
 		return
 	}
-	blockJSON, err := json.Marshal(block)
+	blockJSON, err := json.Marshal(projectBlock(r, block))
 	if err != nil {
 		http.Error(w, "Error marshalling block", http.StatusInternalServerError)
 		return
@@ -116,14 +277,26 @@ func (s *Server) getSubBlocksHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(subBlocksJSON)
 }
 
-// getBalanceHandler returns the balance for a given address.
+// getBalanceHandler returns the balance for a given address. With
+// breakdown=true it splits the chain balance into spendable funds, immature
+// coinbase rewards and pending pool transactions.
 func (s *Server) getBalanceHandler(w http.ResponseWriter, r *http.Request) {
 	address := r.URL.Query().Get("address")
 	if address == "" {
 		http.Error(w, "Missing address parameter", http.StatusBadRequest)
 		return
 	}
-	balance := s.Ledger[address]
+	if r.URL.Query().Get("breakdown") == "true" {
+		breakdown, err := s.Blockchain.BalanceBreakdown(address, s.TxPool)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error computing balance: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(breakdown)
+		return
+	}
+	balance := s.Blockchain.Balance(address)
 	resp := map[string]interface{}{
 		"address": address,
 		"balance": balance,
@@ -132,55 +305,200 @@ func (s *Server) getBalanceHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-// submitTransactionHandler accepts and verifies a new transaction.
-func (s *Server) submitTransactionHandler(w http.ResponseWriter, r *http.Request) {
-	var tx blockchain.Transaction
-	if err := json.NewDecoder(r.Body).Decode(&tx); err != nil {
-		http.Error(w, "Invalid transaction format", http.StatusBadRequest)
+// getBalanceProofHandler returns an address's balance along with Merkle
+// proofs that light clients can check against their headers.
+func (s *Server) getBalanceProofHandler(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		http.Error(w, "Missing address parameter", http.StatusBadRequest)
 		return
 	}
-
-	// Verify the signature.
-	// We assume tx.Sender holds the hex-encoded public key.
-	pubKeyBytes, err := hex.DecodeString(tx.Sender)
+	proof, err := s.Blockchain.BalanceProof(address)
 	if err != nil {
-		http.Error(w, "Invalid sender public key format", http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("Error building balance proof: %v", err), http.StatusInternalServerError)
 		return
 	}
-	x, y := elliptic.Unmarshal(elliptic.P256(), pubKeyBytes)
-	if x == nil || y == nil {
-		http.Error(w, "Could not unmarshal sender public key", http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proof)
+}
+
+// getForksHandler lists the active tip and known competing tips.
+func (s *Server) getForksHandler(w http.ResponseWriter, r *http.Request) {
+	active, forks := s.Blockchain.Forks()
+	resp := map[string]interface{}{
+		"active": active,
+		"forks":  forks,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getDiffHandler fetches the chain of the peer given by ?peer=, which must
+// be in the node's peer list, and diffs it against the local chain, height
+// by height. Only the blocks the local chain holds in memory are compared:
+// heights below them, evicted to the store or pruned, are left out rather
+// than reported as missing.
+func (s *Server) getDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Node == nil {
+		http.Error(w, "P2P node not attached", http.StatusServiceUnavailable)
+		return
+	}
+	peer := r.URL.Query().Get("peer")
+	if peer == "" {
+		http.Error(w, "Missing peer parameter", http.StatusBadRequest)
+		return
+	}
+	if !s.Node.Peers.Has(peer) {
+		http.Error(w, fmt.Sprintf("%s is not a known peer", peer), http.StatusForbidden)
+		return
+	}
+	remote, err := s.Node.FetchChain(peer)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Fetching chain from %s: %v", peer, err), http.StatusBadGateway)
 		return
 	}
-	ecdsaPubKey := &ecdsa.PublicKey{
-		Curve: elliptic.P256(),
-		X:     x,
-		Y:     y,
+	local := s.Blockchain.RecentBlocks()
+	if len(local) > 0 {
+		remote = slices.DeleteFunc(remote, func(b *blockchain.Block) bool {
+			return b.Index < local[0].Index
+		})
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blockchain.DiffChains(local, remote))
+}
 
-	if !blockchain.VerifyTransactionSignature(&tx, ecdsaPubKey) {
-		http.Error(w, "Invalid transaction signature", http.StatusBadRequest)
+// getSupplyHandler returns the number of tokens in existence.
+func (s *Server) getSupplyHandler(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{
+		"total_supply": s.Blockchain.TotalSupply(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getChainInfoHandler returns the chain height, tip hash, transaction count and supply.
+func (s *Server) getChainInfoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Blockchain.ChainInfo())
+}
+
+// submitTransactionHandler accepts and verifies a new transaction. Requests
+// repeating an earlier Idempotency-Key get the original response back.
+func (s *Server) submitTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	s.idempotency.serve(w, r, s.submitTransaction)
+}
+
+// submitTransaction verifies a transaction and adds it to the pool.
+func (s *Server) submitTransaction(w http.ResponseWriter, r *http.Request) {
+	var tx blockchain.Transaction
+	if err := json.NewDecoder(r.Body).Decode(&tx); err != nil {
+		http.Error(w, "Invalid transaction format", http.StatusBadRequest)
 		return
 	}
+	if s.admitTransaction(w, &tx) {
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
 
-	// Process the transaction (e.g., add it to a transaction pool).
-	// For demonstration, we simply print it.
-	fmt.Printf("Received valid transaction: %+v\n", tx)
-	w.WriteHeader(http.StatusAccepted)
+// admitTransaction verifies tx, adds it to the pool and broadcasts it. If
+// tx is refused it writes the error response and returns false.
+func (s *Server) admitTransaction(w http.ResponseWriter, tx *blockchain.Transaction) bool {
+	if tx.IsCoinbase() {
+		http.Error(w, blockchain.ErrForgedCoinbase.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := tx.CheckAmounts(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	// Verify the signature against the sender's public key.
+	if err := blockchain.VerifySignedTransaction(tx); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	// Spends already pending from the sender count against its balance.
+	balance := s.Blockchain.Balance(tx.Sender)
+	if s.TxPool == nil && balance < tx.Cost() {
+		http.Error(w, fmt.Sprintf("Insufficient funds: balance %f, required %f", balance, tx.Cost()), http.StatusPaymentRequired)
+		return false
+	}
+
+	if s.TxPool != nil {
+		if err := s.TxPool.AddFundedTransaction(tx, balance); err != nil {
+			if errors.Is(err, blockchain.ErrInsufficientFunds) {
+				http.Error(w, err.Error(), http.StatusPaymentRequired)
+				return false
+			}
+			if errors.Is(err, blockchain.ErrPoolFull) {
+				// Ask the client to back off instead of silently dropping the transaction.
+				s.rejectedPoolFull.Add(1)
+				w.Header().Set("Retry-After", poolRetryAfter)
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return false
+			}
+			if errors.Is(err, blockchain.ErrDuplicateTransaction) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return false
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+	}
+	fmt.Printf("Received valid transaction: %+v\n", *tx)
+	if s.Node != nil {
+		s.Node.BroadcastTransaction(tx)
+	}
+	return true
 }
 
 // executeContractHandler executes a smart contract based on input parameters.
+// Built-in contracts are tried first, then deployed ones. The call's gas
+// limit, capped by contract.GasLimit, is paid up front: payment must be a
+// signed transaction whose fee covers it at contract.GasPrice and whose
+// CallHash is the call's contract.CallHash. The payment goes to the pool
+// like any transaction, whether or not the call succeeds, and gas the call
+// leaves unused is not refunded. A payment already pending or on chain
+// cannot pay for another call.
 func (s *Server) executeContractHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		ContractName string                 `json:"contract_name"`
-		Method       string                 `json:"method"`
-		Params       map[string]interface{} `json:"params"`
+		ContractName string                  `json:"contract_name"`
+		Method       string                  `json:"method"`
+		Params       map[string]interface{}  `json:"params"`
+		GasLimit     uint64                  `json:"gas_limit,omitempty"`
+		Payment      *blockchain.Transaction `json:"payment"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request format", http.StatusBadRequest)
 		return
 	}
-	result, err := contract.ExecuteContract(req.ContractName, req.Method, req.Params)
+	limit := contract.GasLimit(req.GasLimit)
+	cost := float64(limit) * contract.GasPrice
+	if req.Payment == nil || req.Payment.Fee < cost {
+		http.Error(w, fmt.Sprintf("Payment required: a signed transaction with a fee of at least %f for %d gas", cost, limit),
+			http.StatusPaymentRequired)
+		return
+	}
+	callHash, err := contract.CallHash(req.ContractName, req.Method, req.Params, req.GasLimit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid call: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Payment.CallHash != callHash {
+		http.Error(w, fmt.Sprintf("Payment required: the payment's call_hash must be %s", callHash), http.StatusPaymentRequired)
+		return
+	}
+	if _, err := s.Blockchain.FindTransactionBlock(req.Payment.CalculateHash()); err == nil {
+		http.Error(w, "Payment is already on chain", http.StatusConflict)
+		return
+	}
+	// A payment already pending is refused here as a duplicate.
+	if !s.admitTransaction(w, req.Payment) {
+		return
+	}
+	s.contractExecutions.Add(1)
+	result, err := contract.ExecuteAnyContract(r.Context(), s.DynamicRegistry, req.ContractName, req.Method, req.Params,
+		contract.Gas{Limit: limit})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Contract execution error: %v", err), http.StatusBadRequest)
 		return
@@ -192,9 +510,24 @@ func (s *Server) executeContractHandler(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(resp)
 }
 
+// listContractsHandler returns the names of the built-in and the deployed
+// contracts that /contract can execute.
+func (s *Server) listContractsHandler(w http.ResponseWriter, r *http.Request) {
+	deployed := []string{}
+	if s.DynamicRegistry != nil {
+		deployed = s.DynamicRegistry.List()
+	}
+	resp := map[string]interface{}{
+		"builtin":  contract.ListContracts(),
+		"deployed": deployed,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
 // getPeersHandler returns the current peer list.
 func (s *Server) getPeersHandler(w http.ResponseWriter, r *http.Request) {
-	peerJSON, err := json.Marshal(s.PeerList)
+	peerJSON, err := json.Marshal(s.Peers.All())
 	if err != nil {
 		http.Error(w, "Error marshalling peer list", http.StatusInternalServerError)
 		return
@@ -212,9 +545,8 @@ func (s *Server) addPeerHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid peer data", http.StatusBadRequest)
 		return
 	}
-	// Avoid duplicates and self.
-	if req.Peer != "" && !contains(s.PeerList, req.Peer) {
-		s.PeerList = append(s.PeerList, req.Peer)
+	// The store skips duplicates and our own address.
+	if s.Peers.Add(req.Peer) {
 		fmt.Printf("Peer %s added.\n", req.Peer)
 	}
 	w.WriteHeader(http.StatusAccepted)
@@ -227,17 +559,7 @@ func (s *Server) removePeerHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing peer parameter", http.StatusBadRequest)
 		return
 	}
-	removed := false
-	newPeers := []string{}
-	for _, p := range s.PeerList {
-		if p != peer {
-			newPeers = append(newPeers, p)
-		} else {
-			removed = true
-		}
-	}
-	s.PeerList = newPeers
-	if removed {
+	if s.Peers.Remove(peer) {
 		fmt.Printf("Peer %s removed.\n", peer)
 		w.WriteHeader(http.StatusOK)
 	} else {
@@ -245,19 +567,53 @@ func (s *Server) removePeerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// contractStateHandler returns the state of a given contract.
-// For demonstration, this is a stub endpoint.
+// unregisterContractHandler removes a deployed contract and its state.
+// Use /deployContract with "upgrade" to replace a contract's code instead.
+func (s *Server) unregisterContractHandler(w http.ResponseWriter, r *http.Request) {
+	contractName := r.URL.Query().Get("contract")
+	if contractName == "" {
+		http.Error(w, "Missing contract parameter", http.StatusBadRequest)
+		return
+	}
+	if s.DynamicRegistry == nil {
+		http.Error(w, "Contract registry not attached", http.StatusServiceUnavailable)
+		return
+	}
+	if err := s.DynamicRegistry.Unregister(contractName); err != nil {
+		http.Error(w, fmt.Sprintf("Error unregistering contract: %v", err), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// contractStateHandler returns the stored state of a deployed contract,
+// with each value hex-encoded.
 func (s *Server) contractStateHandler(w http.ResponseWriter, r *http.Request) {
 	contractName := r.URL.Query().Get("contract")
 	if contractName == "" {
 		http.Error(w, "Missing contract parameter", http.StatusBadRequest)
 		return
 	}
-	// For now, return a dummy state. In a real implementation,
-	// you would query the contract's stored state.
+	if s.DynamicRegistry == nil {
+		http.Error(w, "Contract registry not attached", http.StatusServiceUnavailable)
+		return
+	}
+	if _, err := s.DynamicRegistry.GetContract(contractName); err != nil {
+		http.Error(w, "Contract not found", http.StatusNotFound)
+		return
+	}
+	stored, err := s.DynamicRegistry.State.ContractState(contractName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading contract state: %v", err), http.StatusInternalServerError)
+		return
+	}
+	values := make(map[string]string, len(stored))
+	for k, v := range stored {
+		values[k] = hex.EncodeToString(v)
+	}
 	state := map[string]interface{}{
 		"contract": contractName,
-		"state":    "dummy state",
+		"state":    values,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(state)
@@ -274,46 +630,70 @@ func (s *Server) pruneHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Pruning triggered successfully."))
 }
 
+// verifyIndexHandler compares the transaction index with the stored blocks
+// and reports the discrepancies. With repair=true they are also fixed.
+func (s *Server) verifyIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Blockchain.Store == nil {
+		http.Error(w, "No block storage attached", http.StatusServiceUnavailable)
+		return
+	}
+	report, err := s.Blockchain.Store.VerifyTxIndex(r.URL.Query().Get("repair") == "true")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Index verification error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
 // statusHandler returns basic node status.
 func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
 	uptime := time.Since(s.StartTime).String()
 	status := map[string]interface{}{
 		"uptime":         uptime,
-		"block_height":   len(s.Blockchain.Blocks),
-		"peer_count":     len(s.PeerList),
-		"ledger_entries": len(s.Ledger),
+		"block_height":   s.Blockchain.ChainInfo().Height + 1, // Counts pruned blocks too.
+		"peer_count":     s.Peers.Len(),
+		"ledger_entries": len(s.Blockchain.Balances()),
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
 }
 
-// metricsHandler returns dummy metrics for demonstration.
-func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
-	metrics := map[string]interface{}{
-		"transactions_per_second": 5.0,
-		"blocks_per_minute":       2.0,
-		"cpu_usage_percent":       15.0,
+// identityHandler returns the node's listen address, ID, protocol version and chain identity.
+func (s *Server) identityHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Node == nil {
+		http.Error(w, "P2P node not attached", http.StatusServiceUnavailable)
+		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(metrics)
-}
-
-// contains is a helper function to check if a slice contains a string.
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
+	cfg := s.Blockchain.Config
+	if cfg == nil {
+		cfg = blockchain.DefaultChainConfig()
 	}
-	return false
+	identity := map[string]interface{}{
+		"listen_address":   s.Node.Address,
+		"node_id":          s.Node.ID,
+		"protocol_version": p2p.ProtocolVersion,
+		"chain_id":         cfg.ChainID,
+		"hash_algorithm":   cfg.HashAlgorithm,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identity)
 }
 
 // In pkg/api/api.go, add:
 // deployContractHandler allows external developers to deploy a new contract.
+// With "upgrade" set it replaces the code of a deployed contract instead,
+// migrating its state with up to "gas_limit" gas.
 func (s *Server) deployContractHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		ContractName string `json:"contract_name"`
 		Code         string `json:"code"` // Hex-encoded WASM bytecode, for example.
+		Upgrade      bool   `json:"upgrade,omitempty"`
+		GasLimit     uint64 `json:"gas_limit,omitempty"` // Gas for the migration; see contract.GasLimit.
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request format", http.StatusBadRequest)
@@ -333,9 +713,27 @@ func (s *Server) deployContractHandler(w http.ResponseWriter, r *http.Request) {
 		Code: code,
 	}
 
+	if req.Upgrade {
+		version, err := s.DynamicRegistry.UpgradeContract(r.Context(), def, req.GasLimit)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, contract.ErrWASMUnsupported) {
+				status = http.StatusNotImplemented
+			}
+			http.Error(w, fmt.Sprintf("Error upgrading contract: %v", err), status)
+			return
+		}
+		fmt.Fprintf(w, "Contract upgraded to version %d", version)
+		return
+	}
+
 	// Register the contract dynamically.
 	if err := s.DynamicRegistry.RegisterContract(def); err != nil {
-		http.Error(w, fmt.Sprintf("Error registering contract: %v", err), http.StatusBadRequest)
+		status := http.StatusBadRequest
+		if errors.Is(err, contract.ErrWASMUnsupported) {
+			status = http.StatusNotImplemented
+		}
+		http.Error(w, fmt.Sprintf("Error registering contract: %v", err), status)
 		return
 	}
 
@@ -343,24 +741,82 @@ func (s *Server) deployContractHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Contract deployed successfully"))
 }
 
-// StartServer starts the API server on the specified port.
+// routes returns the handler serving every API endpoint. Reads, signed
+// transactions and contract calls are open to anyone; endpoints that
+// reconfigure the node need the admin token.
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chain", s.getChainHandler)
+	mux.HandleFunc("/headers", s.getHeadersHandler)
+	mux.HandleFunc("/block", s.getBlockHandler)
+	mux.HandleFunc("/blockByIndex", s.getBlockByIndexHandler)
+	mux.HandleFunc("/latestBlock", s.getLatestBlockHandler)
+	mux.HandleFunc("/subblocks", s.getSubBlocksHandler)
+	mux.HandleFunc("/balance", s.getBalanceHandler)
+	mux.HandleFunc("/balanceProof", s.getBalanceProofHandler)
+	mux.HandleFunc("/supply", s.getSupplyHandler)
+	mux.HandleFunc("/chainInfo", s.getChainInfoHandler)
+	mux.HandleFunc("/forks", s.getForksHandler)
+	mux.HandleFunc("/diff", s.getDiffHandler)
+	mux.HandleFunc("/ws/tx", s.txWebSocketHandler)
+	mux.HandleFunc("/subscribe", s.subscribeHandler)
+	mux.HandleFunc("/transaction", s.submitTransactionHandler)
+	mux.HandleFunc("/contract", s.executeContractHandler)
+	mux.HandleFunc("/contracts", s.listContractsHandler)
+	mux.HandleFunc("/peers", s.getPeersHandler)
+	mux.HandleFunc("/addPeer", s.requireToken(s.addPeerHandler))
+	mux.HandleFunc("/removePeer", s.requireToken(s.removePeerHandler))
+	mux.HandleFunc("/contractState", s.contractStateHandler)
+	mux.HandleFunc("/prune", s.requireToken(s.pruneHandler))
+	mux.HandleFunc("/admin/verifyIndex", s.requireToken(s.verifyIndexHandler))
+	mux.HandleFunc("/status", s.statusHandler)
+	mux.HandleFunc("/metrics", s.metricsHandler)
+	mux.HandleFunc("/metrics/prometheus", s.prometheusHandler)
+	mux.HandleFunc("/identity", s.identityHandler)
+	mux.HandleFunc("/deployContract", s.requireToken(s.deployContractHandler))
+	mux.HandleFunc("/unregisterContract", s.requireToken(s.unregisterContractHandler))
+	if s.Faucet != nil {
+		mux.HandleFunc("/faucet", s.faucetHandler)
+	}
+	return mux
+}
+
+// Handler returns the handler serving the API, with CORS applied. It does
+// not depend on http.DefaultServeMux, so several servers can run in one
+// process, for example under httptest.
+func (s *Server) Handler() http.Handler {
+	return s.withCORS(s.routes())
+}
+
+// StartServer starts the API server on the specified port of Host and
+// blocks until it stops. Use Shutdown to stop it.
 func (s *Server) StartServer(port string) {
-	http.HandleFunc("/chain", s.getChainHandler)
-	http.HandleFunc("/headers", s.getHeadersHandler)
-	http.HandleFunc("/block", s.getBlockHandler)
-	http.HandleFunc("/latestBlock", s.getLatestBlockHandler)
-	http.HandleFunc("/subblocks", s.getSubBlocksHandler)
-	http.HandleFunc("/balance", s.getBalanceHandler)
-	http.HandleFunc("/transaction", s.submitTransactionHandler)
-	http.HandleFunc("/contract", s.executeContractHandler)
-	http.HandleFunc("/peers", s.getPeersHandler)
-	http.HandleFunc("/addPeer", s.addPeerHandler)
-	http.HandleFunc("/removePeer", s.removePeerHandler)
-	http.HandleFunc("/contractState", s.contractStateHandler)
-	http.HandleFunc("/prune", s.pruneHandler)
-	http.HandleFunc("/status", s.statusHandler)
-	http.HandleFunc("/metrics", s.metricsHandler)
-	http.HandleFunc("/deployContract", s.deployContractHandler)
-	fmt.Printf("API server listening on port %s\n", port)
-	http.ListenAndServe(":"+port, nil)
+	handler := s.Handler()
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.httpServer = &http.Server{Addr: net.JoinHostPort(s.Host, port), Handler: handler}
+	srv := s.httpServer
+	s.mu.Unlock()
+
+	fmt.Printf("API server listening on %s\n", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Println("API server error:", err)
+	}
+}
+
+// Shutdown gracefully stops a server started with StartServer, waiting for
+// in-flight requests until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	srv := s.httpServer
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
 }
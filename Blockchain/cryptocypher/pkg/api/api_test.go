@@ -0,0 +1,931 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cryptocypher/pkg/blockchain"
+	"cryptocypher/pkg/contract"
+	"cryptocypher/pkg/p2p"
+	"cryptocypher/pkg/wallet"
+
+	"golang.org/x/net/websocket"
+)
+
+// testAdminToken is the admin token of servers made by newTestServer.
+const testAdminToken = "test-admin-token"
+
+// newTestServer returns a server over an empty chain and ledger.
+func newTestServer() *Server {
+	return NewServer(blockchain.NewBlockchain(), nil, contract.NewDynamicRegistry(), testAdminToken)
+}
+
+func TestIdentityHandler(t *testing.T) {
+	s := newTestServer()
+	s.Blockchain.Config.ChainID = "testnet"
+	s.Node = p2p.NewNode("127.0.0.1:9000", nil, s.Blockchain, "")
+
+	rec := httptest.NewRecorder()
+	s.identityHandler(rec, httptest.NewRequest(http.MethodGet, "/identity", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var identity struct {
+		ListenAddress   string `json:"listen_address"`
+		NodeID          string `json:"node_id"`
+		ProtocolVersion int    `json:"protocol_version"`
+		ChainID         string `json:"chain_id"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&identity); err != nil {
+		t.Fatal(err)
+	}
+	if identity.ListenAddress != "127.0.0.1:9000" || identity.NodeID != s.Node.ID ||
+		identity.ProtocolVersion != p2p.ProtocolVersion || identity.ChainID != "testnet" {
+		t.Errorf("identity does not match node configuration: %+v", identity)
+	}
+}
+
+func TestBalanceProofVerifiableByLightClient(t *testing.T) {
+	s := newTestServer()
+	alice, bob, charlie := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	txPool := &blockchain.TransactionPool{}
+	txPool.AddTransaction(signedTransfer(t, alice, bob.Address, 10, 1))
+	txPool.AddTransaction(signedTransfer(t, charlie, "Dave", 3, 1))
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "", txPool, 1, "Miner1", 12.5)
+	s.Blockchain.AddBlock(genesis)
+	txPool.Clear()
+	txPool.AddTransaction(signedTransfer(t, bob, alice.Address, 4, 2))
+	s.Blockchain.AddBlock(blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "", "", "", txPool, 1, "Miner1", 12.5))
+
+	rec := httptest.NewRecorder()
+	s.getBalanceProofHandler(rec, httptest.NewRequest(http.MethodGet, "/balanceProof?address="+bob.Address, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var proof blockchain.BalanceProof
+	if err := json.NewDecoder(rec.Body).Decode(&proof); err != nil {
+		t.Fatal(err)
+	}
+	if proof.Balance != 6 || len(proof.Transactions) != 2 {
+		t.Fatalf("unexpected proof: balance %f with %d transactions", proof.Balance, len(proof.Transactions))
+	}
+
+	// The light client only holds headers.
+	headers := s.Blockchain.ExtractHeaders()
+	if err := blockchain.VerifyBalanceProof(&proof, headers); err != nil {
+		t.Fatalf("light client rejected a valid proof: %v", err)
+	}
+	proof.Balance = 600
+	if err := blockchain.VerifyBalanceProof(&proof, headers); err == nil {
+		t.Error("light client accepted an inflated balance")
+	}
+}
+
+func newTestWallet(t *testing.T) *wallet.Wallet {
+	t.Helper()
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// signedTransfer returns a transaction from w to recipient, signed by w.
+func signedTransfer(t *testing.T, w *wallet.Wallet, recipient string, amount float64, nonce int) *blockchain.Transaction {
+	t.Helper()
+	tx := blockchain.NewTransaction(w.Address, recipient, amount, nonce)
+	if err := w.SignTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// signedTransactionBody returns the JSON body of a transaction signed by w.
+func signedTransactionBody(t *testing.T, w *wallet.Wallet, amount float64, nonce int) []byte {
+	t.Helper()
+	body, err := json.Marshal(signedTransfer(t, w, "Bob", amount, nonce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestSubmitTransactionBackpressure(t *testing.T) {
+	s := newTestServer()
+	s.TxPool = &blockchain.TransactionPool{MaxSize: 1}
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Blockchain.AttachLedger(blockchain.Ledger{w.Address: 100})
+
+	rec := httptest.NewRecorder()
+	s.submitTransactionHandler(rec, httptest.NewRequest(http.MethodPost, "/transaction",
+		bytes.NewReader(signedTransactionBody(t, w, 1, 1))))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected first submission to be accepted, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.submitTransactionHandler(rec, httptest.NewRequest(http.MethodPost, "/transaction",
+		bytes.NewReader(signedTransactionBody(t, w, 2, 2))))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 from a full pool, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After hint")
+	}
+	if len(s.TxPool.Transactions) != 1 {
+		t.Errorf("expected pool to stay at its cap, got %d", len(s.TxPool.Transactions))
+	}
+	if got := s.rejectedPoolFull.Load(); got != 1 {
+		t.Errorf("expected 1 rejection counted, got %d", got)
+	}
+}
+
+func TestSubmitTransactionIdempotencyKey(t *testing.T) {
+	s := newTestServer()
+	s.TxPool = &blockchain.TransactionPool{}
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Blockchain.AttachLedger(blockchain.Ledger{w.Address: 100})
+
+	submit := func(body []byte, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/transaction", bytes.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		s.submitTransactionHandler(rec, req)
+		return rec
+	}
+
+	if rec := submit(signedTransactionBody(t, w, 5, 1), "retry-1"); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected first submission to be accepted, got %d", rec.Code)
+	}
+	// The client retries after a timeout and re-signs with a fresh timestamp.
+	rec := submit(signedTransactionBody(t, w, 5, 2), "retry-1")
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expected replayed 202, got %d (replayed=%q)", rec.Code, rec.Header().Get("Idempotent-Replayed"))
+	}
+	if len(s.TxPool.Transactions) != 1 {
+		t.Fatalf("expected a single pool entry, got %d", len(s.TxPool.Transactions))
+	}
+
+	if rec := submit(signedTransactionBody(t, w, 5, 3), "retry-2"); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected submission with a new key to be accepted, got %d: %s", rec.Code, rec.Body)
+	}
+	if len(s.TxPool.Transactions) != 2 {
+		t.Errorf("expected a new key to add a transaction, got %d", len(s.TxPool.Transactions))
+	}
+}
+
+func TestMetricsReportTransactionRate(t *testing.T) {
+	s := newTestServer()
+	s.TxPool = &blockchain.TransactionPool{}
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Blockchain.AttachLedger(blockchain.Ledger{w.Address: 100})
+	genesis := blockchain.NewGenesisBlock(nil, 1)
+	s.Blockchain.AddBlock(genesis)
+	const submitted = 5
+	for nonce := 1; nonce <= submitted; nonce++ {
+		rec := httptest.NewRecorder()
+		s.submitTransactionHandler(rec, httptest.NewRequest(http.MethodPost, "/transaction",
+			bytes.NewReader(signedTransactionBody(t, w, 1, nonce))))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("submission %d: expected 202, got %d", nonce, rec.Code)
+		}
+	}
+
+	var metrics struct {
+		TPS         float64 `json:"transactions_per_second"`
+		MempoolSize int     `json:"mempool_size"`
+		MemoryAlloc uint64  `json:"memory_alloc_bytes"`
+	}
+	read := func() {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.metricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if err := json.NewDecoder(rec.Body).Decode(&metrics); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Pending transactions are not processed yet.
+	read()
+	if metrics.TPS != 0 {
+		t.Errorf("expected no processed transactions, got tps %v", metrics.TPS)
+	}
+	if metrics.MempoolSize != submitted {
+		t.Errorf("expected mempool size %d, got %d", submitted, metrics.MempoolSize)
+	}
+	if metrics.MemoryAlloc == 0 {
+		t.Error("expected a non-zero heap size")
+	}
+
+	// A block carrying them, wherever it was mined, counts them.
+	s.Blockchain.AddBlock(blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{Transactions: s.TxPool.Pending()}, 1, "Miner1", blockchain.DefaultBlockReward))
+	read()
+	if want := submitted / time.Minute.Seconds(); metrics.TPS != want {
+		t.Errorf("expected tps %v, got %v", want, metrics.TPS)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	s := newTestServer()
+	s.Blockchain.AddBlock(blockchain.NewGenesisBlock(nil, 1))
+	var mined atomic.Uint64
+	mined.Add(3)
+	s.MinedBlocks = &mined
+	payer := newTestWallet(t)
+	s.Blockchain.AttachLedger(blockchain.Ledger{payer.Address: 100})
+	params := map[string]interface{}{"a": 1, "b": 2}
+	payment := callPayment(t, payer, 1, float64(contract.DefaultGasLimit)*contract.GasPrice, "AdditionContract", "add", params, 0)
+	body, _ := json.Marshal(map[string]interface{}{
+		"contract_name": "AdditionContract",
+		"method":        "add",
+		"params":        params,
+		"payment":       payment,
+	})
+	s.executeContractHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/contract", bytes.NewReader(body)))
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/metrics/prometheus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	text, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE blockchain_height gauge",
+		"blockchain_height 0",
+		"# TYPE blocks_mined_total counter",
+		"blocks_mined_total 3",
+		"contract_executions_total 1",
+		"mempool_size 0",
+	} {
+		if !strings.Contains("\n"+string(text), "\n"+line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, text)
+		}
+	}
+}
+
+// callPayment returns a payment from w of fee for the given contract call.
+func callPayment(t *testing.T, w *wallet.Wallet, nonce int, fee float64, name, method string, params map[string]interface{}, gasLimit uint64) *blockchain.Transaction {
+	t.Helper()
+	payment := blockchain.NewTransaction(w.Address, w.Address, 1, nonce)
+	payment.Fee = fee
+	callHash, err := contract.CallHash(name, method, params, gasLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment.CallHash = callHash
+	if err := w.SignTransaction(payment); err != nil {
+		t.Fatal(err)
+	}
+	return payment
+}
+
+func TestContractCallsArePaid(t *testing.T) {
+	contract.RegisterContract(contract.AdditionContract{}) // Ignore the error if it is already registered.
+	s := newTestServer()
+	s.TxPool = &blockchain.TransactionPool{}
+	payer := newTestWallet(t)
+	s.Blockchain.AttachLedger(blockchain.Ledger{payer.Address: 1000})
+	params := map[string]interface{}{"a": 1, "b": 2}
+	send := func(gasLimit uint64, payment *blockchain.Transaction) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"contract_name": "AdditionContract",
+			"method":        "add",
+			"params":        params,
+			"gas_limit":     gasLimit,
+			"payment":       payment,
+		})
+		rec := httptest.NewRecorder()
+		s.executeContractHandler(rec, httptest.NewRequest(http.MethodPost, "/contract", bytes.NewReader(body)))
+		return rec
+	}
+	call := func(gasLimit uint64, fee float64, nonce int) *httptest.ResponseRecorder {
+		return send(gasLimit, callPayment(t, payer, nonce, fee, "AdditionContract", "add", params, gasLimit))
+	}
+
+	// An uncapped limit is priced at MaxGasLimit, not what was asked for.
+	maxCost := float64(contract.MaxGasLimit) * contract.GasPrice
+	if rec := call(math.MaxUint64, maxCost/2, 1); rec.Code != http.StatusPaymentRequired {
+		t.Errorf("expected 402 for a fee below the gas limit's cost, got %d", rec.Code)
+	}
+	if rec := call(math.MaxUint64, maxCost, 1); rec.Code != http.StatusOK {
+		t.Fatalf("expected a paid call to run, got %d: %s", rec.Code, rec.Body)
+	}
+	if len(s.TxPool.Transactions) != 1 || s.TxPool.Transactions[0].Fee != maxCost {
+		t.Errorf("expected the payment in the pool, got %v", s.TxPool.Transactions)
+	}
+
+	// A payment cannot be spent on another call, nor twice.
+	cost := float64(contract.DefaultGasLimit) * contract.GasPrice
+	other := callPayment(t, payer, 2, cost, "AdditionContract", "add", map[string]interface{}{"a": 5, "b": 5}, 0)
+	if rec := send(0, other); rec.Code != http.StatusPaymentRequired {
+		t.Errorf("expected 402 for a payment bound to another call, got %d", rec.Code)
+	}
+	plain := blockchain.NewTransaction(payer.Address, payer.Address, 1, 3)
+	plain.Fee = cost
+	payer.SignTransaction(plain)
+	if rec := send(0, plain); rec.Code != http.StatusPaymentRequired {
+		t.Errorf("expected 402 for a transfer not bound to a call, got %d", rec.Code)
+	}
+	pending := s.TxPool.Transactions[0]
+	if rec := send(math.MaxUint64, pending); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a payment already pending, got %d", rec.Code)
+	}
+	s.TxPool.Clear()
+	s.Blockchain.AddBlock(blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{Transactions: []*blockchain.Transaction{pending}}, 1, "Miner1", blockchain.DefaultBlockReward))
+	if rec := send(math.MaxUint64, pending); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a payment already on chain, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDiffOnlyFetchesKnownPeers(t *testing.T) {
+	s := newTestServer()
+	s.Node = p2p.NewNode("127.0.0.1:9000", nil, s.Blockchain, "")
+	rec := httptest.NewRecorder()
+	s.getDiffHandler(rec, httptest.NewRequest(http.MethodGet, "/diff?peer=169.254.169.254:80", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for an address outside the peer list, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestForksHandlerListsCompetingTip(t *testing.T) {
+	s := newTestServer()
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	s.Blockchain.AddBlock(genesis)
+	s.Blockchain.AddBlock(blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5))
+
+	competing := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "Other", "", "",
+		&blockchain.TransactionPool{}, 2, "Miner2", 12.5)
+	if err := s.Blockchain.AddSideBlock(competing); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.getForksHandler(rec, httptest.NewRequest(http.MethodGet, "/forks", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp struct {
+		Active blockchain.ForkTip   `json:"active"`
+		Forks  []blockchain.ForkTip `json:"forks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Active.Hash != s.Blockchain.Tip().Hash || resp.Active.CumulativeWork != 32 {
+		t.Errorf("unexpected active tip: %+v", resp.Active)
+	}
+	if len(resp.Forks) != 1 || resp.Forks[0].Hash != competing.Hash ||
+		resp.Forks[0].Height != 1 || resp.Forks[0].CumulativeWork != 272 {
+		t.Errorf("expected the competing block as the only fork, got %+v", resp.Forks)
+	}
+}
+
+func TestFaucetRateLimited(t *testing.T) {
+	s := newTestServer()
+	s.TxPool = &blockchain.TransactionPool{}
+	funder, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Blockchain.AttachLedger(blockchain.Ledger{funder.Address: 15})
+	s.Faucet = NewFaucet(funder, 10, time.Hour)
+
+	request := func(address, ip string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := strings.NewReader(`{"address":"` + address + `"}`)
+		req := httptest.NewRequest(http.MethodPost, "/faucet", body)
+		req.RemoteAddr = ip + ":1234"
+		s.faucetHandler(rec, req)
+		return rec
+	}
+
+	if rec := request("Alice", "192.0.2.1"); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	// The grant is a signed transfer from the faucet's wallet, not a credit.
+	pending := s.TxPool.Pending()
+	if len(pending) != 1 || pending[0].Sender != funder.Address || pending[0].Recipient != "Alice" || pending[0].Amount != 10 {
+		t.Fatalf("expected a pending grant of 10 to Alice, got %+v", pending)
+	}
+	if err := blockchain.VerifySignedTransaction(pending[0]); err != nil {
+		t.Errorf("grant is not signed by the faucet: %v", err)
+	}
+	if got := s.Blockchain.Balance("Alice"); got != 0 {
+		t.Errorf("expected no balance change before the grant is mined, got %v", got)
+	}
+
+	rec := request("Alice", "192.0.2.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 on immediate retry, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+	// The same client cannot dodge the limit by asking for another address.
+	if rec := request("Bob", "192.0.2.1"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 for a new address from the same client, got %d", rec.Code)
+	}
+	if s.TxPool.Len() != 1 {
+		t.Errorf("rate-limited requests added grants: %d pending", s.TxPool.Len())
+	}
+
+	// The pending grant leaves too little for another; the refusal does not
+	// count against the client.
+	if rec := request("Bob", "192.0.2.2"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 from a dry faucet, got %d", rec.Code)
+	}
+	s.Blockchain.AttachLedger(blockchain.Ledger{funder.Address: 25})
+	if rec := request("Bob", "192.0.2.2"); rec.Code != http.StatusAccepted {
+		t.Errorf("expected 202 once the faucet is funded, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSubmitTransactionAddsFundedTransactionToPool(t *testing.T) {
+	s := newTestServer()
+	s.TxPool = &blockchain.TransactionPool{}
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Blockchain.AttachLedger(blockchain.Ledger{w.Address: 5})
+
+	submit := func(amount float64, nonce int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.submitTransactionHandler(rec, httptest.NewRequest(http.MethodPost, "/transaction",
+			bytes.NewReader(signedTransactionBody(t, w, amount, nonce))))
+		return rec
+	}
+
+	if rec := submit(5, 1); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	if len(s.TxPool.Transactions) != 1 || s.TxPool.Transactions[0].Sender != w.Address {
+		t.Fatalf("expected the transaction in the pool, got %v", s.TxPool.Transactions)
+	}
+
+	if rec := submit(6, 2); rec.Code != http.StatusPaymentRequired {
+		t.Errorf("expected 402 for an unfunded transaction, got %d", rec.Code)
+	}
+	// The balance covers this one alone, but is already spent by the pending one.
+	if rec := submit(1, 3); rec.Code != http.StatusPaymentRequired {
+		t.Errorf("expected 402 for a transaction overspending pending ones, got %d", rec.Code)
+	}
+	if len(s.TxPool.Transactions) != 1 {
+		t.Errorf("unfunded transaction reached the pool: %d pending", len(s.TxPool.Transactions))
+	}
+}
+
+func TestSubmitTransactionRejectsNegativeAmount(t *testing.T) {
+	s := newTestServer()
+	s.TxPool = &blockchain.TransactionPool{}
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.submitTransactionHandler(rec, httptest.NewRequest(http.MethodPost, "/transaction",
+		bytes.NewReader(signedTransactionBody(t, w, -10, 1))))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative amount, got %d: %s", rec.Code, rec.Body)
+	}
+	if s.TxPool.Len() != 0 {
+		t.Errorf("negative transfer reached the pool: %d pending", s.TxPool.Len())
+	}
+}
+
+func TestTxWebSocketNotifiesInclusion(t *testing.T) {
+	defer func(saved time.Duration) { txWatchInterval = saved }(txWatchInterval)
+	txWatchInterval = 10 * time.Millisecond
+
+	s := newTestServer()
+	s.TxPool = &blockchain.TransactionPool{}
+	ts := httptest.NewServer(http.HandlerFunc(s.txWebSocketHandler))
+	defer ts.Close()
+	subscribe := func(hash string) *websocket.Conn {
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws/tx?hash="+hash, "", "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.SetDeadline(time.Now().Add(5 * time.Second))
+		return ws
+	}
+
+	tx := signedTransfer(t, newTestWallet(t), "Bob", 1, 1)
+	s.TxPool.AddTransaction(tx)
+	ws := subscribe(tx.CalculateHash())
+	defer ws.Close()
+
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "", s.TxPool, 1, "Miner1", 12.5)
+	s.Blockchain.AddBlock(genesis)
+	var status TxStatus
+	if err := websocket.JSON.Receive(ws, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "mined" || status.BlockHash != genesis.Hash || status.Hash != tx.CalculateHash() {
+		t.Errorf("unexpected notification: %+v", status)
+	}
+
+	// Subscribing to an already mined transaction answers at once.
+	late := subscribe(tx.CalculateHash())
+	defer late.Close()
+	if err := websocket.JSON.Receive(late, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "mined" || status.BlockIndex != genesis.Index {
+		t.Errorf("unexpected notification for a mined transaction: %+v", status)
+	}
+}
+
+func TestChainRangeAndBlockByIndex(t *testing.T) {
+	s := newTestServer()
+	prevHash := ""
+	for i := 0; i < 5; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		s.Blockchain.AddBlock(b)
+		prevHash = b.Hash
+	}
+
+	get := func(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	indexes := func(rec *httptest.ResponseRecorder) []int {
+		var blocks []*blockchain.Block
+		if err := json.NewDecoder(rec.Body).Decode(&blocks); err != nil {
+			t.Fatal(err)
+		}
+		out := []int{}
+		for _, b := range blocks {
+			out = append(out, b.Index)
+		}
+		return out
+	}
+
+	for target, want := range map[string][]int{
+		"/chain?from=1&to=3": {1, 2, 3},
+		"/chain?from=3":      {3, 4},
+		"/chain?from=2&to=9": {2, 3, 4},
+	} {
+		rec := get(s.getChainHandler, target)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", target, rec.Code)
+			continue
+		}
+		if got := indexes(rec); !slices.Equal(got, want) {
+			t.Errorf("%s: expected blocks %v, got %v", target, want, got)
+		}
+	}
+	for target, want := range map[string]int{
+		"/chain?from=5":         http.StatusNotFound,
+		"/chain?from=3&to=1":    http.StatusBadRequest,
+		"/chain?from=x":         http.StatusBadRequest,
+		"/chain?to=2":           http.StatusBadRequest,
+		"/blockByIndex?index=2": http.StatusOK,
+		"/blockByIndex?index=9": http.StatusNotFound,
+		"/blockByIndex?index=x": http.StatusBadRequest,
+	} {
+		handler := s.getChainHandler
+		if strings.HasPrefix(target, "/blockByIndex") {
+			handler = s.getBlockByIndexHandler
+		}
+		if rec := get(handler, target); rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", target, want, rec.Code)
+		}
+	}
+}
+
+func TestHeadersWindow(t *testing.T) {
+	s := newTestServer()
+	prevHash := ""
+	for i := 0; i < 6; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		s.Blockchain.AddBlock(b)
+		prevHash = b.Hash
+	}
+
+	rec := httptest.NewRecorder()
+	s.getHeadersHandler(rec, httptest.NewRequest(http.MethodGet, "/headers?from=2&count=3", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var headers []blockchain.LightBlockHeader
+	if err := json.NewDecoder(rec.Body).Decode(&headers); err != nil {
+		t.Fatal(err)
+	}
+	got := []int{}
+	for _, h := range headers {
+		got = append(got, h.Index)
+	}
+	if want := []int{2, 3, 4}; !slices.Equal(got, want) {
+		t.Errorf("expected headers %v, got %v", want, got)
+	}
+
+	for target, want := range map[string]int{
+		"/headers?from=4&count=10": http.StatusOK,
+		"/headers?from=6":          http.StatusNotFound,
+		"/headers?from=-1":         http.StatusBadRequest,
+		"/headers?from=0&count=0":  http.StatusBadRequest,
+		"/headers?count=2":         http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		s.getHeadersHandler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", target, want, rec.Code)
+		}
+	}
+}
+
+func TestLightBlockResponsesOmitMedia(t *testing.T) {
+	s := newTestServer()
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{"ReceiverA"}, "Text", "Audio", "Video",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	genesis.SubBlocks = []*blockchain.Block{{Index: 0, TextData: "Sub text"}}
+	s.Blockchain.AddBlock(genesis)
+
+	for target, handler := range map[string]http.HandlerFunc{
+		"/block?hash=" + genesis.Hash + "&light=true": s.getBlockHandler,
+		"/chain?light=true":                           s.getChainHandler,
+		"/chain?from=0&light=true":                    s.getChainHandler,
+	} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", target, rec.Code)
+		}
+		body := rec.Body.String()
+		for _, field := range []string{"text_data", "audio_data", "video_data"} {
+			if strings.Contains(body, field) {
+				t.Errorf("%s: light response contains %s: %s", target, field, body)
+			}
+		}
+		if !strings.Contains(body, genesis.Hash) || !strings.Contains(body, `"transactions"`) {
+			t.Errorf("%s: light response lost block metadata: %s", target, body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.getBlockHandler(rec, httptest.NewRequest(http.MethodGet, "/block?hash="+genesis.Hash, nil))
+	if !strings.Contains(rec.Body.String(), `"text_data":"Text"`) {
+		t.Errorf("expected the full response to keep media, got %s", rec.Body.String())
+	}
+}
+
+func TestListContractsHandler(t *testing.T) {
+	s := newTestServer()
+	if err := s.DynamicRegistry.RegisterContract(contract.ContractDefinition{Name: "Deployed", Code: []byte("\x00asm\x01\x00\x00\x00")}); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	s.listContractsHandler(rec, httptest.NewRequest(http.MethodGet, "/contracts", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp struct {
+		Builtin  []string `json:"builtin"`
+		Deployed []string `json:"deployed"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(resp.Deployed, []string{"Deployed"}) {
+		t.Errorf("expected the deployed contract listed, got %v", resp.Deployed)
+	}
+	if !slices.Equal(resp.Builtin, contract.ListContracts()) {
+		t.Errorf("expected built-in contracts %v, got %v", contract.ListContracts(), resp.Builtin)
+	}
+}
+
+func TestUnregisterContractHandler(t *testing.T) {
+	s := newTestServer()
+	if err := s.DynamicRegistry.RegisterContract(contract.ContractDefinition{Name: "Deployed", Code: []byte("\x00asm\x01\x00\x00\x00")}); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	s.unregisterContractHandler(rec, httptest.NewRequest(http.MethodPost, "/unregisterContract?contract=Deployed", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := s.DynamicRegistry.GetContract("Deployed"); err == nil {
+		t.Error("contract still registered")
+	}
+	rec = httptest.NewRecorder()
+	s.unregisterContractHandler(rec, httptest.NewRequest(http.MethodPost, "/unregisterContract?contract=Deployed", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown contract, got %d", rec.Code)
+	}
+}
+
+func TestMutatingEndpointsRequireToken(t *testing.T) {
+	s := newTestServer()
+	handler := s.routes()
+	addPeer := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/addPeer", strings.NewReader(`{"peer":"10.0.0.1:8000"}`))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, auth := range []string{"", "Bearer wrong-token", testAdminToken} {
+		if rec := addPeer(auth); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected 401, got %d", auth, rec.Code)
+		}
+	}
+	if peers := s.Peers.All(); len(peers) != 0 {
+		t.Fatalf("unauthorized request changed the peer list: %v", peers)
+	}
+	if rec := addPeer("Bearer " + testAdminToken); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 with the admin token, got %d: %s", rec.Code, rec.Body)
+	}
+	if !slices.Contains(s.Peers.All(), "10.0.0.1:8000") {
+		t.Error("authorized request did not add the peer")
+	}
+
+	// Read-only endpoints stay open.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/peers", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /peers to be open, got %d", rec.Code)
+	}
+
+	// Without a configured token, mutating endpoints are off.
+	s.AdminToken = ""
+	if rec := addPeer("Bearer "); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with no token configured, got %d", rec.Code)
+	}
+}
+
+func TestHandlerServesStatusInIsolation(t *testing.T) {
+	// Two servers in one process must not share routes.
+	a, b := newTestServer(), newTestServer()
+	a.Blockchain.AttachLedger(blockchain.Ledger{"Alice": 1})
+	a.CORSOrigins = []string{"https://dashboard.example"}
+	tsA, tsB := httptest.NewServer(a.Handler()), httptest.NewServer(b.Handler())
+	defer tsA.Close()
+	defer tsB.Close()
+
+	for _, tc := range []struct {
+		url     string
+		entries int
+	}{{tsA.URL, 1}, {tsB.URL, 0}} {
+		req, _ := http.NewRequest(http.MethodGet, tc.url+"/status", nil)
+		req.Header.Set("Origin", "https://dashboard.example")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status struct {
+			LedgerEntries int `json:"ledger_entries"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /status: %d (err %v)", resp.StatusCode, err)
+		}
+		if status.LedgerEntries != tc.entries {
+			t.Errorf("%s: expected %d ledger entries, got %d", tc.url, tc.entries, status.LedgerEntries)
+		}
+		allow := resp.Header.Get("Access-Control-Allow-Origin")
+		if tc.url == tsA.URL && allow != "https://dashboard.example" {
+			t.Errorf("expected the configured origin to be allowed, got %q", allow)
+		}
+		if tc.url == tsB.URL && allow != "" {
+			t.Errorf("expected no CORS headers without configured origins, got %q", allow)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodOptions, tsA.URL+"/transaction", nil)
+	req.Header.Set("Origin", "https://dashboard.example")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || !strings.Contains(resp.Header.Get("Access-Control-Allow-Methods"), "POST") {
+		t.Errorf("expected a preflight to be answered, got %d %v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestSubscribeStreamsBlocks(t *testing.T) {
+	s := newTestServer()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	subscribe := func(query string) *websocket.Conn {
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/subscribe"+query, "", "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.SetDeadline(time.Now().Add(5 * time.Second))
+		return ws
+	}
+	all := subscribe("")
+	defer all.Close()
+	contractState := subscribe("?category=contract_state&light=true")
+	defer contractState.Close()
+
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "media", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	s.Blockchain.AddBlock(genesis)
+	s.Blockchain.UpdateBlockWithSubBlockEx(0, "note", "", "", "text")
+	s.Blockchain.UpdateBlockWithSubBlockEx(0, "", "state changed", "", "contract_state")
+
+	var ev struct {
+		Type       string           `json:"type"`
+		Category   string           `json:"category"`
+		ParentHash string           `json:"parent_hash"`
+		Block      blockchain.Block `json:"block"`
+	}
+	for _, want := range []struct{ typ, category string }{{"block", "main"}, {"sub_block", "text"}, {"sub_block", "contract_state"}} {
+		if err := websocket.JSON.Receive(all, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != want.typ || ev.Category != want.category {
+			t.Errorf("expected a %s event of category %q, got %+v", want.typ, want.category, ev)
+		}
+	}
+	if ev.Block.Index != 0 || ev.ParentHash != genesis.Hash {
+		t.Errorf("sub-block event does not name its parent: %+v", ev)
+	}
+
+	// The filtered subscriber only hears about the contract_state sub-block.
+	ev.Block = blockchain.Block{}
+	if err := websocket.JSON.Receive(contractState, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != "sub_block" || ev.Category != "contract_state" || ev.Block.AudioData != "" {
+		t.Errorf("expected a light contract_state event, got %+v", ev)
+	}
+}
+
+func TestStatusAndLatestBlockAfterPruning(t *testing.T) {
+	s := newTestServer()
+	prevHash := ""
+	for i := 0; i < 5; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		s.Blockchain.AddBlock(b)
+		prevHash = b.Hash
+	}
+	if err := s.Blockchain.PruneAndArchive(2, filepath.Join(t.TempDir(), "archive")); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status struct {
+		BlockHeight int `json:"block_height"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.BlockHeight != 5 {
+		t.Errorf("expected pruned blocks to be counted, got block_height %d", status.BlockHeight)
+	}
+
+	rec = httptest.NewRecorder()
+	s.getLatestBlockHandler(rec, httptest.NewRequest(http.MethodGet, "/latestBlock", nil))
+	var latest blockchain.Block
+	if err := json.NewDecoder(rec.Body).Decode(&latest); err != nil {
+		t.Fatal(err)
+	}
+	if latest.Index != 4 || latest.Hash != prevHash {
+		t.Errorf("expected the tip, got block %d", latest.Index)
+	}
+}
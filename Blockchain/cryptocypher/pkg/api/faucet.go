@@ -0,0 +1,138 @@
+// File: pkg/api/faucet.go
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cryptocypher/pkg/blockchain"
+	"cryptocypher/pkg/wallet"
+)
+
+// Faucet hands out test funds through POST /faucet. Each grant is an
+// ordinary transaction signed by Wallet and added to the pool, so it is paid
+// out of Wallet's funds on chain once mined. Anyone may ask, so it must only
+// be enabled on test networks.
+type Faucet struct {
+	Wallet   *wallet.Wallet // Pays the grants.
+	Amount   float64        // Paid per successful request.
+	Cooldown time.Duration  // Minimum delay between payouts to one address or client IP.
+
+	mu    sync.Mutex
+	last  map[string]time.Time // "addr:<address>" or "ip:<host>" -> time of the last payout.
+	nonce int                  // Nonce of the last grant.
+}
+
+// NewFaucet returns a faucet paying amount from w at most once per cooldown
+// to each address and each client IP.
+func NewFaucet(w *wallet.Wallet, amount float64, cooldown time.Duration) *Faucet {
+	return &Faucet{Wallet: w, Amount: amount, Cooldown: cooldown, last: make(map[string]time.Time)}
+}
+
+// reserve records a payout to address from ip, or returns how long the
+// caller must wait if either was paid within the cooldown.
+func (f *Faucet) reserve(address, ip string, now time.Time) (wait time.Duration, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, at := range f.last {
+		if now.Sub(at) >= f.Cooldown {
+			delete(f.last, key)
+		}
+	}
+	keys := []string{"addr:" + address, "ip:" + ip}
+	for _, key := range keys {
+		if at, seen := f.last[key]; seen {
+			if remaining := f.Cooldown - now.Sub(at); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+	for _, key := range keys {
+		f.last[key] = now
+	}
+	return 0, true
+}
+
+// release forgets the payout reserve recorded for address and ip, for a
+// grant that could not be paid.
+func (f *Faucet) release(address, ip string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.last, "addr:"+address)
+	delete(f.last, "ip:"+ip)
+}
+
+// grant returns a transaction paying the faucet amount to address, signed
+// by the faucet's wallet.
+func (f *Faucet) grant(address string) (*blockchain.Transaction, error) {
+	f.mu.Lock()
+	f.nonce++
+	nonce := f.nonce
+	f.mu.Unlock()
+	tx := blockchain.NewTransaction(f.Wallet.Address, address, f.Amount, nonce)
+	if err := f.Wallet.SignTransaction(tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// faucetHandler adds a transaction paying the faucet amount to the
+// requested address to the pool and relays it to peers. A faucet whose
+// wallet cannot cover the grant, counting its grants still pending, answers
+// 503 without holding the cooldown against the caller.
+func (s *Server) faucetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.TxPool == nil {
+		http.Error(w, "Transaction pool not attached", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		Address string `json:"address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Address == "" {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if wait, ok := s.Faucet.reserve(req.Address, ip, time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Faucet already used recently; try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	tx, err := s.Faucet.grant(req.Address)
+	if err == nil {
+		err = s.TxPool.AddFundedTransaction(tx, s.Blockchain.Balance(s.Faucet.Wallet.Address))
+	}
+	if err != nil {
+		s.Faucet.release(req.Address, ip)
+		http.Error(w, "Faucet cannot pay: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if s.Node != nil {
+		s.Node.BroadcastTransaction(tx)
+	}
+	fmt.Printf("Faucet granted %f to %s\n", s.Faucet.Amount, req.Address)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"address": req.Address,
+		"amount":  s.Faucet.Amount,
+		"tx_hash": tx.CalculateHash(),
+	})
+}
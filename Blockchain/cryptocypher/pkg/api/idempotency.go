@@ -0,0 +1,116 @@
+// File: pkg/api/idempotency.go
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// idempotencyHeader lets clients retry a submission without risking a duplicate.
+	idempotencyHeader = "Idempotency-Key"
+	// idempotencyTTL is how long the result for a key is remembered.
+	idempotencyTTL = 10 * time.Minute
+)
+
+// idempotentEntry is the recorded outcome of the first request with a key.
+// done is closed once the outcome is known; status stays zero if the first
+// request failed with a server error and was not recorded.
+type idempotentEntry struct {
+	done    chan struct{}
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// idempotencyCache remembers responses by Idempotency-Key. The zero value is ready to use.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotentEntry
+}
+
+// recordingWriter captures the status and body written through it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
+
+// serve runs next for the first request carrying a given key and replays
+// its response for later requests with the same key. Requests without a key,
+// and retries after a server error, always reach next.
+func (c *idempotencyCache) serve(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := r.Header.Get(idempotencyHeader)
+	if key == "" {
+		next(w, r)
+		return
+	}
+
+	var entry *idempotentEntry
+	for entry == nil {
+		c.mu.Lock()
+		if c.entries == nil {
+			c.entries = make(map[string]*idempotentEntry)
+		}
+		c.expireLocked(time.Now())
+		existing, ok := c.entries[key]
+		if !ok {
+			entry = &idempotentEntry{done: make(chan struct{})}
+			c.entries[key] = entry
+			c.mu.Unlock()
+			break
+		}
+		c.mu.Unlock()
+
+		// Wait for the in-flight request with this key to finish.
+		<-existing.done
+		if existing.status != 0 {
+			for name, values := range existing.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.status)
+			w.Write(existing.body)
+			return
+		}
+	}
+
+	rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+	next(rec, r)
+
+	c.mu.Lock()
+	if rec.status >= http.StatusInternalServerError {
+		// Server errors such as a full pool are worth retrying.
+		delete(c.entries, key)
+	} else {
+		entry.status = rec.status
+		entry.header = w.Header().Clone()
+		entry.body = rec.body.Bytes()
+		entry.expires = time.Now().Add(idempotencyTTL)
+	}
+	c.mu.Unlock()
+	close(entry.done)
+}
+
+// expireLocked forgets recorded responses older than idempotencyTTL.
+// The caller must hold c.mu.
+func (c *idempotencyCache) expireLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
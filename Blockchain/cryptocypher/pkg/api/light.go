@@ -0,0 +1,56 @@
+// File: pkg/api/light.go
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+// lightBlock encodes a block without its media payloads. Its fields
+// shadow those of the embedded block, and nil pointers are omitted.
+type lightBlock struct {
+	*blockchain.Block
+	TextData  *string      `json:"text_data,omitempty"`
+	AudioData *string      `json:"audio_data,omitempty"`
+	VideoData *string      `json:"video_data,omitempty"`
+	SubBlocks []lightBlock `json:"sub_blocks"`
+}
+
+func newLightBlock(b *blockchain.Block) lightBlock {
+	return lightBlock{Block: b, SubBlocks: newLightBlocks(b.SubBlocks)}
+}
+
+func newLightBlocks(blocks []*blockchain.Block) []lightBlock {
+	if blocks == nil {
+		return nil
+	}
+	out := make([]lightBlock, len(blocks))
+	for i, b := range blocks {
+		out[i] = newLightBlock(b)
+	}
+	return out
+}
+
+// wantsLight reports whether r asks for blocks without media with ?light=true.
+func wantsLight(r *http.Request) bool {
+	light, _ := strconv.ParseBool(r.URL.Query().Get("light"))
+	return light
+}
+
+// projectBlock returns what to encode for b in the response to r.
+func projectBlock(r *http.Request, b *blockchain.Block) interface{} {
+	if wantsLight(r) {
+		return newLightBlock(b)
+	}
+	return b
+}
+
+// projectBlocks returns what to encode for blocks in the response to r.
+func projectBlocks(r *http.Request, blocks []*blockchain.Block) interface{} {
+	if wantsLight(r) {
+		return newLightBlocks(blocks)
+	}
+	return blocks
+}
@@ -0,0 +1,92 @@
+// File: pkg/api/metrics.go
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/metrics"
+	"time"
+)
+
+// metricsWindow is the span over which rates are averaged.
+const metricsWindow = time.Minute
+
+// cpuBusySeconds returns the CPU time the process has spent not idle, as
+// estimated by the Go runtime.
+func cpuBusySeconds() float64 {
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/total:cpu-seconds"},
+		{Name: "/cpu/classes/idle:cpu-seconds"},
+	}
+	metrics.Read(samples)
+	for _, s := range samples {
+		if s.Value.Kind() != metrics.KindFloat64 {
+			return 0
+		}
+	}
+	return samples[0].Value.Float64() - samples[1].Value.Float64()
+}
+
+// metricsHandler reports transaction and block rates, the mempool size and
+// the process's CPU and memory use. Both rates come from the blocks on the
+// chain timestamped within metricsWindow, so transactions count once they
+// are processed into a block, whether mined here or received from a peer.
+// CPU use is averaged over the server's uptime across GOMAXPROCS cores.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	mempool := 0
+	if s.TxPool != nil {
+		mempool = s.TxPool.Len()
+	}
+	cpu := 0.0
+	if uptime := now.Sub(s.StartTime).Seconds(); uptime > 0 {
+		cpu = 100 * cpuBusySeconds() / (uptime * float64(runtime.GOMAXPROCS(0)))
+	}
+	since := now.Add(-metricsWindow)
+	metrics := map[string]interface{}{
+		"transactions_per_second": float64(s.Blockchain.TransactionsSince(since)) / metricsWindow.Seconds(),
+		"blocks_per_minute":       float64(s.Blockchain.BlocksSince(since)) / metricsWindow.Minutes(),
+		"mempool_size":            mempool,
+		"mempool_rejected_full":   s.rejectedPoolFull.Load(),
+		"cpu_usage_percent":       min(cpu, 100),
+		"memory_alloc_bytes":      mem.HeapAlloc,
+		"memory_sys_bytes":        mem.Sys,
+		"goroutines":              runtime.NumGoroutine(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// promMetric is one sample in the Prometheus text exposition format.
+type promMetric struct {
+	name, help, kind string // kind is "counter" or "gauge".
+	value            float64
+}
+
+// prometheusHandler serves the node's counters and gauges in the Prometheus
+// text exposition format, for scraping.
+func (s *Server) prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	mempool := 0
+	if s.TxPool != nil {
+		mempool = s.TxPool.Len()
+	}
+	var mined uint64
+	if s.MinedBlocks != nil {
+		mined = s.MinedBlocks.Load()
+	}
+	samples := []promMetric{
+		{"blockchain_height", "Index of the chain tip, or -1 for an empty chain.", "gauge", float64(s.Blockchain.ChainInfo().Height)},
+		{"mempool_size", "Transactions waiting in the pool.", "gauge", float64(mempool)},
+		{"peer_count", "Known peers.", "gauge", float64(s.Peers.Len())},
+		{"blocks_mined_total", "Blocks mined by this node.", "counter", float64(mined)},
+		{"contract_executions_total", "Contract executions requested through /contract.", "counter", float64(s.contractExecutions.Load())},
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range samples {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
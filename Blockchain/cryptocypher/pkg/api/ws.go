@@ -0,0 +1,187 @@
+// File: pkg/api/ws.go
+package api
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"cryptocypher/pkg/blockchain"
+
+	"golang.org/x/net/websocket"
+)
+
+// Polling of the chain and pool behind /ws/tx. Variables so tests can shorten them.
+var (
+	txWatchInterval = time.Second
+	txWatchTimeout  = 10 * time.Minute
+)
+
+// TxStatus is the notification pushed over /ws/tx.
+type TxStatus struct {
+	Hash       string `json:"hash"`
+	Status     string `json:"status"` // "mined", "dropped" or "expired".
+	BlockIndex int    `json:"block_index,omitempty"`
+	BlockHash  string `json:"block_hash,omitempty"`
+}
+
+// txWebSocketHandler serves GET /ws/tx?hash=. It pushes a single TxStatus
+// once the transaction is in a block, leaves the pool without being mined,
+// or is still unresolved after txWatchTimeout, and then closes.
+func (s *Server) txWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	hash := r.URL.Query().Get("hash")
+	if hash == "" {
+		http.Error(w, "Missing hash parameter", http.StatusBadRequest)
+		return
+	}
+	// Subscribe before looking the transaction up, so that a block added
+	// in between is not missed.
+	events, cancel, err := s.Blockchain.Subscribe(subscribeBuffer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer cancel()
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		// Clients do not send anything; a read returning means they left.
+		gone := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, ws)
+			close(gone)
+		}()
+		if status, ok := s.watchTransaction(hash, events, gone); ok {
+			websocket.JSON.Send(ws, status)
+		}
+	}}.ServeHTTP(w, r)
+}
+
+// watchTransaction waits until hash is resolved, the client is gone, or
+// the server shuts down. The chain is searched once; blocks added after
+// that are checked as they arrive on events. Only the pool is polled. It
+// returns false if there is nothing to report.
+func (s *Server) watchTransaction(hash string, events <-chan blockchain.BlockEvent, gone <-chan struct{}) (TxStatus, bool) {
+	mined := func(b *blockchain.Block) TxStatus {
+		return TxStatus{Hash: hash, Status: "mined", BlockIndex: b.Index, BlockHash: b.Hash}
+	}
+	if b, err := s.Blockchain.FindTransactionBlock(hash); err == nil {
+		return mined(b), true
+	}
+	deadline := time.NewTimer(txWatchTimeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(txWatchInterval)
+	defer poll.Stop()
+	seenPending := s.TxPool != nil && s.TxPool.Has(hash)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Parent == nil && carriesTransaction(ev.Block, hash) {
+				return mined(ev.Block), true
+			}
+		case <-poll.C:
+			s.mu.Lock()
+			stopped := s.stopped
+			s.mu.Unlock()
+			if stopped {
+				return TxStatus{}, false
+			}
+			if s.TxPool == nil {
+				continue
+			}
+			pending := s.TxPool.Has(hash)
+			if seenPending && !pending {
+				// The miner removes transactions from the pool only after
+				// their block is added, so the event may still be queued.
+				if b, err := s.Blockchain.FindTransactionBlock(hash); err == nil {
+					return mined(b), true
+				}
+				return TxStatus{Hash: hash, Status: "dropped"}, true
+			}
+			seenPending = seenPending || pending
+		case <-deadline.C:
+			return TxStatus{Hash: hash, Status: "expired"}, true
+		case <-gone:
+			return TxStatus{}, false
+		}
+	}
+}
+
+// carriesTransaction reports whether b includes the transaction with hash.
+func carriesTransaction(b *blockchain.Block, hash string) bool {
+	for _, tx := range b.Transactions {
+		if tx.CalculateHash() == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribeBuffer is how many block events a /subscribe client may lag behind
+// before further events are dropped for it.
+const subscribeBuffer = 64
+
+// BlockEvent is the notification pushed over /subscribe.
+type BlockEvent struct {
+	Type       string      `json:"type"`                  // "block" or "sub_block".
+	Category   string      `json:"category,omitempty"`    // Category of the block, e.g. "contract_state".
+	ParentHash string      `json:"parent_hash,omitempty"` // For a sub-block, the block it was added to.
+	Reorg      bool        `json:"reorg,omitempty"`       // The block replaced the active chain's blocks at its height.
+	Block      interface{} `json:"block"`
+}
+
+// subscribeHandler serves GET /subscribe. It pushes a BlockEvent for every
+// block joining the chain, including those a reorganisation brings in, and
+// every sub-block added to a block until the client leaves. With ?category= only blocks of that category are sent, for
+// example contract_state sub-blocks; ?light=true omits media payloads.
+func (s *Server) subscribeHandler(w http.ResponseWriter, r *http.Request) {
+	category := r.URL.Query().Get("category")
+	// Subscribe before the handshake completes so that the client sees
+	// every block added once it is connected.
+	events, cancel, err := s.Blockchain.Subscribe(subscribeBuffer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer cancel()
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		gone := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, ws)
+			close(gone)
+		}()
+		poll := time.NewTicker(txWatchInterval)
+		defer poll.Stop()
+		for {
+			select {
+			case ev := <-events:
+				if category != "" && ev.Block.Category != category {
+					continue
+				}
+				if err := websocket.JSON.Send(ws, newBlockEvent(r, ev)); err != nil {
+					return
+				}
+			case <-gone:
+				return
+			case <-poll.C:
+				// Hijacked connections outlive Shutdown; end them here.
+				s.mu.Lock()
+				stopped := s.stopped
+				s.mu.Unlock()
+				if stopped {
+					return
+				}
+			}
+		}
+	}}.ServeHTTP(w, r)
+}
+
+// newBlockEvent encodes ev for the client that made request r.
+func newBlockEvent(r *http.Request, ev blockchain.BlockEvent) BlockEvent {
+	out := BlockEvent{Type: "block", Category: ev.Block.Category, Reorg: ev.Reorg, Block: projectBlock(r, ev.Block)}
+	if ev.Parent != nil {
+		out.Type = "sub_block"
+		out.ParentHash = ev.Parent.Hash
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+// File: pkg/blockchain/address.go
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// addressVersion is the first byte of every encoded address.
+const addressVersion = 0x1c
+
+// addressHashLen is the length of the public key hash inside an address.
+const addressHashLen = 20
+
+// ErrInvalidAddress is returned for strings that are not well-formed
+// addresses, including those whose checksum does not match.
+var ErrInvalidAddress = errors.New("invalid address")
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// AddressFromPublicKey derives the address of an uncompressed public key:
+// the version byte and the first 20 bytes of the key's SHA-256, followed by
+// a 4-byte double-SHA-256 checksum, in base58.
+func AddressFromPublicKey(pubKey []byte) string {
+	sum := sha256.Sum256(pubKey)
+	payload := append([]byte{addressVersion}, sum[:addressHashLen]...)
+	return base58Encode(append(payload, addressChecksum(payload)...))
+}
+
+// ValidateAddress checks that addr is a well-formed address with a valid
+// checksum, so that mistyped addresses are caught before funds are sent.
+func ValidateAddress(addr string) error {
+	raw, ok := base58Decode(addr)
+	if !ok || len(raw) != 1+addressHashLen+4 || raw[0] != addressVersion {
+		return ErrInvalidAddress
+	}
+	payload, checksum := raw[:1+addressHashLen], raw[1+addressHashLen:]
+	if !bytes.Equal(checksum, addressChecksum(payload)) {
+		return ErrInvalidAddress
+	}
+	return nil
+}
+
+// AddressMatchesKey reports whether addr is the address of pubKey.
+func AddressMatchesKey(addr string, pubKey []byte) bool {
+	return ValidateAddress(addr) == nil && AddressFromPublicKey(pubKey) == addr
+}
+
+func addressChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
+
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	// Leading zero bytes are kept as leading '1's.
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58Decode(s string) ([]byte, bool) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range []byte(s) {
+		digit := strings.IndexByte(base58Alphabet, c)
+		if digit < 0 {
+			return nil, false
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), true
+}
@@ -0,0 +1,58 @@
+package blockchain_test
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func testPublicKey(d int64) []byte {
+	x, y := elliptic.P256().ScalarBaseMult(big.NewInt(d).Bytes())
+	return elliptic.Marshal(elliptic.P256(), x, y)
+}
+
+func TestAddressIsDeterministic(t *testing.T) {
+	pub := testPublicKey(42)
+	addr := blockchain.AddressFromPublicKey(pub)
+	if again := blockchain.AddressFromPublicKey(pub); again != addr {
+		t.Fatalf("same key gave %s and %s", addr, again)
+	}
+	if other := blockchain.AddressFromPublicKey(testPublicKey(43)); other == addr {
+		t.Fatal("different keys gave the same address")
+	}
+	if len(addr) > 40 {
+		t.Errorf("address %s is %d characters long", addr, len(addr))
+	}
+	if err := blockchain.ValidateAddress(addr); err != nil {
+		t.Fatal(err)
+	}
+	if !blockchain.AddressMatchesKey(addr, pub) {
+		t.Error("address does not match the key it came from")
+	}
+	if blockchain.AddressMatchesKey(addr, testPublicKey(43)) {
+		t.Error("address matches another key")
+	}
+}
+
+func TestAddressChecksumRejectsTypos(t *testing.T) {
+	addr := blockchain.AddressFromPublicKey(testPublicKey(42))
+	for i := range addr {
+		typo := []byte(addr)
+		if typo[i] == 'z' {
+			typo[i] = 'y'
+		} else {
+			typo[i] = 'z'
+		}
+		if err := blockchain.ValidateAddress(string(typo)); err == nil {
+			t.Errorf("typo %s at position %d was accepted", typo, i)
+		}
+	}
+	if err := blockchain.ValidateAddress(addr[:len(addr)-1]); err == nil {
+		t.Error("truncated address was accepted")
+	}
+	if err := blockchain.ValidateAddress("0OIl"); err == nil {
+		t.Error("address outside the base58 alphabet was accepted")
+	}
+}
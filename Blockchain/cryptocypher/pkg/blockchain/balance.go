@@ -0,0 +1,50 @@
+// File: pkg/blockchain/balance.go
+package blockchain
+
+// BalanceBreakdown splits an address's balance by where the funds came from
+// and whether they can be spent yet.
+type BalanceBreakdown struct {
+	Address          string  `json:"address"`
+	Spendable        float64 `json:"spendable"`
+	ImmatureCoinbase float64 `json:"immature_coinbase"`
+	Pending          float64 `json:"pending"`
+	Total            float64 `json:"total"`
+}
+
+// BalanceBreakdown computes addr's balance from the whole chain, reading
+// blocks no longer held in memory from the store. Coinbase rewards younger
+// than the configured maturity are reported as immature, and the net
+// effect of pool transactions as pending. pool may be nil.
+func (bc *Blockchain) BalanceBreakdown(addr string, pool *TransactionPool) (BalanceBreakdown, error) {
+	maturity := bc.config().CoinbaseMaturity
+	out := BalanceBreakdown{Address: addr}
+
+	bc.mu.Lock()
+	tip := -1
+	if len(bc.Blocks) > 0 {
+		tip = bc.Blocks[len(bc.Blocks)-1].Index
+	}
+	err := bc.walkChainLocked(func(blk *Block) error {
+		for _, tx := range blk.Transactions {
+			delta := balanceDelta(tx, addr)
+			if tx.IsCoinbase() && tip-blk.Index < maturity {
+				out.ImmatureCoinbase += delta
+			} else {
+				out.Spendable += delta
+			}
+		}
+		return nil
+	})
+	bc.mu.Unlock()
+	if err != nil {
+		return BalanceBreakdown{}, err
+	}
+
+	if pool != nil {
+		for _, tx := range pool.Pending() {
+			out.Pending += balanceDelta(tx, addr)
+		}
+	}
+	out.Total = out.Spendable + out.ImmatureCoinbase + out.Pending
+	return out, nil
+}
@@ -0,0 +1,76 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestBalanceBreakdown(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	bc.Config.CoinbaseMaturity = 2
+	miner := testWallet(t, 1)
+
+	txPool := &blockchain.TransactionPool{}
+	prevHash := ""
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			txPool.AddTransaction(signedTx(t, miner, "Bob", 5, 1))
+		}
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "", txPool, 1, miner.Address, 12.5)
+		bc.AddBlock(b)
+		prevHash = b.Hash
+		txPool.Clear()
+	}
+	pending := &blockchain.TransactionPool{}
+	pending.AddTransaction(blockchain.NewTransaction("Bob", miner.Address, 1, 1))
+
+	got, err := bc.BalanceBreakdown(miner.Address, pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the reward at height 0 is buried deep enough to spend.
+	if got.ImmatureCoinbase != 25 {
+		t.Errorf("expected 25 immature, got %v", got.ImmatureCoinbase)
+	}
+	if got.Spendable != 7.5 || got.Pending != 1 {
+		t.Errorf("expected 7.5 spendable and 1 pending, got %+v", got)
+	}
+	if got.Spendable+got.ImmatureCoinbase+got.Pending != got.Total || got.Total != 33.5 {
+		t.Errorf("breakdown does not sum to total: %+v", got)
+	}
+}
+
+func TestBalanceViewsReadEvictedBlocks(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	bc.Config.CoinbaseMaturity = 2
+	bc.Store = openTestDB(t)
+	bc.CacheWindow = 2
+	miner := testWallet(t, 1)
+
+	prevHash := ""
+	for i := 0; i < 5; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, miner.Address, 12.5)
+		bc.AddBlock(b)
+		prevHash = b.Hash
+	}
+	if bc.Blocks[0].Index != 3 {
+		t.Fatalf("expected blocks 0-2 to be evicted, window starts at %d", bc.Blocks[0].Index)
+	}
+
+	got, err := bc.BalanceBreakdown(miner.Address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Spendable != 37.5 || got.ImmatureCoinbase != 25 {
+		t.Errorf("expected 37.5 spendable and 25 immature across the store, got %+v", got)
+	}
+	proof, err := bc.BalanceProof(miner.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof.Transactions) != 5 || proof.Balance != 62.5 {
+		t.Errorf("expected proofs for all 5 rewards worth 62.5, got %d worth %v", len(proof.Transactions), proof.Balance)
+	}
+}
@@ -2,17 +2,19 @@
 package blockchain
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Block represents a single block in the blockchain.
 type Block struct {
 	Index            int            `json:"index"`
-	Timestamp        int64          `json:"timestamp"`
+	Timestamp        int64          `json:"timestamp"`                  // Unix seconds.
+	TimestampMillis  int64          `json:"timestamp_millis,omitempty"` // Unix milliseconds; 0 in blocks from before it was recorded.
 	PrevHash         string         `json:"prev_hash"`
 	Hash             string         `json:"hash"`
 	Nonce            int            `json:"nonce"`
@@ -25,25 +27,52 @@ type Block struct {
 	SubBlocks        []*Block       `json:"sub_blocks"`
 	Difficulty       int            `json:"difficulty"` // New field representing block difficulty.
 	Category         string         `json:"category"`
+	Version          int            `json:"version,omitempty"` // How the block is hashed; see BlockVersion.
 }
 
-// CalculateHash computes a SHA‑256 hash based on the block's data.
-// The difficulty is now incorporated in the record to be hashed.
+// BlockVersion is the version of the blocks this package creates. Version 1
+// blocks are hashed through their light header, with the payload committed
+// to by a digest, so a header alone is enough to recompute the hash.
+// Version 0 blocks hash their fields directly and keep doing so.
+const BlockVersion = 1
+
+// CalculateHash computes the block's hash with DefaultHasher.
 func CalculateHash(b *Block) string {
-	record := fmt.Sprintf("%d%d%s%s%s%s%s%s%d%d",
-		b.Index,
-		b.Timestamp,
-		b.PrevHash,
-		b.RelationshipType,
-		b.TextData,
-		b.AudioData,
-		b.VideoData,
-		serializeReceivers(b.Receivers),
-		b.Difficulty,
-		b.Nonce,
-		b.Category)
-	h := sha256.Sum256([]byte(record))
-	return hex.EncodeToString(h[:])
+	return CalculateHashWith(b, DefaultHasher)
+}
+
+// CalculateHashWith computes a hash based on the block's data using h.
+// The difficulty is now incorporated in the record to be hashed, and the
+// transactions are committed to through their Merkle root.
+func CalculateHashWith(b *Block, h Hasher) string {
+	if b.Version >= 1 {
+		return b.HeaderWith(h).HashWith(h)
+	}
+	var record strings.Builder
+	fmt.Fprintf(&record, "%d%d%s", b.Index, b.Timestamp, b.PrevHash)
+	fmt.Fprintf(&record, "%s%s%s%s", b.RelationshipType, b.TextData, b.AudioData, b.VideoData)
+	fmt.Fprintf(&record, "%s%s", serializeReceivers(b.Receivers), MerkleRootWith(b.Transactions, h))
+	fmt.Fprintf(&record, "%d%d%s", b.Difficulty, b.Nonce, b.Category)
+	// Only hashed when set, so blocks from before it keep their hashes.
+	if b.TimestampMillis != 0 {
+		fmt.Fprintf(&record, "|%d", b.TimestampMillis)
+	}
+	return hexDigest(h, record.String())
+}
+
+// Millis returns the block's timestamp in Unix milliseconds, falling back
+// to the whole seconds of blocks that predate TimestampMillis.
+func (b *Block) Millis() int64 {
+	if b.TimestampMillis != 0 {
+		return b.TimestampMillis
+	}
+	return b.Timestamp * 1000
+}
+
+// stamp sets both timestamps of b to t.
+func (b *Block) stamp(t time.Time) {
+	b.Timestamp = t.Unix()
+	b.TimestampMillis = t.UnixMilli()
 }
 
 // serializeReceivers converts the slice of receivers into a string.
@@ -51,14 +80,52 @@ func serializeReceivers(receivers []string) string {
 	return fmt.Sprintf("%v", receivers)
 }
 
+// ErrNonceExhausted is returned when mining reaches its nonce limit without a valid hash.
+var ErrNonceExhausted = errors.New("nonce limit reached without a valid hash")
+
+// miningCheckInterval is how many nonces are tried between cancellation checks.
+const miningCheckInterval = 1024
+
+// MeetsDifficulty reports whether hash starts with difficulty zeros. A
+// negative difficulty is never met.
+func MeetsDifficulty(hash string, difficulty int) bool {
+	if difficulty < 0 {
+		return false
+	}
+	return strings.HasPrefix(hash, strings.Repeat("0", difficulty))
+}
+
+// MineBlock searches for a nonce that satisfies difficulty, without a deadline.
 func MineBlock(b *Block, difficulty int) {
-	target := strings.Repeat("0", difficulty)
+	MineBlockContext(context.Background(), b, difficulty)
+}
+
+// MineBlockContext searches for a nonce that satisfies difficulty. It stops
+// with ctx's error once ctx is done.
+func MineBlockContext(ctx context.Context, b *Block, difficulty int) error {
+	return MineBlockLimit(ctx, b, difficulty, 0)
+}
+
+// MineBlockLimit is MineBlockContext that also stops with ErrNonceExhausted
+// once the nonce reaches maxNonce; 0 means no limit.
+func MineBlockLimit(ctx context.Context, b *Block, difficulty, maxNonce int) error {
+	if difficulty < 0 {
+		return fmt.Errorf("block %d: negative difficulty %d", b.Index, difficulty)
+	}
 	for {
 		b.Hash = CalculateHash(b)
-		if strings.HasPrefix(b.Hash, target) {
-			break
+		if MeetsDifficulty(b.Hash, difficulty) {
+			return nil
+		}
+		if maxNonce > 0 && b.Nonce >= maxNonce {
+			return fmt.Errorf("block %d: %w", b.Index, ErrNonceExhausted)
 		}
 		b.Nonce++
+		if b.Nonce%miningCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
 	}
 }
 
@@ -67,50 +134,319 @@ func MineBlock(b *Block, difficulty int) {
 // Now it also takes a minerAddress and reward amount for the coinbase transaction.
 func CreateBlock(index int, prevHash string, relationshipType string, receivers []string,
 	text, audio, video string, txPool *TransactionPool, difficulty int, minerAddress string, reward float64) *Block {
+	block, _ := CreateBlockContext(context.Background(), index, prevHash, relationshipType, receivers,
+		text, audio, video, txPool, difficulty, minerAddress, reward)
+	return block
+}
+
+// CreateBlockContext is CreateBlock with a mining deadline. If mining stops
+// early the error is returned. The block includes a snapshot of txPool taken
+// when it is called; the pool itself is not modified, so remove the
+// included transactions from it once the block is accepted. The snapshot
+// is taken as it is; ChainConfig.CreateBlockContext also orders and caps it.
+func CreateBlockContext(ctx context.Context, index int, prevHash string, relationshipType string, receivers []string,
+	text, audio, video string, txPool *TransactionPool, difficulty int, minerAddress string, reward float64) (*Block, error) {
+	block := assembleBlock(index, prevHash, relationshipType, receivers, text, audio, video,
+		txPool.Pending(), difficulty, minerAddress, reward)
+	if err := MineBlockContext(ctx, block, difficulty); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
 
-	// Create a coinbase transaction for miner reward.
-	coinbaseTx := NewTransaction("COINBASE", minerAddress, reward, 0)
+// CreateBlockContext is the package's CreateBlockContext for a chain with
+// the parameters cfg. The block carries the transactions of txPool that
+// SelectTransactions picks against balances, the state before the block,
+// and pays the reward of its height.
+func (cfg *ChainConfig) CreateBlockContext(ctx context.Context, index int, prevHash string, relationshipType string, receivers []string,
+	text, audio, video string, txPool *TransactionPool, balances Ledger, difficulty int, minerAddress string) (*Block, error) {
+	block := assembleBlock(index, prevHash, relationshipType, receivers, text, audio, video,
+		cfg.SelectTransactions(txPool.Pending(), balances), difficulty, minerAddress, cfg.Reward.RewardAt(index))
+	if err := MineBlockContext(ctx, block, difficulty); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// assembleBlock builds the unmined block CreateBlockContext mines, carrying
+// pending after its coinbase.
+func assembleBlock(index int, prevHash string, relationshipType string, receivers []string,
+	text, audio, video string, pending []*Transaction, difficulty int, minerAddress string, reward float64) *Block {
+	now := nextStamp()
+	timestamp := now.Unix()
+	// Create a coinbase transaction paying the reward plus the fees of the included transactions.
+	fees := 0.0
+	for _, tx := range pending {
+		fees += tx.Fee
+	}
+	coinbaseTx := NewCoinbaseTransaction(minerAddress, reward+fees, index, timestamp)
 	// Optionally, you could sign this transaction differently or leave it unsigned.
-	// Prepend coinbase transaction to transaction pool.
-	txPool.Transactions = append([]*Transaction{coinbaseTx}, txPool.Transactions...)
+	// The coinbase comes first.
+	txs := append([]*Transaction{coinbaseTx}, pending...)
 
 	block := &Block{
 		Index:            index,
-		Timestamp:        time.Now().Unix(),
+		Timestamp:        timestamp,
 		PrevHash:         prevHash,
 		RelationshipType: relationshipType,
 		Receivers:        receivers,
 		TextData:         text,
 		AudioData:        audio,
 		VideoData:        video,
-		Transactions:     txPool.Transactions,
+		Transactions:     txs,
 		SubBlocks:        []*Block{},
 		Difficulty:       difficulty,
 		Nonce:            0,
 		Category:         "main",
+		Version:          BlockVersion,
 	}
-	MineBlock(block, difficulty)
+	block.stamp(now)
 	return block
 }
 
 // Blockchain represents a chain of blocks.
 type Blockchain struct {
 	Blocks []*Block
+	Config *ChainConfig // Consensus parameters; nil means DefaultChainConfig.
+
+	// Store, when set, receives every appended block. With a positive
+	// CacheWindow only the most recent CacheWindow blocks stay in Blocks and
+	// older ones are read back from Store on demand.
+	Store       *DB
+	CacheWindow int
+
+	// Archives lists the files PruneAndArchive wrote, oldest first.
+	Archives []string
+	// ArchiveMaxFiles and ArchiveMaxBytes bound how many archives, and how
+	// many bytes of them, are kept; the oldest are deleted first. Zero means
+	// unlimited.
+	ArchiveMaxFiles int
+	ArchiveMaxBytes int64
+	// CompressArchives makes PruneAndArchive write gzip-compressed archives.
+	CompressArchives bool
+
+	// archivedSupply and archivedWork are the supply change and the work of
+	// blocks no longer in Blocks.
+	archivedSupply float64
+	archivedWork   float64
+
+	// sideBlocks holds competing blocks that do not extend the active tip, by hash.
+	sideBlocks map[string]*Block
+
+	// ledger holds the balances of the active chain once attached with
+	// AttachLedger; nil otherwise. It is guarded by mu and moves with Blocks.
+	ledger Ledger
+
+	mu sync.Mutex // Guards appends so concurrent producers cannot fork the tip.
+
+	subsMu sync.Mutex
+	subs   map[chan BlockEvent]struct{} // Subscribers; see Subscribe.
 }
 
 // NewBlockchain creates and returns an empty blockchain.
 func NewBlockchain() *Blockchain {
 	return &Blockchain{
 		Blocks: []*Block{},
+		Config: DefaultChainConfig(),
+	}
+}
+
+// config returns the chain's consensus parameters, falling back to the defaults.
+func (bc *Blockchain) config() *ChainConfig {
+	if bc.Config == nil {
+		return DefaultChainConfig()
 	}
+	return bc.Config
 }
 
-// AddBlock appends a new block to the blockchain.
+// AddBlock appends a new block to the blockchain. Blocks carrying a
+// transaction without a valid signature are refused, and so are blocks the
+// attached ledger cannot apply.
 func (bc *Blockchain) AddBlock(b *Block) {
+	if err := bc.ValidateBlockTransactions(b, nil); err != nil {
+		fmt.Println("Error adding block:", err)
+		return
+	}
+	bc.mu.Lock()
+	err := bc.appendLocked(b)
+	bc.mu.Unlock()
+	if err != nil {
+		fmt.Println("Error adding block:", err)
+	}
+	bc.autoPrune()
+}
+
+// appendLocked applies b to the attached ledger, appends it, writes it
+// through to the store and evicts blocks that fell out of the cache window.
+// A block the ledger cannot apply is refused. The caller must hold bc.mu.
+func (bc *Blockchain) appendLocked(b *Block) error {
+	if bc.ledger != nil {
+		if err := bc.ledger.ProcessBlock(b); err != nil {
+			return err
+		}
+	}
+	if bc.Store != nil {
+		if err := bc.Store.SaveBlock(b); err != nil {
+			if bc.ledger != nil {
+				bc.ledger.Revert([]*Block{b})
+			}
+			return err
+		}
+	}
 	bc.Blocks = append(bc.Blocks, b)
-	// Automatically prune the blockchain if it exceeds a certain size.
+	bc.evictLocked()
+	bc.publish(BlockEvent{Block: b})
+	return nil
+}
+
+// evictLocked drops blocks that fell out of the cache window. They must
+// already be in the store. The caller must hold bc.mu.
+func (bc *Blockchain) evictLocked() {
+	if bc.Store == nil || bc.CacheWindow <= 0 || len(bc.Blocks) <= bc.CacheWindow {
+		return
+	}
+	evicted := len(bc.Blocks) - bc.CacheWindow
+	bc.archivedSupply += supplyOf(bc.Blocks[:evicted])
+	bc.archivedWork += CumulativeWork(bc.Blocks[:evicted])
+	// Copy so the evicted blocks are not pinned by the backing array.
+	bc.Blocks = append([]*Block(nil), bc.Blocks[evicted:]...)
+}
+
+// Tip returns the last block of the chain, or nil if the chain is empty.
+func (bc *Blockchain) Tip() *Block {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if len(bc.Blocks) == 0 {
+		return nil
+	}
+	return bc.Blocks[len(bc.Blocks)-1]
+}
+
+// RecentBlocks returns a copy of the blocks held in memory: the whole
+// chain, or only its most recent blocks once older ones were evicted to
+// the store or pruned. Use it instead of reading Blocks while other
+// goroutines may extend or replace the chain.
+func (bc *Blockchain) RecentBlocks() []*Block {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return append([]*Block(nil), bc.Blocks...)
+}
+
+// FullChain returns a copy of the whole chain from genesis, reading the
+// blocks evicted or pruned from memory back from the store. Without a
+// store, pruned blocks are gone and an error is returned.
+func (bc *Blockchain) FullChain() ([]*Block, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	var chain []*Block
+	if err := bc.walkChainLocked(func(b *Block) error {
+		chain = append(chain, b)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(chain) > 0 && chain[0].Index != 0 {
+		return nil, fmt.Errorf("blocks below %d are no longer available", chain[0].Index)
+	}
+	return chain, nil
+}
+
+// BlocksSince returns how many blocks at the end of the chain are
+// timestamped at or after t.
+func (bc *Blockchain) BlocksSince(t time.Time) int {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	count := 0
+	for i := len(bc.Blocks) - 1; i >= 0 && bc.Blocks[i].Timestamp >= t.Unix(); i-- {
+		count++
+	}
+	return count
+}
+
+// TransactionsSince returns how many transactions, coinbase outputs aside,
+// the blocks at the end of the chain timestamped at or after t carry.
+func (bc *Blockchain) TransactionsSince(t time.Time) int {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	count := 0
+	for i := len(bc.Blocks) - 1; i >= 0 && bc.Blocks[i].Timestamp >= t.Unix(); i-- {
+		for _, tx := range bc.Blocks[i].Transactions {
+			if !tx.IsCoinbase() {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// AddBlockIfTip appends b only if the current tip still has hash expectedPrevHash,
+// checking and appending atomically. It returns false without an error when
+// another block extended the tip first, and an error when b itself is invalid.
+func (bc *Blockchain) AddBlockIfTip(b *Block, expectedPrevHash string) (bool, error) {
+	if b.PrevHash != expectedPrevHash {
+		return false, fmt.Errorf("block %d does not build on %s", b.Index, expectedPrevHash)
+	}
+	h, err := bc.config().Hasher()
+	if err != nil {
+		return false, err
+	}
+	if b.Hash != CalculateHashWith(b, h) {
+		return false, fmt.Errorf("block %d has an invalid hash", b.Index)
+	}
+	if err := checkDifficulty(b, bc.config()); err != nil {
+		return false, err
+	}
+	if err := checkBlockSize(b, bc.config()); err != nil {
+		return false, err
+	}
+	if err := bc.config().checkCheckpoint(b.Index, b.Hash); err != nil {
+		return false, err
+	}
+	if err := bc.ValidateBlockTransactions(b, nil); err != nil {
+		return false, err
+	}
+
+	bc.mu.Lock()
+	tip, nextIndex := "", 0
+	if len(bc.Blocks) > 0 {
+		tip = bc.Blocks[len(bc.Blocks)-1].Hash
+		nextIndex = bc.Blocks[len(bc.Blocks)-1].Index + 1
+	}
+	if tip != expectedPrevHash {
+		bc.mu.Unlock()
+		return false, nil
+	}
+	if b.Index != nextIndex {
+		bc.mu.Unlock()
+		return false, fmt.Errorf("block %d: expected index %d", b.Index, nextIndex)
+	}
+	if len(bc.Blocks) > 0 {
+		if err := checkDifficultyStep(b, bc.Blocks[len(bc.Blocks)-1], bc.config()); err != nil {
+			bc.mu.Unlock()
+			return false, err
+		}
+	}
+	if err := checkTimestamp(b, bc.Blocks, bc.config(), time.Now()); err != nil {
+		bc.mu.Unlock()
+		return false, err
+	}
+	err = bc.appendLocked(b)
+	bc.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	bc.autoPrune()
+	return true, nil
+}
+
+// autoPrune prunes the blockchain if it exceeds a certain size.
+func (bc *Blockchain) autoPrune() {
 	const maxBlocks = 100 // for example
-	if len(bc.Blocks) > maxBlocks {
+	bc.mu.Lock()
+	size := len(bc.Blocks)
+	bc.mu.Unlock()
+	if size > maxBlocks {
 		// Keep only the last 50 blocks.
 		err := bc.PruneAndArchive(50, "archive")
 		if err != nil {
@@ -119,52 +455,237 @@ func (bc *Blockchain) AddBlock(b *Block) {
 	}
 }
 
-// CumulativeDifficulty calculates the total difficulty of a chain.
-func CumulativeDifficulty(chain []*Block) int {
-	total := 0
+// CumulativeWork calculates the total work of a chain: the hashes expected
+// to mine its blocks, so each block counts 16 times more than one a
+// difficulty below it.
+func CumulativeWork(chain []*Block) float64 {
+	total := 0.0
 	for _, b := range chain {
-		total += b.Difficulty
+		total += Work(b.Difficulty)
 	}
 	return total
 }
 
-// IsValidChain verifies that the chain is valid.
+// IsValidChain verifies that the chain is valid under the default configuration.
 func IsValidChain(chain []*Block) bool {
+	return ValidateChain(chain, nil) == nil
+}
+
+// ValidateChain verifies the chain against cfg and reports the first problem found.
+// A nil cfg validates against DefaultChainConfig.
+func ValidateChain(chain []*Block, cfg *ChainConfig) error {
+	if cfg == nil {
+		cfg = DefaultChainConfig()
+	}
 	if len(chain) == 0 {
-		return false
+		return fmt.Errorf("chain is empty")
+	}
+	h, err := cfg.Hasher()
+	if err != nil {
+		return err
 	}
 
 	// Validate the genesis block (assumed to have an empty PrevHash).
-	if chain[0].PrevHash != "" || chain[0].Hash != CalculateHash(chain[0]) {
-		return false
+	if chain[0].Index != 0 || chain[0].PrevHash != "" || chain[0].Hash != CalculateHashWith(chain[0], h) {
+		return fmt.Errorf("invalid genesis block")
+	}
+	if err := checkDifficulty(chain[0], cfg); err != nil {
+		return fmt.Errorf("genesis: %w", err)
+	}
+	if err := cfg.checkCheckpoint(0, chain[0].Hash); err != nil {
+		return err
+	}
+	if err := VerifyGenesisAlloc(chain[0], cfg.GenesisAlloc); err != nil {
+		return err
+	}
+	if err := validateGenesisCoinbase(chain[0], cfg.Reward.RewardAt(0)); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := checkTimestamp(chain[0], nil, cfg, now); err != nil {
+		return err
+	}
+	if err := validateTransactions(chain[0], nil); err != nil {
+		return err
+	}
+	// Every transfer must be funded by the blocks before it.
+	funds := NewLedger()
+	if err := funds.ProcessBlock(chain[0]); err != nil {
+		return err
 	}
 
 	// Validate subsequent blocks.
 	for i := 1; i < len(chain); i++ {
-		current := chain[i]
-		previous := chain[i-1]
-
-		if current.PrevHash != previous.Hash {
-			return false
+		if err := validateNext(chain[i], chain[:i], cfg, h, now, funds); err != nil {
+			return err
 		}
-		if current.Hash != CalculateHash(current) {
-			return false
+	}
+	return nil
+}
+
+// validateNext verifies that current may follow the blocks in prev, which
+// end with its parent and were validated already. If funds is not nil,
+// current's transfers must be funded by it and are applied to it.
+func validateNext(current *Block, prev []*Block, cfg *ChainConfig, h Hasher, now time.Time, funds Ledger) error {
+	previous := prev[len(prev)-1]
+	if current.PrevHash != previous.Hash {
+		return fmt.Errorf("block %d does not link to its predecessor", current.Index)
+	}
+	// Index-based lookups rely on heights having no gaps or repeats.
+	if current.Index != previous.Index+1 {
+		return fmt.Errorf("block %d follows block %d", current.Index, previous.Index)
+	}
+	if current.Hash != CalculateHashWith(current, h) {
+		return fmt.Errorf("block %d has an invalid hash", current.Index)
+	}
+	// Without this a peer could claim any difficulty to win ReplaceChain.
+	if err := checkDifficulty(current, cfg); err != nil {
+		return err
+	}
+	if err := checkDifficultyStep(current, previous, cfg); err != nil {
+		return err
+	}
+	if err := checkBlockSize(current, cfg); err != nil {
+		return err
+	}
+	if err := cfg.checkCheckpoint(current.Index, current.Hash); err != nil {
+		return err
+	}
+	if err := checkTimestamp(current, prev, cfg, now); err != nil {
+		return err
+	}
+	if err := ValidateCoinbase(current, cfg.Reward.RewardAt(current.Index)); err != nil {
+		return err
+	}
+	if err := validateTransactions(current, nil); err != nil {
+		return err
+	}
+	for _, tx := range current.Transactions {
+		if tx.Sender == GenesisSender {
+			return fmt.Errorf("block %d carries a genesis allocation", current.Index)
 		}
 	}
-	return true
+	if funds != nil {
+		return funds.ProcessBlock(current)
+	}
+	return nil
 }
 
 // ReplaceChain replaces the current blockchain with newChain if newChain is valid
-// and has a higher cumulative difficulty than the current chain.
+// and has more cumulative work than the current chain.
 func (bc *Blockchain) ReplaceChain(newChain []*Block) bool {
-	if !IsValidChain(newChain) {
-		return false
+	replaced, err := bc.TryReplaceChain(newChain)
+	return replaced && err == nil
+}
+
+// TryReplaceChain is ReplaceChain reporting why newChain was refused: a
+// chain that is invalid, or whose transactions cannot be replayed with
+// BuildLedgerFromChain, gives the error, and a valid chain that is not
+// heavier than the whole local chain, blocks pruned or evicted from memory
+// included, gives false without one. The attached ledger, if any, is
+// replaced by the replayed balances together with the blocks.
+func (bc *Blockchain) TryReplaceChain(newChain []*Block) (bool, error) {
+	if err := ValidateChain(newChain, bc.config()); err != nil {
+		return false, err
+	}
+	rebuilt, err := BuildLedgerFromChain(newChain)
+	if err != nil {
+		return false, err
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if CumulativeWork(newChain) <= bc.archivedWork+CumulativeWork(bc.Blocks) {
+		return false, nil
+	}
+	replaced := make(map[string]bool, len(bc.Blocks))
+	for _, b := range bc.Blocks {
+		replaced[b.Hash] = true
+	}
+	// The new chain starts at genesis, so it accounts for the whole supply.
+	bc.Blocks = newChain
+	bc.archivedSupply = 0
+	bc.archivedWork = 0
+	bc.sideBlocks = nil
+	if bc.ledger != nil {
+		bc.ledger = rebuilt
+	}
+	bc.storeChainLocked()
+	for _, b := range newChain {
+		if !replaced[b.Hash] {
+			bc.publish(BlockEvent{Block: b, Reorg: true})
+		}
+	}
+	return true, nil
+}
+
+// Rollback removes the blocks above height toIndex and returns them in
+// chain order, deleting them from the store too. Their effect on the
+// attached ledger is undone; to keep a ledger of your own, pass them to
+// Ledger.Revert. Blocks that were pruned or evicted from memory cannot be
+// rolled back to.
+func (bc *Blockchain) Rollback(toIndex int) ([]*Block, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if len(bc.Blocks) == 0 {
+		return nil, fmt.Errorf("chain is empty")
+	}
+	if first := bc.Blocks[0].Index; toIndex < first {
+		return nil, fmt.Errorf("cannot roll back to height %d: blocks below %d are no longer in memory", toIndex, first)
+	}
+	keep := toIndex - bc.Blocks[0].Index + 1
+	if keep >= len(bc.Blocks) {
+		return nil, nil
+	}
+	removed := append([]*Block(nil), bc.Blocks[keep:]...)
+	if bc.ledger != nil {
+		if err := bc.ledger.Revert(removed); err != nil {
+			return nil, err
+		}
+	}
+	bc.Blocks = bc.Blocks[:keep]
+	if bc.Store != nil {
+		for _, b := range removed {
+			if err := bc.Store.DeleteBlock(b.Hash); err != nil {
+				fmt.Println("Error deleting rolled back block:", err)
+			}
+		}
+	}
+	return removed, nil
+}
+
+// storeChainLocked writes bc.Blocks through to the store after a
+// replacement, in one transaction with the deletion of stored blocks above
+// the new tip. Only blocks that differ from the stored chain are written.
+// Errors are logged like other write-through failures.
+func (bc *Blockchain) storeChainLocked() {
+	if bc.Store == nil || len(bc.Blocks) == 0 {
+		return
 	}
-	if CumulativeDifficulty(newChain) > CumulativeDifficulty(bc.Blocks) {
-		bc.Blocks = newChain
-		return true
+	if err := bc.Store.ReplaceChain(bc.Blocks); err != nil {
+		fmt.Println("Error persisting chain:", err)
 	}
-	return false
+}
+
+// ChainInfo returns the height, tip, transaction count and supply of the
+// chain, from the store aggregates when a store is set and from the blocks
+// held in memory otherwise.
+func (bc *Blockchain) ChainInfo() ChainMeta {
+	if bc.Store != nil {
+		if meta, err := bc.Store.ChainMeta(); err == nil {
+			return meta
+		}
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	info := ChainMeta{Height: -1, Supply: bc.archivedSupply + supplyOf(bc.Blocks)}
+	if n := len(bc.Blocks); n > 0 {
+		info.Height = bc.Blocks[n-1].Index
+		info.TipHash = bc.Blocks[n-1].Hash
+	}
+	for _, b := range bc.Blocks {
+		info.TxCount += len(b.Transactions)
+	}
+	return info
 }
 
 // UpdateBlockWithSubBlock simulates a change event on an existing block.
@@ -188,10 +709,13 @@ func (bc *Blockchain) UpdateBlockWithSubBlock(parentIndex int, newText, newAudio
 		Difficulty:       1, // Default difficulty; adjust if needed.
 		Nonce:            0,
 		Category:         subBlockCategory,
+		Version:          BlockVersion,
 	}
+	subBlock.stamp(time.Now())
 	MineBlock(subBlock, subBlock.Difficulty)
 	subBlock.Hash = CalculateHash(subBlock)
 	parentBlock.SubBlocks = append(parentBlock.SubBlocks, subBlock)
+	bc.publish(BlockEvent{Block: subBlock, Parent: parentBlock})
 }
 
 // UpdateBlockWithSubBlockEx creates a sub-block with a specified category and appends it to the parent block.
@@ -215,20 +739,96 @@ func (bc *Blockchain) UpdateBlockWithSubBlockEx(parentIndex int, newText, newAud
 		Difficulty:       1, // Default difficulty for sub-blocks.
 		Nonce:            0,
 		Category:         subBlockCategory, // e.g., "text", "metadata", "contract_state", "transaction_update"
+		Version:          BlockVersion,
 	}
+	subBlock.stamp(time.Now())
 	// Mine the sub-block if you want to simulate PoW for sub-blocks.
 	MineBlock(subBlock, subBlock.Difficulty)
 	// Compute the sub-block's hash.
 	subBlock.Hash = CalculateHash(subBlock)
 	// Append the sub-block to the parent's SubBlocks slice.
 	parentBlock.SubBlocks = append(parentBlock.SubBlocks, subBlock)
+	bc.publish(BlockEvent{Block: subBlock, Parent: parentBlock})
 }
 
+// GetBlockFromChain looks a block up by hash in memory, then in the store.
 func GetBlockFromChain(bc *Blockchain, hash string) (*Block, error) {
+	bc.mu.Lock()
 	for _, b := range bc.Blocks {
 		if b.Hash == hash {
+			bc.mu.Unlock()
 			return b, nil
 		}
 	}
+	bc.mu.Unlock()
+	if bc.Store != nil {
+		return bc.Store.GetBlock(hash)
+	}
 	return nil, fmt.Errorf("block not found")
 }
+
+// GetBlockByIndex returns the block at height i, from memory when it is
+// within the cache window and from the store otherwise.
+func (bc *Blockchain) GetBlockByIndex(i int) (*Block, error) {
+	bc.mu.Lock()
+	if n := len(bc.Blocks); n > 0 {
+		first := bc.Blocks[0].Index
+		if i >= first && i-first < n && bc.Blocks[i-first].Index == i {
+			b := bc.Blocks[i-first]
+			bc.mu.Unlock()
+			return b, nil
+		}
+	}
+	bc.mu.Unlock()
+	if bc.Store != nil {
+		return bc.Store.GetBlockByIndex(i)
+	}
+	return nil, fmt.Errorf("block not found")
+}
+
+// errStopWalk ends a Store.IterateBlocks early in walkChainLocked.
+var errStopWalk = errors.New("stop walking the store")
+
+// walkChainLocked calls fn with every block of the main chain, oldest
+// first: those evicted or pruned from memory are read from Store, the rest
+// come from Blocks. It stops at the first error from fn, which is
+// returned. The caller must hold bc.mu.
+func (bc *Blockchain) walkChainLocked(fn func(*Block) error) error {
+	if bc.Store != nil && len(bc.Blocks) > 0 && bc.Blocks[0].Index > 0 {
+		first := bc.Blocks[0].Index
+		err := bc.Store.IterateBlocks(func(b *Block) error {
+			if b.Index >= first {
+				return errStopWalk
+			}
+			return fn(b)
+		})
+		if err != nil && !errors.Is(err, errStopWalk) {
+			return err
+		}
+	}
+	for _, b := range bc.Blocks {
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindTransactionBlock returns the block that includes the transaction with
+// the given hash, looking in memory first and then in the store.
+func (bc *Blockchain) FindTransactionBlock(hash string) (*Block, error) {
+	bc.mu.Lock()
+	for _, b := range bc.Blocks {
+		for _, tx := range b.Transactions {
+			if tx.CalculateHash() == hash {
+				bc.mu.Unlock()
+				return b, nil
+			}
+		}
+	}
+	bc.mu.Unlock()
+	if bc.Store != nil {
+		return bc.Store.GetTransactionBlock(hash)
+	}
+	return nil, fmt.Errorf("transaction not found")
+}
@@ -0,0 +1,203 @@
+package blockchain_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestAddBlockIfTipSingleWinner(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	bc.AddBlock(genesis)
+
+	candidates := []*blockchain.Block{
+		blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, "Miner1", 12.5),
+		blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{"ReceiverA"},
+			"Other", "Audio", "Video", &blockchain.TransactionPool{}, 1, "Miner2", 12.5),
+	}
+
+	var wg sync.WaitGroup
+	results := make([]bool, len(candidates))
+	for i, c := range candidates {
+		wg.Add(1)
+		go func(i int, c *blockchain.Block) {
+			defer wg.Done()
+			added, err := bc.AddBlockIfTip(c, genesis.Hash)
+			if err != nil {
+				t.Errorf("candidate %d: unexpected error %v", i, err)
+			}
+			results[i] = added
+		}(i, c)
+	}
+	wg.Wait()
+
+	if results[0] == results[1] {
+		t.Fatalf("expected exactly one winner, got %v", results)
+	}
+	if len(bc.Blocks) != 2 {
+		t.Fatalf("expected chain length 2, got %d", len(bc.Blocks))
+	}
+	winner := candidates[0]
+	if results[1] {
+		winner = candidates[1]
+	}
+	if bc.Blocks[1] != winner {
+		t.Error("tip is not the winning candidate")
+	}
+
+	// A block building on a stale tip is rejected without error.
+	if added, err := bc.AddBlockIfTip(candidates[0], genesis.Hash); added || err != nil {
+		t.Errorf("expected stale candidate to lose, got added=%v err=%v", added, err)
+	}
+}
+
+func TestTamperedTransactionInvalidatesChain(t *testing.T) {
+	w := testWallet(t, 1)
+	txPool := &blockchain.TransactionPool{}
+	// The genesis reward funds the transfer.
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", txPool, 1, w.Address, 12.5)
+	txPool.AddTransaction(signedTx(t, w, "Bob", 10, 1))
+	block1 := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", txPool, 1, "Miner1", 12.5)
+	chain := []*blockchain.Block{genesis, block1}
+	if !blockchain.IsValidChain(chain) {
+		t.Fatal("expected untampered chain to be valid")
+	}
+
+	block1.Transactions[1].Amount = 1000
+	if blockchain.IsValidChain(chain) {
+		t.Error("chain with a tampered transaction amount was accepted")
+	}
+}
+
+func TestCategoryAffectsHash(t *testing.T) {
+	a := &blockchain.Block{Index: 1, Timestamp: 1700000000, PrevHash: "prev", Receivers: []string{}, Category: "text"}
+	b := *a
+	b.Category = "metadata"
+	if blockchain.CalculateHash(a) == blockchain.CalculateHash(&b) {
+		t.Error("blocks differing only in category hashed the same")
+	}
+}
+
+func TestMineBlockContextStops(t *testing.T) {
+	// Far beyond what can be found in a test run.
+	const difficulty = 64
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b := &blockchain.Block{Index: 1, Receivers: []string{}}
+	if err := blockchain.MineBlockContext(ctx, b, difficulty); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	b = &blockchain.Block{Index: 1, Receivers: []string{}}
+	if err := blockchain.MineBlockLimit(context.Background(), b, difficulty, 5000); !errors.Is(err, blockchain.ErrNonceExhausted) {
+		t.Errorf("expected ErrNonceExhausted, got %v", err)
+	}
+	if b.Nonce != 5000 {
+		t.Errorf("expected mining to stop at the cap, stopped at %d", b.Nonce)
+	}
+}
+
+func TestSkippedIndexRejected(t *testing.T) {
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	skipping := blockchain.CreateBlock(2, genesis.Hash, "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+
+	if err := blockchain.ValidateChain([]*blockchain.Block{genesis, skipping}, nil); err == nil {
+		t.Error("expected a chain skipping index 1 to be rejected")
+	}
+
+	bc := blockchain.NewBlockchain()
+	bc.AddBlock(genesis)
+	if added, err := bc.AddBlockIfTip(skipping, genesis.Hash); added || err == nil {
+		t.Errorf("expected a block skipping an index to be refused, got added=%v err=%v", added, err)
+	}
+	if len(bc.Blocks) != 1 {
+		t.Errorf("expected the chain to stay at 1 block, got %d", len(bc.Blocks))
+	}
+}
+
+func TestBlocksWithinOneSecondHaveDistinctTimestamps(t *testing.T) {
+	start := time.Now()
+	var blocks []*blockchain.Block
+	prevHash := ""
+	for i := 0; i < 4; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "", &blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		blocks = append(blocks, b)
+		prevHash = b.Hash
+		time.Sleep(2 * time.Millisecond)
+	}
+	if time.Since(start) >= time.Second {
+		t.Skip("mining took over a second")
+	}
+	for i := 1; i < len(blocks); i++ {
+		if blocks[i].TimestampMillis <= blocks[i-1].TimestampMillis {
+			t.Errorf("block %d timestamp %d does not follow %d", i, blocks[i].TimestampMillis, blocks[i-1].TimestampMillis)
+		}
+		if blocks[i].Timestamp != blocks[i].TimestampMillis/1000 {
+			t.Errorf("block %d seconds %d disagree with milliseconds %d", i, blocks[i].Timestamp, blocks[i].TimestampMillis)
+		}
+	}
+
+	// The milliseconds are part of the hash.
+	if !blockchain.IsValidChain(blocks) {
+		t.Fatal("expected the chain to be valid")
+	}
+	blocks[2].TimestampMillis++
+	if blockchain.IsValidChain(blocks) {
+		t.Error("chain with a tampered millisecond timestamp was accepted")
+	}
+}
+
+func TestBlockTransactionCap(t *testing.T) {
+	cfg := blockchain.DefaultChainConfig()
+	cfg.MaxBlockTransactions = 3
+
+	w := testWallet(t, 1)
+	txPool := &blockchain.TransactionPool{}
+	for nonce := 1; nonce <= 5; nonce++ {
+		if err := txPool.AddTransaction(signedTx(t, w, "Bob", 1, nonce)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, w.Address, 12.5)
+	b := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{"ReceiverA"}, "Text", "Audio", "Video",
+		&blockchain.TransactionPool{Transactions: cfg.BlockTransactions(txPool.Pending())}, 1, "Miner1", 12.5)
+	if got := len(b.Transactions) - 1; got != 3 {
+		t.Fatalf("expected 3 transactions besides the coinbase, got %d", got)
+	}
+	txPool.Remove(b.Transactions)
+	pending := txPool.Pending()
+	if len(pending) != 2 || pending[0].Nonce != 4 || pending[1].Nonce != 5 {
+		t.Errorf("expected nonces 4 and 5 to stay pending, got %d transactions", len(pending))
+	}
+	if err := blockchain.ValidateChain([]*blockchain.Block{genesis, b}, cfg); err != nil {
+		t.Errorf("block at the cap rejected: %v", err)
+	}
+
+	// A block over the cap is refused however it arrives.
+	cfg.MaxBlockTransactions = 2
+	if err := blockchain.ValidateChain([]*blockchain.Block{genesis, b}, cfg); !errors.Is(err, blockchain.ErrBlockTooLarge) {
+		t.Errorf("expected ErrBlockTooLarge from ValidateChain, got %v", err)
+	}
+	bc := blockchain.NewBlockchain()
+	bc.Config = cfg
+	bc.AddBlock(genesis)
+	if _, err := bc.AddBlockIfTip(b, genesis.Hash); !errors.Is(err, blockchain.ErrBlockTooLarge) {
+		t.Errorf("expected ErrBlockTooLarge from AddBlockIfTip, got %v", err)
+	}
+	if err := bc.AddSideBlock(b); !errors.Is(err, blockchain.ErrBlockTooLarge) {
+		t.Errorf("expected ErrBlockTooLarge from AddSideBlock, got %v", err)
+	}
+}
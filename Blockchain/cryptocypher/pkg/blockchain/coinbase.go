@@ -0,0 +1,60 @@
+// File: pkg/blockchain/coinbase.go
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCoinbase is returned for blocks whose coinbase breaks the
+// consensus rules.
+var ErrInvalidCoinbase = errors.New("invalid coinbase")
+
+// ValidateCoinbase checks that b carries exactly one coinbase transaction,
+// as its first transaction, paying no more than expectedReward plus the
+// fees of the block's other transactions. Without this check a block could
+// mint any amount through extra or inflated coinbases.
+func ValidateCoinbase(b *Block, expectedReward float64) error {
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return fmt.Errorf("%w: block %d does not start with a coinbase", ErrInvalidCoinbase, b.Index)
+	}
+	fees := 0.0
+	for _, tx := range b.Transactions[1:] {
+		if tx.IsCoinbase() {
+			return fmt.Errorf("%w: block %d has more than one coinbase", ErrInvalidCoinbase, b.Index)
+		}
+		fees += tx.Fee
+	}
+	coinbase := b.Transactions[0]
+	if coinbase.Amount < 0 || coinbase.Amount > expectedReward+fees {
+		return fmt.Errorf("%w: block %d pays %f, allowed %f in reward and %f in fees",
+			ErrInvalidCoinbase, b.Index, coinbase.Amount, expectedReward, fees)
+	}
+	return nil
+}
+
+// validateGenesisCoinbase checks that genesis creates no coins besides its
+// allocations, which VerifyGenesisAlloc checks, and at most one coinbase
+// paying no more than reward plus the fees of the genesis transfers. The
+// coinbase is optional, as NewGenesisBlock does not add one.
+func validateGenesisCoinbase(genesis *Block, reward float64) error {
+	coinbases, paid, fees := 0, 0.0, 0.0
+	for _, tx := range genesis.Transactions {
+		switch {
+		case tx.Sender == GenesisSender:
+		case tx.IsCoinbase():
+			coinbases++
+			paid += tx.Amount
+		default:
+			fees += tx.Fee
+		}
+	}
+	if coinbases > 1 {
+		return fmt.Errorf("%w: genesis has more than one coinbase", ErrInvalidCoinbase)
+	}
+	if paid < 0 || paid > reward+fees {
+		return fmt.Errorf("%w: genesis pays %f, allowed %f in reward and %f in fees",
+			ErrInvalidCoinbase, paid, reward, fees)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package blockchain_test
+
+import (
+	"errors"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestValidateCoinbase(t *testing.T) {
+	transfer := blockchain.NewTransaction("Alice", "Bob", 5, 1)
+	transfer.Fee = 0.5
+	extra := blockchain.NewCoinbaseTransaction("Miner2", 12.5, 1, 0)
+
+	tests := []struct {
+		name    string
+		txs     func(coinbase *blockchain.Transaction) []*blockchain.Transaction
+		wantErr bool
+	}{
+		{"no coinbase", func(*blockchain.Transaction) []*blockchain.Transaction {
+			return []*blockchain.Transaction{transfer}
+		}, true},
+		{"one coinbase", func(cb *blockchain.Transaction) []*blockchain.Transaction {
+			return []*blockchain.Transaction{cb, transfer}
+		}, false},
+		{"two coinbases", func(cb *blockchain.Transaction) []*blockchain.Transaction {
+			return []*blockchain.Transaction{cb, transfer, extra}
+		}, true},
+		{"coinbase not first", func(cb *blockchain.Transaction) []*blockchain.Transaction {
+			return []*blockchain.Transaction{transfer, cb}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := blockchain.NewCoinbaseTransaction("Miner1", 12.5+transfer.Fee, 1, 0)
+			b := &blockchain.Block{Index: 1, Transactions: tt.txs(cb)}
+			err := blockchain.ValidateCoinbase(b, 12.5)
+			if tt.wantErr && !errors.Is(err, blockchain.ErrInvalidCoinbase) {
+				t.Fatalf("expected ErrInvalidCoinbase, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	inflated := &blockchain.Block{Index: 1, Transactions: []*blockchain.Transaction{
+		blockchain.NewCoinbaseTransaction("Miner1", 1000, 1, 0), transfer,
+	}}
+	if err := blockchain.ValidateCoinbase(inflated, 12.5); !errors.Is(err, blockchain.ErrInvalidCoinbase) {
+		t.Errorf("expected an inflated coinbase to be rejected, got %v", err)
+	}
+}
+
+func TestValidateChainRejectsExtraCoinbase(t *testing.T) {
+	alice := testWallet(t, 1)
+	chain := buildSupplyChain(t, alice, 2)
+	cfg := blockchain.DefaultChainConfig()
+	cfg.GenesisAlloc = blockchain.GenesisAlloc{alice.Address: 100}
+	if err := blockchain.ValidateChain(chain, cfg); err != nil {
+		t.Fatalf("valid chain rejected: %v", err)
+	}
+
+	forged := chain[2]
+	forged.Transactions = append(forged.Transactions, blockchain.NewCoinbaseTransaction("Mallory", 1e6, 2, forged.Timestamp))
+	blockchain.MineBlock(forged, forged.Difficulty)
+	if err := blockchain.ValidateChain(chain, cfg); !errors.Is(err, blockchain.ErrInvalidCoinbase) {
+		t.Fatalf("expected ErrInvalidCoinbase, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+// File: pkg/blockchain/config.go
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultCoinbaseMaturity is the coinbase maturity used by DefaultChainConfig.
+const DefaultCoinbaseMaturity = 10
+
+// DefaultBlockReward is the initial block reward used by DefaultChainConfig.
+const DefaultBlockReward = 12.5
+
+// ChainConfig holds the consensus parameters a node validates blocks against.
+// Peers whose chains were built under different parameters are rejected.
+type ChainConfig struct {
+	// ChainID names the network; nodes on different chains refuse each other.
+	ChainID string
+	// HashAlgorithm names the Hasher used for blocks, transactions and Merkle trees.
+	HashAlgorithm string
+	// GenesisAlloc lists the accounts pre-funded by the genesis block.
+	GenesisAlloc GenesisAlloc
+	// CoinbaseMaturity is how many blocks must follow a coinbase before its
+	// reward becomes spendable.
+	CoinbaseMaturity int
+	// Reward gives, by height, the most a block's coinbase may pay on top
+	// of the fees of the block's transactions.
+	Reward RewardSchedule
+	// MaxFutureDrift is how far ahead of local time a block may be
+	// timestamped, and MedianTimeSpan how many preceding blocks a block's
+	// timestamp must not precede the median of. Zero disables either rule.
+	MaxFutureDrift time.Duration
+	MedianTimeSpan int
+	// MinDifficulty is the lowest difficulty a block may claim, and
+	// MaxDifficultyStep how far it may move from its parent's; 0 leaves
+	// the step unbounded.
+	MinDifficulty     int
+	MaxDifficultyStep int
+	// MaxBlockTransactions caps how many transactions a block may carry
+	// besides its coinbase; 0 means no limit.
+	MaxBlockTransactions int
+	// GenesisHash, if set, is the hash the genesis block must have, and
+	// Checkpoints the hashes the blocks at the given heights must have.
+	// They anchor the chains and header chains accepted from peers.
+	GenesisHash string
+	Checkpoints map[int]string
+}
+
+// DefaultChainConfig returns the parameters used when none are configured.
+func DefaultChainConfig() *ChainConfig {
+	return &ChainConfig{
+		ChainID:              "cryptocypher",
+		HashAlgorithm:        "sha256",
+		GenesisAlloc:         GenesisAlloc{},
+		CoinbaseMaturity:     DefaultCoinbaseMaturity,
+		Reward:               RewardSchedule{Initial: DefaultBlockReward, HalvingInterval: DefaultHalvingInterval},
+		MaxFutureDrift:       DefaultMaxFutureDrift,
+		MedianTimeSpan:       DefaultMedianTimeSpan,
+		MinDifficulty:        DefaultMinDifficulty,
+		MaxDifficultyStep:    DefaultMaxDifficultyStep,
+		MaxBlockTransactions: DefaultMaxBlockTransactions,
+	}
+}
+
+// DefaultMaxBlockTransactions is the transaction cap used by DefaultChainConfig.
+const DefaultMaxBlockTransactions = 1000
+
+// ErrBlockTooLarge is returned for a block carrying more transactions than
+// the chain allows.
+var ErrBlockTooLarge = errors.New("block carries too many transactions")
+
+// BlockTransactions returns the transactions of pending, in order, that fit
+// in one block. Taking a prefix keeps a pool arranged with
+// OrderTransactions applying in order; the rest wait for a later block.
+func (cfg *ChainConfig) BlockTransactions(pending []*Transaction) []*Transaction {
+	if cfg.MaxBlockTransactions > 0 && len(pending) > cfg.MaxBlockTransactions {
+		return pending[:cfg.MaxBlockTransactions]
+	}
+	return pending
+}
+
+// SelectTransactions returns the transactions of pending that go into the
+// next block: those OrderTransactions can apply to balances, the state
+// before the block, in that order and capped by BlockTransactions. The
+// others wait in the pool.
+func (cfg *ChainConfig) SelectTransactions(pending []*Transaction, balances Ledger) []*Transaction {
+	ordered, _ := OrderTransactions(pending, balances)
+	return cfg.BlockTransactions(ordered)
+}
+
+// checkBlockSize reports whether b stays within cfg's transaction cap. The
+// coinbase is not counted, and neither are genesis allocations.
+func checkBlockSize(b *Block, cfg *ChainConfig) error {
+	if cfg.MaxBlockTransactions <= 0 || b.Index == 0 {
+		return nil
+	}
+	n := 0
+	for _, tx := range b.Transactions {
+		if !tx.IsCoinbase() {
+			n++
+		}
+	}
+	if n > cfg.MaxBlockTransactions {
+		return fmt.Errorf("block %d: %w: %d > %d", b.Index, ErrBlockTooLarge, n, cfg.MaxBlockTransactions)
+	}
+	return nil
+}
+
+// ErrCheckpointMismatch is returned for a block or header whose hash differs
+// from the one the chain pins at its height.
+var ErrCheckpointMismatch = errors.New("hash does not match the checkpoint")
+
+// checkCheckpoint reports whether hash is allowed at height index by cfg's
+// genesis hash and checkpoints.
+func (cfg *ChainConfig) checkCheckpoint(index int, hash string) error {
+	want, ok := cfg.Checkpoints[index]
+	if index == 0 && cfg.GenesisHash != "" {
+		want, ok = cfg.GenesisHash, true
+	}
+	if ok && hash != want {
+		return fmt.Errorf("block %d: %w %s", index, ErrCheckpointMismatch, want)
+	}
+	return nil
+}
+
+// VerifyCheckpoints checks headers against cfg's genesis hash and
+// checkpoints.
+func (cfg *ChainConfig) VerifyCheckpoints(headers []LightBlockHeader) error {
+	for _, hd := range headers {
+		if err := cfg.checkCheckpoint(hd.Index, hd.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hasher returns the configured hash algorithm.
+func (cfg *ChainConfig) Hasher() (Hasher, error) {
+	h, err := HasherByName(cfg.HashAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("chain %s: %w", cfg.ChainID, err)
+	}
+	return h, nil
+}
@@ -2,23 +2,68 @@
 package blockchain
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"slices"
 	"sync"
+	"time"
 )
 
+// ErrInvalidVote is returned by CastSignedVote for a vote that cannot be
+// counted: a bad signature, an unknown or slashed validator or a repeated
+// vote. ErrDoubleVote is returned for a vote for a second block at the same
+// height, for which the validator is slashed.
+var (
+	ErrInvalidVote = errors.New("invalid vote")
+	ErrDoubleVote  = errors.New("validator voted for conflicting blocks")
+)
+
+// DefaultSlashFraction is the share of its stake a validator loses for
+// voting twice at one height; see HybridConsensusManager.SlashFraction.
+const DefaultSlashFraction = 0.5
+
+// SlashRecord describes a validator slashed for a double vote. The two
+// signatures, each made with SignVote and verified before the validator
+// was slashed, are the evidence.
+type SlashRecord struct {
+	Validator       string  `json:"validator"`
+	Height          int     `json:"height"`
+	FirstHash       string  `json:"first_hash"`  // Block the validator voted for first.
+	SecondHash      string  `json:"second_hash"` // Conflicting block whose vote was discarded.
+	FirstSignature  []byte  `json:"first_signature"`
+	SecondSignature []byte  `json:"second_signature"`
+	Penalty         float64 `json:"penalty"` // Stake taken from the validator.
+}
+
 // CandidateBlock represents a proposed block with associated work and votes.
 type CandidateBlock struct {
 	Block      *Block
-	Work       int // For example, the nonce value (as a proxy for work)
-	ValidVotes int // Sum of votes (weighted by stake)
+	Work       int       // For example, the nonce value (as a proxy for work)
+	ValidVotes float64   // Stake of the validators approving the block.
+	Proposed   time.Time // When the block was proposed; see PruneExpired.
+
+	voters map[string]bool // Validators whose approval is counted.
 }
 
 // HybridConsensusManager handles candidate block proposals and validator votes.
 type HybridConsensusManager struct {
 	CandidateBlocks []*CandidateBlock
-	Stakeholders    map[string]float64 // e.g., {"Miner1":50.0, "Validator1":30.0, ...}
+	Stakeholders    map[string]float64 // Stake by validator; see ValidatorID.
 	VoteThreshold   float64            // e.g., 0.67 (67% of total stake)
+	SlashFraction   float64            // Share of stake forfeited for a double vote.
 	mu              sync.Mutex
+
+	votes   map[string]map[int]signedVote // Vote each validator cast, by height.
+	slashed []SlashRecord
+}
+
+// signedVote is a verified approval of the block with hash.
+type signedVote struct {
+	hash string
+	sig  []byte
 }
 
 // NewHybridConsensusManager creates a new consensus manager.
@@ -27,6 +72,7 @@ func NewHybridConsensusManager() *HybridConsensusManager {
 		CandidateBlocks: []*CandidateBlock{},
 		Stakeholders:    make(map[string]float64),
 		VoteThreshold:   0.67,
+		SlashFraction:   DefaultSlashFraction,
 	}
 }
 
@@ -38,39 +84,186 @@ func (hcm *HybridConsensusManager) ProposeBlock(b *Block) {
 		Block:      b,
 		Work:       b.Nonce,
 		ValidVotes: 0,
+		Proposed:   time.Now(),
 	}
 	hcm.CandidateBlocks = append(hcm.CandidateBlocks, candidate)
 	fmt.Printf("Block proposed: %s with work %d\n", b.Hash, b.Nonce)
 }
 
-// CastVote adds a vote (true for approval) from a validator.
-func (hcm *HybridConsensusManager) CastVote(candidateIndex int, validator string, vote bool) {
+// ValidatorID returns the key under which the validator with public key
+// pub is listed in Stakeholders: the address derived from the key.
+func ValidatorID(pub *ecdsa.PublicKey) string {
+	return AddressFromPublicKey(elliptic.Marshal(pub.Curve, pub.X, pub.Y))
+}
+
+// AddValidator gives the validator with public key pub the given stake.
+func (hcm *HybridConsensusManager) AddValidator(pub *ecdsa.PublicKey, stake float64) {
 	hcm.mu.Lock()
 	defer hcm.mu.Unlock()
-	if candidateIndex < 0 || candidateIndex >= len(hcm.CandidateBlocks) {
-		fmt.Println("Invalid candidate index")
-		return
+	hcm.Stakeholders[ValidatorID(pub)] = stake
+}
+
+// voteDigest is what a validator signs to approve candidate b.
+func voteDigest(b *Block) []byte {
+	digest := sha256.Sum256([]byte(b.Hash))
+	return digest[:]
+}
+
+// SignVote signs an approving vote for the candidate block b.
+func SignVote(b *Block, privKey *ecdsa.PrivateKey) ([]byte, error) {
+	return signDigest(privKey, voteDigest(b))
+}
+
+// VerifyVote reports whether sig is pub's approving vote for block b, as
+// made by SignVote. It checks the evidence in a SlashRecord.
+func VerifyVote(b *Block, pub *ecdsa.PublicKey, sig []byte) bool {
+	return verifyDigest(pub, voteDigest(b), sig)
+}
+
+// CastSignedVote counts an approving vote for the candidate with
+// blockHash if sig, made with SignVote, verifies against validatorPubKey
+// over that hash. The validator's stake is looked up by its public key,
+// and each validator is counted once per candidate. Only signed votes
+// count, so no one can vote, or get a validator slashed, in another's
+// name.
+func (hcm *HybridConsensusManager) CastSignedVote(blockHash string, validatorPubKey *ecdsa.PublicKey, sig []byte) error {
+	hcm.mu.Lock()
+	defer hcm.mu.Unlock()
+	i := slices.IndexFunc(hcm.CandidateBlocks, func(c *CandidateBlock) bool {
+		return c.Block.Hash == blockHash
+	})
+	if i < 0 {
+		return fmt.Errorf("%w: no candidate %s", ErrInvalidVote, blockHash)
+	}
+	candidate := hcm.CandidateBlocks[i]
+	if !VerifyVote(candidate.Block, validatorPubKey, sig) {
+		return fmt.Errorf("%w: signature does not match the validator key", ErrInvalidVote)
+	}
+	return hcm.approveLocked(candidate, ValidatorID(validatorPubKey), sig)
+}
+
+// approveLocked adds the stake of validator to candidate's votes; sig is
+// the validator's verified signature over it. A validator that already
+// approved another block at the same height is slashed instead, with both
+// signatures kept as evidence, and neither this nor any later vote of
+// theirs counts.
+func (hcm *HybridConsensusManager) approveLocked(candidate *CandidateBlock, validator string, sig []byte) error {
+	stake, exists := hcm.Stakeholders[validator]
+	if !exists {
+		return fmt.Errorf("%w: validator %s not found", ErrInvalidVote, validator)
 	}
-	if vote {
-		stake, exists := hcm.Stakeholders[validator]
-		if !exists {
-			fmt.Printf("Validator %s not found\n", validator)
-			return
+	if hcm.isSlashedLocked(validator) {
+		return fmt.Errorf("%w: validator %s is slashed", ErrInvalidVote, validator)
+	}
+	if candidate.voters[validator] {
+		return fmt.Errorf("%w: validator %s already voted", ErrInvalidVote, validator)
+	}
+	height, hash := candidate.Block.Index, candidate.Block.Hash
+	if first, voted := hcm.votes[validator][height]; voted && first.hash != hash {
+		penalty := stake * hcm.SlashFraction
+		hcm.Stakeholders[validator] = stake - penalty
+		hcm.slashed = append(hcm.slashed, SlashRecord{
+			Validator:       validator,
+			Height:          height,
+			FirstHash:       first.hash,
+			SecondHash:      hash,
+			FirstSignature:  first.sig,
+			SecondSignature: sig,
+			Penalty:         penalty,
+		})
+		return fmt.Errorf("%w: %s at height %d", ErrDoubleVote, validator, height)
+	}
+
+	if hcm.votes == nil {
+		hcm.votes = make(map[string]map[int]signedVote)
+	}
+	if hcm.votes[validator] == nil {
+		hcm.votes[validator] = make(map[int]signedVote)
+	}
+	hcm.votes[validator][height] = signedVote{hash: hash, sig: sig}
+	if candidate.voters == nil {
+		candidate.voters = make(map[string]bool)
+	}
+	candidate.voters[validator] = true
+	candidate.ValidVotes += stake
+	return nil
+}
+
+// isSlashedLocked reports whether validator has been slashed.
+func (hcm *HybridConsensusManager) isSlashedLocked(validator string) bool {
+	for _, r := range hcm.slashed {
+		if r.Validator == validator {
+			return true
 		}
-		hcm.CandidateBlocks[candidateIndex].ValidVotes += int(stake * 100) // Scale stake for demo.
 	}
+	return false
 }
 
-// FinalizeBlock returns a candidate block if it meets the threshold.
-func (hcm *HybridConsensusManager) FinalizeBlock(totalStake int) *Block {
+// SlashReport lists the validators slashed for double votes, in the order
+// they were caught.
+func (hcm *HybridConsensusManager) SlashReport() []SlashRecord {
 	hcm.mu.Lock()
 	defer hcm.mu.Unlock()
-	threshold := int(float64(totalStake) * hcm.VoteThreshold)
+	return append([]SlashRecord(nil), hcm.slashed...)
+}
+
+// stakeEpsilon absorbs float rounding when comparing stake, so that, for
+// example, approvals of 0.1+0.2 meet a threshold of 0.3.
+const stakeEpsilon = 1e-9
+
+// FinalizeBlock returns the first candidate block approved by at least
+// VoteThreshold of totalStake, or nil if none is. The finalized candidate
+// and every other candidate at its height are dropped, along with the
+// votes cast at that height.
+func (hcm *HybridConsensusManager) FinalizeBlock(totalStake float64) *Block {
+	hcm.mu.Lock()
+	defer hcm.mu.Unlock()
+	threshold := totalStake * hcm.VoteThreshold
 	for _, candidate := range hcm.CandidateBlocks {
-		if candidate.ValidVotes >= threshold {
-			fmt.Printf("Finalizing block %s with votes %d (threshold %d)\n", candidate.Block.Hash, candidate.ValidVotes, threshold)
+		if candidate.ValidVotes >= threshold-stakeEpsilon {
+			fmt.Printf("Finalizing block %s with votes %g (threshold %g)\n", candidate.Block.Hash, candidate.ValidVotes, threshold)
+			height := candidate.Block.Index
+			hcm.CandidateBlocks = slices.DeleteFunc(hcm.CandidateBlocks, func(c *CandidateBlock) bool {
+				return c.Block.Index == height
+			})
+			hcm.pruneVotesLocked()
 			return candidate.Block
 		}
 	}
 	return nil
 }
+
+// PruneExpired drops candidates proposed more than maxAge ago and returns
+// how many were dropped. Votes cast at heights left without candidates
+// are forgotten too.
+func (hcm *HybridConsensusManager) PruneExpired(maxAge time.Duration) int {
+	hcm.mu.Lock()
+	defer hcm.mu.Unlock()
+	before := len(hcm.CandidateBlocks)
+	cutoff := time.Now().Add(-maxAge)
+	hcm.CandidateBlocks = slices.DeleteFunc(hcm.CandidateBlocks, func(c *CandidateBlock) bool {
+		return c.Proposed.Before(cutoff)
+	})
+	hcm.pruneVotesLocked()
+	return before - len(hcm.CandidateBlocks)
+}
+
+// pruneVotesLocked forgets the votes cast at heights that no longer have a
+// candidate, so that the record of votes kept for double-vote detection
+// stays bounded by the open candidates.
+func (hcm *HybridConsensusManager) pruneVotesLocked() {
+	open := make(map[int]bool, len(hcm.CandidateBlocks))
+	for _, c := range hcm.CandidateBlocks {
+		open[c.Block.Index] = true
+	}
+	for validator, byHeight := range hcm.votes {
+		for height := range byHeight {
+			if !open[height] {
+				delete(byHeight, height)
+			}
+		}
+		if len(byHeight) == 0 {
+			delete(hcm.votes, validator)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package blockchain_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cryptocypher/pkg/blockchain"
+	"cryptocypher/pkg/wallet"
+)
+
+// castVote approves candidate b with w's signature.
+func castVote(t *testing.T, hcm *blockchain.HybridConsensusManager, b *blockchain.Block, w *wallet.Wallet) error {
+	t.Helper()
+	sig, err := blockchain.SignVote(b, w.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hcm.CastSignedVote(b.Hash, w.PublicKey, sig)
+}
+
+func TestCastSignedVote(t *testing.T) {
+	validator := testWallet(t, 1)
+	forger := testWallet(t, 2)
+	hcm := blockchain.NewHybridConsensusManager()
+	hcm.AddValidator(validator.PublicKey, 30)
+
+	candidate := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	hcm.ProposeBlock(candidate)
+
+	// A vote signed by another key cannot be passed off as the validator's.
+	forged, err := blockchain.SignVote(candidate, forger.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hcm.CastSignedVote(candidate.Hash, validator.PublicKey, forged); !errors.Is(err, blockchain.ErrInvalidVote) {
+		t.Errorf("expected ErrInvalidVote for a forged vote, got %v", err)
+	}
+	// Nor can the forger vote with its own, stakeless, key.
+	if err := hcm.CastSignedVote(candidate.Hash, forger.PublicKey, forged); !errors.Is(err, blockchain.ErrInvalidVote) {
+		t.Errorf("expected ErrInvalidVote for an unknown validator, got %v", err)
+	}
+	if votes := hcm.CandidateBlocks[0].ValidVotes; votes != 0 {
+		t.Fatalf("rejected votes were counted: %v", votes)
+	}
+
+	sig, err := blockchain.SignVote(candidate, validator.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hcm.CastSignedVote(candidate.Hash, validator.PublicKey, sig); err != nil {
+		t.Fatalf("signed vote rejected: %v", err)
+	}
+	counted := hcm.CandidateBlocks[0].ValidVotes
+	if counted == 0 {
+		t.Fatal("signed vote was not counted")
+	}
+	if err := hcm.CastSignedVote(candidate.Hash, validator.PublicKey, sig); !errors.Is(err, blockchain.ErrInvalidVote) {
+		t.Errorf("expected a repeated vote to be rejected, got %v", err)
+	}
+	if hcm.CandidateBlocks[0].ValidVotes != counted {
+		t.Error("repeated vote was counted twice")
+	}
+}
+
+func TestFinalizeBlockFractionalStake(t *testing.T) {
+	candidate := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	// 0.1+0.1 of 0.3 is two thirds, despite float rounding.
+	a, b, c := testWallet(t, 1), testWallet(t, 2), testWallet(t, 3)
+	frac := blockchain.NewHybridConsensusManager()
+	frac.AddValidator(a.PublicKey, 0.1)
+	frac.AddValidator(b.PublicKey, 0.1)
+	frac.AddValidator(c.PublicKey, 0.1)
+	frac.VoteThreshold = 2.0 / 3
+	frac.ProposeBlock(candidate)
+	castVote(t, frac, candidate, a)
+	if frac.FinalizeBlock(0.3) != nil {
+		t.Error("finalized with a third of the stake")
+	}
+	castVote(t, frac, candidate, b)
+	if frac.FinalizeBlock(0.3) != candidate {
+		t.Errorf("expected finalization with two thirds of the stake, votes %v", frac.CandidateBlocks[0].ValidVotes)
+	}
+}
+
+func TestFinalizeBlockCrossesThreshold(t *testing.T) {
+	validators := map[string]*wallet.Wallet{
+		"Miner1":     testWallet(t, 1),
+		"Validator1": testWallet(t, 2),
+		"Validator2": testWallet(t, 3),
+	}
+	for _, tc := range []struct {
+		voters []string
+		want   bool
+	}{
+		{[]string{"Miner1"}, false},                     // 50
+		{[]string{"Validator1", "Validator2"}, false},   // 50
+		{[]string{"Miner1", "Validator2"}, true},        // 70
+		{[]string{"Miner1", "Validator1"}, true},        // 80
+		{[]string{"Miner1", "Miner1", "Miner1"}, false}, // Repeats count once.
+	} {
+		hcm := blockchain.NewHybridConsensusManager()
+		hcm.AddValidator(validators["Miner1"].PublicKey, 50)
+		hcm.AddValidator(validators["Validator1"].PublicKey, 30)
+		hcm.AddValidator(validators["Validator2"].PublicKey, 20)
+		candidate := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		hcm.ProposeBlock(candidate)
+		for _, v := range tc.voters {
+			castVote(t, hcm, candidate, validators[v])
+		}
+		if got := hcm.FinalizeBlock(100) != nil; got != tc.want {
+			t.Errorf("voters %v: expected finalized=%v at 67%%", tc.voters, tc.want)
+		}
+	}
+}
+
+func TestDoubleVoteIsSlashed(t *testing.T) {
+	validator := testWallet(t, 1)
+	id := blockchain.ValidatorID(validator.PublicKey)
+	hcm := blockchain.NewHybridConsensusManager()
+	hcm.AddValidator(validator.PublicKey, 40)
+
+	// Two conflicting candidates at height 1, and one at height 2.
+	genesis := blockchain.NewGenesisBlock(nil, 1)
+	first := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "first", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	second := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "second", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner2", 12.5)
+	next := blockchain.CreateBlock(2, first.Hash, "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	for _, b := range []*blockchain.Block{first, second, next} {
+		hcm.ProposeBlock(b)
+	}
+	if err := castVote(t, hcm, first, validator); err != nil {
+		t.Fatal(err)
+	}
+	if len(hcm.SlashReport()) != 0 {
+		t.Fatal("slashed for a single vote")
+	}
+	if err := castVote(t, hcm, second, validator); !errors.Is(err, blockchain.ErrDoubleVote) {
+		t.Fatalf("expected ErrDoubleVote, got %v", err)
+	}
+	if votes := hcm.CandidateBlocks[1].ValidVotes; votes != 0 {
+		t.Errorf("conflicting vote was counted: %v", votes)
+	}
+	if stake := hcm.Stakeholders[id]; stake != 20 {
+		t.Errorf("expected stake halved to 20, got %v", stake)
+	}
+	report := hcm.SlashReport()
+	if len(report) != 1 || report[0].Validator != id || report[0].Height != 1 ||
+		report[0].FirstHash != first.Hash || report[0].SecondHash != second.Hash || report[0].Penalty != 20 {
+		t.Errorf("unexpected slash report: %+v", report)
+	}
+	// The report carries both votes as evidence anyone can check.
+	if !blockchain.VerifyVote(first, validator.PublicKey, report[0].FirstSignature) ||
+		!blockchain.VerifyVote(second, validator.PublicKey, report[0].SecondSignature) {
+		t.Error("slash evidence does not verify")
+	}
+
+	// A slashed validator's later votes are excluded.
+	if err := castVote(t, hcm, next, validator); !errors.Is(err, blockchain.ErrInvalidVote) {
+		t.Errorf("expected votes from a slashed validator to be refused, got %v", err)
+	}
+	if votes := hcm.CandidateBlocks[2].ValidVotes; votes != 0 {
+		t.Errorf("slashed validator's vote was counted: %v", votes)
+	}
+}
+
+func TestCandidateCleanup(t *testing.T) {
+	validator := testWallet(t, 1)
+	hcm := blockchain.NewHybridConsensusManager()
+	hcm.AddValidator(validator.PublicKey, 100)
+	genesis := blockchain.NewGenesisBlock(nil, 1)
+	candidate := func(index int, miner string) *blockchain.Block {
+		return blockchain.CreateBlock(index, genesis.Hash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, miner, 12.5)
+	}
+	winner, rival, later, stale := candidate(1, "Miner1"), candidate(1, "Miner2"), candidate(2, "Miner1"), candidate(3, "Miner1")
+	for _, b := range []*blockchain.Block{winner, rival, later, stale} {
+		hcm.ProposeBlock(b)
+	}
+
+	castVote(t, hcm, winner, validator)
+	if hcm.FinalizeBlock(100) != winner {
+		t.Fatal("expected the approved candidate to be finalized")
+	}
+	if len(hcm.CandidateBlocks) != 2 || hcm.CandidateBlocks[0].Block != later || hcm.CandidateBlocks[1].Block != stale {
+		t.Fatalf("expected only the candidates above the finalized height to remain, got %d", len(hcm.CandidateBlocks))
+	}
+
+	hcm.CandidateBlocks[1].Proposed = time.Now().Add(-time.Hour)
+	if n := hcm.PruneExpired(time.Minute); n != 1 {
+		t.Errorf("expected 1 expired candidate, pruned %d", n)
+	}
+	if len(hcm.CandidateBlocks) != 1 || hcm.CandidateBlocks[0].Block != later {
+		t.Errorf("expected the fresh candidate to survive pruning")
+	}
+
+	// Votes address candidates by hash, so pruning does not redirect them.
+	if err := castVote(t, hcm, later, validator); err != nil {
+		t.Fatal(err)
+	}
+	if hcm.CandidateBlocks[0].ValidVotes != 100 {
+		t.Errorf("vote did not reach its candidate after pruning")
+	}
+	if err := castVote(t, hcm, stale, validator); !errors.Is(err, blockchain.ErrInvalidVote) {
+		t.Errorf("expected a vote for a pruned candidate to be refused, got %v", err)
+	}
+
+	// Votes at a finalized height are forgotten: a later candidate there
+	// starts from a clean record rather than slashing past voters.
+	if hcm.FinalizeBlock(100) != later {
+		t.Fatal("expected the approved candidate to be finalized")
+	}
+	replay := candidate(2, "Miner2")
+	hcm.ProposeBlock(replay)
+	if err := castVote(t, hcm, replay, validator); err != nil {
+		t.Errorf("vote at a finalized height was held against the validator: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+// File: pkg/blockchain/crosslink.go
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// CrosslinkCategory is the category of the main chain blocks that commit
+// shard tips.
+const CrosslinkCategory = "crosslink"
+
+// Crosslink records the tip of a shard at the time it was committed.
+type Crosslink struct {
+	ShardID   int    `json:"shard_id"`
+	Height    int    `json:"height"`
+	BlockHash string `json:"block_hash"`
+}
+
+// Commit collects a crosslink for every shard that has blocks and queues
+// them for the next main chain block, replacing any still waiting. The
+// crosslinks only anchor the shards once a block made with
+// CreateCrosslinkBlockContext carries them: its proof of work then covers
+// them, and the shards' blocks inherit the main chain's ordering. It
+// returns the queued crosslinks.
+func (bc *BeaconChain) Commit() ([]Crosslink, error) {
+	var links []Crosslink
+	for _, shard := range bc.Shards {
+		tip := shard.Blockchain.Tip()
+		if tip == nil {
+			continue
+		}
+		links = append(links, Crosslink{ShardID: shard.ID, Height: tip.Index, BlockHash: tip.Hash})
+	}
+	if len(links) == 0 {
+		return nil, fmt.Errorf("no shard has blocks to commit")
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.crosslinks = links
+	return slices.Clone(links), nil
+}
+
+// PendingCrosslinks returns the crosslinks queued by Commit that no main
+// chain block has carried yet.
+func (bc *BeaconChain) PendingCrosslinks() []Crosslink {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return slices.Clone(bc.crosslinks)
+}
+
+// CrosslinksCommitted removes links, carried by a block the main chain
+// accepted, from the queue. Crosslinks queued by a later Commit stay.
+func (bc *BeaconChain) CrosslinksCommitted(links []Crosslink) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.crosslinks = slices.DeleteFunc(bc.crosslinks, func(l Crosslink) bool {
+		return slices.Contains(links, l)
+	})
+}
+
+// CreateCrosslinkBlockContext is CreateBlockContext for a main chain block
+// of category CrosslinkCategory that carries links in its hashed payload.
+func CreateCrosslinkBlockContext(ctx context.Context, index int, prevHash string, links []Crosslink,
+	txPool *TransactionPool, difficulty int, minerAddress string, reward float64) (*Block, error) {
+	return createCrosslinkBlock(ctx, index, prevHash, links, txPool.Pending(), difficulty, minerAddress, reward)
+}
+
+// CreateCrosslinkBlockContext is the package's CreateCrosslinkBlockContext
+// for a chain with the parameters cfg, choosing the transactions of txPool
+// as ChainConfig.CreateBlockContext does.
+func (cfg *ChainConfig) CreateCrosslinkBlockContext(ctx context.Context, index int, prevHash string, links []Crosslink,
+	txPool *TransactionPool, balances Ledger, difficulty int, minerAddress string) (*Block, error) {
+	return createCrosslinkBlock(ctx, index, prevHash, links, cfg.SelectTransactions(txPool.Pending(), balances),
+		difficulty, minerAddress, cfg.Reward.RewardAt(index))
+}
+
+func createCrosslinkBlock(ctx context.Context, index int, prevHash string, links []Crosslink,
+	pending []*Transaction, difficulty int, minerAddress string, reward float64) (*Block, error) {
+	data, err := json.Marshal(links)
+	if err != nil {
+		return nil, err
+	}
+	block := assembleBlock(index, prevHash, "one-to-one", []string{}, string(data), "", "",
+		pending, difficulty, minerAddress, reward)
+	block.Category = CrosslinkCategory
+	if err := MineBlockContext(ctx, block, difficulty); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Crosslinks decodes the crosslinks committed by a block of category
+// CrosslinkCategory.
+func Crosslinks(b *Block) ([]Crosslink, error) {
+	if b.Category != CrosslinkCategory {
+		return nil, fmt.Errorf("block %s is a %q block, not a crosslink", b.Hash, b.Category)
+	}
+	var links []Crosslink
+	if err := json.Unmarshal([]byte(b.TextData), &links); err != nil {
+		return nil, fmt.Errorf("decoding crosslinks: %w", err)
+	}
+	return links, nil
+}
@@ -7,9 +7,14 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"math/big"
 )
 
+// ErrInvalidSignature is returned by VerifySignedTransaction when the
+// signature does not match the transaction and its sender.
+var ErrInvalidSignature = errors.New("invalid transaction signature")
+
 // GenerateKeyPair creates a new ECDSA key pair.
 func GenerateKeyPair() (*ecdsa.PrivateKey, error) {
 	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -18,12 +23,10 @@ func GenerateKeyPair() (*ecdsa.PrivateKey, error) {
 // SignTransaction signs a transaction using the provided private key.
 func SignTransaction(tx *Transaction, privKey *ecdsa.PrivateKey) (string, error) {
 	txHash := sha256.Sum256([]byte(tx.String()))
-	r, s, err := ecdsa.Sign(rand.Reader, privKey, txHash[:])
+	signature, err := signDigest(privKey, txHash[:])
 	if err != nil {
 		return "", err
 	}
-	// Serialize signature (concatenate r and s)
-	signature := append(r.Bytes(), s.Bytes()...)
 	return hex.EncodeToString(signature), nil
 }
 
@@ -37,12 +40,56 @@ func VerifyTransactionSignature(tx *Transaction, pubKey *ecdsa.PublicKey) bool {
 		return false
 	}
 	txHash := sha256.Sum256([]byte(tx.String()))
-	// Assuming r and s are of equal length.
-	sigLen := len(sigBytes)
-	if sigLen%2 != 0 {
+	return verifyDigest(pubKey, txHash[:], sigBytes)
+}
+
+// signDigest signs digest and serializes the signature as r followed by s.
+// Both are padded to the curve size so the verifier can split the
+// signature in half.
+func signDigest(privKey *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, privKey, digest)
+	if err != nil {
+		return nil, err
+	}
+	size := (privKey.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signature, nil
+}
+
+// verifyDigest checks a signature produced by signDigest.
+func verifyDigest(pubKey *ecdsa.PublicKey, digest, sig []byte) bool {
+	if pubKey == nil || len(sig) == 0 || len(sig)%2 != 0 {
 		return false
 	}
-	r := new(big.Int).SetBytes(sigBytes[:sigLen/2])
-	s := new(big.Int).SetBytes(sigBytes[sigLen/2:])
-	return ecdsa.Verify(pubKey, txHash[:], r, s)
+	r := new(big.Int).SetBytes(sig[:len(sig)/2])
+	s := new(big.Int).SetBytes(sig[len(sig)/2:])
+	return ecdsa.Verify(pubKey, digest, r, s)
+}
+
+// VerifySignedTransaction checks the signature of tx against its sender.
+// tx.PublicKey holds the hex-encoded uncompressed P-256 public key, which
+// must derive to the sender's address. Transactions without PublicKey are
+// from before addresses and carry the key itself as the sender.
+func VerifySignedTransaction(tx *Transaction) error {
+	keyHex := tx.PublicKey
+	if keyHex == "" {
+		keyHex = tx.Sender
+	}
+	pubKeyBytes, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return errors.New("invalid sender public key format")
+	}
+	if tx.PublicKey != "" && !AddressMatchesKey(tx.Sender, pubKeyBytes) {
+		return errors.New("public key does not match the sender address")
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), pubKeyBytes)
+	if x == nil || y == nil {
+		return errors.New("could not unmarshal sender public key")
+	}
+	if !VerifyTransactionSignature(tx, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}) {
+		return ErrInvalidSignature
+	}
+	return nil
 }
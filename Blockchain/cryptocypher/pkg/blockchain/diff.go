@@ -0,0 +1,55 @@
+// File: pkg/blockchain/diff.go
+package blockchain
+
+import "sort"
+
+// Statuses reported by DiffChains.
+const (
+	DiffSame      = "same"
+	DiffDifferent = "different"
+	DiffOnlyA     = "only_a"
+	DiffOnlyB     = "only_b"
+)
+
+// ChainDiff compares the blocks two chains hold at one height.
+type ChainDiff struct {
+	Height int    `json:"height"`
+	Status string `json:"status"`
+	HashA  string `json:"hash_a,omitempty"`
+	HashB  string `json:"hash_b,omitempty"`
+}
+
+// DiffChains compares a and b height by height and returns one entry per
+// height held by either chain, lowest first. The first entry whose status
+// is not DiffSame marks where the chains diverge.
+func DiffChains(a, b []*Block) []ChainDiff {
+	byHeight := make(map[int]*ChainDiff)
+	for _, blk := range a {
+		byHeight[blk.Index] = &ChainDiff{Height: blk.Index, HashA: blk.Hash}
+	}
+	for _, blk := range b {
+		d, ok := byHeight[blk.Index]
+		if !ok {
+			d = &ChainDiff{Height: blk.Index}
+			byHeight[blk.Index] = d
+		}
+		d.HashB = blk.Hash
+	}
+
+	diffs := make([]ChainDiff, 0, len(byHeight))
+	for _, d := range byHeight {
+		switch {
+		case d.HashB == "":
+			d.Status = DiffOnlyA
+		case d.HashA == "":
+			d.Status = DiffOnlyB
+		case d.HashA == d.HashB:
+			d.Status = DiffSame
+		default:
+			d.Status = DiffDifferent
+		}
+		diffs = append(diffs, *d)
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Height < diffs[j].Height })
+	return diffs
+}
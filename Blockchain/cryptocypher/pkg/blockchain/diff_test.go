@@ -0,0 +1,37 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestDiffChainsReportsDivergence(t *testing.T) {
+	newBlock := func(index int, prev *blockchain.Block, miner string) *blockchain.Block {
+		prevHash := ""
+		if prev != nil {
+			prevHash = prev.Hash
+		}
+		return blockchain.CreateBlock(index, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, miner, 12.5)
+	}
+	genesis := newBlock(0, nil, "Miner1")
+	block1 := newBlock(1, genesis, "Miner1")
+	local := []*blockchain.Block{genesis, block1, newBlock(2, block1, "Miner1")}
+	forkTip := newBlock(2, block1, "Miner2")
+	forked := []*blockchain.Block{genesis, block1, forkTip, newBlock(3, forkTip, "Miner2")}
+
+	diffs := blockchain.DiffChains(local, forked)
+	want := []string{blockchain.DiffSame, blockchain.DiffSame, blockchain.DiffDifferent, blockchain.DiffOnlyB}
+	if len(diffs) != len(want) {
+		t.Fatalf("expected %d heights, got %+v", len(want), diffs)
+	}
+	for i, d := range diffs {
+		if d.Height != i || d.Status != want[i] {
+			t.Errorf("height %d: expected %s, got %+v", i, want[i], d)
+		}
+	}
+	if diffs[2].HashA != local[2].Hash || diffs[2].HashB != forkTip.Hash {
+		t.Errorf("divergence point does not carry both hashes: %+v", diffs[2])
+	}
+}
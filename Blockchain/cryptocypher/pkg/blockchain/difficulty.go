@@ -2,30 +2,171 @@
 package blockchain
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"time"
 )
 
+// DifficultyAt returns the difficulty of the block at height, looking in
+// memory, then storage, then the archives written by pruning.
+func (bc *Blockchain) DifficultyAt(height int) (int, error) {
+	if b, err := bc.GetBlockByIndex(height); err == nil {
+		return b.Difficulty, nil
+	}
+	bc.mu.Lock()
+	archives := append([]string(nil), bc.Archives...)
+	bc.mu.Unlock()
+	for _, path := range archives {
+		blocks, err := LoadArchive(path)
+		if err != nil {
+			return 0, err
+		}
+		for _, b := range blocks {
+			if b.Index == height {
+				return b.Difficulty, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no block at height %d", height)
+}
+
+// MaxDifficulty is the highest difficulty AdjustDifficulty moves to.
+const MaxDifficulty = 32
+
+// DefaultMinDifficulty is the minimum difficulty used by DefaultChainConfig.
+const DefaultMinDifficulty = 1
+
+// ErrDifficultyTooLow is returned for a block claiming less than the chain's
+// minimum difficulty.
+var ErrDifficultyTooLow = errors.New("difficulty below the chain minimum")
+
+// DefaultMaxDifficultyStep is the difficulty step used by
+// DefaultChainConfig, the most AdjustDifficulty moves per block.
+const DefaultMaxDifficultyStep = 1
+
+// ErrDifficultyStep is returned for a block whose difficulty moves further
+// from its parent's than the chain allows.
+var ErrDifficultyStep = errors.New("difficulty moves too far from the parent's")
+
+// checkDifficultyStep reports whether b's difficulty is within
+// cfg.MaxDifficultyStep of its parent's. Without it a branch could drop to
+// the minimum difficulty right after a fork and outpace the honest chain.
+func checkDifficultyStep(b, parent *Block, cfg *ChainConfig) error {
+	if cfg.MaxDifficultyStep > 0 && abs(b.Difficulty-parent.Difficulty) > cfg.MaxDifficultyStep {
+		return fmt.Errorf("block %d: %w: %d after %d", b.Index, ErrDifficultyStep, b.Difficulty, parent.Difficulty)
+	}
+	return nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Work returns the number of hashes expected to find a block of the given
+// difficulty, which needs that many leading hex zeros.
+func Work(difficulty int) float64 {
+	return math.Pow(difficultyFactor, float64(difficulty))
+}
+
+// checkDifficulty reports whether b claims a difficulty cfg accepts and its
+// hash meets it. The claim comes from whoever mined b, so it is bounded
+// before any work is weighed by it.
+func checkDifficulty(b *Block, cfg *ChainConfig) error {
+	if b.Difficulty < max(cfg.MinDifficulty, 0) {
+		return fmt.Errorf("block %d: %w: %d < %d", b.Index, ErrDifficultyTooLow, b.Difficulty, cfg.MinDifficulty)
+	}
+	if !MeetsDifficulty(b.Hash, b.Difficulty) {
+		return fmt.Errorf("block %d does not meet its difficulty %d", b.Index, b.Difficulty)
+	}
+	return nil
+}
+
 // AdjustDifficulty recalculates difficulty based on the time taken to mine the last 'adjustmentInterval' blocks.
+// The difficulty moves by at most one per adjustment and stays within
+// [1, MaxDifficulty]. Elapsed time is measured in milliseconds where blocks
+// record them. Blocks mined less than a second apart count as one second
+// apart; timestamps that go backwards leave the difficulty unchanged.
 func AdjustDifficulty(chain []*Block, targetTimePerBlock time.Duration, adjustmentInterval int) int {
 	n := len(chain)
-	if n < adjustmentInterval {
+	if n == 0 {
+		return 1
+	}
+	if n < adjustmentInterval || adjustmentInterval < 1 {
 		return chain[n-1].Difficulty
 	}
 	start := chain[n-adjustmentInterval]
 	end := chain[n-1]
-	actualTime := time.Duration(end.Timestamp-start.Timestamp) * time.Second
-	expectedTime := targetTimePerBlock * time.Duration(adjustmentInterval)
 	currentDifficulty := chain[n-1].Difficulty
+	elapsed := end.Millis() - start.Millis()
+	if elapsed < 0 {
+		fmt.Printf("Keeping difficulty: block %d is timestamped before block %d\n", end.Index, start.Index)
+		return currentDifficulty
+	}
+	actualTime := max(time.Duration(elapsed)*time.Millisecond, time.Second)
+	expectedTime := targetTimePerBlock * time.Duration(adjustmentInterval)
 
 	if actualTime < expectedTime/2 {
-		fmt.Printf("Increasing difficulty: actual %v < expected/2 %v\n", actualTime, expectedTime/2)
-		return currentDifficulty + 1
+		if currentDifficulty < MaxDifficulty {
+			fmt.Printf("Increasing difficulty: actual %v < expected/2 %v\n", actualTime, expectedTime/2)
+			return currentDifficulty + 1
+		}
+		return MaxDifficulty
 	} else if actualTime > expectedTime*2 {
 		if currentDifficulty > 1 {
 			fmt.Printf("Decreasing difficulty: actual %v > expected*2 %v\n", actualTime, expectedTime*2)
-			return currentDifficulty - 1
+			return min(currentDifficulty-1, MaxDifficulty)
+		}
+		return 1
+	}
+	return min(max(currentDifficulty, 1), MaxDifficulty)
+}
+
+// DefaultEMAAlpha is the smoothing factor AdjustDifficultyEMA falls back to
+// when given one outside (0, 1].
+const DefaultEMAAlpha = 0.2
+
+// emaWindow is how many recent blocks AdjustDifficultyEMA averages over.
+const emaWindow = 100
+
+// difficultyFactor is how much more work each difficulty step requires:
+// one more leading hex zero.
+const difficultyFactor = 16
+
+// AdjustDifficultyEMA computes the next difficulty from an exponential
+// moving average of recent block times, weighting each newer block by
+// alpha. Every block time is first scaled to the current difficulty, so
+// blocks mined before earlier adjustments still predict the current rate.
+// The difficulty then moves by as many steps as bring the average to
+// targetTimePerBlock, within [1, MaxDifficulty]. It is an alternative to
+// AdjustDifficulty that does not oscillate around the target.
+func AdjustDifficultyEMA(chain []*Block, targetTimePerBlock time.Duration, alpha float64) int {
+	n := len(chain)
+	if n == 0 {
+		return 1
+	}
+	current := chain[n-1].Difficulty
+	if n < 2 {
+		return current
+	}
+	if alpha <= 0 || alpha > 1 {
+		alpha = DefaultEMAAlpha
+	}
+
+	ema := 0.0
+	for i := max(1, n-emaWindow); i < n; i++ {
+		// Same-millisecond and backwards timestamps count as one millisecond.
+		elapsed := float64(max(chain[i].Millis()-chain[i-1].Millis(), 1)) / 1000
+		scaled := elapsed * math.Pow(difficultyFactor, float64(current-chain[i].Difficulty))
+		if ema == 0 {
+			ema = scaled
+		} else {
+			ema = alpha*scaled + (1-alpha)*ema
 		}
 	}
-	return currentDifficulty
+	steps := math.Round(math.Log(targetTimePerBlock.Seconds()/ema) / math.Log(difficultyFactor))
+	return int(min(max(float64(current)+steps, 1), MaxDifficulty))
 }
@@ -0,0 +1,138 @@
+package blockchain_test
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestDifficultyAtAcrossPrunedRange(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	prevHash := ""
+	for i := 0; i < 6; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1+i%2, "Miner1", 12.5)
+		bc.AddBlock(b)
+		prevHash = b.Hash
+	}
+	if err := bc.PruneAndArchive(3, filepath.Join(t.TempDir(), "archive")); err != nil {
+		t.Fatal(err)
+	}
+
+	for height, want := range map[int]int{0: 1, 1: 2, 4: 1, 5: 2} {
+		got, err := bc.DifficultyAt(height)
+		if err != nil {
+			t.Errorf("height %d: %v", height, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("height %d: expected difficulty %d, got %d", height, want, got)
+		}
+	}
+	if _, err := bc.DifficultyAt(6); err == nil {
+		t.Error("expected an error for a height beyond the tip")
+	}
+}
+
+// timedChain returns blocks of the given difficulty with the given timestamps.
+func timedChain(difficulty int, timestamps ...int64) []*blockchain.Block {
+	chain := make([]*blockchain.Block, len(timestamps))
+	for i, ts := range timestamps {
+		chain[i] = &blockchain.Block{Index: i, Timestamp: ts, Difficulty: difficulty}
+	}
+	return chain
+}
+
+func TestAdjustDifficulty(t *testing.T) {
+	const target = 10 * time.Second
+	tests := []struct {
+		name  string
+		chain []*blockchain.Block
+		want  int
+	}{
+		{"on target", timedChain(5, 1000, 1010, 1020), 5},
+		{"too fast", timedChain(5, 1000, 1001, 1002), 6},
+		{"too slow", timedChain(5, 1000, 1100, 1200), 4},
+		{"equal timestamps", timedChain(5, 1000, 1000, 1000), 6},
+		{"decreasing timestamps", timedChain(5, 1000, 900, 800), 5},
+		{"at the ceiling", timedChain(blockchain.MaxDifficulty, 1000, 1000, 1000), blockchain.MaxDifficulty},
+		{"at the floor", timedChain(1, 1000, 1100, 1200), 1},
+		{"short chain", timedChain(5, 1000, 1000), 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blockchain.AdjustDifficulty(tt.chain, target, 3); got != tt.want {
+				t.Errorf("expected difficulty %d, got %d", tt.want, got)
+			}
+		})
+	}
+
+	// Repeated equal timestamps never push the difficulty past the ceiling.
+	chain := timedChain(1, 1000, 1000, 1000)
+	for i := 0; i < 2*blockchain.MaxDifficulty; i++ {
+		next := blockchain.AdjustDifficulty(chain, target, 3)
+		chain = append(chain, &blockchain.Block{Index: len(chain), Timestamp: 1000, Difficulty: next})
+	}
+	if got := chain[len(chain)-1].Difficulty; got != blockchain.MaxDifficulty {
+		t.Errorf("difficulty ran to %d, want the ceiling %d", got, blockchain.MaxDifficulty)
+	}
+}
+
+func TestAdjustDifficultyEMAConverges(t *testing.T) {
+	const target = time.Hour
+	// Blocks take the target time at difficulty 3 and a sixteenth of it
+	// for every step below.
+	const want = 3
+	blockTime := func(difficulty int) int64 {
+		return int64(target.Seconds() / math.Pow(16, float64(want-difficulty)))
+	}
+
+	chain := timedChain(1, 0)
+	var history []int
+	for i := 0; i < 30; i++ {
+		tip := chain[len(chain)-1]
+		next := blockchain.AdjustDifficultyEMA(chain, target, 0.3)
+		chain = append(chain, &blockchain.Block{Index: len(chain), Timestamp: tip.Timestamp + blockTime(next), Difficulty: next})
+		history = append(history, next)
+	}
+	for i, d := range history[len(history)-10:] {
+		if d != want {
+			t.Fatalf("difficulty %d at step %d did not settle at %d: %v", d, len(history)-10+i, want, history)
+		}
+	}
+
+	if got := blockchain.AdjustDifficultyEMA(timedChain(5, 1000), target, 0.3); got != 5 {
+		t.Errorf("a single block changed the difficulty to %d", got)
+	}
+}
+
+func TestDifficultyBelowMinimumRejected(t *testing.T) {
+	if blockchain.MeetsDifficulty("0abc", -1) {
+		t.Error("expected a negative difficulty never to be met")
+	}
+
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	bc := blockchain.NewBlockchain()
+	bc.AddBlock(genesis)
+	for _, difficulty := range []int{-3, 0} {
+		// Negative difficulties cannot be mined, so the claim is made after the fact.
+		b := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 0, "Miner1", 12.5)
+		b.Difficulty = difficulty
+		b.Hash = blockchain.CalculateHash(b)
+		if err := blockchain.ValidateChain([]*blockchain.Block{genesis, b}, nil); !errors.Is(err, blockchain.ErrDifficultyTooLow) {
+			t.Errorf("difficulty %d: expected ErrDifficultyTooLow from ValidateChain, got %v", difficulty, err)
+		}
+		if _, err := bc.AddBlockIfTip(b, genesis.Hash); !errors.Is(err, blockchain.ErrDifficultyTooLow) {
+			t.Errorf("difficulty %d: expected ErrDifficultyTooLow from AddBlockIfTip, got %v", difficulty, err)
+		}
+		if err := bc.AddSideBlock(b); !errors.Is(err, blockchain.ErrDifficultyTooLow) {
+			t.Errorf("difficulty %d: expected ErrDifficultyTooLow from AddSideBlock, got %v", difficulty, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// File: pkg/blockchain/events.go
+package blockchain
+
+import "errors"
+
+// BlockEvent announces a block joining the active chain or a sub-block
+// added to one of its blocks. Block is a shallow copy taken when the event
+// was published, so sub-blocks added later do not change it under a reader.
+type BlockEvent struct {
+	Block  *Block
+	Parent *Block // For a sub-block, the block it was added to; nil otherwise.
+	// Reorg is set for a block that joined the active chain by replacing
+	// the blocks at its height, through SelectHeaviestFork or ReplaceChain.
+	Reorg bool
+}
+
+// MaxSubscribers bounds how many subscriptions a chain serves at once.
+const MaxSubscribers = 1024
+
+// ErrTooManySubscribers is returned by Subscribe when MaxSubscribers
+// subscriptions are open.
+var ErrTooManySubscribers = errors.New("too many subscribers")
+
+// Subscribe returns a channel receiving a BlockEvent for every block that
+// joins the active chain and every sub-block added, and a function ending
+// the subscription. The channel holds up to buffer events; events for a
+// subscriber that has not drained it are dropped, so a slow subscriber
+// cannot hold up the chain.
+func (bc *Blockchain) Subscribe(buffer int) (<-chan BlockEvent, func(), error) {
+	ch := make(chan BlockEvent, buffer)
+	bc.subsMu.Lock()
+	defer bc.subsMu.Unlock()
+	if len(bc.subs) >= MaxSubscribers {
+		return nil, nil, ErrTooManySubscribers
+	}
+	if bc.subs == nil {
+		bc.subs = make(map[chan BlockEvent]struct{})
+	}
+	bc.subs[ch] = struct{}{}
+	return ch, func() {
+		bc.subsMu.Lock()
+		delete(bc.subs, ch)
+		bc.subsMu.Unlock()
+	}, nil
+}
+
+// publish sends ev to every subscriber with room for it.
+func (bc *Blockchain) publish(ev BlockEvent) {
+	bc.subsMu.Lock()
+	defer bc.subsMu.Unlock()
+	if len(bc.subs) == 0 {
+		return
+	}
+	snapshot := *ev.Block
+	ev.Block = &snapshot
+	for ch := range bc.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// File: pkg/blockchain/forks.go
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// maxSideBlocks bounds how many competing blocks are remembered.
+const maxSideBlocks = 256
+
+// ErrUnknownParent is returned by AddSideBlock for a block whose parent is
+// neither on the active chain nor a known side block.
+var ErrUnknownParent = errors.New("parent block is unknown")
+
+// ForkTip describes the head of a chain branch.
+type ForkTip struct {
+	Hash           string  `json:"hash"`
+	PrevHash       string  `json:"prev_hash"`
+	Height         int     `json:"height"`
+	CumulativeWork float64 `json:"cumulative_work"` // CumulativeWork over the blocks held in memory.
+}
+
+// AddSideBlock records b as a competing block that does not extend the
+// active tip. Its parent must be a block of the active chain or another
+// side block, and b must carry valid proof of work.
+func (bc *Blockchain) AddSideBlock(b *Block) error {
+	h, err := bc.config().Hasher()
+	if err != nil {
+		return err
+	}
+	if b.Hash != CalculateHashWith(b, h) {
+		return fmt.Errorf("block %d has an invalid hash", b.Index)
+	}
+	if err := checkDifficulty(b, bc.config()); err != nil {
+		return err
+	}
+	if err := checkBlockSize(b, bc.config()); err != nil {
+		return err
+	}
+	if err := bc.config().checkCheckpoint(b.Index, b.Hash); err != nil {
+		return err
+	}
+	// The median time past is checked once the branch is validated as a chain.
+	if err := checkTimestamp(b, nil, bc.config(), time.Now()); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if _, known := bc.sideBlocks[b.Hash]; known || bc.mainIndexLocked(b.Hash) >= 0 {
+		return fmt.Errorf("block %s is already known", b.Hash)
+	}
+	parent, ok := bc.sideBlocks[b.PrevHash]
+	if !ok {
+		i := bc.mainIndexLocked(b.PrevHash)
+		if i < 0 {
+			return fmt.Errorf("block %d: %w: %s", b.Index, ErrUnknownParent, b.PrevHash)
+		}
+		parent = bc.Blocks[i]
+	}
+	if b.Index != parent.Index+1 {
+		return fmt.Errorf("block %d does not follow its parent at height %d", b.Index, parent.Index)
+	}
+	if err := checkDifficultyStep(b, parent, bc.config()); err != nil {
+		return err
+	}
+	if len(bc.sideBlocks) >= maxSideBlocks && !bc.evictSideBlockLocked(b) {
+		return fmt.Errorf("too many competing blocks held")
+	}
+	if bc.sideBlocks == nil {
+		bc.sideBlocks = make(map[string]*Block)
+	}
+	bc.sideBlocks[b.Hash] = b
+	return nil
+}
+
+// evictSideBlockLocked makes room for b by dropping the branch tip with the
+// least cumulative work, the oldest among equals. Only tips are dropped, so
+// no remembered branch loses a block in its middle, and b's parent is kept.
+// It reports false, dropping nothing, when no tip has less work than b.
+// The caller must hold bc.mu.
+func (bc *Blockchain) evictSideBlockLocked(b *Block) bool {
+	hasChild := make(map[string]bool, len(bc.sideBlocks))
+	for _, s := range bc.sideBlocks {
+		hasChild[s.PrevHash] = true
+	}
+	var victim *Block
+	victimWork := bc.workLocked(b)
+	for hash, s := range bc.sideBlocks {
+		if hasChild[hash] || hash == b.PrevHash {
+			continue
+		}
+		work := bc.workLocked(s)
+		if work < victimWork || (victim != nil && work == victimWork && s.Index < victim.Index) {
+			victim, victimWork = s, work
+		}
+	}
+	if victim == nil {
+		return false
+	}
+	delete(bc.sideBlocks, victim.Hash)
+	return true
+}
+
+// SelectHeaviestFork makes the known branch with the most cumulative work
+// the active chain, so a side branch that overtakes the active one wins.
+// Blocks that leave the active chain are kept as side blocks, so the choice
+// can switch back. It reports whether the active chain changed.
+//
+// The attached ledger, if any, is moved along with the chain: the blocks
+// leaving are reverted and those joining applied. A branch whose blocks are
+// invalid or cannot be applied is refused with the error and the active
+// chain does not change; the invalid block and the side blocks built on it
+// are forgotten, so they cannot block the next heaviest branch.
+func (bc *Blockchain) SelectHeaviestFork() (bool, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if len(bc.Blocks) == 0 {
+		return false, nil
+	}
+	best, bestWork := (*Block)(nil), CumulativeWork(bc.Blocks)
+	for _, b := range bc.sideBlocks {
+		if work := bc.workLocked(b); work > bestWork {
+			best, bestWork = b, work
+		}
+	}
+	if best == nil {
+		return false, nil
+	}
+
+	// Walk back from the winning tip to where it leaves the active chain.
+	var branch []*Block
+	for b := best; ; {
+		branch = append(branch, b)
+		parent, ok := bc.sideBlocks[b.PrevHash]
+		if !ok {
+			break
+		}
+		b = parent
+	}
+	slices.Reverse(branch)
+	fork := bc.mainIndexLocked(branch[0].PrevHash)
+	if fork < 0 {
+		return false, fmt.Errorf("branch %s: %w", best.Hash, ErrUnknownParent)
+	}
+	candidate := append(slices.Clip(bc.Blocks[:fork+1]), branch...)
+	removed := bc.Blocks[fork+1:]
+
+	// The blocks up to the fork were validated when they joined the chain.
+	// Funds are checked against the ledger at the fork: the attached one
+	// with the leaving blocks undone, or one replayed from genesis. A
+	// pruned chain without a ledger has no balances to check them against.
+	cfg := bc.config()
+	h, err := cfg.Hasher()
+	if err != nil {
+		return false, err
+	}
+	var funds Ledger
+	switch {
+	case bc.ledger != nil:
+		funds = bc.ledger.clone()
+		if err := funds.Revert(removed); err != nil {
+			return false, err
+		}
+	case bc.Blocks[0].Index == 0:
+		if funds, err = BuildLedgerFromChain(bc.Blocks[:fork+1]); err != nil {
+			return false, err
+		}
+	}
+	now := time.Now()
+	for i, b := range branch {
+		if err := validateNext(b, candidate[:fork+1+i], cfg, h, now, funds); err != nil {
+			bc.dropSideBranchLocked(b)
+			return false, fmt.Errorf("branch %s: %w", best.Hash, err)
+		}
+	}
+	if bc.ledger != nil {
+		bc.ledger = funds
+	}
+
+	for _, b := range branch {
+		delete(bc.sideBlocks, b.Hash)
+	}
+	for _, b := range removed {
+		bc.sideBlocks[b.Hash] = b
+	}
+	bc.Blocks = candidate
+	bc.storeChainLocked()
+	for _, b := range branch {
+		bc.publish(BlockEvent{Block: b, Reorg: true})
+	}
+	return true, nil
+}
+
+// Forks returns the active tip and the tips of all known competing branches.
+func (bc *Blockchain) Forks() (active *ForkTip, alternatives []ForkTip) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	alternatives = []ForkTip{}
+	if len(bc.Blocks) == 0 {
+		return nil, alternatives
+	}
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	active = &ForkTip{Hash: tip.Hash, PrevHash: tip.PrevHash, Height: tip.Index, CumulativeWork: CumulativeWork(bc.Blocks)}
+
+	hasChild := make(map[string]bool)
+	for _, b := range bc.sideBlocks {
+		hasChild[b.PrevHash] = true
+	}
+	for hash, b := range bc.sideBlocks {
+		if hasChild[hash] {
+			continue
+		}
+		alternatives = append(alternatives, ForkTip{
+			Hash:           b.Hash,
+			PrevHash:       b.PrevHash,
+			Height:         b.Index,
+			CumulativeWork: bc.workLocked(b),
+		})
+	}
+	return active, alternatives
+}
+
+// workLocked returns the cumulative work up to and including side block b.
+// The caller must hold bc.mu.
+func (bc *Blockchain) workLocked(b *Block) float64 {
+	work := 0.0
+	for {
+		work += Work(b.Difficulty)
+		parent, ok := bc.sideBlocks[b.PrevHash]
+		if !ok {
+			break
+		}
+		b = parent
+	}
+	if i := bc.mainIndexLocked(b.PrevHash); i >= 0 {
+		work += CumulativeWork(bc.Blocks[:i+1])
+	}
+	return work
+}
+
+// dropSideBranchLocked forgets side block b and every side block built on
+// it. The caller must hold bc.mu.
+func (bc *Blockchain) dropSideBranchLocked(b *Block) {
+	dropped := map[string]bool{b.Hash: true}
+	for grew := true; grew; {
+		grew = false
+		for hash, s := range bc.sideBlocks {
+			if dropped[s.PrevHash] && !dropped[hash] {
+				dropped[hash], grew = true, true
+			}
+		}
+	}
+	for hash := range dropped {
+		delete(bc.sideBlocks, hash)
+	}
+}
+
+// mainIndexLocked returns the position of hash in bc.Blocks, or -1.
+// The caller must hold bc.mu.
+func (bc *Blockchain) mainIndexLocked(hash string) int {
+	for i := len(bc.Blocks) - 1; i >= 0; i-- {
+		if bc.Blocks[i].Hash == hash {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,262 @@
+package blockchain_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestHeavierBranchWins(t *testing.T) {
+	mine := func(index int, prevHash, miner string) *blockchain.Block {
+		return blockchain.CreateBlock(index, prevHash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, miner, blockchain.DefaultBlockReward)
+	}
+	bc := blockchain.NewBlockchain()
+	bc.AttachLedger(blockchain.NewLedger())
+	genesis := mine(0, "", "Miner1")
+	a1 := mine(1, genesis.Hash, "MinerA")
+	bc.AddBlock(genesis)
+	bc.AddBlock(a1)
+
+	// A block found at the same height only ties with the active tip.
+	b1 := mine(1, genesis.Hash, "MinerB")
+	if err := bc.AddSideBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+	if switched, err := bc.SelectHeaviestFork(); switched || err != nil {
+		t.Fatalf("a tied branch must not replace the active one: switched=%v err=%v", switched, err)
+	}
+
+	// Extending the competing branch makes it heavier.
+	b2 := mine(2, b1.Hash, "MinerB")
+	if err := bc.AddSideBlock(b2); err != nil {
+		t.Fatal(err)
+	}
+	switched, err := bc.SelectHeaviestFork()
+	if err != nil || !switched {
+		t.Fatalf("expected the heavier branch to win: switched=%v err=%v", switched, err)
+	}
+	if tip := bc.Tip(); tip.Hash != b2.Hash || len(bc.Blocks) != 3 || bc.Blocks[1].Hash != b1.Hash {
+		t.Fatalf("active chain does not follow the heavier branch")
+	}
+	if !blockchain.IsValidChain(bc.Blocks) {
+		t.Error("active chain is invalid after switching")
+	}
+	if bc.Balance("MinerA") != 0 || bc.Balance("MinerB") != 2*blockchain.DefaultBlockReward {
+		t.Errorf("ledger does not follow the switch: %v", bc.Balances())
+	}
+
+	// The abandoned block is kept as a competing tip.
+	_, alternatives := bc.Forks()
+	if len(alternatives) != 1 || alternatives[0].Hash != a1.Hash {
+		t.Errorf("expected %s as the only alternative, got %+v", a1.Hash, alternatives)
+	}
+}
+
+func TestSideBlocksEvictLeastWork(t *testing.T) {
+	mine := func(index int, prevHash, text string) *blockchain.Block {
+		return blockchain.CreateBlock(index, prevHash, "one-to-one", []string{"ReceiverA"},
+			text, "", "", &blockchain.TransactionPool{}, 1, "Miner1", blockchain.DefaultBlockReward)
+	}
+	bc := blockchain.NewBlockchain()
+	genesis := mine(0, "", "genesis")
+	bc.AddBlock(genesis)
+	bc.AddBlock(mine(1, genesis.Hash, "active"))
+
+	// Competing blocks at height 1 are held until the cap; one more of the
+	// same work does not displace them.
+	var held []*blockchain.Block
+	for i := 0; ; i++ {
+		if i == 10000 {
+			t.Fatal("competing blocks are never capped")
+		}
+		b := mine(1, genesis.Hash, fmt.Sprintf("rival %d", i))
+		if err := bc.AddSideBlock(b); err != nil {
+			break
+		}
+		held = append(held, b)
+	}
+	_, before := bc.Forks()
+	if len(before) != len(held) {
+		t.Fatalf("expected %d competing tips, got %d", len(held), len(before))
+	}
+
+	// A block with more work than the weakest tip replaces one of them.
+	child := mine(2, held[0].Hash, "child")
+	if err := bc.AddSideBlock(child); err != nil {
+		t.Fatalf("expected a heavier block to evict a weaker tip, got %v", err)
+	}
+	_, after := bc.Forks()
+	if len(after) != len(held)-1 {
+		t.Errorf("expected %d competing tips after eviction, got %d", len(held)-1, len(after))
+	}
+	found := false
+	for _, tip := range after {
+		found = found || tip.Hash == child.Hash
+	}
+	if !found {
+		t.Error("the heavier block is not held")
+	}
+}
+
+func TestInvalidBranchIsForgotten(t *testing.T) {
+	mine := func(index int, prevHash, miner string, difficulty int, reward float64) *blockchain.Block {
+		return blockchain.CreateBlock(index, prevHash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", &blockchain.TransactionPool{}, difficulty, miner, reward)
+	}
+	bc := blockchain.NewBlockchain()
+	genesis := mine(0, "", "Miner1", 1, blockchain.DefaultBlockReward)
+	bc.AddBlock(genesis)
+	bc.AddBlock(mine(1, genesis.Hash, "MinerA", 1, blockchain.DefaultBlockReward))
+
+	// Mallory's branch carries the most work but overpays her coinbase.
+	m1 := mine(1, genesis.Hash, "Mallory", 2, 1000)
+	m2 := mine(2, m1.Hash, "Mallory", 2, blockchain.DefaultBlockReward)
+	for _, b := range []*blockchain.Block{m1, m2} {
+		if err := bc.AddSideBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if switched, err := bc.SelectHeaviestFork(); switched || !errors.Is(err, blockchain.ErrInvalidCoinbase) {
+		t.Fatalf("expected the invalid branch to be refused, got switched=%v err=%v", switched, err)
+	}
+
+	// The honest branch overtakes the active chain once the invalid one is gone.
+	b1 := mine(1, genesis.Hash, "MinerB", 1, blockchain.DefaultBlockReward)
+	b2 := mine(2, b1.Hash, "MinerB", 1, blockchain.DefaultBlockReward)
+	for _, b := range []*blockchain.Block{b1, b2} {
+		if err := bc.AddSideBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if switched, err := bc.SelectHeaviestFork(); !switched || err != nil {
+		t.Fatalf("expected the honest branch to win, got switched=%v err=%v", switched, err)
+	}
+	if bc.Tip().Hash != b2.Hash {
+		t.Error("active chain does not follow the honest branch")
+	}
+	_, alternatives := bc.Forks()
+	for _, alt := range alternatives {
+		if alt.Hash == m1.Hash || alt.Hash == m2.Hash {
+			t.Errorf("invalid block %d is still held", alt.Height)
+		}
+	}
+}
+
+func TestWorkGrowsWithDifficulty(t *testing.T) {
+	mine := func(index int, prevHash, miner string, difficulty int) *blockchain.Block {
+		return blockchain.CreateBlock(index, prevHash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", &blockchain.TransactionPool{}, difficulty, miner, blockchain.DefaultBlockReward)
+	}
+	bc := blockchain.NewBlockchain()
+	genesis := mine(0, "", "Miner1", 1)
+	bc.AddBlock(genesis)
+	bc.AddBlock(mine(1, genesis.Hash, "MinerA", 2))
+
+	// Three easy blocks take fewer hashes than one a difficulty higher.
+	prev := genesis
+	for i := 1; i <= 3; i++ {
+		b := mine(i, prev.Hash, "MinerB", 1)
+		if err := bc.AddSideBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		prev = b
+	}
+	if switched, err := bc.SelectHeaviestFork(); switched || err != nil {
+		t.Fatalf("a longer branch with less work must not win: switched=%v err=%v", switched, err)
+	}
+
+	// A block may not jump difficulty to outweigh the chain cheaply.
+	jump := mine(1, genesis.Hash, "Mallory", 3)
+	if err := bc.AddSideBlock(jump); !errors.Is(err, blockchain.ErrDifficultyStep) {
+		t.Errorf("expected ErrDifficultyStep, got %v", err)
+	}
+}
+
+func TestPrunedChainChecksBranchSignatures(t *testing.T) {
+	mine := func(index int, prevHash, miner string, txs ...*blockchain.Transaction) *blockchain.Block {
+		return blockchain.CreateBlock(index, prevHash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", &blockchain.TransactionPool{Transactions: txs}, 1, miner, blockchain.DefaultBlockReward)
+	}
+	bc := blockchain.NewBlockchain()
+	prev := mine(0, "", "Miner1")
+	bc.AddBlock(prev)
+	for i := 1; i <= 3; i++ {
+		prev = mine(i, prev.Hash, "MinerA")
+		bc.AddBlock(prev)
+	}
+	if err := bc.PruneAndArchive(2, filepath.Join(t.TempDir(), "archive")); err != nil {
+		t.Fatal(err)
+	}
+	fork := bc.Blocks[0]
+
+	// The branch moves Alice's funds under a signature that is not hers.
+	alice, mallory := testWallet(t, 1), testWallet(t, 2)
+	forged := blockchain.NewTransaction(alice.Address, "Mallory", 5, 1)
+	if err := mallory.SignTransaction(forged); err != nil {
+		t.Fatal(err)
+	}
+	b1 := mine(fork.Index+1, fork.Hash, "Mallory", forged)
+	b2 := mine(fork.Index+2, b1.Hash, "Mallory")
+	for _, b := range []*blockchain.Block{b1, b2} {
+		if err := bc.AddSideBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if switched, err := bc.SelectHeaviestFork(); switched || err == nil {
+		t.Fatalf("expected the forged branch to be refused, got switched=%v err=%v", switched, err)
+	}
+}
+
+func TestReplaceChainPublishesNewBlocks(t *testing.T) {
+	mine := func(index int, prevHash, miner string) *blockchain.Block {
+		return blockchain.CreateBlock(index, prevHash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, miner, blockchain.DefaultBlockReward)
+	}
+	bc := blockchain.NewBlockchain()
+	genesis := mine(0, "", "Miner1")
+	bc.AddBlock(genesis)
+	bc.AddBlock(mine(1, genesis.Hash, "MinerA"))
+
+	events, cancel, err := bc.Subscribe(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	b1 := mine(1, genesis.Hash, "MinerB")
+	b2 := mine(2, b1.Hash, "MinerB")
+	if !bc.ReplaceChain([]*blockchain.Block{genesis, b1, b2}) {
+		t.Fatal("expected the longer chain to replace the active one")
+	}
+	// Only the blocks the active chain did not hold are announced.
+	for _, want := range []*blockchain.Block{b1, b2} {
+		select {
+		case ev := <-events:
+			if ev.Block.Hash != want.Hash || !ev.Reorg {
+				t.Errorf("expected a reorg event for block %d, got block %d reorg=%v", want.Index, ev.Block.Index, ev.Reorg)
+			}
+		default:
+			t.Fatalf("no event for block %d", want.Index)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event for block %d", ev.Block.Index)
+	default:
+	}
+}
+
+func TestSubscribersAreBounded(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	for i := 0; i < blockchain.MaxSubscribers; i++ {
+		if _, _, err := bc.Subscribe(1); err != nil {
+			t.Fatalf("subscription %d refused: %v", i, err)
+		}
+	}
+	if _, _, err := bc.Subscribe(1); !errors.Is(err, blockchain.ErrTooManySubscribers) {
+		t.Fatalf("expected ErrTooManySubscribers, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+// File: pkg/blockchain/genesis.go
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// GenesisSender marks the allocation transactions carried by a genesis block.
+const GenesisSender = "GENESIS"
+
+// GenesisAlloc maps addresses to the balance they are pre-funded with at genesis.
+type GenesisAlloc map[string]float64
+
+// NewGenesisBlock creates a mined genesis block whose transactions credit
+// every address in alloc. Allocations are sorted by address so the block
+// content is the same on every node.
+func NewGenesisBlock(alloc GenesisAlloc, difficulty int) *Block {
+	addresses := make([]string, 0, len(alloc))
+	for addr := range alloc {
+		addresses = append(addresses, addr)
+	}
+	sort.Strings(addresses)
+
+	txs := make([]*Transaction, 0, len(addresses))
+	for _, addr := range addresses {
+		txs = append(txs, &Transaction{
+			Sender:    GenesisSender,
+			Recipient: addr,
+			Amount:    alloc[addr],
+		})
+	}
+
+	block := &Block{
+		Index:        0,
+		Timestamp:    time.Now().Unix(),
+		PrevHash:     "",
+		Receivers:    []string{},
+		Transactions: txs,
+		SubBlocks:    []*Block{},
+		Difficulty:   difficulty,
+		Category:     "main",
+		Version:      BlockVersion,
+	}
+	block.stamp(nextStamp())
+	MineBlock(block, difficulty)
+	return block
+}
+
+// VerifyGenesisAlloc checks that the allocation transactions in genesis
+// match alloc exactly, so a peer cannot present a genesis that mints funds
+// the node never agreed to.
+func VerifyGenesisAlloc(genesis *Block, alloc GenesisAlloc) error {
+	seen := make(GenesisAlloc)
+	for _, tx := range genesis.Transactions {
+		if tx.Sender != GenesisSender {
+			continue
+		}
+		if _, dup := seen[tx.Recipient]; dup {
+			return fmt.Errorf("duplicate genesis allocation for %s", tx.Recipient)
+		}
+		seen[tx.Recipient] = tx.Amount
+	}
+	if len(seen) != len(alloc) {
+		return fmt.Errorf("genesis allocates %d accounts, expected %d", len(seen), len(alloc))
+	}
+	for addr, amount := range alloc {
+		got, ok := seen[addr]
+		if !ok {
+			return fmt.Errorf("genesis allocation for %s is missing", addr)
+		}
+		if got != amount {
+			return fmt.Errorf("genesis allocates %f to %s, expected %f", got, addr, amount)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package blockchain_test
+
+import (
+	"errors"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestGenesisAllocationMismatchRejected(t *testing.T) {
+	local := blockchain.NewBlockchain()
+	local.Config.GenesisAlloc = blockchain.GenesisAlloc{"Alice": 100, "Bob": 50}
+
+	honest := blockchain.NewGenesisBlock(blockchain.GenesisAlloc{"Bob": 50, "Alice": 100}, 2)
+	if err := blockchain.ValidateChain([]*blockchain.Block{honest}, local.Config); err != nil {
+		t.Fatalf("expected matching genesis to validate, got %v", err)
+	}
+
+	// A well-formed, properly mined genesis that mints extra funds.
+	forged := blockchain.NewGenesisBlock(blockchain.GenesisAlloc{"Alice": 100, "Bob": 50, "Mallory": 1e6}, 2)
+	if forged.Hash != blockchain.CalculateHash(forged) {
+		t.Fatal("forged genesis should be internally consistent")
+	}
+	if err := blockchain.ValidateChain([]*blockchain.Block{forged}, local.Config); err == nil {
+		t.Error("expected genesis with a different allocation to be rejected")
+	}
+	if local.ReplaceChain([]*blockchain.Block{forged}) {
+		t.Error("chain with forged genesis allocation replaced the local chain")
+	}
+}
+
+func TestGenesisCoinbaseCannotMint(t *testing.T) {
+	cfg := blockchain.DefaultChainConfig()
+	mined := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", cfg.Reward.RewardAt(0))
+	if err := blockchain.ValidateChain([]*blockchain.Block{mined}, cfg); err != nil {
+		t.Fatalf("expected a genesis paying the block reward to validate, got %v", err)
+	}
+
+	// A properly mined genesis whose coinbase mints a fortune.
+	forged := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Mallory", 1e6)
+	if err := blockchain.ValidateChain([]*blockchain.Block{forged}, cfg); !errors.Is(err, blockchain.ErrInvalidCoinbase) {
+		t.Errorf("expected ErrInvalidCoinbase for a minting genesis, got %v", err)
+	}
+	local := blockchain.NewBlockchain()
+	if local.ReplaceChain([]*blockchain.Block{forged}) {
+		t.Error("chain with a minting genesis replaced the local chain")
+	}
+}
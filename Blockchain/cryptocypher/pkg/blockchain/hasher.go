@@ -0,0 +1,69 @@
+// File: pkg/blockchain/hasher.go
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// Hasher computes the digests used for block, transaction and Merkle hashing.
+type Hasher interface {
+	// Name identifies the algorithm in chain configuration.
+	Name() string
+	// Sum returns the digest of data.
+	Sum(data []byte) []byte
+}
+
+type sha256Hasher struct{}
+
+func (sha256Hasher) Name() string { return "sha256" }
+func (sha256Hasher) Sum(data []byte) []byte {
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+type sha3Hasher struct{}
+
+func (sha3Hasher) Name() string { return "sha3-256" }
+func (sha3Hasher) Sum(data []byte) []byte {
+	h := sha3.Sum256(data)
+	return h[:]
+}
+
+type blake2bHasher struct{}
+
+func (blake2bHasher) Name() string { return "blake2b-256" }
+func (blake2bHasher) Sum(data []byte) []byte {
+	h := blake2b.Sum256(data)
+	return h[:]
+}
+
+// hashers lists the supported algorithms by name.
+var hashers = map[string]Hasher{
+	"sha256":      sha256Hasher{},
+	"sha3-256":    sha3Hasher{},
+	"blake2b-256": blake2bHasher{},
+}
+
+// DefaultHasher is used by CalculateHash, MineBlock and the other helpers
+// that are not handed a configuration. Nodes set it once at startup to the
+// algorithm named in their ChainConfig.
+var DefaultHasher Hasher = sha256Hasher{}
+
+// HasherByName returns the supported hasher with the given name.
+func HasherByName(name string) (Hasher, error) {
+	h, ok := hashers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %q", name)
+	}
+	return h, nil
+}
+
+// hexDigest hashes record with h and returns the hex-encoded digest.
+func hexDigest(h Hasher, record string) string {
+	return hex.EncodeToString(h.Sum([]byte(record)))
+}
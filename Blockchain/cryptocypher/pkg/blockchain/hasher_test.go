@@ -0,0 +1,43 @@
+package blockchain_test
+
+import (
+	"strings"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestHashAlgorithmIsPartOfChainIdentity(t *testing.T) {
+	sha, err := blockchain.HasherByName("sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blake, err := blockchain.HasherByName("blake2b-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	if blockchain.CalculateHashWith(genesis, sha) == blockchain.CalculateHashWith(genesis, blake) {
+		t.Fatal("expected different algorithms to produce different block hashes")
+	}
+
+	// The genesis was mined under sha256, the default.
+	chain := []*blockchain.Block{genesis}
+	shaConfig := blockchain.DefaultChainConfig()
+	if err := blockchain.ValidateChain(chain, shaConfig); err != nil {
+		t.Fatalf("expected sha256 chain to validate under sha256, got %v", err)
+	}
+	blakeConfig := blockchain.DefaultChainConfig()
+	blakeConfig.HashAlgorithm = blake.Name()
+	if err := blockchain.ValidateChain(chain, blakeConfig); err == nil {
+		t.Error("expected sha256 chain to be rejected by a blake2b node")
+	}
+
+	unknown := blockchain.DefaultChainConfig()
+	unknown.HashAlgorithm = "md5"
+	if err := blockchain.ValidateChain(chain, unknown); err == nil || !strings.Contains(err.Error(), "md5") {
+		t.Errorf("expected unsupported algorithm error, got %v", err)
+	}
+}
@@ -33,19 +33,42 @@ func TestChainReplacementWithHigherDifficulty(t *testing.T) {
 	localChain.AddBlock(localBlock2)
 
 	// Create a new block for the incoming chain with a higher difficulty.
+	// It has to be mined at that difficulty to pass validation.
 	incomingBlock2 := blockchain.CreateBlock(1, genesis.Hash, "one-to-many", []string{"ReceiverA", "ReceiverB"},
-		"Text", "Audio", "Video", txPool, difficulty, minerAddress, reward)
-	// Artificially increase difficulty to simulate more work.
-	incomingBlock2.Difficulty = 5
-	// Recalculate hash after modifying difficulty.
-	incomingBlock2.Hash = blockchain.CalculateHash(incomingBlock2)
+		"Text", "Audio", "Video", txPool, difficulty+1, minerAddress, reward)
 	incomingChain.AddBlock(incomingBlock2)
 
 	// Now, localChain's cumulative difficulty is: 3 (genesis) + 3 (localBlock2) = 6.
-	// IncomingChain's cumulative difficulty is: 3 (genesis) + 5 (incomingBlock2) = 8.
+	// IncomingChain's cumulative difficulty is: 3 (genesis) + 4 (incomingBlock2) = 7.
 	// Therefore, localChain should be replaced by incomingChain.
 	replaced := localChain.ReplaceChain(incomingChain.Blocks)
 	if !replaced {
 		t.Error("Expected chain replacement due to higher cumulative difficulty, but it did not occur.")
 	}
 }
+
+func TestChainClaimingUnminedDifficultyRejected(t *testing.T) {
+	localChain := blockchain.NewBlockchain()
+	txPool := &blockchain.TransactionPool{}
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", txPool, 2, "Miner1", 12.5)
+	localChain.AddBlock(genesis)
+	txPool.Clear()
+	localChain.AddBlock(blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", txPool, 2, "Miner1", 12.5))
+	txPool.Clear()
+
+	// The forger claims difficulty 50 but only rehashes instead of mining.
+	forged := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{"ReceiverA"},
+		"Forged", "Audio", "Video", txPool, 1, "Mallory", 12.5)
+	forged.Difficulty = 50
+	forged.Hash = blockchain.CalculateHash(forged)
+
+	incoming := []*blockchain.Block{genesis, forged}
+	if blockchain.IsValidChain(incoming) {
+		t.Error("chain with an unmined high-difficulty block was accepted as valid")
+	}
+	if localChain.ReplaceChain(incoming) {
+		t.Error("inflated difficulty won chain replacement")
+	}
+}
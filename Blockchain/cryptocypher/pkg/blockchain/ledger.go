@@ -1,7 +1,7 @@
 // File: pkg/blockchain/ledger.go
 package blockchain
 
-import "errors"
+import "fmt"
 
 // Ledger represents an account-based ledger.
 type Ledger map[string]float64
@@ -12,18 +12,137 @@ func NewLedger() Ledger {
 }
 
 // ProcessTransaction updates the ledger if the transaction is valid.
+// Coinbase transactions must go through ProcessCoinbaseTransaction instead.
 func (l Ledger) ProcessTransaction(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return ErrForgedCoinbase
+	}
+	if err := tx.CheckAmounts(); err != nil {
+		return err
+	}
 	// Check that the sender has enough balance.
 	senderBalance := l[tx.Sender]
-	if senderBalance < tx.Amount {
-		return errors.New("insufficient funds")
+	if senderBalance < tx.Cost() {
+		return ErrInsufficientFunds
 	}
-	l[tx.Sender] -= tx.Amount
+	l[tx.Sender] -= tx.Cost()
 	l[tx.Recipient] += tx.Amount
 	return nil
 }
 
+// ProcessBlock applies every transaction of b. Transfers are applied first
+// and the coinbase, which carries the block reward plus the fees paid by
+// those transfers, is credited to the miner last. The allocations of a
+// genesis block are credited like coinbase outputs. If any transfer fails
+// the ledger is left unchanged.
+func (l Ledger) ProcessBlock(b *Block) error {
+	next := l.clone()
+	var coinbase []*Transaction
+	for _, tx := range b.Transactions {
+		if tx.IsCoinbase() || (b.Index == 0 && tx.Sender == GenesisSender) {
+			coinbase = append(coinbase, tx)
+			continue
+		}
+		if err := next.ProcessTransaction(tx); err != nil {
+			return fmt.Errorf("block %d: %w", b.Index, err)
+		}
+	}
+	for _, tx := range coinbase {
+		next.ProcessCoinbaseTransaction(tx.Recipient, tx.Amount)
+	}
+	for addr, balance := range next {
+		l[addr] = balance
+	}
+	return nil
+}
+
+// revertTolerance is how far below zero Revert lets a balance fall before
+// treating it as negative; smaller shortfalls are floating-point rounding
+// and are cleared to zero.
+const revertTolerance = 1e-9
+
+// Revert undoes ProcessBlock for blocks, which must be the most recent
+// blocks applied to the ledger, in chain order. The newest block is undone
+// first. If undoing would leave an account negative, the ledger does not
+// match the blocks; it is left unchanged and an error is returned.
+func (l Ledger) Revert(blocks []*Block) error {
+	next := l.clone()
+	for i := len(blocks) - 1; i >= 0; i-- {
+		b := blocks[i]
+		for j := len(b.Transactions) - 1; j >= 0; j-- {
+			tx := b.Transactions[j]
+			next[tx.Recipient] -= tx.Amount
+			if next[tx.Recipient] < -revertTolerance {
+				return fmt.Errorf("block %d: reverting leaves %s with %f", b.Index, tx.Recipient, next[tx.Recipient])
+			}
+			if next[tx.Recipient] < 0 {
+				next[tx.Recipient] = 0
+			}
+			if !tx.IsCoinbase() && !(b.Index == 0 && tx.Sender == GenesisSender) {
+				next[tx.Sender] += tx.Cost()
+			}
+		}
+	}
+	for addr, balance := range next {
+		l[addr] = balance
+	}
+	return nil
+}
+
+// clone returns a copy of l.
+func (l Ledger) clone() Ledger {
+	c := make(Ledger, len(l))
+	for addr, balance := range l {
+		c[addr] = balance
+	}
+	return c
+}
+
+// AttachLedger makes bc keep l, which must hold the balances after bc's
+// blocks, in step with the active chain: appended blocks are applied to it,
+// and chain replacements, reorganizations and rollbacks move it along under
+// the same lock as the blocks. Read the balances through Balance and
+// Balances afterwards, not through l, which a replacement swaps out.
+func (bc *Blockchain) AttachLedger(l Ledger) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.ledger = l
+}
+
+// Balance returns addr's balance on the active chain, or 0 if no ledger is
+// attached.
+func (bc *Blockchain) Balance(addr string) float64 {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.ledger[addr]
+}
+
+// Balances returns a copy of the balances on the active chain, or nil if no
+// ledger is attached.
+func (bc *Blockchain) Balances() Ledger {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.ledger == nil {
+		return nil
+	}
+	return bc.ledger.clone()
+}
+
 // ProcessCoinbaseTransaction awards tokens to a miner.
 func (l Ledger) ProcessCoinbaseTransaction(recipient string, reward float64) {
 	l[recipient] += reward
 }
+
+// BuildLedgerFromChain replays the transactions of chain, which must be
+// ordered from genesis, and returns the resulting balances. Coinbase
+// outputs and genesis allocations credit their recipient without debiting
+// anyone; every other transaction must be funded by the blocks before it.
+func BuildLedgerFromChain(chain []*Block) (Ledger, error) {
+	l := NewLedger()
+	for _, b := range chain {
+		if err := l.ProcessBlock(b); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
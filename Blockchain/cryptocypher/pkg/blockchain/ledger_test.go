@@ -0,0 +1,208 @@
+package blockchain_test
+
+import (
+	"errors"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestBuildLedgerFromChain(t *testing.T) {
+	genesis := blockchain.NewGenesisBlock(blockchain.GenesisAlloc{"Alice": 100}, 1)
+	chain := []*blockchain.Block{genesis}
+
+	txPool := &blockchain.TransactionPool{}
+	txPool.AddTransaction(blockchain.NewTransaction("Alice", "Bob", 30, 1))
+	block1 := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "", "", "", txPool, 1, "Miner1", 12.5)
+	chain = append(chain, block1)
+
+	txPool = &blockchain.TransactionPool{}
+	fee := blockchain.NewTransaction("Bob", "Charlie", 10, 1)
+	fee.Fee = 1
+	txPool.AddTransaction(fee)
+	block2 := blockchain.CreateBlock(2, block1.Hash, "one-to-one", []string{}, "", "", "", txPool, 1, "Miner2", 12.5)
+	chain = append(chain, block2)
+
+	ledger, err := blockchain.BuildLedgerFromChain(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := blockchain.Ledger{"Alice": 70, "Bob": 19, "Charlie": 10, "Miner1": 12.5, "Miner2": 13.5}
+	for addr, balance := range want {
+		if ledger[addr] != balance {
+			t.Errorf("%s: expected %v, got %v", addr, balance, ledger[addr])
+		}
+	}
+	if balance, ok := ledger[blockchain.CoinbaseSender]; ok {
+		t.Errorf("coinbase sender was debited: %v", balance)
+	}
+
+	// A transfer that the replayed history cannot fund is an error.
+	if _, err := blockchain.BuildLedgerFromChain(chain[1:]); err == nil {
+		t.Error("expected an unfunded transfer to be rejected")
+	}
+}
+
+func TestNegativeAmountCannotMoveFunds(t *testing.T) {
+	mallory := testWallet(t, 2)
+	ledger := blockchain.Ledger{mallory.Address: 1, "Bob": 100}
+	// A negative amount would debit Bob and credit Mallory.
+	theft := signedTx(t, mallory, "Bob", -50, 1)
+	negativeFee := signedTx(t, mallory, "Bob", 1, 2)
+	negativeFee.Fee = -50
+
+	for name, tx := range map[string]*blockchain.Transaction{"negative amount": theft, "negative fee": negativeFee} {
+		if err := ledger.ProcessTransaction(tx); !errors.Is(err, blockchain.ErrInvalidAmount) {
+			t.Errorf("%s: expected ErrInvalidAmount from ProcessTransaction, got %v", name, err)
+		}
+		if err := (&blockchain.TransactionPool{}).AddTransaction(tx); !errors.Is(err, blockchain.ErrInvalidAmount) {
+			t.Errorf("%s: expected ErrInvalidAmount from AddTransaction, got %v", name, err)
+		}
+		b := &blockchain.Block{Index: 1, Transactions: []*blockchain.Transaction{tx}}
+		if err := blockchain.NewBlockchain().ValidateBlockTransactions(b, nil); !errors.Is(err, blockchain.ErrInvalidAmount) {
+			t.Errorf("%s: expected ErrInvalidAmount from ValidateBlockTransactions, got %v", name, err)
+		}
+	}
+	if ledger[mallory.Address] != 1 || ledger["Bob"] != 100 {
+		t.Errorf("ledger changed: %v", ledger)
+	}
+}
+
+func TestRollbackRestoresLedger(t *testing.T) {
+	alice := testWallet(t, 1)
+	bc := blockchain.NewBlockchain()
+	bc.Config.GenesisAlloc = blockchain.GenesisAlloc{alice.Address: 100}
+	genesis := blockchain.NewGenesisBlock(bc.Config.GenesisAlloc, 1)
+	bc.AddBlock(genesis)
+	ledger, err := blockchain.BuildLedgerFromChain(bc.Blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevHash := genesis.Hash
+	var snapshots []blockchain.Ledger
+	for i, amount := range []float64{30, 20} {
+		snapshot := blockchain.Ledger{}
+		for addr, balance := range ledger {
+			snapshot[addr] = balance
+		}
+		snapshots = append(snapshots, snapshot)
+
+		tx := signedTx(t, alice, "Bob", amount, i+1)
+		tx.Fee = 1
+		if err := alice.SignTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+		txPool := &blockchain.TransactionPool{}
+		txPool.AddTransaction(tx)
+		b := blockchain.CreateBlock(i+1, prevHash, "one-to-one", []string{}, "", "", "", txPool, 1, "Miner1", 12.5)
+		bc.AddBlock(b)
+		if err := ledger.ProcessBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = b.Hash
+	}
+
+	removed, err := bc.Rollback(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Index != 2 || len(bc.Blocks) != 2 || bc.Tip().Index != 1 {
+		t.Fatalf("expected block 2 to be rolled back, removed %d blocks and kept %d", len(removed), len(bc.Blocks))
+	}
+	if err := ledger.Revert(removed); err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range snapshots[1] {
+		if ledger[addr] != want {
+			t.Errorf("%s: expected %v after rollback, got %v", addr, want, ledger[addr])
+		}
+	}
+	if ledger["Bob"] != 30 || ledger[alice.Address] != 69 || ledger["Miner1"] != 13.5 {
+		t.Errorf("unexpected balances after rollback: %v", ledger)
+	}
+
+	if _, err := bc.Rollback(-1); err == nil {
+		t.Error("expected rolling back below the first block to fail")
+	}
+	// Reverting a block the ledger never applied is refused without changes.
+	if err := ledger.Revert(append(removed, removed...)); err == nil {
+		t.Error("expected reverting unapplied blocks to fail")
+	}
+	if ledger["Bob"] != 30 {
+		t.Errorf("failed revert changed the ledger: %v", ledger)
+	}
+}
+
+func TestRevertToleratesRounding(t *testing.T) {
+	// Taking 0.2 and then 0.1 from 0.3 leaves slightly less than zero.
+	ledger := blockchain.Ledger{"Bob": 0.3}
+	b := &blockchain.Block{Index: 1, Transactions: []*blockchain.Transaction{
+		blockchain.NewCoinbaseTransaction("Bob", 0.1, 1, 0),
+		blockchain.NewCoinbaseTransaction("Bob", 0.2, 1, 0),
+	}}
+	if err := ledger.Revert([]*blockchain.Block{b}); err != nil {
+		t.Fatalf("expected rounding error to be tolerated, got %v", err)
+	}
+	if ledger["Bob"] != 0 {
+		t.Errorf("expected Bob to be cleared to 0, got %v", ledger["Bob"])
+	}
+}
+
+func TestReplaceChainMovesLedger(t *testing.T) {
+	alice := testWallet(t, 1)
+	alloc := blockchain.GenesisAlloc{alice.Address: 100}
+	genesis := blockchain.NewGenesisBlock(alloc, 1)
+
+	// extend builds blocks on genesis, each paying amount to recipient.
+	extend := func(recipient string, amounts ...float64) []*blockchain.Block {
+		chain := []*blockchain.Block{genesis}
+		for i, amount := range amounts {
+			txPool := &blockchain.TransactionPool{}
+			txPool.AddTransaction(signedTx(t, alice, recipient, amount, i+1))
+			chain = append(chain, blockchain.CreateBlock(i+1, chain[i].Hash, "one-to-one", []string{}, "", "", "",
+				txPool, 1, "Miner1", 12.5))
+		}
+		return chain
+	}
+
+	bc := blockchain.NewBlockchain()
+	bc.Config.GenesisAlloc = alloc
+	bc.AttachLedger(blockchain.NewLedger())
+	for _, b := range extend("Bob", 30) {
+		bc.AddBlock(b)
+	}
+	if got := bc.Balance("Bob"); got != 30 {
+		t.Fatalf("expected appended blocks to reach the ledger, Bob has %v", got)
+	}
+
+	// The weaker side of the fork leaves the ledger alone.
+	if replaced, err := bc.TryReplaceChain(extend("Bob", 30)); replaced || err != nil {
+		t.Fatalf("expected an equally heavy chain to be ignored, got %v, %v", replaced, err)
+	}
+
+	fork := extend("Carol", 10, 5)
+	replaced, err := bc.TryReplaceChain(fork)
+	if err != nil || !replaced {
+		t.Fatalf("expected the heavier fork to win, got %v, %v", replaced, err)
+	}
+	want, err := blockchain.BuildLedgerFromChain(fork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger := bc.Balances()
+	if len(ledger) != len(want) {
+		t.Errorf("expected %d accounts, got %v", len(want), ledger)
+	}
+	for addr, balance := range want {
+		if ledger[addr] != balance {
+			t.Errorf("%s: expected %v, got %v", addr, balance, ledger[addr])
+		}
+	}
+	if _, ok := ledger["Bob"]; ok {
+		t.Errorf("Bob's balance from the losing chain survived: %v", ledger)
+	}
+	if ledger["Carol"] != 15 || ledger[alice.Address] != 85 {
+		t.Errorf("unexpected balances after replacement: %v", ledger)
+	}
+}
@@ -1,28 +1,225 @@
 // File: pkg/blockchain/light.go
 package blockchain
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
 // LightBlockHeader contains only the essential fields of a block.
 type LightBlockHeader struct {
 	Index      int    `json:"index"`
 	Timestamp  int64  `json:"timestamp"`
+	Millis     int64  `json:"timestamp_millis,omitempty"`
 	PrevHash   string `json:"prev_hash"`
 	Hash       string `json:"hash"`
 	Difficulty int    `json:"difficulty"`
 	Nonce      int    `json:"nonce"`
+	TxRoot     string `json:"tx_root"`             // Merkle root of the block's transactions.
+	BodyHash   string `json:"body_hash,omitempty"` // Digest of the block's payload; see bodyDigest.
+	Version    int    `json:"version,omitempty"`
+}
+
+// HashWith recomputes the hash of the version 1 block hd describes, using
+// h, from the header fields alone. Headers of version 0 blocks do not carry
+// every hashed field, and give "".
+func (hd LightBlockHeader) HashWith(h Hasher) string {
+	if hd.Version < 1 {
+		return ""
+	}
+	return hexDigest(h, fmt.Sprintf("v%d|%d|%d|%d|%s|%s|%s|%d|%d", hd.Version, hd.Index, hd.Timestamp, hd.Millis,
+		hd.PrevHash, hd.TxRoot, hd.BodyHash, hd.Difficulty, hd.Nonce))
+}
+
+// bodyDigest commits to the payload of b, everything hashed besides the
+// header fields and the transactions.
+func bodyDigest(b *Block, h Hasher) string {
+	body, _ := json.Marshal(struct {
+		RelationshipType string   `json:"relationship_type"`
+		Receivers        []string `json:"receivers"`
+		TextData         string   `json:"text_data"`
+		AudioData        string   `json:"audio_data"`
+		VideoData        string   `json:"video_data"`
+		Category         string   `json:"category"`
+	}{b.RelationshipType, b.Receivers, b.TextData, b.AudioData, b.VideoData, b.Category})
+	return hexDigest(h, string(body))
+}
+
+// Header returns the light header of b for a chain using DefaultHasher.
+func (b *Block) Header() LightBlockHeader {
+	return b.HeaderWith(DefaultHasher)
+}
+
+// HeaderWith returns the light header of b, with the Merkle root and the
+// payload digest taken with h.
+func (b *Block) HeaderWith(h Hasher) LightBlockHeader {
+	hd := LightBlockHeader{
+		Index:      b.Index,
+		Timestamp:  b.Timestamp,
+		Millis:     b.TimestampMillis,
+		PrevHash:   b.PrevHash,
+		Hash:       b.Hash,
+		Difficulty: b.Difficulty,
+		Nonce:      b.Nonce,
+		TxRoot:     MerkleRootWith(b.Transactions, h),
+		Version:    b.Version,
+	}
+	if b.Version >= 1 {
+		hd.BodyHash = bodyDigest(b, h)
+	}
+	return hd
+}
+
+// Header returns the light header of b under bc's hash algorithm.
+func (bc *Blockchain) Header(b *Block) LightBlockHeader {
+	h, err := bc.config().Hasher()
+	if err != nil {
+		h = DefaultHasher
+	}
+	return b.HeaderWith(h)
 }
 
 // ExtractHeaders returns the headers of all blocks in the blockchain.
 func (bc *Blockchain) ExtractHeaders() []LightBlockHeader {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
 	headers := make([]LightBlockHeader, len(bc.Blocks))
 	for i, blk := range bc.Blocks {
-		headers[i] = LightBlockHeader{
-			Index:      blk.Index,
-			Timestamp:  blk.Timestamp,
-			PrevHash:   blk.PrevHash,
-			Hash:       blk.Hash,
-			Difficulty: blk.Difficulty,
-			Nonce:      blk.Nonce,
-		}
+		headers[i] = bc.Header(blk)
 	}
 	return headers
 }
+
+// VerifyHeaderRange is VerifyHeaderRangeWith for a chain using DefaultHasher.
+func VerifyHeaderRange(headers []LightBlockHeader, startHash, endHash string) error {
+	return VerifyHeaderRangeWith(headers, startHash, endHash, DefaultHasher)
+}
+
+// VerifyHeaderRangeWith checks that headers form an unbroken chain from the
+// trusted block startHash to the trusted block endHash, both included: each
+// header's hash is recomputed with h from its fields, and it must follow the
+// previous header by index and PrevHash and satisfy its difficulty. Only
+// headers of version 1 blocks can be recomputed, so older ones are refused.
+func VerifyHeaderRangeWith(headers []LightBlockHeader, startHash, endHash string, h Hasher) error {
+	if len(headers) == 0 {
+		return errors.New("empty header range")
+	}
+	if headers[0].Hash != startHash {
+		return fmt.Errorf("range starts at %s, expected %s", headers[0].Hash, startHash)
+	}
+	if last := headers[len(headers)-1]; last.Hash != endHash {
+		return fmt.Errorf("range ends at %s, expected %s", last.Hash, endHash)
+	}
+	for i, hd := range headers {
+		if hd.Version < 1 {
+			return fmt.Errorf("header %d is of version %d and cannot be verified", hd.Index, hd.Version)
+		}
+		if hd.HashWith(h) != hd.Hash {
+			return fmt.Errorf("header %d does not hash to %s", hd.Index, hd.Hash)
+		}
+		if hd.Difficulty < DefaultMinDifficulty || !MeetsDifficulty(hd.Hash, hd.Difficulty) {
+			return fmt.Errorf("header %d does not meet difficulty %d", hd.Index, hd.Difficulty)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := headers[i-1]
+		if hd.Index != prev.Index+1 {
+			return fmt.Errorf("header %d follows header %d", hd.Index, prev.Index)
+		}
+		if hd.PrevHash != prev.Hash {
+			return fmt.Errorf("header %d does not link to header %d", hd.Index, prev.Index)
+		}
+	}
+	return nil
+}
+
+// TxInclusionProof proves that a transaction is part of a block.
+type TxInclusionProof struct {
+	BlockIndex int               `json:"block_index"`
+	BlockHash  string            `json:"block_hash"`
+	Tx         *Transaction      `json:"tx"`
+	Proof      []MerkleProofStep `json:"proof"`
+}
+
+// BalanceProof carries an address's balance together with inclusion proofs
+// for every transaction that contributed to it, so a light client can check
+// it against headers it already trusts.
+type BalanceProof struct {
+	Address      string             `json:"address"`
+	Balance      float64            `json:"balance"`
+	Transactions []TxInclusionProof `json:"transactions"`
+}
+
+// balanceDelta returns how much tx changes the balance of addr.
+func balanceDelta(tx *Transaction, addr string) float64 {
+	delta := 0.0
+	if tx.Recipient == addr {
+		delta += tx.Amount
+	}
+	if tx.Sender == addr && !tx.IsCoinbase() {
+		delta -= tx.Cost()
+	}
+	return delta
+}
+
+// BalanceProof builds a balance proof for addr from the whole chain,
+// reading blocks no longer held in memory from the store.
+func (bc *Blockchain) BalanceProof(addr string) (*BalanceProof, error) {
+	proof := &BalanceProof{Address: addr, Transactions: []TxInclusionProof{}}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	err := bc.walkChainLocked(func(blk *Block) error {
+		for i, tx := range blk.Transactions {
+			if tx.Sender != addr && tx.Recipient != addr {
+				continue
+			}
+			path, err := MerkleProof(blk.Transactions, i)
+			if err != nil {
+				return err
+			}
+			proof.Transactions = append(proof.Transactions, TxInclusionProof{
+				BlockIndex: blk.Index,
+				BlockHash:  blk.Hash,
+				Tx:         tx,
+				Proof:      path,
+			})
+			proof.Balance += balanceDelta(tx, addr)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// VerifyBalanceProof checks every transaction in p against the transaction
+// roots of the given headers and that they add up to the claimed balance.
+// Inclusion proofs cannot show that no transaction was left out, so clients
+// should query several full nodes.
+func VerifyBalanceProof(p *BalanceProof, headers []LightBlockHeader) error {
+	byHash := make(map[string]LightBlockHeader, len(headers))
+	for _, h := range headers {
+		byHash[h.Hash] = h
+	}
+	balance := 0.0
+	for _, inc := range p.Transactions {
+		header, ok := byHash[inc.BlockHash]
+		if !ok || header.Index != inc.BlockIndex {
+			return fmt.Errorf("block %s is not among the trusted headers", inc.BlockHash)
+		}
+		if inc.Tx == nil || (inc.Tx.Sender != p.Address && inc.Tx.Recipient != p.Address) {
+			return fmt.Errorf("proof in block %d does not involve %s", inc.BlockIndex, p.Address)
+		}
+		if !VerifyMerkleProof(inc.Tx.CalculateHash(), inc.Proof, header.TxRoot) {
+			return fmt.Errorf("transaction not included in block %d", inc.BlockIndex)
+		}
+		balance += balanceDelta(inc.Tx, p.Address)
+	}
+	if balance != p.Balance {
+		return fmt.Errorf("proven balance %f does not match claimed %f", balance, p.Balance)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package blockchain_test
+
+import (
+	"strings"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestVerifyHeaderRange(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	prevHash := ""
+	for i := 0; i < 5; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		bc.AddBlock(b)
+		prevHash = b.Hash
+	}
+	headers := bc.ExtractHeaders()[1:]
+	start, end := headers[0].Hash, headers[len(headers)-1].Hash
+
+	if err := blockchain.VerifyHeaderRange(headers, start, end); err != nil {
+		t.Fatalf("valid range rejected: %v", err)
+	}
+	if err := blockchain.VerifyHeaderRange(headers, start, headers[1].Hash); err == nil {
+		t.Error("expected a range not ending at the checkpoint to be rejected")
+	}
+
+	broken := append([]blockchain.LightBlockHeader(nil), headers...)
+	broken[2].PrevHash = broken[0].Hash
+	if err := blockchain.VerifyHeaderRange(broken, start, end); err == nil {
+		t.Error("expected a broken link to be rejected")
+	}
+
+	// A header whose hash was made up, but which links and looks mined,
+	// must not pass: the hash is recomputed from the header fields.
+	forged := append([]blockchain.LightBlockHeader(nil), headers...)
+	forged[1].Hash = strings.Repeat("0", len(forged[1].Hash))
+	forged[2].PrevHash = forged[1].Hash
+	if err := blockchain.VerifyHeaderRange(forged, start, end); err == nil {
+		t.Error("expected a forged header hash to be rejected")
+	}
+	tampered := append([]blockchain.LightBlockHeader(nil), headers...)
+	tampered[1].BodyHash = tampered[0].BodyHash + "00"
+	if err := blockchain.VerifyHeaderRange(tampered, start, end); err == nil {
+		t.Error("expected a header with a changed body digest to be rejected")
+	}
+}
@@ -0,0 +1,89 @@
+// File: pkg/blockchain/merkle.go
+package blockchain
+
+import "fmt"
+
+// MerkleProofStep is one sibling hash on the path from a leaf to the root.
+type MerkleProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // True when the sibling sits to the left of the path.
+}
+
+// hashPair combines two child hashes into their parent hash.
+func hashPair(h Hasher, left, right string) string {
+	return hexDigest(h, left+right)
+}
+
+// merkleLeaves returns the leaf hashes of a transaction list.
+func merkleLeaves(txs []*Transaction, h Hasher) []string {
+	leaves := make([]string, len(txs))
+	for i, tx := range txs {
+		leaves[i] = tx.CalculateHashWith(h)
+	}
+	return leaves
+}
+
+// nextMerkleLevel hashes a level pairwise, duplicating the last hash of an odd level.
+func nextMerkleLevel(level []string, h Hasher) []string {
+	if len(level)%2 == 1 {
+		level = append(level, level[len(level)-1])
+	}
+	next := make([]string, 0, len(level)/2)
+	for i := 0; i < len(level); i += 2 {
+		next = append(next, hashPair(h, level[i], level[i+1]))
+	}
+	return next
+}
+
+// MerkleRoot returns the Merkle root of the transactions' hashes under DefaultHasher.
+// A block without transactions has an empty root.
+func MerkleRoot(txs []*Transaction) string {
+	return MerkleRootWith(txs, DefaultHasher)
+}
+
+// MerkleRootWith returns the Merkle root of the transactions' hashes under h.
+func MerkleRootWith(txs []*Transaction, h Hasher) string {
+	if len(txs) == 0 {
+		return ""
+	}
+	level := merkleLeaves(txs, h)
+	for len(level) > 1 {
+		level = nextMerkleLevel(level, h)
+	}
+	return level[0]
+}
+
+// MerkleProof returns the sibling path proving that txs[index] is part of MerkleRoot(txs).
+func MerkleProof(txs []*Transaction, index int) ([]MerkleProofStep, error) {
+	if index < 0 || index >= len(txs) {
+		return nil, fmt.Errorf("transaction index %d out of range", index)
+	}
+	proof := []MerkleProofStep{}
+	level := merkleLeaves(txs, DefaultHasher)
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		if index%2 == 0 {
+			proof = append(proof, MerkleProofStep{Hash: level[index+1], Left: false})
+		} else {
+			proof = append(proof, MerkleProofStep{Hash: level[index-1], Left: true})
+		}
+		level = nextMerkleLevel(level, DefaultHasher)
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof reports whether proof links txHash to root.
+func VerifyMerkleProof(txHash string, proof []MerkleProofStep, root string) bool {
+	current := txHash
+	for _, step := range proof {
+		if step.Left {
+			current = hashPair(DefaultHasher, step.Hash, current)
+		} else {
+			current = hashPair(DefaultHasher, current, step.Hash)
+		}
+	}
+	return root != "" && current == root
+}
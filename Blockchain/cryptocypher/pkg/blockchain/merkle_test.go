@@ -0,0 +1,28 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestMerkleProofForEveryLeaf(t *testing.T) {
+	var txs []*blockchain.Transaction
+	for i := 0; i < 5; i++ {
+		txs = append(txs, blockchain.NewTransaction("Alice", "Bob", float64(i+1), i))
+	}
+	root := blockchain.MerkleRoot(txs)
+	for i, tx := range txs {
+		proof, err := blockchain.MerkleProof(txs, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !blockchain.VerifyMerkleProof(tx.CalculateHash(), proof, root) {
+			t.Errorf("proof for leaf %d did not verify", i)
+		}
+	}
+	proof, _ := blockchain.MerkleProof(txs, 0)
+	if blockchain.VerifyMerkleProof(txs[1].CalculateHash(), proof, root) {
+		t.Error("proof verified for the wrong leaf")
+	}
+}
@@ -0,0 +1,57 @@
+// File: pkg/blockchain/ordering.go
+package blockchain
+
+import "sort"
+
+// OrderTransactions arranges txs so that every transaction can be applied
+// to balances in order: each sender's transactions go in nonce order, and a
+// transaction comes after the ones that fund it. Transactions that cannot be
+// funded, and later nonces from the same sender, are returned as skipped.
+// balances is the state before the block and is not modified.
+func OrderTransactions(txs []*Transaction, balances Ledger) (ordered, skipped []*Transaction) {
+	state := make(Ledger, len(balances))
+	for addr, amount := range balances {
+		state[addr] = amount
+	}
+
+	// Queue each sender's transactions by nonce, keeping arrival order for ties.
+	queues := make(map[string][]*Transaction)
+	var senders []string
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			ordered = append(ordered, tx)
+			continue
+		}
+		if _, ok := queues[tx.Sender]; !ok {
+			senders = append(senders, tx.Sender)
+		}
+		queues[tx.Sender] = append(queues[tx.Sender], tx)
+	}
+	for _, sender := range senders {
+		queue := queues[sender]
+		sort.SliceStable(queue, func(i, j int) bool { return queue[i].Nonce < queue[j].Nonce })
+	}
+
+	// Keep placing the next transaction of any sender that can afford it
+	// until no sender can make progress.
+	for progress := true; progress; {
+		progress = false
+		for _, sender := range senders {
+			for len(queues[sender]) > 0 {
+				tx := queues[sender][0]
+				if state[sender] < tx.Cost() {
+					break
+				}
+				state[sender] -= tx.Cost()
+				state[tx.Recipient] += tx.Amount
+				ordered = append(ordered, tx)
+				queues[sender] = queues[sender][1:]
+				progress = true
+			}
+		}
+	}
+	for _, sender := range senders {
+		skipped = append(skipped, queues[sender]...)
+	}
+	return ordered, skipped
+}
@@ -0,0 +1,78 @@
+package blockchain_test
+
+import (
+	"context"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestOrderTransactionsByDependency(t *testing.T) {
+	balances := blockchain.Ledger{"Alice": 10}
+
+	// Bob can only pay Carol after Alice pays him, and Alice's second
+	// transfer arrived before her first.
+	bobToCarol := blockchain.NewTransaction("Bob", "Carol", 6, 1)
+	aliceSecond := blockchain.NewTransaction("Alice", "Dave", 2, 2)
+	aliceFirst := blockchain.NewTransaction("Alice", "Bob", 8, 1)
+	unfunded := blockchain.NewTransaction("Carol", "Eve", 100, 1)
+
+	ordered, skipped := blockchain.OrderTransactions(
+		[]*blockchain.Transaction{bobToCarol, aliceSecond, unfunded, aliceFirst}, balances)
+
+	want := []*blockchain.Transaction{aliceFirst, aliceSecond, bobToCarol}
+	if len(ordered) != len(want) {
+		t.Fatalf("expected %d ordered transactions, got %d", len(want), len(ordered))
+	}
+	for i := range want {
+		if ordered[i] != want[i] {
+			t.Fatalf("position %d: expected %+v, got %+v", i, want[i], ordered[i])
+		}
+	}
+	if len(skipped) != 1 || skipped[0] != unfunded {
+		t.Errorf("expected only the unfunded transaction to be skipped, got %v", skipped)
+	}
+	if balances["Bob"] != 0 {
+		t.Error("OrderTransactions modified the input balances")
+	}
+
+	// The ordered transactions apply cleanly.
+	ledger := blockchain.Ledger{"Alice": 10}
+	for _, tx := range ordered {
+		if err := ledger.ProcessTransaction(tx); err != nil {
+			t.Fatalf("ordered transaction rejected: %v", err)
+		}
+	}
+}
+
+func TestBlockAssemblyOrdersAndCapsTransactions(t *testing.T) {
+	balances := blockchain.Ledger{"Alice": 10}
+	aliceSecond := blockchain.NewTransaction("Alice", "Dave", 2, 2)
+	aliceFirst := blockchain.NewTransaction("Alice", "Bob", 8, 1)
+	unfunded := blockchain.NewTransaction("Carol", "Eve", 100, 1)
+	pool := &blockchain.TransactionPool{Transactions: []*blockchain.Transaction{aliceSecond, unfunded, aliceFirst}}
+
+	cfg := blockchain.DefaultChainConfig()
+	for limit, want := range map[int][]*blockchain.Transaction{
+		0: {aliceFirst, aliceSecond},
+		1: {aliceFirst},
+	} {
+		cfg.MaxBlockTransactions = limit
+		b, err := cfg.CreateBlockContext(context.Background(), 1, "prev", "one-to-one", []string{}, "", "", "",
+			pool, balances, 1, "Miner1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !b.Transactions[0].IsCoinbase() || len(b.Transactions) != len(want)+1 {
+			t.Fatalf("limit %d: expected a coinbase and %d transactions, got %d", limit, len(want), len(b.Transactions))
+		}
+		for i, tx := range want {
+			if b.Transactions[i+1] != tx {
+				t.Errorf("limit %d, position %d: expected %+v, got %+v", limit, i+1, tx, b.Transactions[i+1])
+			}
+		}
+	}
+	if pool.Len() != 3 {
+		t.Error("block assembly modified the pool")
+	}
+}
@@ -2,15 +2,56 @@
 package blockchain
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"time"
 )
 
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// LoadArchive loads the blocks stored in an archive file written by
+// PruneAndArchive, whether plain or gzip-compressed.
+func LoadArchive(path string) ([]*Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open compressed archive %s: %v", path, err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("failed to decompress archive %s: %v", path, err)
+		}
+	}
+	var blocks []*Block
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return nil, fmt.Errorf("failed to parse archive %s: %v", path, err)
+	}
+	return blocks, nil
+}
+
+// ReadArchive is LoadArchive.
+//
+// Deprecated: Use LoadArchive, which this only forwards to.
+func ReadArchive(path string) ([]*Block, error) {
+	return LoadArchive(path)
+}
+
 // PruneAndArchive prunes the blockchain, keeping only the last retainCount blocks,
-// and archives the older blocks to a file.
+// and archives the older blocks to a file. The file is archiveFilename_<ts>.json,
+// or compact JSON in archiveFilename_<ts>.json.gz if CompressArchives is set.
 func (bc *Blockchain) PruneAndArchive(retainCount int, archiveFilename string) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
 	totalBlocks := len(bc.Blocks)
 	if totalBlocks <= retainCount {
 		// Nothing to prune.
@@ -19,20 +60,103 @@ func (bc *Blockchain) PruneAndArchive(retainCount int, archiveFilename string) e
 
 	// Archive blocks older than the last retainCount blocks.
 	archiveBlocks := bc.Blocks[:totalBlocks-retainCount]
-	archiveData, err := json.MarshalIndent(archiveBlocks, "", "  ")
+	// Nanoseconds keep archives written in quick succession apart.
+	archiveFile := fmt.Sprintf("%s_%d.json", archiveFilename, time.Now().UnixNano())
+	var archiveData []byte
+	var err error
+	if bc.CompressArchives {
+		archiveFile += ".gz"
+		archiveData, err = gzipJSON(archiveBlocks)
+	} else {
+		archiveData, err = json.MarshalIndent(archiveBlocks, "", "  ")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to marshal archive blocks: %v", err)
 	}
 
-	// You might want to include a timestamp in the archive file name.
-	archiveFile := fmt.Sprintf("%s_%d.json", archiveFilename, time.Now().Unix())
-	err = ioutil.WriteFile(archiveFile, archiveData, 0644)
-	if err != nil {
+	// The blocks stay in memory unless the archive is safely on disk.
+	if err := writeFileAtomic(archiveFile, archiveData, 0644); err != nil {
 		return fmt.Errorf("failed to write archive file: %v", err)
 	}
 
 	// Retain only the last retainCount blocks in memory.
+	bc.archivedSupply += supplyOf(archiveBlocks)
+	bc.archivedWork += CumulativeWork(archiveBlocks)
+	bc.Archives = append(bc.Archives, archiveFile)
 	bc.Blocks = bc.Blocks[totalBlocks-retainCount:]
 	fmt.Printf("Pruned blockchain: archived %d blocks to %s\n", totalBlocks-retainCount, archiveFile)
+	return bc.rotateArchivesLocked()
+}
+
+// rotateArchivesLocked deletes the oldest archives until the retention
+// limits are met. The newest archive is always kept. The caller must hold bc.mu.
+func (bc *Blockchain) rotateArchivesLocked() error {
+	var total int64
+	sizes := make([]int64, len(bc.Archives))
+	for i, path := range bc.Archives {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Already gone; it no longer counts towards the limits.
+			continue
+		}
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+
+	for len(bc.Archives) > 1 {
+		overFiles := bc.ArchiveMaxFiles > 0 && len(bc.Archives) > bc.ArchiveMaxFiles
+		overBytes := bc.ArchiveMaxBytes > 0 && total > bc.ArchiveMaxBytes
+		if !overFiles && !overBytes {
+			break
+		}
+		if err := os.Remove(bc.Archives[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove archive %s: %v", bc.Archives[0], err)
+		}
+		fmt.Println("Removed old archive", bc.Archives[0])
+		total -= sizes[0]
+		bc.Archives = bc.Archives[1:]
+		sizes = sizes[1:]
+	}
 	return nil
 }
+
+// gzipJSON returns v encoded as compact JSON and gzip-compressed.
+func gzipJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(v); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path, flushes it
+// to disk and renames it into place, so path never holds a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,117 @@
+package blockchain_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestArchiveRotationDeletesOldest(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	bc.ArchiveMaxFiles = 2
+	prefix := filepath.Join(t.TempDir(), "archive")
+
+	prevHash := ""
+	var written []string
+	for i := 0; i < 8; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		bc.AddBlock(b)
+		prevHash = b.Hash
+		if i >= 2 && i%2 == 0 {
+			if err := bc.PruneAndArchive(2, prefix); err != nil {
+				t.Fatal(err)
+			}
+			written = append(written, bc.Archives[len(bc.Archives)-1])
+		}
+	}
+	if len(written) != 3 {
+		t.Fatalf("expected 3 archives written, got %d", len(written))
+	}
+
+	if len(bc.Archives) != 2 || bc.Archives[0] != written[1] || bc.Archives[1] != written[2] {
+		t.Errorf("expected the two newest archives to be kept, got %v", bc.Archives)
+	}
+	if _, err := os.Stat(written[0]); !os.IsNotExist(err) {
+		t.Errorf("expected oldest archive to be deleted, stat returned %v", err)
+	}
+	for _, path := range bc.Archives {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("retained archive missing: %v", err)
+		}
+	}
+}
+
+func TestCompressedArchiveRoundTrip(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		bc := blockchain.NewBlockchain()
+		bc.CompressArchives = compress
+		prevHash := ""
+		for i := 0; i < 5; i++ {
+			b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{"ReceiverA"}, "text", "audio", "video",
+				&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+			bc.AddBlock(b)
+			prevHash = b.Hash
+		}
+		want := append([]*blockchain.Block(nil), bc.Blocks[:3]...)
+		if err := bc.PruneAndArchive(2, filepath.Join(t.TempDir(), "archive")); err != nil {
+			t.Fatal(err)
+		}
+		path := bc.Archives[0]
+		if strings.HasSuffix(path, ".json.gz") != compress {
+			t.Errorf("compress=%v wrote %s", compress, path)
+		}
+
+		got, err := blockchain.LoadArchive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("compress=%v: reloaded blocks differ from the archived ones", compress)
+		}
+	}
+}
+
+func TestFailedArchiveKeepsBlocks(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	prevHash := ""
+	for i := 0; i < 5; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		bc.AddBlock(b)
+		prevHash = b.Hash
+	}
+	supply := bc.TotalSupply()
+
+	// The archive directory does not exist, so writing it fails.
+	dir := filepath.Join(t.TempDir(), "missing")
+	if err := bc.PruneAndArchive(2, filepath.Join(dir, "archive")); err == nil {
+		t.Fatal("expected archiving into a missing directory to fail")
+	}
+	if len(bc.Blocks) != 5 || bc.Blocks[0].Hash == bc.Blocks[4].Hash {
+		t.Fatalf("blocks were lost: %d remain", len(bc.Blocks))
+	}
+	if len(bc.Archives) != 0 {
+		t.Errorf("failed archive was recorded: %v", bc.Archives)
+	}
+	if got := bc.TotalSupply(); got != supply {
+		t.Errorf("supply changed from %v to %v", supply, got)
+	}
+
+	// No partial or temporary file is left behind in a writable directory.
+	dir = t.TempDir()
+	if err := bc.PruneAndArchive(2, filepath.Join(dir, "archive")); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(bc.Archives[0]) {
+		t.Errorf("unexpected files after archiving: %v", entries)
+	}
+}
@@ -0,0 +1,30 @@
+// File: pkg/blockchain/reward.go
+package blockchain
+
+import "math"
+
+// DefaultHalvingInterval is the halving interval used by DefaultChainConfig.
+const DefaultHalvingInterval = 210000
+
+// maxHalvings is the number of halvings after which the reward is zero.
+const maxHalvings = 64
+
+// RewardSchedule is the block reward emission curve: Initial per block,
+// halved every HalvingInterval blocks. Because the reward halves until it
+// reaches zero, total issuance is bounded by 2 * Initial * HalvingInterval.
+type RewardSchedule struct {
+	Initial         float64
+	HalvingInterval int // Zero or negative keeps the reward at Initial forever.
+}
+
+// RewardAt returns the reward of the block at height.
+func (s RewardSchedule) RewardAt(height int) float64 {
+	if s.HalvingInterval <= 0 || height < 0 {
+		return s.Initial
+	}
+	halvings := height / s.HalvingInterval
+	if halvings >= maxHalvings {
+		return 0
+	}
+	return math.Ldexp(s.Initial, -halvings)
+}
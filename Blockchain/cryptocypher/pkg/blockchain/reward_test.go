@@ -0,0 +1,48 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestRewardHalvesAtInterval(t *testing.T) {
+	s := blockchain.RewardSchedule{Initial: 50, HalvingInterval: 10}
+	tests := []struct {
+		height int
+		want   float64
+	}{
+		{0, 50},
+		{9, 50},
+		{10, 25},
+		{19, 25},
+		{20, 12.5},
+		{35, 6.25},
+	}
+	for _, tt := range tests {
+		if got := s.RewardAt(tt.height); got != tt.want {
+			t.Errorf("RewardAt(%d) = %v, want %v", tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestRewardReachesZero(t *testing.T) {
+	s := blockchain.RewardSchedule{Initial: 50, HalvingInterval: 10}
+	if got := s.RewardAt(64 * 10); got != 0 {
+		t.Fatalf("reward after 64 halvings is %v, want 0", got)
+	}
+
+	// Issuance is bounded by twice the first era's.
+	total := 0.0
+	for h := 0; h < 70*10; h++ {
+		total += s.RewardAt(h)
+	}
+	if total > 2*50*10 {
+		t.Errorf("total issuance %v exceeds the bound %v", total, 2*50*10)
+	}
+
+	constant := blockchain.RewardSchedule{Initial: 12.5}
+	if got := constant.RewardAt(1_000_000); got != 12.5 {
+		t.Errorf("reward without halvings is %v, want 12.5", got)
+	}
+}
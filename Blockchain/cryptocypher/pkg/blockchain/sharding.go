@@ -3,18 +3,46 @@ package blockchain
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"sync"
 )
 
 // Shard represents a partition of the blockchain.
 type Shard struct {
 	ID         int
 	Blockchain *Blockchain
+	Ledger     Ledger           // Balances of the accounts AssignShardForAddress maps here.
+	TxPool     *TransactionPool // Transactions waiting for the shard's next block.
 }
 
 // BeaconChain coordinates multiple shards.
 type BeaconChain struct {
-	Shards []*Shard
+	Shards      []*Shard
+	Checkpoints map[string]ShardCheckpoint // Anchored shard blocks keyed by block hash.
+
+	relayQueue []*CrossShardReceipt // Verified receipts awaiting their credit.
+	relayed    map[string]bool      // Receipts already queued, by source block and tx hash.
+	crosslinks []Crosslink          // Shard tips waiting for a main block; see Commit.
+	mu         sync.Mutex
+}
+
+// ShardCheckpoint anchors a shard block's transaction root in the beacon chain.
+type ShardCheckpoint struct {
+	ShardID   int    `json:"shard_id"`
+	Height    int    `json:"height"`
+	BlockHash string `json:"block_hash"`
+	TxRoot    string `json:"tx_root"`
+}
+
+// CrossShardReceipt proves that a transfer was debited on its source shard
+// so the destination shard can safely credit the recipient.
+type CrossShardReceipt struct {
+	SourceShard int               `json:"source_shard"`
+	DestShard   int               `json:"dest_shard"`
+	BlockHash   string            `json:"block_hash"`
+	Tx          *Transaction      `json:"tx"`
+	Proof       []MerkleProofStep `json:"proof"`
 }
 
 // NewBeaconChain initializes a beacon chain with the specified number of shards.
@@ -24,24 +52,261 @@ func NewBeaconChain(numShards int) *BeaconChain {
 		shards[i] = &Shard{
 			ID:         i,
 			Blockchain: NewBlockchain(),
+			Ledger:     NewLedger(),
+			TxPool:     &TransactionPool{},
 		}
 	}
 	return &BeaconChain{
-		Shards: shards,
+		Shards:      shards,
+		Checkpoints: make(map[string]ShardCheckpoint),
+		relayed:     make(map[string]bool),
 	}
 }
 
 // AssignShard assigns a transaction to a shard based on the sender's address.
 func (bc *BeaconChain) AssignShard(tx *Transaction) int {
-	hash := sha256.Sum256([]byte(tx.Sender))
-	shardID := int(hash[0]) % len(bc.Shards)
-	return shardID
+	return bc.AssignShardForAddress(tx.Sender)
+}
+
+// AssignShardForAddress maps an address to the shard that owns its account.
+func (bc *BeaconChain) AssignShardForAddress(addr string) int {
+	hash := sha256.Sum256([]byte(addr))
+	return int(hash[0]) % len(bc.Shards)
 }
 
-// ProcessTransaction assigns and processes a transaction in the appropriate shard.
-func (bc *BeaconChain) ProcessTransaction(tx *Transaction) {
+// ProcessTransaction adds tx to the pool of its sender's shard, to be
+// executed by the next MineShard of that shard. The signature and the
+// sender's balance on the shard are checked up front.
+func (bc *BeaconChain) ProcessTransaction(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return ErrForgedCoinbase
+	}
+	if err := tx.CheckAmounts(); err != nil {
+		return err
+	}
+	if err := VerifySignedTransaction(tx); err != nil {
+		return err
+	}
 	shardID := bc.AssignShard(tx)
 	fmt.Printf("Assigning transaction from %s to shard %d\n", tx.Sender, shardID)
-	// Here, you'd add the transaction to the shard's transaction pool or process it.
-	// For demonstration, we just print a message.
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	shard := bc.Shards[shardID]
+	if balance := shard.Ledger[tx.Sender]; balance < tx.Cost() {
+		return fmt.Errorf("shard %d: insufficient funds: balance %f, required %f", shardID, balance, tx.Cost())
+	}
+	return shard.TxPool.AddTransaction(tx)
+}
+
+// MineShard drains the pool of shard shardID into a new block on the
+// shard's chain, paying reward and the fees to minerAddress on the miner's
+// own shard. Transactions the shard's balances cannot fund yet stay in the
+// pool. A transfer within the shard moves the funds directly; a transfer to
+// an account on another shard runs in two phases: the block debits the
+// sender and is checkpointed, and a receipt for the debit is then relayed
+// through the beacon chain to credit the recipient on its own shard. The
+// receipts are queued together with the debits, so none is lost half-way;
+// should one not verify, its amount goes back to the sender and the
+// error is returned with the block.
+func (bc *BeaconChain) MineShard(shardID int, minerAddress string, reward float64) (*Block, error) {
+	if shardID < 0 || shardID >= len(bc.Shards) {
+		return nil, fmt.Errorf("unknown shard %d", shardID)
+	}
+	bc.mu.Lock()
+	shard := bc.Shards[shardID]
+	ordered, _ := OrderTransactions(shard.TxPool.Pending(), shard.Ledger)
+	if len(ordered) == 0 {
+		bc.mu.Unlock()
+		return nil, fmt.Errorf("shard %d has no transactions to mine", shardID)
+	}
+	index, prevHash := 0, ""
+	if tip := shard.Blockchain.Tip(); tip != nil {
+		index, prevHash = tip.Index+1, tip.Hash
+	}
+	receivers := make([]string, 0, len(ordered))
+	for _, tx := range ordered {
+		receivers = append(receivers, tx.Recipient)
+	}
+	block := CreateBlock(index, prevHash, "one-to-many", receivers, "", "", "",
+		&TransactionPool{Transactions: ordered}, 1, minerAddress, reward)
+	if added, err := shard.Blockchain.AddBlockIfTip(block, prevHash); err != nil {
+		bc.mu.Unlock()
+		return nil, fmt.Errorf("shard %d: %w", shardID, err)
+	} else if !added {
+		bc.mu.Unlock()
+		return nil, fmt.Errorf("shard %d: chain tip moved", shardID)
+	}
+	shard.TxPool.Remove(block.Transactions)
+
+	var crossShard []*Transaction
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			bc.Shards[bc.AssignShardForAddress(tx.Recipient)].Ledger[tx.Recipient] += tx.Amount
+			continue
+		}
+		shard.Ledger[tx.Sender] -= tx.Cost()
+		if bc.AssignShardForAddress(tx.Recipient) == shardID {
+			shard.Ledger[tx.Recipient] += tx.Amount
+		} else {
+			crossShard = append(crossShard, tx)
+		}
+	}
+	// Anchor the block before another one can move the shard's tip, and
+	// relay the receipts of its debits.
+	var errs []error
+	_, cpErr := bc.checkpointLocked(shardID)
+	for _, tx := range crossShard {
+		var receipt *CrossShardReceipt
+		err := cpErr
+		if err == nil {
+			receipt, err = bc.GenerateCrossShardReceipt(shardID, block.Hash, tx.CalculateHash())
+		}
+		if err == nil {
+			err = bc.relayLocked(receipt)
+		}
+		if err != nil {
+			shard.Ledger[tx.Sender] += tx.Amount
+			errs = append(errs, fmt.Errorf("transfer %s refunded: %w", tx.CalculateHash(), err))
+		}
+	}
+	bc.mu.Unlock()
+	bc.DeliverReceipts()
+	if cpErr != nil && len(crossShard) == 0 {
+		errs = append(errs, cpErr)
+	}
+	return block, errors.Join(errs...)
+}
+
+// ShardHeight returns the index of the tip of shard id, or -1 if the shard
+// has no blocks or does not exist.
+func (bc *BeaconChain) ShardHeight(id int) int {
+	if id < 0 || id >= len(bc.Shards) {
+		return -1
+	}
+	tip := bc.Shards[id].Blockchain.Tip()
+	if tip == nil {
+		return -1
+	}
+	return tip.Index
+}
+
+// RelayReceipt verifies a cross-shard receipt and queues it for crediting
+// on its destination shard by DeliverReceipts. A receipt is accepted once.
+func (bc *BeaconChain) RelayReceipt(r *CrossShardReceipt) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.relayLocked(r)
+}
+
+// relayLocked is RelayReceipt for a caller holding bc.mu.
+func (bc *BeaconChain) relayLocked(r *CrossShardReceipt) error {
+	if err := bc.verifyReceiptLocked(r); err != nil {
+		return err
+	}
+	key := r.BlockHash + "/" + r.Tx.CalculateHash()
+	if bc.relayed[key] {
+		return fmt.Errorf("receipt for transaction %s was already relayed", r.Tx.CalculateHash())
+	}
+	bc.relayed[key] = true
+	bc.relayQueue = append(bc.relayQueue, r)
+	return nil
+}
+
+// DeliverReceipts credits the recipients of all queued receipts on their
+// shards and returns how many were delivered.
+func (bc *BeaconChain) DeliverReceipts() int {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for _, r := range bc.relayQueue {
+		bc.Shards[r.DestShard].Ledger[r.Tx.Recipient] += r.Tx.Amount
+	}
+	delivered := len(bc.relayQueue)
+	bc.relayQueue = nil
+	return delivered
+}
+
+// Checkpoint anchors the current tip of a shard in the beacon chain so
+// receipts for its transactions can later be verified.
+func (bc *BeaconChain) Checkpoint(shardID int) (ShardCheckpoint, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.checkpointLocked(shardID)
+}
+
+// checkpointLocked is Checkpoint for a caller holding bc.mu.
+func (bc *BeaconChain) checkpointLocked(shardID int) (ShardCheckpoint, error) {
+	if shardID < 0 || shardID >= len(bc.Shards) {
+		return ShardCheckpoint{}, fmt.Errorf("unknown shard %d", shardID)
+	}
+	tip := bc.Shards[shardID].Blockchain.Tip()
+	if tip == nil {
+		return ShardCheckpoint{}, fmt.Errorf("shard %d has no blocks", shardID)
+	}
+	cp := ShardCheckpoint{
+		ShardID:   shardID,
+		Height:    tip.Index,
+		BlockHash: tip.Hash,
+		TxRoot:    MerkleRoot(tip.Transactions),
+	}
+	bc.Checkpoints[tip.Hash] = cp
+	return cp, nil
+}
+
+// GenerateCrossShardReceipt builds a receipt for the transaction txHash
+// included in block blockHash of the source shard.
+func (bc *BeaconChain) GenerateCrossShardReceipt(shardID int, blockHash, txHash string) (*CrossShardReceipt, error) {
+	if shardID < 0 || shardID >= len(bc.Shards) {
+		return nil, fmt.Errorf("unknown shard %d", shardID)
+	}
+	block, err := GetBlockFromChain(bc.Shards[shardID].Blockchain, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	for i, tx := range block.Transactions {
+		if tx.CalculateHash() != txHash {
+			continue
+		}
+		proof, err := MerkleProof(block.Transactions, i)
+		if err != nil {
+			return nil, err
+		}
+		return &CrossShardReceipt{
+			SourceShard: shardID,
+			DestShard:   bc.AssignShardForAddress(tx.Recipient),
+			BlockHash:   blockHash,
+			Tx:          tx,
+			Proof:       proof,
+		}, nil
+	}
+	return nil, fmt.Errorf("transaction %s not found in block %s", txHash, blockHash)
+}
+
+// VerifyCrossShardReceipt checks that the receipt's transaction was debited
+// on its source shard, by proving its inclusion against a checkpointed block.
+func (bc *BeaconChain) VerifyCrossShardReceipt(r *CrossShardReceipt) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.verifyReceiptLocked(r)
+}
+
+// verifyReceiptLocked is VerifyCrossShardReceipt for a caller holding bc.mu.
+func (bc *BeaconChain) verifyReceiptLocked(r *CrossShardReceipt) error {
+	if r == nil || r.Tx == nil {
+		return fmt.Errorf("receipt has no transaction")
+	}
+	cp, ok := bc.Checkpoints[r.BlockHash]
+	if !ok {
+		return fmt.Errorf("block %s is not anchored in the beacon chain", r.BlockHash)
+	}
+	if cp.ShardID != r.SourceShard || bc.AssignShard(r.Tx) != r.SourceShard {
+		return fmt.Errorf("transaction does not belong to source shard %d", r.SourceShard)
+	}
+	if bc.AssignShardForAddress(r.Tx.Recipient) != r.DestShard || r.DestShard == r.SourceShard {
+		return fmt.Errorf("receipt destination shard %d does not match recipient", r.DestShard)
+	}
+	if !VerifyMerkleProof(r.Tx.CalculateHash(), r.Proof, cp.TxRoot) {
+		return fmt.Errorf("no matching debit in source shard block %s", r.BlockHash)
+	}
+	return nil
 }
@@ -0,0 +1,275 @@
+package blockchain_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+	"cryptocypher/pkg/wallet"
+)
+
+// walletOnShard returns a wallet whose address the beacon chain assigns to shardID.
+func walletOnShard(t *testing.T, beacon *blockchain.BeaconChain, shardID int) *wallet.Wallet {
+	t.Helper()
+	for seed := 0; seed < 256; seed++ {
+		w := testWallet(t, byte(seed))
+		if beacon.AssignShard(&blockchain.Transaction{Sender: w.Address}) == shardID {
+			return w
+		}
+	}
+	t.Fatalf("no wallet found for shard %d", shardID)
+	return nil
+}
+
+// addressOnShard returns an address that the beacon chain assigns to shardID.
+func addressOnShard(t *testing.T, beacon *blockchain.BeaconChain, shardID int, prefix string) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		addr := fmt.Sprintf("%s%d", prefix, i)
+		if beacon.AssignShard(&blockchain.Transaction{Sender: addr}) == shardID {
+			return addr
+		}
+	}
+	t.Fatalf("no address found for shard %d", shardID)
+	return ""
+}
+
+func TestCrossShardReceiptVerification(t *testing.T) {
+	beacon := blockchain.NewBeaconChain(2)
+	sender := walletOnShard(t, beacon, 0)
+	recipient := addressOnShard(t, beacon, 1, "recipient")
+
+	tx := signedTx(t, sender, recipient, 7, 1)
+	txPool := &blockchain.TransactionPool{}
+	txPool.AddTransaction(tx)
+	txPool.AddTransaction(signedTx(t, sender, "someone", 1, 2))
+	source := beacon.Shards[0].Blockchain
+	block := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "", txPool, 1, "Miner1", 12.5)
+	source.AddBlock(block)
+	if _, err := beacon.Checkpoint(0); err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := beacon.GenerateCrossShardReceipt(0, block.Hash, tx.CalculateHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := beacon.VerifyCrossShardReceipt(receipt); err != nil {
+		t.Fatalf("expected valid receipt to verify, got %v", err)
+	}
+
+	// A receipt for a debit that never happened on the source shard.
+	forgedTx := *tx
+	forgedTx.Amount = 7000
+	forged := *receipt
+	forged.Tx = &forgedTx
+	if err := beacon.VerifyCrossShardReceipt(&forged); err == nil {
+		t.Error("expected receipt without a matching source debit to be rejected")
+	}
+
+	// A receipt pointing at a block the beacon chain never anchored.
+	unanchored := *receipt
+	unanchored.BlockHash = "deadbeef"
+	if err := beacon.VerifyCrossShardReceipt(&unanchored); err == nil {
+		t.Error("expected receipt for an unanchored block to be rejected")
+	}
+}
+
+func TestCrossShardTransferMovesFunds(t *testing.T) {
+	beacon := blockchain.NewBeaconChain(2)
+	sender := walletOnShard(t, beacon, 0)
+	recipient := addressOnShard(t, beacon, 1, "recipient")
+	source, dest := beacon.Shards[0], beacon.Shards[1]
+	source.Ledger[sender.Address] = 20
+
+	tx := signedTx(t, sender, recipient, 7, 1)
+	if err := beacon.ProcessTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := beacon.MineShard(0, "Miner1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := source.Ledger[sender.Address]; got != 13 {
+		t.Errorf("sender balance on source shard is %v, want 13", got)
+	}
+	if got := dest.Ledger[recipient]; got != 7 {
+		t.Errorf("recipient balance on destination shard is %v, want 7", got)
+	}
+	if got := source.Ledger[recipient]; got != 0 {
+		t.Errorf("recipient was credited on the source shard: %v", got)
+	}
+	if len(source.Blockchain.Blocks) != 1 {
+		t.Fatalf("expected the debit to be recorded in a source shard block")
+	}
+
+	// The receipt cannot be relayed a second time to credit the recipient again.
+	receipt, err := beacon.GenerateCrossShardReceipt(0, source.Blockchain.Blocks[0].Hash, tx.CalculateHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := beacon.RelayReceipt(receipt); err == nil {
+		t.Error("a receipt was relayed twice")
+	}
+	if beacon.DeliverReceipts() != 0 || dest.Ledger[recipient] != 7 {
+		t.Error("a replayed receipt credited the recipient again")
+	}
+
+	// Transfers beyond the sender's shard balance are refused.
+	if err := beacon.ProcessTransaction(signedTx(t, sender, recipient, 50, 2)); err == nil {
+		t.Error("an unfunded cross-shard transfer was accepted")
+	}
+}
+
+func TestCheckpointWhileMining(t *testing.T) {
+	beacon := blockchain.NewBeaconChain(2)
+	sender := walletOnShard(t, beacon, 0)
+	recipient := addressOnShard(t, beacon, 1, "recipient")
+	beacon.Shards[0].Ledger[sender.Address] = 20
+
+	// Checkpoints taken by other callers race with the ones MineShard takes.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			beacon.Checkpoint(0)
+		}
+	}()
+	for nonce := 1; nonce <= 3; nonce++ {
+		if err := beacon.ProcessTransaction(signedTx(t, sender, recipient, 1, nonce)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := beacon.MineShard(0, "Miner1", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if got := beacon.Shards[1].Ledger[recipient]; got != 3 {
+		t.Errorf("recipient balance on destination shard is %v, want 3", got)
+	}
+}
+
+func TestMineShardStoresTransactions(t *testing.T) {
+	beacon := blockchain.NewBeaconChain(2)
+	alice := walletOnShard(t, beacon, 0)
+	bob := addressOnShard(t, beacon, 0, "bob")
+	carol := addressOnShard(t, beacon, 1, "carol")
+	beacon.Shards[0].Ledger[alice.Address] = 100
+	if got := beacon.ShardHeight(0); got != -1 {
+		t.Fatalf("empty shard has height %d, want -1", got)
+	}
+
+	txs := []*blockchain.Transaction{
+		signedTx(t, alice, bob, 10, 1),
+		signedTx(t, alice, carol, 20, 2),
+		signedTx(t, alice, bob, 5, 3),
+	}
+	for _, tx := range txs {
+		if err := beacon.ProcessTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if beacon.Shards[0].TxPool.Len() != len(txs) || beacon.Shards[1].TxPool.Len() != 0 {
+		t.Fatal("transactions were not pooled on the sender's shard")
+	}
+
+	block, err := beacon.MineShard(0, alice.Address, 12.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := beacon.ShardHeight(0); got != 0 {
+		t.Errorf("shard 0 height is %d after one block, want 0", got)
+	}
+	if got := beacon.ShardHeight(1); got != -1 {
+		t.Errorf("shard 1 height is %d, want -1", got)
+	}
+	if len(block.Transactions) != len(txs)+1 || !block.Transactions[0].IsCoinbase() {
+		t.Fatalf("expected a coinbase and %d transactions, got %d transactions", len(txs), len(block.Transactions))
+	}
+	for i, tx := range txs {
+		if block.Transactions[i+1].CalculateHash() != tx.CalculateHash() {
+			t.Errorf("transaction %d of the block is not the pooled one", i)
+		}
+	}
+	if beacon.Shards[0].TxPool.Len() != 0 {
+		t.Error("mined transactions are still pooled")
+	}
+	// 100 - 35 sent + 12.5 reward.
+	if got := beacon.Shards[0].Ledger[alice.Address]; got != 77.5 {
+		t.Errorf("alice has %v, want 77.5", got)
+	}
+	if beacon.Shards[0].Ledger[bob] != 15 || beacon.Shards[1].Ledger[carol] != 20 {
+		t.Errorf("recipients were not credited: bob %v, carol %v", beacon.Shards[0].Ledger[bob], beacon.Shards[1].Ledger[carol])
+	}
+
+	if _, err := beacon.MineShard(0, alice.Address, 12.5); err == nil {
+		t.Error("mined a block from an empty pool")
+	}
+}
+
+func TestCommitCrosslinksShardTips(t *testing.T) {
+	beacon := blockchain.NewBeaconChain(2)
+	if _, err := beacon.Commit(); err == nil {
+		t.Fatal("committed without shard blocks")
+	}
+	mainChain := blockchain.NewBlockchain()
+	genesis := blockchain.NewGenesisBlock(blockchain.GenesisAlloc{}, 1)
+	mainChain.AddBlock(genesis)
+
+	for shardID := 0; shardID < 2; shardID++ {
+		w := walletOnShard(t, beacon, shardID)
+		beacon.Shards[shardID].Ledger[w.Address] = 10
+		for nonce := 1; nonce <= shardID+1; nonce++ {
+			if err := beacon.ProcessTransaction(signedTx(t, w, w.Address, 1, nonce)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := beacon.MineShard(shardID, "Miner1", 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	links, err := beacon.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(genesis.SubBlocks) != 0 || !slices.Equal(beacon.PendingCrosslinks(), links) {
+		t.Fatal("expected the crosslinks to wait for the next main block")
+	}
+	block, err := blockchain.CreateCrosslinkBlockContext(context.Background(), 1, genesis.Hash, links,
+		&blockchain.TransactionPool{}, 1, "Miner1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, err := mainChain.AddBlockIfTip(block, genesis.Hash); err != nil || !added {
+		t.Fatalf("crosslink block rejected: %v", err)
+	}
+	beacon.CrosslinksCommitted(links)
+	if len(beacon.PendingCrosslinks()) != 0 {
+		t.Error("committed crosslinks are still pending")
+	}
+	committed, err := blockchain.Crosslinks(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The block's proof of work covers its crosslinks.
+	forged := *block
+	forged.TextData = strings.Replace(block.TextData, links[0].BlockHash, strings.Repeat("0", 64), 1)
+	if blockchain.CalculateHash(&forged) == block.Hash {
+		t.Error("crosslinks are not committed to by the block hash")
+	}
+	if len(committed) != 2 || len(links) != 2 {
+		t.Fatalf("expected crosslinks for 2 shards, got %d", len(committed))
+	}
+	for _, link := range committed {
+		tip := beacon.Shards[link.ShardID].Blockchain.Tip()
+		if link.BlockHash != tip.Hash || link.Height != tip.Index {
+			t.Errorf("crosslink %+v does not match shard %d tip %s at %d", link, link.ShardID, tip.Hash, tip.Index)
+		}
+	}
+	if committed[1].Height != 1 {
+		t.Errorf("shard 1 committed at height %d, want 1", committed[1].Height)
+	}
+}
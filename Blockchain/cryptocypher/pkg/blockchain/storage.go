@@ -2,33 +2,79 @@
 package blockchain
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
+	"slices"
+	"sort"
 
 	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
 )
 
 const (
-	dbName     = "blockchain.db"
-	bucketName = "Blocks"
+	dbName          = "blockchain.db"
+	bucketName      = "Blocks"
+	txIndexBucket   = "TxIndex"       // transaction hash -> block hash
+	addrIndexBucket = "AddrIndex"     // address -> JSON list of transaction hashes
+	heightBucket    = "BlockIndex"    // big-endian block index -> block hash
+	ledgerBucket    = "Ledger"        // address -> big-endian float64 bits of its balance
+	metaBucket      = "Meta"          // metaKey -> JSON ChainMeta
+	stateBucket     = "ContractState" // contract name -> bucket of its state keys and values
+	codeBucket      = "ContractCode"  // contract name -> JSON storedCode
 )
 
+// storedCode is the deployed code of a contract, as kept in codeBucket.
+type storedCode struct {
+	Code    []byte `json:"code"`
+	Version int    `json:"version"`
+}
+
+// metaKey is the key of the chain aggregates in metaBucket.
+var metaKey = []byte("chain")
+
+// ChainMeta holds aggregates over the stored main chain, that is the blocks
+// reachable through the height index. It is updated in the same transaction
+// as every block write or delete, so reading it is O(1).
+type ChainMeta struct {
+	Height  int     `json:"height"` // Index of the tip; -1 if no block is stored.
+	TipHash string  `json:"tip_hash"`
+	TxCount int     `json:"tx_count"`
+	Supply  float64 `json:"supply"`
+}
+
 // DB is a wrapper around BoltDB for blockchain persistence.
 type DB struct {
 	*bolt.DB
 }
 
-// OpenDB opens or creates the BoltDB database.
+// OpenDB opens or creates the BoltDB database blockchain.db in the working
+// directory.
 func OpenDB() (*DB, error) {
-	db, err := bolt.Open(dbName, 0600, nil)
+	return OpenDBPath(dbName)
+}
+
+// OpenDBPath opens or creates the BoltDB database at path. The directory
+// must already exist. A database can be open in one process at a time.
+func OpenDBPath(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, nil)
 	if err != nil {
 		return nil, err
 	}
-	// Ensure the bucket exists.
+	// Ensure the buckets exist.
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
-		return err
+		for _, name := range []string{bucketName, txIndexBucket, addrIndexBucket, heightBucket, ledgerBucket, metaBucket, stateBucket, codeBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		// Databases written before the aggregates existed get them computed once.
+		if tx.Bucket([]byte(metaBucket)).Get(metaKey) == nil {
+			return rebuildMeta(tx)
+		}
+		return nil
 	})
 	if err != nil {
 		return nil, err
@@ -37,15 +83,304 @@ func OpenDB() (*DB, error) {
 }
 
 // SaveBlock saves a block into the database using its hash as the key.
+// The transaction and address indexes and the chain aggregates are updated
+// in the same transaction. A block previously stored at the same height is
+// deleted first.
 func (db *DB) SaveBlock(b *Block) error {
+	return db.SaveBlocks([]*Block{b})
+}
+
+// SaveBlocks saves blocks as SaveBlock does, but all in a single
+// transaction: either every block is stored or none is. Use it to persist
+// a synced chain instead of paying for one transaction per block.
+func (db *DB) SaveBlocks(blocks []*Block) error {
 	return db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		encoded, err := json.Marshal(b)
+		meta, err := readMeta(tx)
+		if err != nil {
+			return err
+		}
+		for _, b := range blocks {
+			if err := saveBlock(tx, b, &meta); err != nil {
+				return err
+			}
+		}
+		return writeMeta(tx, meta)
+	})
+}
+
+// ReplaceChain makes blocks, a run of consecutive main-chain blocks, the
+// top of the stored main chain in a single transaction: blocks already
+// stored at their height are left alone, the others are saved, and main
+// chain blocks above the last one are deleted. A crash leaves either the
+// old or the new chain stored.
+func (db *DB) ReplaceChain(blocks []*Block) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := readMeta(tx)
+		if err != nil {
+			return err
+		}
+		heights := tx.Bucket([]byte(heightBucket))
+		for _, b := range blocks {
+			if string(heights.Get(heightKey(b.Index))) == b.Hash {
+				continue
+			}
+			if err := saveBlock(tx, b, &meta); err != nil {
+				return err
+			}
+		}
+		var stale []string
+		c := heights.Cursor()
+		for k, v := c.Seek(heightKey(blocks[len(blocks)-1].Index + 1)); k != nil; k, v = c.Next() {
+			stale = append(stale, string(v))
+		}
+		for _, hash := range stale {
+			if err := deleteBlock(tx, hash, &meta); err != nil {
+				return err
+			}
+		}
+		return writeMeta(tx, meta)
+	})
+}
+
+// SaveChain saves every block bc holds in memory in a single transaction.
+func (db *DB) SaveChain(bc *Blockchain) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return db.SaveBlocks(bc.Blocks)
+}
+
+// saveBlock stores b with its height and index entries within tx and adds
+// it to meta, replacing any block stored at the same height.
+func saveBlock(tx *bolt.Tx, b *Block, meta *ChainMeta) error {
+	if old := tx.Bucket([]byte(heightBucket)).Get(heightKey(b.Index)); old != nil {
+		if err := deleteBlock(tx, string(old), meta); err != nil {
+			return err
+		}
+	}
+
+	bucket := tx.Bucket([]byte(bucketName))
+	encoded, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put([]byte(b.Hash), encoded); err != nil {
+		return err
+	}
+	if err := tx.Bucket([]byte(heightBucket)).Put(heightKey(b.Index), []byte(b.Hash)); err != nil {
+		return err
+	}
+	if err := indexBlockTransactions(tx, b); err != nil {
+		return err
+	}
+	meta.TxCount += len(b.Transactions)
+	meta.Supply += blockSupplyDelta(b)
+	if b.Index >= meta.Height {
+		meta.Height = b.Index
+		meta.TipHash = b.Hash
+	}
+	return nil
+}
+
+// DeleteBlock removes the block with the given hash together with its
+// height and index entries, and updates the chain aggregates.
+func (db *DB) DeleteBlock(hash string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := readMeta(tx)
 		if err != nil {
 			return err
 		}
-		return bucket.Put([]byte(b.Hash), encoded)
+		if err := deleteBlock(tx, hash, &meta); err != nil {
+			return err
+		}
+		return writeMeta(tx, meta)
+	})
+}
+
+// deleteBlock removes a block inside tx. If it was on the main chain its
+// contribution is taken out of meta and, if it was the tip, the highest
+// remaining block becomes the tip.
+func deleteBlock(tx *bolt.Tx, hash string, meta *ChainMeta) error {
+	blocks := tx.Bucket([]byte(bucketName))
+	data := blocks.Get([]byte(hash))
+	if data == nil {
+		return fmt.Errorf("block not found")
+	}
+	var b Block
+	if err := json.Unmarshal(data, &b); err != nil {
+		return err
+	}
+	if err := blocks.Delete([]byte(hash)); err != nil {
+		return err
+	}
+
+	txIndex := tx.Bucket([]byte(txIndexBucket))
+	addrIndex := tx.Bucket([]byte(addrIndexBucket))
+	for _, t := range b.Transactions {
+		txHash := t.CalculateHash()
+		if string(txIndex.Get([]byte(txHash))) != hash {
+			continue // Indexed under another block that includes it too.
+		}
+		if err := txIndex.Delete([]byte(txHash)); err != nil {
+			return err
+		}
+		for _, addr := range []string{t.Recipient, t.Sender} {
+			if err := removeAddressIndex(addrIndex, addr, txHash); err != nil {
+				return err
+			}
+		}
+	}
+
+	heights := tx.Bucket([]byte(heightBucket))
+	if string(heights.Get(heightKey(b.Index))) != hash {
+		return nil // Not on the main chain, so not in the aggregates.
+	}
+	if err := heights.Delete(heightKey(b.Index)); err != nil {
+		return err
+	}
+	meta.TxCount -= len(b.Transactions)
+	meta.Supply -= blockSupplyDelta(&b)
+	if meta.TipHash == hash {
+		meta.Height, meta.TipHash = -1, ""
+		if k, v := heights.Cursor().Last(); k != nil {
+			meta.Height = int(binary.BigEndian.Uint64(k))
+			meta.TipHash = string(v)
+		}
+	}
+	return nil
+}
+
+// removeAddressIndex drops txHash from the list stored for addr.
+func removeAddressIndex(bucket *bolt.Bucket, addr, txHash string) error {
+	data := bucket.Get([]byte(addr))
+	if data == nil {
+		return nil
+	}
+	var hashes []string
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		return err
+	}
+	kept := hashes[:0]
+	for _, h := range hashes {
+		if h != txHash {
+			kept = append(kept, h)
+		}
+	}
+	if len(kept) == 0 {
+		return bucket.Delete([]byte(addr))
+	}
+	encoded, err := json.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(addr), encoded)
+}
+
+// ChainMeta returns the aggregates of the stored main chain.
+func (db *DB) ChainMeta() (ChainMeta, error) {
+	var meta ChainMeta
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		meta, err = readMeta(tx)
+		return err
+	})
+	return meta, err
+}
+
+func readMeta(tx *bolt.Tx) (ChainMeta, error) {
+	meta := ChainMeta{Height: -1}
+	data := tx.Bucket([]byte(metaBucket)).Get(metaKey)
+	if data == nil {
+		return meta, nil
+	}
+	err := json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+func writeMeta(tx *bolt.Tx, meta ChainMeta) error {
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(metaBucket)).Put(metaKey, encoded)
+}
+
+// rebuildMeta recomputes the chain aggregates from the height index.
+func rebuildMeta(tx *bolt.Tx) error {
+	meta := ChainMeta{Height: -1}
+	blocks := tx.Bucket([]byte(bucketName))
+	err := tx.Bucket([]byte(heightBucket)).ForEach(func(k, v []byte) error {
+		data := blocks.Get(v)
+		if data == nil {
+			return nil
+		}
+		var b Block
+		if err := json.Unmarshal(data, &b); err != nil {
+			return err
+		}
+		meta.TxCount += len(b.Transactions)
+		meta.Supply += blockSupplyDelta(&b)
+		meta.Height = b.Index
+		meta.TipHash = b.Hash
+		return nil
 	})
+	if err != nil {
+		return err
+	}
+	return writeMeta(tx, meta)
+}
+
+// heightKey encodes a block index so that keys sort by height.
+func heightKey(index int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(index))
+	return key
+}
+
+// indexBlockTransactions records every transaction of b in the tx index
+// and appends its hash to the history of both sender and recipient.
+func indexBlockTransactions(tx *bolt.Tx, b *Block) error {
+	txIndex := tx.Bucket([]byte(txIndexBucket))
+	addrIndex := tx.Bucket([]byte(addrIndexBucket))
+	for _, t := range b.Transactions {
+		txHash := t.CalculateHash()
+		if err := txIndex.Put([]byte(txHash), []byte(b.Hash)); err != nil {
+			return err
+		}
+		addresses := []string{t.Recipient}
+		if !t.IsCoinbase() && t.Sender != t.Recipient {
+			addresses = append(addresses, t.Sender)
+		}
+		for _, addr := range addresses {
+			if err := appendAddressIndex(addrIndex, addr, txHash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// appendAddressIndex adds txHash to the list stored for addr, skipping duplicates.
+func appendAddressIndex(bucket *bolt.Bucket, addr, txHash string) error {
+	var hashes []string
+	if data := bucket.Get([]byte(addr)); data != nil {
+		if err := json.Unmarshal(data, &hashes); err != nil {
+			return err
+		}
+	}
+	for _, h := range hashes {
+		if h == txHash {
+			return nil
+		}
+	}
+	hashes = append(hashes, txHash)
+	encoded, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(addr), encoded)
 }
 
 // GetBlock retrieves a block from the database by its hash.
@@ -65,7 +400,198 @@ func (db *DB) GetBlock(hash string) (*Block, error) {
 	return &b, nil
 }
 
-// GetAllBlocks retrieves all blocks from the database.
+// GetBlockByIndex retrieves the stored block at the given height.
+func (db *DB) GetBlockByIndex(index int) (*Block, error) {
+	if index < 0 {
+		return nil, fmt.Errorf("block not found")
+	}
+	var b Block
+	err := db.View(func(tx *bolt.Tx) error {
+		hash := tx.Bucket([]byte(heightBucket)).Get(heightKey(index))
+		if hash == nil {
+			return fmt.Errorf("block not found")
+		}
+		data := tx.Bucket([]byte(bucketName)).Get(hash)
+		if data == nil {
+			return fmt.Errorf("block not found")
+		}
+		return json.Unmarshal(data, &b)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetTransaction looks up a transaction by its hash using the tx index.
+func (db *DB) GetTransaction(hash string) (*Transaction, error) {
+	var found *Transaction
+	err := db.View(func(tx *bolt.Tx) error {
+		t, err := lookupTransaction(tx, hash, map[string]*Block{})
+		found = t
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// GetTransactionBlock returns the stored block that includes the
+// transaction with the given hash.
+func (db *DB) GetTransactionBlock(hash string) (*Block, error) {
+	var blockHash string
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(txIndexBucket)).Get([]byte(hash))
+		if v == nil {
+			return fmt.Errorf("transaction not found")
+		}
+		blockHash = string(v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.GetBlock(blockHash)
+}
+
+// GetTransactionsByAddress returns every stored transaction sent or received
+// by addr, in the order they were indexed.
+func (db *DB) GetTransactionsByAddress(addr string) ([]*Transaction, error) {
+	var txs []*Transaction
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(addrIndexBucket)).Get([]byte(addr))
+		if data == nil {
+			return nil
+		}
+		var hashes []string
+		if err := json.Unmarshal(data, &hashes); err != nil {
+			return err
+		}
+		// Several transactions usually share a block; decode each block once.
+		blocks := make(map[string]*Block)
+		for _, h := range hashes {
+			t, err := lookupTransaction(tx, h, blocks)
+			if err != nil {
+				return err
+			}
+			txs = append(txs, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// lookupTransaction resolves a transaction hash to the transaction stored in its block.
+func lookupTransaction(tx *bolt.Tx, hash string, blocks map[string]*Block) (*Transaction, error) {
+	blockHash := tx.Bucket([]byte(txIndexBucket)).Get([]byte(hash))
+	if blockHash == nil {
+		return nil, fmt.Errorf("transaction not found")
+	}
+	b, ok := blocks[string(blockHash)]
+	if !ok {
+		data := tx.Bucket([]byte(bucketName)).Get(blockHash)
+		if data == nil {
+			return nil, fmt.Errorf("block %s for transaction %s not found", blockHash, hash)
+		}
+		b = &Block{}
+		if err := json.Unmarshal(data, b); err != nil {
+			return nil, err
+		}
+		blocks[string(blockHash)] = b
+	}
+	for _, t := range b.Transactions {
+		if t.CalculateHash() == hash {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("transaction %s missing from block %s", hash, blockHash)
+}
+
+// IndexReport summarizes the differences found between the stored blocks
+// and the transaction index.
+type IndexReport struct {
+	Checked  int      `json:"checked"`  // Index entries examined.
+	Missing  []string `json:"missing"`  // Transactions in a block with no index entry.
+	Stale    []string `json:"stale"`    // Index entries not pointing at a block holding the transaction.
+	Repaired bool     `json:"repaired"` // Whether the discrepancies were fixed.
+}
+
+// VerifyTxIndex rebuilds the expected transaction index from the stored
+// blocks and compares it with the one on disk. With repair set, missing
+// entries are added and stale ones are corrected or removed.
+func (db *DB) VerifyTxIndex(repair bool) (*IndexReport, error) {
+	report := &IndexReport{Missing: []string{}, Stale: []string{}}
+	check := func(tx *bolt.Tx) error {
+		expected := make(map[string]string)
+		err := tx.Bucket([]byte(bucketName)).ForEach(func(k, v []byte) error {
+			var b Block
+			if err := json.Unmarshal(v, &b); err != nil {
+				return err
+			}
+			for _, t := range b.Transactions {
+				expected[t.CalculateHash()] = b.Hash
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		index := tx.Bucket([]byte(txIndexBucket))
+		err = index.ForEach(func(k, v []byte) error {
+			report.Checked++
+			if blockHash, ok := expected[string(k)]; !ok || blockHash != string(v) {
+				report.Stale = append(report.Stale, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for txHash := range expected {
+			if index.Get([]byte(txHash)) == nil {
+				report.Missing = append(report.Missing, txHash)
+			}
+		}
+		sort.Strings(report.Missing)
+		sort.Strings(report.Stale)
+
+		if !repair {
+			return nil
+		}
+		for _, txHash := range append(report.Missing, report.Stale...) {
+			var err error
+			if blockHash, ok := expected[txHash]; ok {
+				err = index.Put([]byte(txHash), []byte(blockHash))
+			} else {
+				err = index.Delete([]byte(txHash))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		report.Repaired = true
+		return nil
+	}
+
+	var err error
+	if repair {
+		err = db.Update(check)
+	} else {
+		err = db.View(check)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// GetAllBlocks retrieves all blocks from the database, ordered by hash
+// rather than by index; use LoadBlockchain for the chain in order.
 func (db *DB) GetAllBlocks() ([]*Block, error) {
 	var blocks []*Block
 	err := db.View(func(tx *bolt.Tx) error {
@@ -85,13 +611,235 @@ func (db *DB) GetAllBlocks() ([]*Block, error) {
 	return blocks, nil
 }
 
-// LoadBlockchain loads the blockchain from the database and returns a Blockchain instance.
+// IterateBlocks calls fn with each block stored in the height index, in
+// index order, decoding one block at a time within a single read
+// transaction so the chain is never held in memory as a whole. Iteration
+// stops at the first error from fn, which is returned. fn must not write
+// to db.
+func (db *DB) IterateBlocks(fn func(*Block) error) error {
+	return db.View(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(bucketName))
+		return tx.Bucket([]byte(heightBucket)).ForEach(func(k, v []byte) error {
+			data := blocks.Get(v)
+			if data == nil {
+				return fmt.Errorf("block %x in the height index is not stored", v)
+			}
+			var b Block
+			if err := json.Unmarshal(data, &b); err != nil {
+				return err
+			}
+			return fn(&b)
+		})
+	})
+}
+
+// RebuildLedger replays every stored block, in order, into a new ledger.
+// Blocks are streamed with IterateBlocks, so memory use is bounded by the
+// number of accounts rather than the length of the chain.
+func (db *DB) RebuildLedger() (Ledger, error) {
+	ledger := NewLedger()
+	if err := db.IterateBlocks(ledger.ProcessBlock); err != nil {
+		return nil, fmt.Errorf("rebuilding ledger: %w", err)
+	}
+	return ledger, nil
+}
+
+// LoadBlockchain loads the blockchain from the database and returns a
+// Blockchain instance. The chain is rebuilt in index order by walking back
+// from the stored tip through each block's PrevHash, so blocks left over
+// from replaced forks are not included. Pruning leaves stored blocks in
+// place, so the walk must reach genesis; a missing parent means the store
+// is damaged and is an error. The chain is validated against
+// DefaultChainConfig before it is returned.
 func (db *DB) LoadBlockchain() (*Blockchain, error) {
-	blocks, err := db.GetAllBlocks()
+	return db.LoadBlockchainConfig(DefaultChainConfig())
+}
+
+// LoadBlockchainConfig is LoadBlockchain for a chain with the consensus
+// parameters cfg, which the returned Blockchain uses.
+func (db *DB) LoadBlockchainConfig(cfg *ChainConfig) (*Blockchain, error) {
+	var blocks []*Block
+	err := db.View(func(tx *bolt.Tx) error {
+		meta, err := readMeta(tx)
+		if err != nil {
+			return err
+		}
+		stored := tx.Bucket([]byte(bucketName))
+		for hash := meta.TipHash; hash != ""; {
+			data := stored.Get([]byte(hash))
+			if data == nil {
+				return fmt.Errorf("stored chain does not reach genesis: block %s is missing", hash)
+			}
+			var b Block
+			if err := json.Unmarshal(data, &b); err != nil {
+				return err
+			}
+			blocks = append(blocks, &b)
+			hash = b.PrevHash
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &Blockchain{Blocks: blocks}, nil
+	slices.Reverse(blocks)
+	if len(blocks) > 0 {
+		if err := ValidateChain(blocks, cfg); err != nil {
+			return nil, fmt.Errorf("stored chain is invalid: %w", err)
+		}
+	}
+	return &Blockchain{Blocks: blocks, Config: cfg}, nil
+}
+
+// SaveLedger replaces the persisted ledger with l. The old balances are
+// dropped and the new ones written in a single transaction, so a crash
+// leaves either the previous or the new ledger on disk.
+func (db *DB) SaveLedger(l Ledger) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(ledgerBucket)); err != nil && err != bolterrors.ErrBucketNotFound {
+			return err
+		}
+		bucket, err := tx.CreateBucket([]byte(ledgerBucket))
+		if err != nil {
+			return err
+		}
+		for addr, balance := range l {
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, math.Float64bits(balance))
+			if err := bucket.Put([]byte(addr), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LoadLedger returns the persisted ledger, which is empty if none was saved.
+func (db *DB) LoadLedger() (Ledger, error) {
+	l := NewLedger()
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(ledgerBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if len(v) != 8 {
+				return fmt.Errorf("corrupt ledger entry for %s", k)
+			}
+			l[string(k)] = math.Float64frombits(binary.BigEndian.Uint64(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// ContractState returns the persisted state of contract, empty if it has none.
+func (db *DB) ContractState(contract string) (map[string][]byte, error) {
+	state := make(map[string][]byte)
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(stateBucket)).Bucket([]byte(contract))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			state[string(k)] = append([]byte(nil), v...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// SaveContractState replaces the persisted state of contract with state in
+// a single transaction.
+func (db *DB) SaveContractState(contract string, state map[string][]byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return putState(tx, contract, state)
+	})
+}
+
+// SaveContract persists the code and version of contract and replaces its
+// state with state, all in a single transaction, so a restart never pairs
+// code with state written for another version.
+func (db *DB) SaveContract(contract string, code []byte, version int, state map[string][]byte) error {
+	data, err := json.Marshal(storedCode{Code: code, Version: version})
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(codeBucket)).Put([]byte(contract), data); err != nil {
+			return err
+		}
+		return putState(tx, contract, state)
+	})
+}
+
+// ContractCode returns the persisted code and version of contract; the
+// code is nil if none is stored.
+func (db *DB) ContractCode(contract string) ([]byte, int, error) {
+	var stored storedCode
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(codeBucket)).Get([]byte(contract))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &stored)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return stored.Code, stored.Version, nil
+}
+
+// ContractNames returns the names of the contracts whose code is persisted,
+// sorted.
+func (db *DB) ContractNames() ([]string, error) {
+	var names []string
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(codeBucket)).ForEach(func(k, _ []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	return names, err
+}
+
+// DeleteContract removes the persisted code and state of contract in a
+// single transaction.
+func (db *DB) DeleteContract(contract string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(codeBucket)).Delete([]byte(contract)); err != nil {
+			return err
+		}
+		err := tx.Bucket([]byte(stateBucket)).DeleteBucket([]byte(contract))
+		if err != nil && err != bolterrors.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+// putState replaces the state of contract with state within tx.
+func putState(tx *bolt.Tx, contract string, state map[string][]byte) error {
+	parent := tx.Bucket([]byte(stateBucket))
+	if err := parent.DeleteBucket([]byte(contract)); err != nil && err != bolterrors.ErrBucketNotFound {
+		return err
+	}
+	bucket, err := parent.CreateBucket([]byte(contract))
+	if err != nil {
+		return err
+	}
+	for k, v := range state {
+		if err := bucket.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Close closes the database.
@@ -114,7 +862,7 @@ func TestStorage() {
 
 	// Define miner's address and reward for coinbase transaction.
 	minerAddress := "Miner1"
-	reward := 12.5
+	reward := DefaultBlockReward
 
 	// Create a block with PoW and coinbase transaction.
 	block := CreateBlock(0, "", "one-to-one", []string{"ReceiverA"},
@@ -132,26 +880,13 @@ func TestStorage() {
 	fmt.Printf("Retrieved Block: %+v\n", retrieved)
 }
 
-// File: pkg/blockchain/storage.go (add this function)
-func (db *DB) GetAllBlockHeaders() ([]LightBlockHeader, error) {
+// GetAllBlockHeaders returns the headers of the stored blocks in index
+// order, for a chain hashed with h.
+func (db *DB) GetAllBlockHeaders(h Hasher) ([]LightBlockHeader, error) {
 	var headers []LightBlockHeader
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		return bucket.ForEach(func(k, v []byte) error {
-			var b Block
-			if err := json.Unmarshal(v, &b); err != nil {
-				return err
-			}
-			headers = append(headers, LightBlockHeader{
-				Index:      b.Index,
-				Timestamp:  b.Timestamp,
-				PrevHash:   b.PrevHash,
-				Hash:       b.Hash,
-				Difficulty: b.Difficulty,
-				Nonce:      b.Nonce,
-			})
-			return nil
-		})
+	err := db.IterateBlocks(func(b *Block) error {
+		headers = append(headers, b.HeaderWith(h))
+		return nil
 	})
 	if err != nil {
 		return nil, err
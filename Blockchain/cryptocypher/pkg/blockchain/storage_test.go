@@ -0,0 +1,584 @@
+package blockchain_test
+
+import (
+	"bytes"
+
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// openTestDB opens a fresh database in a temporary directory.
+func openTestDB(t testing.TB) *blockchain.DB {
+	t.Helper()
+	return openTestDBAt(t, filepath.Join(t.TempDir(), "blockchain.db"))
+}
+
+// openTestDBAt opens the database at path and closes it when the test ends.
+func openTestDBAt(t testing.TB, path string) *blockchain.DB {
+	t.Helper()
+	db, err := blockchain.OpenDBPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestGetTransactionsByAddress(t *testing.T) {
+	db := openTestDB(t)
+
+	txPool := &blockchain.TransactionPool{}
+	txPool.AddTransaction(blockchain.NewTransaction("Alice", "Bob", 10, 1))
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", txPool, 1, "Miner1", 12.5)
+	if err := db.SaveBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	txPool.Clear()
+	txPool.AddTransaction(blockchain.NewTransaction("Bob", "Charlie", 4, 1))
+	block1 := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", txPool, 1, "Miner1", 12.5)
+	if err := db.SaveBlock(block1); err != nil {
+		t.Fatal(err)
+	}
+
+	txs, err := db.GetTransactionsByAddress("Bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 {
+		t.Fatalf("expected 2 transactions for Bob, got %d", len(txs))
+	}
+	if txs[0].Recipient != "Bob" || txs[1].Sender != "Bob" {
+		t.Errorf("unexpected history for Bob: %+v, %+v", txs[0], txs[1])
+	}
+
+	none, err := db.GetTransactionsByAddress("Nobody")
+	if err != nil || len(none) != 0 {
+		t.Errorf("expected empty history for unknown address, got %v (err %v)", none, err)
+	}
+}
+
+func TestCacheWindowSpillsToStorage(t *testing.T) {
+	db := openTestDB(t)
+	bc := blockchain.NewBlockchain()
+	bc.Store = db
+	bc.CacheWindow = 5
+
+	prevHash := ""
+	var hashes []string
+	for i := 0; i < 20; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		bc.AddBlock(b)
+		prevHash = b.Hash
+		hashes = append(hashes, b.Hash)
+		if len(bc.Blocks) > bc.CacheWindow {
+			t.Fatalf("in-memory chain grew to %d blocks with window %d", len(bc.Blocks), bc.CacheWindow)
+		}
+	}
+	if bc.Blocks[0].Index != 15 {
+		t.Errorf("expected window to start at block 15, got %d", bc.Blocks[0].Index)
+	}
+
+	genesis, err := bc.GetBlockByIndex(0)
+	if err != nil {
+		t.Fatalf("expected genesis to load from disk: %v", err)
+	}
+	if genesis.Hash != hashes[0] {
+		t.Error("loaded the wrong genesis block")
+	}
+	old, err := blockchain.GetBlockFromChain(bc, hashes[3])
+	if err != nil || old.Index != 3 {
+		t.Errorf("expected block 3 by hash from disk, got %v (err %v)", old, err)
+	}
+	recent, err := bc.GetBlockByIndex(18)
+	if err != nil || recent != bc.Blocks[3] {
+		t.Errorf("expected block 18 to come from the cache, got %v (err %v)", recent, err)
+	}
+	if _, err := bc.GetBlockByIndex(25); err == nil {
+		t.Error("expected missing height to return an error")
+	}
+	chain, err := bc.FullChain()
+	if err != nil || len(chain) != 20 || chain[0].Hash != hashes[0] || chain[19].Hash != hashes[19] {
+		t.Errorf("expected the full chain to span the store and the cache, got %d blocks (err %v)", len(chain), err)
+	}
+}
+
+func TestReplaceChainWeighsEvictedBlocks(t *testing.T) {
+	db := openTestDB(t)
+	bc := blockchain.NewBlockchain()
+	bc.Store = db
+	bc.CacheWindow = 2
+	mine := func(index int, prevHash, miner string) *blockchain.Block {
+		return blockchain.CreateBlock(index, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, miner, 12.5)
+	}
+	genesis := mine(0, "", "Miner1")
+	bc.AddBlock(genesis)
+	for i := 1; i < 6; i++ {
+		bc.AddBlock(mine(i, bc.Tip().Hash, "Miner1"))
+	}
+
+	// rival builds a chain of n blocks on the same genesis.
+	rival := func(n int) []*blockchain.Block {
+		chain := []*blockchain.Block{genesis}
+		for i := 1; i < n; i++ {
+			chain = append(chain, mine(i, chain[i-1].Hash, "Miner2"))
+		}
+		return chain
+	}
+	if replaced, err := bc.TryReplaceChain(rival(4)); replaced || err != nil {
+		t.Fatalf("expected a chain lighter than the stored one to be refused, got %v, %v", replaced, err)
+	}
+	if replaced, err := bc.TryReplaceChain(rival(7)); !replaced || err != nil {
+		t.Fatalf("expected a heavier chain to win, got %v, %v", replaced, err)
+	}
+}
+
+func TestReplaceChainDropsStaleBlocks(t *testing.T) {
+	db := openTestDB(t)
+	mine := func(index int, prevHash, miner string) *blockchain.Block {
+		return blockchain.CreateBlock(index, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, miner, 12.5)
+	}
+	chain := []*blockchain.Block{mine(0, "", "Miner1")}
+	for i := 1; i < 4; i++ {
+		chain = append(chain, mine(i, chain[i-1].Hash, "Miner1"))
+	}
+	if err := db.SaveBlocks(chain); err != nil {
+		t.Fatal(err)
+	}
+
+	// A shorter fork from block 1 replaces blocks 2 and 3.
+	fork := append(chain[:2:2], mine(2, chain[1].Hash, "Miner2"))
+	if err := db.ReplaceChain(fork); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := db.ChainMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Height != 2 || meta.TipHash != fork[2].Hash {
+		t.Errorf("expected the fork's block 2 as the tip, got height %d tip %s", meta.Height, meta.TipHash)
+	}
+	if _, err := db.GetBlockByIndex(3); err == nil {
+		t.Error("block 3 of the replaced chain is still stored")
+	}
+	if meta.TxCount != len(fork) {
+		t.Errorf("expected %d coinbase transactions counted, got %d", len(fork), meta.TxCount)
+	}
+}
+
+func TestVerifyTxIndexRepairsDrift(t *testing.T) {
+	db := openTestDB(t)
+	txPool := &blockchain.TransactionPool{}
+	txPool.AddTransaction(blockchain.NewTransaction("Alice", "Bob", 10, 1))
+	block := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "", txPool, 1, "Miner1", 12.5)
+	if err := db.SaveBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	transferHash := block.Transactions[1].CalculateHash()
+
+	// Simulate a crash that lost one entry and left a dangling one behind.
+	err := db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte("TxIndex"))
+		if err := index.Delete([]byte(transferHash)); err != nil {
+			return err
+		}
+		return index.Put([]byte("dangling"), []byte(block.Hash))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetTransaction(transferHash); err == nil {
+		t.Fatal("expected lookup to fail with a missing index entry")
+	}
+
+	report, err := db.VerifyTxIndex(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Missing) != 1 || report.Missing[0] != transferHash ||
+		len(report.Stale) != 1 || report.Stale[0] != "dangling" || report.Repaired {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	if _, err := db.VerifyTxIndex(true); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetTransaction(transferHash); err != nil || got.Amount != 10 {
+		t.Errorf("expected repaired lookup to succeed, got %v (err %v)", got, err)
+	}
+	report, err = db.VerifyTxIndex(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Missing) != 0 || len(report.Stale) != 0 {
+		t.Errorf("expected a clean index after repair, got %+v", report)
+	}
+}
+
+func TestLedgerSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blockchain.db")
+	db := openTestDBAt(t, path)
+	if err := db.SaveLedger(blockchain.Ledger{"Alice": 3}); err != nil {
+		t.Fatal(err)
+	}
+	want := blockchain.Ledger{"Bob": 42.5, "Miner1": 12.5}
+	if err := db.SaveLedger(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := blockchain.OpenDBPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	got, err := reopened.LoadLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for addr, balance := range want {
+		if got[addr] != balance {
+			t.Errorf("%s: expected %v, got %v", addr, balance, got[addr])
+		}
+	}
+}
+
+func TestContractStateSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blockchain.db")
+	db := openTestDBAt(t, path)
+	if err := db.SaveContractState("counter", map[string][]byte{"stale": {1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveContractState("counter", map[string][]byte{"count": {2, 0, 0, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := blockchain.OpenDBPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	got, err := reopened.ContractState("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !bytes.Equal(got["count"], []byte{2, 0, 0, 0}) {
+		t.Errorf("expected only the saved count, got %v", got)
+	}
+	if other, err := reopened.ContractState("other"); err != nil || len(other) != 0 {
+		t.Errorf("expected no state for an unknown contract, got %v (err %v)", other, err)
+	}
+}
+
+func TestContractCodeSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blockchain.db")
+	db := openTestDBAt(t, path)
+	if err := db.SaveContractState("counter", map[string][]byte{"stale": {1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveContract("counter", []byte("v2"), 2, map[string][]byte{"count": {2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveContract("gone", []byte("v1"), 1, map[string][]byte{"k": {1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteContract("gone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := blockchain.OpenDBPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	names, err := reopened.ContractNames()
+	if err != nil || len(names) != 1 || names[0] != "counter" {
+		t.Fatalf("expected only counter to be stored, got %v (err %v)", names, err)
+	}
+	code, version, err := reopened.ContractCode("counter")
+	if err != nil || string(code) != "v2" || version != 2 {
+		t.Errorf("expected code v2 at version 2, got %q at %d (err %v)", code, version, err)
+	}
+	if state, _ := reopened.ContractState("counter"); len(state) != 1 || !bytes.Equal(state["count"], []byte{2}) {
+		t.Errorf("expected SaveContract to replace the state, got %v", state)
+	}
+	if state, _ := reopened.ContractState("gone"); len(state) != 0 {
+		t.Errorf("expected a deleted contract to have no state, got %v", state)
+	}
+}
+
+func TestChainMetaTracksWritesAndDeletes(t *testing.T) {
+	db := openTestDB(t)
+
+	var blocks []*blockchain.Block
+	prevHash := ""
+	for i := 0; i < 3; i++ {
+		txPool := &blockchain.TransactionPool{}
+		txPool.AddTransaction(blockchain.NewTransaction("Alice", blockchain.BurnAddress, 1, i))
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "", txPool, 1, "Miner1", 12.5)
+		if err := db.SaveBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, b)
+		prevHash = b.Hash
+	}
+	check := func(want blockchain.ChainMeta) {
+		t.Helper()
+		got, err := db.ChainMeta()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("expected %+v, got %+v", want, got)
+		}
+	}
+	check(blockchain.ChainMeta{Height: 2, TipHash: blocks[2].Hash, TxCount: 6, Supply: 3 * 11.5})
+
+	// Saving a block again does not count it twice.
+	if err := db.SaveBlock(blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	check(blockchain.ChainMeta{Height: 2, TipHash: blocks[2].Hash, TxCount: 6, Supply: 3 * 11.5})
+
+	if err := db.DeleteBlock(blocks[2].Hash); err != nil {
+		t.Fatal(err)
+	}
+	check(blockchain.ChainMeta{Height: 1, TipHash: blocks[1].Hash, TxCount: 4, Supply: 2 * 11.5})
+	if _, err := db.GetTransaction(blocks[2].Transactions[1].CalculateHash()); err == nil {
+		t.Error("expected the deleted block's transactions to leave the index")
+	}
+	if err := db.DeleteBlock(blocks[2].Hash); err == nil {
+		t.Error("expected an error deleting a missing block")
+	}
+}
+
+func TestLoadBlockchainOrdersBlocks(t *testing.T) {
+	db := openTestDB(t)
+	blocks := make([]*blockchain.Block, 4)
+	prevHash := ""
+	for i := range blocks {
+		blocks[i] = blockchain.CreateBlock(i, prevHash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		prevHash = blocks[i].Hash
+	}
+	for _, i := range []int{2, 0, 3, 1} {
+		if err := db.SaveBlock(blocks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bc, err := db.LoadBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bc.Blocks) != len(blocks) {
+		t.Fatalf("expected %d blocks, got %d", len(blocks), len(bc.Blocks))
+	}
+	for i, b := range bc.Blocks {
+		if b.Index != i || b.Hash != blocks[i].Hash {
+			t.Errorf("position %d holds block %d (%s), want %s", i, b.Index, b.Hash, blocks[i].Hash)
+		}
+	}
+	if b, err := db.GetBlockByIndex(3); err != nil || b.Hash != blocks[3].Hash {
+		t.Errorf("GetBlockByIndex(3) = %v, %v; want the tip", b, err)
+	}
+}
+
+func TestLoadBlockchainIsValid(t *testing.T) {
+	db := openTestDB(t)
+	w := testWallet(t, 1)
+	prevHash := ""
+	for i := 0; i < 5; i++ {
+		txPool := &blockchain.TransactionPool{}
+		if i > 0 {
+			txPool.AddTransaction(signedTx(t, w, "Bob", 1, i))
+		}
+		// The sender mines every block, so the rewards fund its transfers.
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", txPool, 1, w.Address, 12.5)
+		if err := db.SaveBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = b.Hash
+	}
+
+	bc, err := db.LoadBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !blockchain.IsValidChain(bc.Blocks) {
+		t.Error("loaded chain is not valid")
+	}
+	for i, b := range bc.Blocks {
+		if b.Index != i {
+			t.Errorf("position %d holds block %d", i, b.Index)
+		}
+	}
+	if len(bc.Blocks) != 5 {
+		t.Errorf("expected 5 blocks, got %d", len(bc.Blocks))
+	}
+}
+
+func TestLoadBlockchainRejectsTamperedBlock(t *testing.T) {
+	db := openTestDB(t)
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	genesis.Nonce++
+	if err := db.SaveBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LoadBlockchain(); err == nil {
+		t.Error("expected a tampered stored chain to be rejected")
+	}
+}
+
+func TestLoadBlockchainRequiresGenesis(t *testing.T) {
+	db := openTestDB(t)
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	prevHash := genesis.Hash
+	// Only the blocks after genesis are stored.
+	for i := 1; i < 3; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		if err := db.SaveBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = b.Hash
+	}
+	if bc, err := db.LoadBlockchain(); err == nil {
+		t.Errorf("expected a stored chain without genesis to be rejected, loaded %d blocks", len(bc.Blocks))
+	}
+}
+
+func TestIterateBlocksAndRebuildLedger(t *testing.T) {
+	db := openTestDB(t)
+	w := testWallet(t, 1)
+	prevHash := ""
+	for i := 0; i < 4; i++ {
+		txPool := &blockchain.TransactionPool{}
+		if i > 0 {
+			txPool.AddTransaction(signedTx(t, w, "Bob", 2, i))
+		}
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", txPool, 1, w.Address, 12.5)
+		if err := db.SaveBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = b.Hash
+	}
+
+	var seen []int
+	err := db.IterateBlocks(func(b *blockchain.Block) error {
+		seen = append(seen, b.Index)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(seen, []int{0, 1, 2, 3}) {
+		t.Errorf("expected each block once in index order, got %v", seen)
+	}
+
+	ledger, err := db.RebuildLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ledger["Bob"] != 6 {
+		t.Errorf("expected Bob to hold 6, got %v", ledger["Bob"])
+	}
+}
+
+func TestOpenDBPathKeepsNodesApart(t *testing.T) {
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	dbs := make([]*blockchain.DB, 2)
+	errs := make(chan error, len(dbs))
+	for i := range dbs {
+		go func() {
+			db, err := blockchain.OpenDBPath(filepath.Join(t.TempDir(), "blockchain.db"))
+			dbs[i] = db
+			errs <- err
+		}()
+	}
+	for range dbs {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, db := range dbs {
+		defer db.Close()
+	}
+
+	if err := dbs[0].SaveBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbs[0].GetBlock(genesis.Hash); err != nil {
+		t.Errorf("first database lost its block: %v", err)
+	}
+	if _, err := dbs[1].GetBlock(genesis.Hash); err == nil {
+		t.Error("block saved to the first database appeared in the second")
+	}
+}
+
+// benchmarkChain returns n linked blocks with one transaction each.
+func benchmarkChain(b *testing.B, n int) []*blockchain.Block {
+	b.Helper()
+	blocks := make([]*blockchain.Block, n)
+	prevHash := ""
+	for i := range blocks {
+		txPool := &blockchain.TransactionPool{}
+		txPool.AddTransaction(blockchain.NewTransaction("Alice", "Bob", 1, i+1))
+		blocks[i] = blockchain.CreateBlock(i, prevHash, "one-to-one", []string{"ReceiverA"},
+			"Text", "Audio", "Video", txPool, 1, "Miner1", 12.5)
+		prevHash = blocks[i].Hash
+	}
+	return blocks
+}
+
+func BenchmarkSaveBlocks(b *testing.B) {
+	blocks := benchmarkChain(b, 1000)
+	b.Run("PerBlock", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			db := openTestDB(b)
+			b.StartTimer()
+			for _, block := range blocks {
+				if err := db.SaveBlock(block); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("Batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			db := openTestDB(b)
+			b.StartTimer()
+			if err := db.SaveBlocks(blocks); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
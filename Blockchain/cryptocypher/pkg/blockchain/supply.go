@@ -0,0 +1,49 @@
+// File: pkg/blockchain/supply.go
+package blockchain
+
+// BurnAddress receives tokens that are permanently removed from circulation.
+const BurnAddress = "BURN"
+
+// blockSupplyDelta returns how much a block changes the circulating supply:
+// coinbase rewards and genesis allocations mint, transfers to BurnAddress burn.
+// Fees move existing tokens to the miner through the coinbase, so they are
+// not counted as minted.
+func blockSupplyDelta(b *Block) float64 {
+	delta := 0.0
+	for _, tx := range b.Transactions {
+		switch {
+		case tx.IsCoinbase(), tx.Sender == GenesisSender:
+			delta += tx.Amount
+		default:
+			delta -= tx.Fee
+			if tx.Recipient == BurnAddress {
+				delta -= tx.Amount
+			}
+		}
+	}
+	return delta
+}
+
+// supplyOf sums the supply change of blocks.
+func supplyOf(blocks []*Block) float64 {
+	total := 0.0
+	for _, b := range blocks {
+		total += blockSupplyDelta(b)
+	}
+	return total
+}
+
+// TotalSupply returns the number of tokens in existence: everything minted
+// by coinbase and genesis transactions minus everything burned. With a
+// store it is read from the stored chain aggregates; otherwise blocks that
+// were pruned or evicted from memory are covered by a running aggregate.
+func (bc *Blockchain) TotalSupply() float64 {
+	if bc.Store != nil {
+		if meta, err := bc.Store.ChainMeta(); err == nil {
+			return meta.Supply
+		}
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.archivedSupply + supplyOf(bc.Blocks)
+}
@@ -0,0 +1,59 @@
+package blockchain_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+	"cryptocypher/pkg/wallet"
+)
+
+// buildSupplyChain returns a genesis allocating 100 tokens to alice
+// followed by blocks blocks rewarding 12.5 each; burns by alice are added
+// to block 1.
+func buildSupplyChain(t *testing.T, alice *wallet.Wallet, blocks int, burns ...float64) []*blockchain.Block {
+	genesis := blockchain.NewGenesisBlock(blockchain.GenesisAlloc{alice.Address: 100}, 1)
+	chain := []*blockchain.Block{genesis}
+	for i := 1; i <= blocks; i++ {
+		txPool := &blockchain.TransactionPool{}
+		if i == 1 {
+			for _, amount := range burns {
+				txPool.AddTransaction(signedTx(t, alice, blockchain.BurnAddress, amount, 1))
+			}
+		}
+		chain = append(chain, blockchain.CreateBlock(i, chain[i-1].Hash, "one-to-one", []string{}, "", "", "",
+			txPool, 1, "Miner1", 12.5))
+	}
+	return chain
+}
+
+func TestTotalSupply(t *testing.T) {
+	alice := testWallet(t, 1)
+	bc := blockchain.NewBlockchain()
+	bc.Config.GenesisAlloc = blockchain.GenesisAlloc{alice.Address: 100}
+	for _, b := range buildSupplyChain(t, alice, 4, 20, 5) {
+		bc.AddBlock(b)
+	}
+
+	// 100 allocated + 4 rewards of 12.5 - 25 burned.
+	const want = 125.0
+	if got := bc.TotalSupply(); got != want {
+		t.Fatalf("expected supply %v, got %v", want, got)
+	}
+
+	if err := bc.PruneAndArchive(2, filepath.Join(t.TempDir(), "archive")); err != nil {
+		t.Fatal(err)
+	}
+	if got := bc.TotalSupply(); got != want {
+		t.Errorf("supply changed after pruning: expected %v, got %v", want, got)
+	}
+
+	// A heavier fork without the burns replaces the pruned chain.
+	fork := buildSupplyChain(t, alice, 6)
+	if !bc.ReplaceChain(fork) {
+		t.Fatal("expected heavier fork to replace the chain")
+	}
+	if got := bc.TotalSupply(); got != 175 {
+		t.Errorf("expected supply 175 after reorg, got %v", got)
+	}
+}
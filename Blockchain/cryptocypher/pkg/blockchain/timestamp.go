@@ -0,0 +1,74 @@
+// File: pkg/blockchain/timestamp.go
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxFutureDrift is how far ahead of local time a block may be
+	// timestamped under DefaultChainConfig.
+	DefaultMaxFutureDrift = 2 * time.Hour
+	// DefaultMedianTimeSpan is how many preceding blocks the median time
+	// past is taken over under DefaultChainConfig.
+	DefaultMedianTimeSpan = 11
+)
+
+// Errors returned for blocks with an unacceptable timestamp.
+var (
+	ErrFutureBlock    = errors.New("block timestamp is too far in the future")
+	ErrBlockTimeStale = errors.New("block timestamp precedes the median time past")
+)
+
+// MedianTimePast returns the median timestamp, in Unix milliseconds, of the
+// last n of blocks, or 0 if blocks is empty.
+func MedianTimePast(blocks []*Block, n int) int64 {
+	if len(blocks) > n {
+		blocks = blocks[len(blocks)-n:]
+	}
+	if len(blocks) == 0 {
+		return 0
+	}
+	times := make([]int64, len(blocks))
+	for i, b := range blocks {
+		times[i] = b.Millis()
+	}
+	slices.Sort(times)
+	return times[len(times)/2]
+}
+
+// checkTimestamp verifies that b, which follows prev, is timestamped no
+// more than cfg.MaxFutureDrift after now and strictly later than the
+// median time past of prev, both to the millisecond. A zero drift or span
+// turns the corresponding rule off.
+func checkTimestamp(b *Block, prev []*Block, cfg *ChainConfig, now time.Time) error {
+	if cfg.MaxFutureDrift > 0 && b.Millis() > now.Add(cfg.MaxFutureDrift).UnixMilli() {
+		return fmt.Errorf("block %d at %d: %w", b.Index, b.Millis(), ErrFutureBlock)
+	}
+	if cfg.MedianTimeSpan > 0 && len(prev) > 0 {
+		if median := MedianTimePast(prev, cfg.MedianTimeSpan); b.Millis() <= median {
+			return fmt.Errorf("block %d at %d, median %d: %w", b.Index, b.Millis(), median, ErrBlockTimeStale)
+		}
+	}
+	return nil
+}
+
+// lastStamp is the latest Unix millisecond handed out by nextStamp.
+var lastStamp atomic.Int64
+
+// nextStamp returns the current time, moved forward if needed to a
+// millisecond later than any it returned before, so that blocks this
+// process mines in quick succession still follow one another.
+func nextStamp() time.Time {
+	for {
+		last, now := lastStamp.Load(), time.Now().UnixMilli()
+		next := max(now, last+1)
+		if lastStamp.CompareAndSwap(last, next) {
+			return time.UnixMilli(next)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package blockchain_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+// blockAt mines a block on prevHash timestamped at t.
+func blockAt(index int, prevHash string, t time.Time) *blockchain.Block {
+	b := blockchain.CreateBlock(index, prevHash, "one-to-one", []string{"ReceiverA"},
+		"Text", "Audio", "Video", &blockchain.TransactionPool{}, 1, "Miner1", blockchain.DefaultBlockReward)
+	b.Timestamp, b.TimestampMillis = t.Unix(), t.UnixMilli()
+	blockchain.MineBlock(b, b.Difficulty)
+	return b
+}
+
+func TestFutureBlockRejected(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	genesis := blockAt(0, "", time.Now())
+	bc.AddBlock(genesis)
+
+	future := blockAt(1, genesis.Hash, time.Now().Add(blockchain.DefaultMaxFutureDrift+time.Hour))
+	if _, err := bc.AddBlockIfTip(future, genesis.Hash); !errors.Is(err, blockchain.ErrFutureBlock) {
+		t.Errorf("expected ErrFutureBlock, got %v", err)
+	}
+	if err := blockchain.ValidateChain([]*blockchain.Block{genesis, future}, nil); !errors.Is(err, blockchain.ErrFutureBlock) {
+		t.Errorf("expected the chain to be rejected with ErrFutureBlock, got %v", err)
+	}
+
+	// Within the allowed drift a block is accepted.
+	ahead := blockAt(1, genesis.Hash, time.Now().Add(time.Minute))
+	if added, err := bc.AddBlockIfTip(ahead, genesis.Hash); !added || err != nil {
+		t.Errorf("block slightly ahead rejected: added=%v err=%v", added, err)
+	}
+}
+
+func TestBackdatedBlockRejected(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	start := time.Now().Add(-time.Hour)
+	chain := []*blockchain.Block{blockAt(0, "", start)}
+	bc.AddBlock(chain[0])
+	for i := 1; i < 5; i++ {
+		b := blockAt(i, chain[i-1].Hash, start.Add(time.Duration(i)*time.Minute))
+		if added, err := bc.AddBlockIfTip(b, chain[i-1].Hash); !added || err != nil {
+			t.Fatalf("block %d: added=%v err=%v", i, added, err)
+		}
+		chain = append(chain, b)
+	}
+	if median := blockchain.MedianTimePast(chain, blockchain.DefaultMedianTimeSpan); median != start.Add(2*time.Minute).UnixMilli() {
+		t.Fatalf("unexpected median time past %d", median)
+	}
+
+	// Older than the median of the previous blocks, though newer than genesis.
+	backdated := blockAt(5, chain[4].Hash, start.Add(time.Minute))
+	if _, err := bc.AddBlockIfTip(backdated, chain[4].Hash); !errors.Is(err, blockchain.ErrBlockTimeStale) {
+		t.Errorf("expected ErrBlockTimeStale, got %v", err)
+	}
+	if err := blockchain.ValidateChain(append(chain, backdated), nil); !errors.Is(err, blockchain.ErrBlockTimeStale) {
+		t.Errorf("expected the chain to be rejected with ErrBlockTimeStale, got %v", err)
+	}
+}
+
+func TestBlockAtMedianTimeRejected(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	at := time.Now().Add(-time.Minute).Truncate(time.Second)
+	genesis := blockAt(0, "", at)
+	bc.AddBlock(genesis)
+
+	// Same second and millisecond as its only predecessor.
+	same := blockAt(1, genesis.Hash, at)
+	if _, err := bc.AddBlockIfTip(same, genesis.Hash); !errors.Is(err, blockchain.ErrBlockTimeStale) {
+		t.Errorf("expected ErrBlockTimeStale for a block at the median, got %v", err)
+	}
+	// One millisecond later, within the same second, is enough.
+	next := blockAt(1, genesis.Hash, at.Add(time.Millisecond))
+	if added, err := bc.AddBlockIfTip(next, genesis.Hash); !added || err != nil {
+		t.Errorf("block a millisecond later rejected: added=%v err=%v", added, err)
+	}
+}
@@ -1,23 +1,50 @@
 package blockchain
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"sync"
 	"time"
 )
 
+// CoinbaseSender is the sender recorded on block reward transactions.
+const CoinbaseSender = "COINBASE"
+
+// ErrForgedCoinbase is returned when a coinbase transaction arrives through
+// a path other than block assembly.
+var ErrForgedCoinbase = errors.New("coinbase transactions can only be created by block assembly")
+
+// ErrPoolFull is returned when the transaction pool has reached its size cap.
+var ErrPoolFull = errors.New("transaction pool is full")
+
+// ErrDuplicateTransaction is returned when a transaction is already pending.
+var ErrDuplicateTransaction = errors.New("transaction already pending")
+
+// ErrInvalidAmount is returned for a transfer whose amount is not positive
+// or whose fee is negative.
+var ErrInvalidAmount = errors.New("amount must be positive and fee not negative")
+
+// ErrInsufficientFunds is returned for a transaction its sender cannot pay for.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
 // Transaction represents a simple transaction.
 type Transaction struct {
 	Sender       string                 `json:"sender"`
 	Recipient    string                 `json:"recipient"`
 	Amount       float64                `json:"amount"`
+	Fee          float64                `json:"fee,omitempty"` // Paid by the sender to the block's miner.
 	Timestamp    int64                  `json:"timestamp"`
 	ContractName string                 `json:"contract_name,omitempty"`
 	Method       string                 `json:"method,omitempty"`
 	Params       map[string]interface{} `json:"params,omitempty"`
-	Signature    string                 `json:"signature,omitempty"` // Digital signature (hex-encoded).
-	Nonce        int                    `json:"nonce,omitempty"`     // Optional nonce to prevent replay.
+	Signature    string                 `json:"signature,omitempty"`  // Digital signature (hex-encoded).
+	PublicKey    string                 `json:"public_key,omitempty"` // Sender's public key (hex-encoded); its address must be Sender.
+	Nonce        int                    `json:"nonce,omitempty"`      // Optional nonce to prevent replay.
+	// CallHash binds a payment for a contract call to that call; see
+	// contract.CallHash. It is signed along with the transfer.
+	CallHash string `json:"call_hash,omitempty"`
 	// In a more complete system, you might include digital signatures.
 }
 
@@ -32,29 +59,217 @@ func NewTransaction(sender, recipient string, amount float64, nonce int) *Transa
 	}
 }
 
-// String returns a string representation for signing.
+// NewCoinbaseTransaction creates the reward transaction for the block at height.
+// Its timestamp is the block's and its nonce the height, so the same block
+// template always produces the same coinbase.
+func NewCoinbaseTransaction(minerAddress string, reward float64, height int, blockTimestamp int64) *Transaction {
+	return &Transaction{
+		Sender:    CoinbaseSender,
+		Recipient: minerAddress,
+		Amount:    reward,
+		Timestamp: blockTimestamp,
+		Nonce:     height,
+	}
+}
+
+// Cost returns what the transaction takes from the sender: the amount plus the fee.
+func (tx *Transaction) Cost() float64 {
+	return tx.Amount + tx.Fee
+}
+
+// CheckAmounts reports whether tx moves a positive, finite amount for a
+// finite fee that is not negative. A negative amount would otherwise pass
+// any balance check and move funds from the recipient to the sender.
+func (tx *Transaction) CheckAmounts() error {
+	if !(tx.Amount > 0) || math.IsInf(tx.Amount, 0) || !(tx.Fee >= 0) || math.IsInf(tx.Fee, 0) {
+		return fmt.Errorf("%w: amount %v, fee %v", ErrInvalidAmount, tx.Amount, tx.Fee)
+	}
+	return nil
+}
+
+// IsCoinbase reports whether the transaction is a block reward rather than a transfer.
+func (tx *Transaction) IsCoinbase() bool {
+	return tx.Sender == CoinbaseSender
+}
+
+// String returns a string representation for signing. CallHash is only
+// appended when set, so plain transfers sign as they always have.
 func (tx *Transaction) String() string {
-	return fmt.Sprintf("%s:%s:%f:%d:%d", tx.Sender, tx.Recipient, tx.Amount, tx.Timestamp, tx.Nonce)
+	s := fmt.Sprintf("%s:%s:%f:%f:%d:%d", tx.Sender, tx.Recipient, tx.Amount, tx.Fee, tx.Timestamp, tx.Nonce)
+	if tx.CallHash != "" {
+		s += ":" + tx.CallHash
+	}
+	return s
 }
 
-// CalculateHash returns the SHA‑256 hash of the transaction.
+// CalculateHash returns the hash of the transaction under DefaultHasher.
 func (tx *Transaction) CalculateHash() string {
-	record := fmt.Sprintf("%s%s%f%d", tx.Sender, tx.Recipient, tx.Amount, tx.Timestamp)
-	h := sha256.Sum256([]byte(record))
-	return hex.EncodeToString(h[:])
+	return tx.CalculateHashWith(DefaultHasher)
+}
+
+// CalculateHashWith returns the hash of the transaction under h.
+func (tx *Transaction) CalculateHashWith(h Hasher) string {
+	record := fmt.Sprintf("%s:%s", tx.String(), tx.Signature)
+	return hexDigest(h, record)
+}
+
+// SizeBytes returns the length of the transaction's canonical JSON encoding,
+// which is what peers and blocks carry.
+func (tx *Transaction) SizeBytes() int {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		// Params holding unencodable values cannot be relayed anyway.
+		return 0
+	}
+	return len(data)
 }
 
-// TransactionPool holds pending transactions.
+// TransactionPool holds pending transactions. Its methods are safe for
+// concurrent use; Transactions must only be accessed directly while no
+// other goroutine uses the pool, and only set before its methods are used.
 type TransactionPool struct {
 	Transactions []*Transaction
+	MaxSize      int // Maximum number of pending transactions; 0 means unlimited.
+	MaxBytes     int // Maximum combined SizeBytes of pending transactions; 0 means unlimited.
+
+	mu      sync.Mutex
+	bytes   int  // Combined SizeBytes of Transactions, kept up to date once counted.
+	counted bool // Whether bytes has been counted; false for a pool built with Transactions set.
 }
 
 // AddTransaction appends a new transaction to the pool.
-func (tp *TransactionPool) AddTransaction(tx *Transaction) {
+// Coinbase transactions are rejected; CreateBlock adds its own. A
+// transaction already pending is rejected with ErrDuplicateTransaction.
+func (tp *TransactionPool) AddTransaction(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return ErrForgedCoinbase
+	}
+	if err := tx.CheckAmounts(); err != nil {
+		return err
+	}
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.addLocked(tx)
+}
+
+// AddFundedTransaction is AddTransaction for a transaction whose sender
+// holds balance. It is only added if balance covers it together with the
+// sender's transactions already pending, checked atomically with the
+// addition so that concurrent submissions cannot overspend.
+func (tp *TransactionPool) AddFundedTransaction(tx *Transaction, balance float64) error {
+	if tx.IsCoinbase() {
+		return ErrForgedCoinbase
+	}
+	if err := tx.CheckAmounts(); err != nil {
+		return err
+	}
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	pending := 0.0
+	for _, p := range tp.Transactions {
+		if p.Sender == tx.Sender {
+			pending += p.Cost()
+		}
+	}
+	if balance-pending < tx.Cost() {
+		return fmt.Errorf("%w: balance %f, pending %f, required %f", ErrInsufficientFunds, balance, pending, tx.Cost())
+	}
+	return tp.addLocked(tx)
+}
+
+// addLocked adds tx within the pool's limits. The caller must hold tp.mu.
+func (tp *TransactionPool) addLocked(tx *Transaction) error {
+	if tp.hasLocked(tx.CalculateHash()) {
+		return ErrDuplicateTransaction
+	}
+	if tp.MaxSize > 0 && len(tp.Transactions) >= tp.MaxSize {
+		return ErrPoolFull
+	}
+	size, total := tx.SizeBytes(), tp.sizeBytesLocked()
+	if tp.MaxBytes > 0 && total+size > tp.MaxBytes {
+		return ErrPoolFull
+	}
 	tp.Transactions = append(tp.Transactions, tx)
+	tp.bytes = total + size
+	return nil
+}
+
+// SizeBytes returns the combined size of the pending transactions.
+func (tp *TransactionPool) SizeBytes() int {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.sizeBytesLocked()
+}
+
+// sizeBytesLocked returns the running total of SizeBytes, counting it on
+// first use. The caller must hold tp.mu.
+func (tp *TransactionPool) sizeBytesLocked() int {
+	if !tp.counted {
+		tp.bytes = 0
+		for _, tx := range tp.Transactions {
+			tp.bytes += tx.SizeBytes()
+		}
+		tp.counted = true
+	}
+	return tp.bytes
+}
+
+// Has reports whether a transaction with the given hash is pending in the pool.
+func (tp *TransactionPool) Has(hash string) bool {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.hasLocked(hash)
+}
+
+func (tp *TransactionPool) hasLocked(hash string) bool {
+	for _, tx := range tp.Transactions {
+		if tx.CalculateHash() == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// Pending returns a snapshot of the pending transactions in arrival order.
+func (tp *TransactionPool) Pending() []*Transaction {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return append([]*Transaction(nil), tp.Transactions...)
+}
+
+// Len returns the number of pending transactions.
+func (tp *TransactionPool) Len() int {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return len(tp.Transactions)
+}
+
+// Remove drops the given transactions from the pool, typically those just
+// included in a block. Transactions not in the pool are ignored.
+func (tp *TransactionPool) Remove(txs []*Transaction) {
+	drop := make(map[string]bool, len(txs))
+	for _, tx := range txs {
+		drop[tx.CalculateHash()] = true
+	}
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	total := tp.sizeBytesLocked()
+	kept := tp.Transactions[:0]
+	for _, tx := range tp.Transactions {
+		if !drop[tx.CalculateHash()] {
+			kept = append(kept, tx)
+		} else {
+			total -= tx.SizeBytes()
+		}
+	}
+	tp.Transactions = kept
+	tp.bytes = total
 }
 
 // Clear empties the transaction pool.
 func (tp *TransactionPool) Clear() {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
 	tp.Transactions = []*Transaction{}
+	tp.bytes, tp.counted = 0, true
 }
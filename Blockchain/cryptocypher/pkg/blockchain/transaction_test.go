@@ -0,0 +1,152 @@
+package blockchain_test
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestForgedCoinbaseRejected(t *testing.T) {
+	forged := blockchain.NewTransaction(blockchain.CoinbaseSender, "Mallory", 1000, 1)
+	if !forged.IsCoinbase() {
+		t.Fatal("expected transaction with COINBASE sender to be treated as coinbase")
+	}
+
+	txPool := &blockchain.TransactionPool{}
+	if err := txPool.AddTransaction(forged); !errors.Is(err, blockchain.ErrForgedCoinbase) {
+		t.Errorf("expected pool to reject forged coinbase, got %v", err)
+	}
+	if len(txPool.Transactions) != 0 {
+		t.Errorf("forged coinbase should not be pooled")
+	}
+
+	ledger := blockchain.NewLedger()
+	ledger[blockchain.CoinbaseSender] = 5000
+	if err := ledger.ProcessTransaction(forged); !errors.Is(err, blockchain.ErrForgedCoinbase) {
+		t.Errorf("expected ledger to reject forged coinbase, got %v", err)
+	}
+	if ledger["Mallory"] != 0 {
+		t.Errorf("forged coinbase credited Mallory with %f", ledger["Mallory"])
+	}
+}
+
+func TestCoinbaseIsDeterministic(t *testing.T) {
+	a := blockchain.NewCoinbaseTransaction("Miner1", 12.5, 7, 1700000000)
+	b := blockchain.NewCoinbaseTransaction("Miner1", 12.5, 7, 1700000000)
+	if !reflect.DeepEqual(a, b) || a.CalculateHash() != b.CalculateHash() {
+		t.Errorf("coinbase for the same template differs: %+v vs %+v", a, b)
+	}
+
+	block := blockchain.CreateBlock(3, "prev", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	coinbase := block.Transactions[0]
+	if coinbase.Timestamp != block.Timestamp || coinbase.Nonce != block.Index {
+		t.Errorf("coinbase not bound to its block: %+v", coinbase)
+	}
+}
+
+func TestSizeBytesTracksPayload(t *testing.T) {
+	plain := blockchain.NewTransaction("Alice", "Bob", 10, 1)
+	withParams := *plain
+	withParams.Nonce = 2
+	withParams.ContractName = "AdditionContract"
+	withParams.Params = map[string]interface{}{"a": 10.0, "b": 15.5}
+	if withParams.SizeBytes() <= plain.SizeBytes() {
+		t.Errorf("expected params to grow the size: %d vs %d", withParams.SizeBytes(), plain.SizeBytes())
+	}
+
+	txPool := &blockchain.TransactionPool{MaxBytes: plain.SizeBytes() + withParams.SizeBytes() - 1}
+	if err := txPool.AddTransaction(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := txPool.AddTransaction(&withParams); !errors.Is(err, blockchain.ErrPoolFull) {
+		t.Errorf("expected byte cap to reject the larger transaction, got %v", err)
+	}
+	if txPool.SizeBytes() != plain.SizeBytes() {
+		t.Errorf("pool size %d does not match its contents", txPool.SizeBytes())
+	}
+
+	// The running total follows removals and clears, and counts a pool
+	// built with its transactions.
+	txPool.MaxBytes = 0
+	if err := txPool.AddTransaction(&withParams); err != nil {
+		t.Fatal(err)
+	}
+	txPool.Remove([]*blockchain.Transaction{plain})
+	if txPool.SizeBytes() != withParams.SizeBytes() {
+		t.Errorf("pool size %d after removal, want %d", txPool.SizeBytes(), withParams.SizeBytes())
+	}
+	txPool.Clear()
+	if txPool.SizeBytes() != 0 {
+		t.Errorf("pool size %d after clearing, want 0", txPool.SizeBytes())
+	}
+	built := &blockchain.TransactionPool{Transactions: []*blockchain.Transaction{plain, &withParams}}
+	if built.SizeBytes() != plain.SizeBytes()+withParams.SizeBytes() {
+		t.Errorf("built pool size %d does not match its contents", built.SizeBytes())
+	}
+}
+
+func TestFeeIsDeductedAndPaidToMiner(t *testing.T) {
+	ledger := blockchain.Ledger{"Alice": 10}
+	tx := blockchain.NewTransaction("Alice", "Bob", 10, 1)
+	tx.Fee = 0.5
+	if err := ledger.ProcessTransaction(tx); err == nil {
+		t.Fatal("expected a sender with only the amount to be rejected")
+	}
+	if ledger["Alice"] != 10 || ledger["Bob"] != 0 {
+		t.Errorf("rejected transaction changed balances: %v", ledger)
+	}
+
+	tx.Amount = 9.5
+	txPool := &blockchain.TransactionPool{}
+	txPool.AddTransaction(tx)
+	block := blockchain.CreateBlock(1, "prev", "one-to-one", []string{}, "", "", "", txPool, 1, "Miner1", 12.5)
+	if coinbase := block.Transactions[0]; coinbase.Amount != 13 {
+		t.Errorf("expected coinbase of reward plus fee, got %v", coinbase.Amount)
+	}
+	if err := ledger.ProcessBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if ledger["Alice"] != 0 || ledger["Bob"] != 9.5 || ledger["Miner1"] != 13 {
+		t.Errorf("unexpected balances after block: %v", ledger)
+	}
+}
+
+func TestTransactionPoolConcurrentUse(t *testing.T) {
+	txPool := &blockchain.TransactionPool{}
+	tx := blockchain.NewTransaction("Alice", "Bob", 1, 1)
+	if err := txPool.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := txPool.AddTransaction(tx); !errors.Is(err, blockchain.ErrDuplicateTransaction) {
+		t.Errorf("expected ErrDuplicateTransaction for a pending transaction, got %v", err)
+	}
+
+	// Run with -race: adds, reads and clears from several goroutines.
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				tx := blockchain.NewTransaction("Alice", "Bob", 1, g*1000+i)
+				txPool.AddTransaction(tx)
+				txPool.Has(tx.CalculateHash())
+				txPool.Pending()
+				if i%25 == 0 {
+					txPool.Clear()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	pending := txPool.Pending()
+	txPool.Remove(pending)
+	if txPool.Len() != 0 {
+		t.Errorf("expected Remove to drop every pending transaction, %d left", txPool.Len())
+	}
+}
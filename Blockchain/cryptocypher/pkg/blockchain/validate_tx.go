@@ -0,0 +1,42 @@
+// File: pkg/blockchain/validate_tx.go
+package blockchain
+
+import "fmt"
+
+// ValidateBlockTransactions verifies the signature of every transaction in
+// b against its sender's public key. Coinbase outputs and genesis
+// allocations carry no signature and are skipped. If ledger is not nil the
+// transfers must also be funded by it, applied in block order; ledger itself
+// is not modified. AddBlock and AddBlockIfTip check funds when they apply
+// the block to the attached ledger, and ValidateChain replays the chain's
+// own balances from genesis.
+func (bc *Blockchain) ValidateBlockTransactions(b *Block, ledger Ledger) error {
+	return validateTransactions(b, ledger)
+}
+
+func validateTransactions(b *Block, ledger Ledger) error {
+	var funds Ledger
+	if ledger != nil {
+		funds = make(Ledger, len(ledger))
+		for addr, balance := range ledger {
+			funds[addr] = balance
+		}
+	}
+	for i, tx := range b.Transactions {
+		if tx.IsCoinbase() || (b.Index == 0 && tx.Sender == GenesisSender) {
+			continue
+		}
+		if err := tx.CheckAmounts(); err != nil {
+			return fmt.Errorf("block %d transaction %d: %w", b.Index, i, err)
+		}
+		if err := VerifySignedTransaction(tx); err != nil {
+			return fmt.Errorf("block %d transaction %d: %w", b.Index, i, err)
+		}
+		if funds != nil {
+			if err := funds.ProcessTransaction(tx); err != nil {
+				return fmt.Errorf("block %d transaction %d: %w", b.Index, i, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package blockchain_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+	"cryptocypher/pkg/wallet"
+)
+
+// testWallet returns a wallet derived from seed, so tests get stable addresses.
+func testWallet(t *testing.T, seed byte) *wallet.Wallet {
+	t.Helper()
+	w, err := wallet.NewWalletFromReader(bytes.NewReader(bytes.Repeat([]byte{seed}, 40)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// signedTx returns a transaction from w to recipient, signed by w.
+func signedTx(t *testing.T, w *wallet.Wallet, recipient string, amount float64, nonce int) *blockchain.Transaction {
+	t.Helper()
+	tx := blockchain.NewTransaction(w.Address, recipient, amount, nonce)
+	if err := w.SignTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestValidateBlockTransactionsRejectsForgedSignature(t *testing.T) {
+	alice, mallory := testWallet(t, 1), testWallet(t, 2)
+	valid := signedTx(t, alice, "Bob", 5, 1)
+	// Mallory signs a transfer out of Alice's address with her own key.
+	forged := blockchain.NewTransaction(alice.Address, "Mallory", 50, 2)
+	if err := mallory.SignTransaction(forged); err != nil {
+		t.Fatal(err)
+	}
+
+	bc := blockchain.NewBlockchain()
+	genesis := blockchain.NewGenesisBlock(blockchain.GenesisAlloc{}, 1)
+	bc.AddBlock(genesis)
+
+	txPool := &blockchain.TransactionPool{}
+	txPool.AddTransaction(valid)
+	good := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "", "", "", txPool, 1, "Miner1", 12.5)
+	if err := bc.ValidateBlockTransactions(good, nil); err != nil {
+		t.Fatalf("block with a valid signature rejected: %v", err)
+	}
+	if err := bc.ValidateBlockTransactions(good, blockchain.Ledger{alice.Address: 1}); err == nil {
+		t.Error("block spending more than the sender's balance was accepted")
+	}
+
+	txPool.AddTransaction(forged)
+	bad := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "", "", "", txPool, 1, "Miner1", 12.5)
+	if err := bc.ValidateBlockTransactions(bad, nil); err == nil {
+		t.Fatal("block with a forged signature was accepted")
+	}
+	if added, err := bc.AddBlockIfTip(bad, genesis.Hash); err == nil || added {
+		t.Fatalf("AddBlockIfTip accepted a forged signature: added=%v err=%v", added, err)
+	}
+	bc.AddBlock(bad)
+	if len(bc.Blocks) != 1 {
+		t.Fatalf("AddBlock appended a block with a forged signature")
+	}
+	if err := blockchain.ValidateChain([]*blockchain.Block{genesis, bad}, nil); err == nil {
+		t.Error("chain with a forged signature was accepted")
+	}
+
+	bc.AddBlock(good)
+	if len(bc.Blocks) != 2 {
+		t.Error("AddBlock refused a block with valid signatures")
+	}
+}
+
+func TestValidateChainRejectsUnfundedTransfer(t *testing.T) {
+	alice := testWallet(t, 1)
+	cfg := blockchain.DefaultChainConfig()
+	cfg.GenesisAlloc = blockchain.GenesisAlloc{alice.Address: 10}
+	genesis := blockchain.NewGenesisBlock(cfg.GenesisAlloc, 1)
+
+	// block returns a block on parent carrying txs.
+	block := func(parent *blockchain.Block, txs ...*blockchain.Transaction) *blockchain.Block {
+		return blockchain.CreateBlock(parent.Index+1, parent.Hash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{Transactions: txs}, 1, "Miner1", 12.5)
+	}
+	funded := block(genesis, signedTx(t, alice, "Bob", 6, 1))
+	if err := blockchain.ValidateChain([]*blockchain.Block{genesis, funded}, cfg); err != nil {
+		t.Fatalf("funded chain rejected: %v", err)
+	}
+	// Each transfer is signed and funded on its own, but not both together.
+	overspent := block(funded, signedTx(t, alice, "Bob", 6, 2))
+	if err := blockchain.ValidateChain([]*blockchain.Block{genesis, funded, overspent}, cfg); !errors.Is(err, blockchain.ErrInsufficientFunds) {
+		t.Errorf("expected ErrInsufficientFunds, got %v", err)
+	}
+}
+
+func TestSignaturesArePaddedToCurveSize(t *testing.T) {
+	key, err := blockchain.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// About one signature in 128 has an r or s shorter than 32 bytes;
+	// unpadded, it would be split in the wrong place and fail to verify.
+	for nonce := 0; nonce < 1000; nonce++ {
+		tx := blockchain.NewTransaction("Alice", "Bob", 1, nonce)
+		sig, err := blockchain.SignTransaction(tx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sig) != 128 {
+			t.Fatalf("expected a 64-byte signature, got %d hex digits", len(sig))
+		}
+		tx.Signature = sig
+		if !blockchain.VerifyTransactionSignature(tx, &key.PublicKey) {
+			t.Fatalf("signature for nonce %d did not verify", nonce)
+		}
+	}
+}
@@ -2,8 +2,12 @@
 package contract
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // Contract is an interface that all smart contracts must implement.
@@ -30,36 +34,116 @@ func RegisterContract(c Contract) error {
 	return nil
 }
 
-// ExecuteContract looks up a contract by name and executes it using the given method and parameters.
-func ExecuteContract(name string, method string, params map[string]interface{}) (interface{}, error) {
+// ListContracts returns the names of the built-in contracts in
+// ContractRegistry, sorted.
+func ListContracts() []string {
+	names := make([]string, 0, len(ContractRegistry))
+	for name := range ContractRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExecuteContract looks up a contract by name and executes it using the given
+// method and parameters, charging NativeCallGas to the gas payer.
+func ExecuteContract(name string, method string, params map[string]interface{}, gas Gas) (interface{}, error) {
+	if method == "" {
+		return nil, errors.New("missing contract method")
+	}
 	contract, exists := ContractRegistry[name]
 	if !exists {
 		return nil, errors.New("contract not found")
 	}
-	return contract.Execute(method, params)
+	return gas.run(func(limit uint64) (interface{}, uint64, error) {
+		if limit < NativeCallGas {
+			return nil, limit, fmt.Errorf("%w: call needs %d gas", ErrOutOfGas, NativeCallGas)
+		}
+		result, err := contract.Execute(method, params)
+		return result, NativeCallGas, err
+	})
 }
 
 // --- Example Contract Implementation ---
 
-// AdditionContract is a sample contract that adds two numbers.
+// ParamError reports a missing or unusable contract parameter.
+type ParamError struct {
+	Param  string
+	Reason string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("invalid or missing parameter %s: %s", e.Param, e.Reason)
+}
+
+// NumberParam returns params[name] as a float64. Besides float64, which is
+// what JSON numbers decode to, it accepts the other Go integer and float
+// types and numeric strings, so that programmatic callers need not convert.
+func NumberParam(params map[string]interface{}, name string) (float64, error) {
+	v, ok := params[name]
+	if !ok {
+		return 0, &ParamError{Param: name, Reason: "missing"}
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint:
+		return float64(n), nil
+	case uint32:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case json.Number:
+		f, err := n.Float64()
+		if err != nil {
+			return 0, &ParamError{Param: name, Reason: fmt.Sprintf("%q is not a number", n)}
+		}
+		return f, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, &ParamError{Param: name, Reason: fmt.Sprintf("%q is not a number", n)}
+		}
+		return f, nil
+	default:
+		return 0, &ParamError{Param: name, Reason: fmt.Sprintf("%T is not a number", v)}
+	}
+}
+
+// AdditionContract is a sample contract doing arithmetic on two numbers.
 type AdditionContract struct{}
 
-// Execute processes the "add" method by adding two parameters "a" and "b".
-// If a method other than "add" is passed, it returns an error.
+// Execute applies the method "add", "sub" or "mul" to the parameters "a"
+// and "b", which may be given as any type NumberParam accepts.
 func (ac AdditionContract) Execute(method string, params map[string]interface{}) (interface{}, error) {
-	if method != "add" {
+	var op func(a, b float64) float64
+	switch method {
+	case "add":
+		op = func(a, b float64) float64 { return a + b }
+	case "sub":
+		op = func(a, b float64) float64 { return a - b }
+	case "mul":
+		op = func(a, b float64) float64 { return a * b }
+	default:
 		return nil, errors.New("unsupported method")
 	}
-	aVal, ok := params["a"].(float64)
-	if !ok {
-		return nil, errors.New("invalid or missing parameter: a")
+	aVal, err := NumberParam(params, "a")
+	if err != nil {
+		return nil, err
 	}
-	bVal, ok := params["b"].(float64)
-	if !ok {
-		return nil, errors.New("invalid or missing parameter: b")
+	bVal, err := NumberParam(params, "b")
+	if err != nil {
+		return nil, err
 	}
-	result := aVal + bVal
-	return result, nil
+	return op(aVal, bVal), nil
 }
 
 // Name returns the unique name of the contract.
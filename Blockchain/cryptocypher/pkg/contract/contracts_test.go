@@ -0,0 +1,75 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// namedContract is a do-nothing contract registered under name.
+type namedContract string
+
+func (c namedContract) Execute(method string, params map[string]interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+func (c namedContract) Name() string {
+	return string(c)
+}
+
+func TestListContracts(t *testing.T) {
+	if err := RegisterContract(namedContract("ListedContract")); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(ContractRegistry, "ListedContract")
+	if names := ListContracts(); !slices.Contains(names, "ListedContract") || !slices.IsSorted(names) {
+		t.Errorf("expected a sorted listing with ListedContract, got %v", names)
+	}
+
+	dr := NewDynamicRegistry()
+	if names := dr.List(); len(names) != 0 {
+		t.Fatalf("expected an empty registry, got %v", names)
+	}
+	for _, name := range []string{"Zeta", "Alpha"} {
+		if err := dr.RegisterContract(ContractDefinition{Name: name, Code: emptyModule}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names := dr.List(); !slices.Equal(names, []string{"Alpha", "Zeta"}) {
+		t.Errorf("expected [Alpha Zeta], got %v", names)
+	}
+}
+
+func TestAdditionContractMethodsAndNumberTypes(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		want   float64
+	}{{"add", 8}, {"sub", 4}, {"mul", 12}} {
+		for _, a := range []interface{}{6.0, 6, int64(6), float32(6), "6", " 6 ", json.Number("6")} {
+			result, err := AdditionContract{}.Execute(tc.method, map[string]interface{}{"a": a, "b": 2})
+			if err != nil {
+				t.Errorf("%s with a=%#v: %v", tc.method, a, err)
+				continue
+			}
+			if result != tc.want {
+				t.Errorf("%s with a=%#v: expected %v, got %v", tc.method, a, tc.want, result)
+			}
+		}
+	}
+
+	if _, err := (AdditionContract{}).Execute("div", map[string]interface{}{"a": 1.0, "b": 2.0}); err == nil {
+		t.Error("expected an unsupported method to fail")
+	}
+	for _, params := range []map[string]interface{}{
+		{"a": 1.0},
+		{"a": 1.0, "b": "two"},
+		{"a": 1.0, "b": true},
+	} {
+		_, err := AdditionContract{}.Execute("add", params)
+		var paramErr *ParamError
+		if !errors.As(err, &paramErr) || paramErr.Param != "b" {
+			t.Errorf("params %v: expected a ParamError naming b, got %v", params, err)
+		}
+	}
+}
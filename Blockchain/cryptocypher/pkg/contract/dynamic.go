@@ -2,43 +2,154 @@
 package contract
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 )
 
 // ContractDefinition holds the code and metadata for a deployed contract.
+// A contract keeps its name, and with it its state, across versions.
 type ContractDefinition struct {
-	Name string
-	Code []byte // For example, WASM bytecode.
+	Name    string
+	Code    []byte // For example, WASM bytecode.
+	Version int    // Starts at 1 and grows with every upgrade.
 	// Additional metadata such as initial state can be added here.
 }
 
 // DynamicRegistry is a thread-safe registry for deployed contracts.
 type DynamicRegistry struct {
+	State StateStore // Where contract state is kept between executions.
+
 	contracts map[string]ContractDefinition
 	mu        sync.RWMutex
+	execMu    sync.Mutex // Serializes executions so state updates do not interleave.
 }
 
 // NewDynamicRegistry creates and returns a new dynamic contract registry.
+// Contracts are kept in memory until State is replaced; see Restore.
 func NewDynamicRegistry() *DynamicRegistry {
 	return &DynamicRegistry{
+		State:     NewMemoryStateStore(),
 		contracts: make(map[string]ContractDefinition),
 	}
 }
 
-// RegisterContract deploys a new contract by adding it to the registry.
+// Restore loads the contracts stored in State, so contracts deployed
+// before a restart can be executed again. Call it after replacing State.
+func (dr *DynamicRegistry) Restore() error {
+	names, err := dr.State.ContractNames()
+	if err != nil {
+		return err
+	}
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	for _, name := range names {
+		code, version, err := dr.State.ContractCode(name)
+		if err != nil {
+			return fmt.Errorf("loading contract %s: %w", name, err)
+		}
+		dr.contracts[name] = ContractDefinition{Name: name, Code: code, Version: version}
+	}
+	return nil
+}
+
+// RegisterContract deploys a new contract by adding it to the registry and
+// storing its code in State. The contract starts with empty state, even if
+// State holds state left behind under the same name.
+// It fails with ErrWASMUnsupported if the node cannot run WASM.
 func (dr *DynamicRegistry) RegisterContract(def ContractDefinition) error {
+	if err := wasmSupported(); err != nil {
+		return err
+	}
+	dr.execMu.Lock()
+	defer dr.execMu.Unlock()
 	dr.mu.Lock()
 	defer dr.mu.Unlock()
 	if _, exists := dr.contracts[def.Name]; exists {
 		return errors.New("contract already exists")
 	}
+	if def.Version == 0 {
+		def.Version = 1
+	}
+	if err := dr.State.SaveContract(def.Name, def.Code, def.Version, map[string][]byte{}); err != nil {
+		return fmt.Errorf("storing contract %s: %w", def.Name, err)
+	}
 	dr.contracts[def.Name] = def
 	fmt.Printf("Dynamic contract '%s' registered successfully.\n", def.Name)
 	return nil
 }
 
+// UpgradeContract replaces the code of the deployed contract def.Name with
+// def.Code. The new code's migrate export, if any, is run over the stored
+// state first with gasLimit gas (see GasLimit); the upgrade is applied
+// only if the migration succeeds. Executions of any contract wait for the
+// migration, so gasLimit also bounds how long they are held up. A zero
+// def.Version becomes the next version; otherwise it must be greater than
+// the deployed one. It returns the new version.
+func (dr *DynamicRegistry) UpgradeContract(ctx context.Context, def ContractDefinition, gasLimit uint64) (int, error) {
+	if err := wasmSupported(); err != nil {
+		return 0, err
+	}
+	dr.execMu.Lock()
+	defer dr.execMu.Unlock()
+	old, err := dr.GetContract(def.Name)
+	if err != nil {
+		return 0, err
+	}
+	if def.Version == 0 {
+		def.Version = old.Version + 1
+	} else if def.Version <= old.Version {
+		return 0, fmt.Errorf("version %d does not follow deployed version %d", def.Version, old.Version)
+	}
+
+	state, err := dr.State.ContractState(def.Name)
+	if err != nil {
+		return 0, fmt.Errorf("loading state of %s: %w", def.Name, err)
+	}
+	if _, err := MigrateContractCode(ctx, def.Code, old.Version, state, gasLimit); err != nil {
+		return 0, err
+	}
+	if err := dr.State.SaveContract(def.Name, def.Code, def.Version, state); err != nil {
+		return 0, err
+	}
+
+	dr.mu.Lock()
+	dr.contracts[def.Name] = def
+	dr.mu.Unlock()
+	fmt.Printf("Dynamic contract '%s' upgraded to version %d.\n", def.Name, def.Version)
+	return def.Version, nil
+}
+
+// Update replaces the code of the deployed contract def.Name, keeping its
+// state; it is UpgradeContract with DefaultGasLimit for callers that do
+// not need the version. Use Unregister and RegisterContract to start over
+// with empty state.
+func (dr *DynamicRegistry) Update(def ContractDefinition) error {
+	_, err := dr.UpgradeContract(context.Background(), def, DefaultGasLimit)
+	return err
+}
+
+// Unregister removes the deployed contract name and discards its code and
+// state, so the name can be registered again from scratch.
+func (dr *DynamicRegistry) Unregister(name string) error {
+	dr.execMu.Lock()
+	defer dr.execMu.Unlock()
+	dr.mu.Lock()
+	if _, exists := dr.contracts[name]; !exists {
+		dr.mu.Unlock()
+		return errors.New("contract not found")
+	}
+	delete(dr.contracts, name)
+	dr.mu.Unlock()
+	if err := dr.State.DeleteContract(name); err != nil {
+		return fmt.Errorf("discarding contract %s: %w", name, err)
+	}
+	fmt.Printf("Dynamic contract '%s' unregistered.\n", name)
+	return nil
+}
+
 // GetContract retrieves a contract definition by name.
 func (dr *DynamicRegistry) GetContract(name string) (ContractDefinition, error) {
 	dr.mu.RLock()
@@ -49,3 +160,50 @@ func (dr *DynamicRegistry) GetContract(name string) (ContractDefinition, error)
 	}
 	return def, nil
 }
+
+// List returns the names of the deployed contracts, sorted.
+func (dr *DynamicRegistry) List() []string {
+	dr.mu.RLock()
+	defer dr.mu.RUnlock()
+	names := make([]string, 0, len(dr.contracts))
+	for name := range dr.contracts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExecuteContract runs method on the deployed contract name, metering its
+// execution and charging the gas used to the gas payer. The contract's
+// state is saved only if the call succeeds.
+func (dr *DynamicRegistry) ExecuteContract(ctx context.Context, name, method string, params map[string]interface{}, gas Gas) (interface{}, error) {
+	// Look the code up only once running upgrades are done, so it matches
+	// the state the upgrade left.
+	dr.execMu.Lock()
+	defer dr.execMu.Unlock()
+	def, err := dr.GetContract(name)
+	if err != nil {
+		return nil, err
+	}
+	state, err := dr.State.ContractState(name)
+	if err != nil {
+		return nil, fmt.Errorf("loading state of %s: %w", name, err)
+	}
+	return gas.run(func(limit uint64) (interface{}, uint64, error) {
+		result, used, err := ExecuteContractCode(ctx, def.Code, method, params, state, limit)
+		if err == nil {
+			err = dr.State.SaveContractState(name, state)
+		}
+		return result, used, err
+	})
+}
+
+// ExecuteAnyContract runs method on the contract called name: a built-in
+// contract from ContractRegistry if there is one, otherwise a contract
+// deployed to dr. dr may be nil to consider built-in contracts only.
+func ExecuteAnyContract(ctx context.Context, dr *DynamicRegistry, name, method string, params map[string]interface{}, gas Gas) (interface{}, error) {
+	if _, native := ContractRegistry[name]; native || dr == nil {
+		return ExecuteContract(name, method, params, gas)
+	}
+	return dr.ExecuteContract(ctx, name, method, params, gas)
+}
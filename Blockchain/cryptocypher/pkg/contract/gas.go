@@ -0,0 +1,94 @@
+// File: pkg/contract/gas.go
+package contract
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrOutOfGas is returned when a contract call uses up its gas limit.
+var ErrOutOfGas = errors.New("contract ran out of gas")
+
+// Gas is counted in WASM instructions, plus HostCallGas and a unit per
+// byte for each state access, so a call costs the same on every node.
+// Growing memory costs MemoryPageGas per page and the bulk memory and table
+// instructions BulkGas per byte or element. Native contracts cost
+// NativeCallGas per call.
+const (
+	// DefaultGasLimit is used when a call does not set its own limit.
+	DefaultGasLimit uint64 = 5_000_000
+	// MaxGasLimit is the most gas any call may use.
+	MaxGasLimit uint64 = 100_000_000
+	// NativeCallGas is the flat cost of calling a contract compiled into the node.
+	NativeCallGas uint64 = 1_000
+	// HostCallGas is the cost of a get_state or set_state call, besides
+	// one unit per byte of key and value.
+	HostCallGas uint64 = 100
+	// MemoryPageGas is the cost of each 64 KiB page memory.grow adds, one
+	// unit per byte.
+	MemoryPageGas uint64 = 65_536
+	// BulkGas is the cost of each byte or element memory.copy, memory.fill,
+	// memory.init and the table bulk instructions touch.
+	BulkGas uint64 = 1
+)
+
+// GasPrice is the balance charged per unit of gas used.
+var GasPrice = 0.000001
+
+// Gas sets the budget of one contract call and who pays for it.
+type Gas struct {
+	Limit    uint64             // Most gas the call may use; see GasLimit.
+	Payer    string             // Address whose balance pays for the gas used.
+	Balances map[string]float64 // Ledger holding Payer's balance; nil to run without charging.
+}
+
+// CallHash identifies a contract call: the SHA-256, hex-encoded, of the
+// JSON object {"contract", "method", "params", "gas_limit"}, with the gas
+// limit as requested. A payment for the call carries it in its signed
+// CallHash, so it cannot be spent on any other call.
+func CallHash(name, method string, params map[string]interface{}, gasLimit uint64) (string, error) {
+	data, err := json.Marshal(struct {
+		Contract string                 `json:"contract"`
+		Method   string                 `json:"method"`
+		Params   map[string]interface{} `json:"params"`
+		GasLimit uint64                 `json:"gas_limit"`
+	}{name, method, params, gasLimit})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (g Gas) limit() uint64 {
+	return GasLimit(g.Limit)
+}
+
+// GasLimit returns the limit a call asking for requested gas gets:
+// DefaultGasLimit for 0, and at most MaxGasLimit.
+func GasLimit(requested uint64) uint64 {
+	if requested == 0 {
+		return DefaultGasLimit
+	}
+	return min(requested, MaxGasLimit)
+}
+
+// run executes call with the gas limit and charges the gas it reports as
+// used to the payer, whether or not the call succeeds. The payer must be
+// able to afford the whole limit up front.
+func (g Gas) run(call func(limit uint64) (interface{}, uint64, error)) (interface{}, error) {
+	limit := g.limit()
+	if g.Balances != nil {
+		if cost := float64(limit) * GasPrice; g.Balances[g.Payer] < cost {
+			return nil, fmt.Errorf("%s cannot pay for %d gas (%f needed)", g.Payer, limit, cost)
+		}
+	}
+	result, used, err := call(limit)
+	if g.Balances != nil {
+		g.Balances[g.Payer] -= float64(used) * GasPrice
+	}
+	return result, err
+}
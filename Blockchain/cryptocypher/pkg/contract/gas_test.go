@@ -0,0 +1,138 @@
+package contract
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// loopModule is echoModule with an execute that never returns:
+//
+//	(func (export "execute") (param i32 i32) (result i64)
+//	  (loop $spin (br $spin))
+//	  unreachable)
+var loopModule = append(abiPreamble,
+	0x0a, 0x16, 0x02,
+	0x0b, 0x00, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b, // malloc
+	0x08, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x00, 0x0b, // execute
+)
+
+func TestBusyLoopRunsOutOfGas(t *testing.T) {
+	done := make(chan error, 1)
+	go func() {
+		_, used, err := ExecuteContractCode(context.Background(), loopModule, "spin", nil, nil, 50_000)
+		if used != 50_000 {
+			t.Errorf("expected the whole limit to be used, got %d", used)
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrOutOfGas) {
+			t.Errorf("expected ErrOutOfGas, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("busy loop was not aborted")
+	}
+}
+
+func TestGasChargedToPayer(t *testing.T) {
+	dr := NewDynamicRegistry()
+	if err := dr.RegisterContract(ContractDefinition{Name: "spin", Code: loopModule}); err != nil {
+		t.Fatal(err)
+	}
+	balances := map[string]float64{"Alice": 1}
+	gas := Gas{Limit: 20_000, Payer: "Alice", Balances: balances}
+	if _, err := dr.ExecuteContract(context.Background(), "spin", "spin", nil, gas); !errors.Is(err, ErrOutOfGas) {
+		t.Fatalf("expected ErrOutOfGas, got %v", err)
+	}
+	if want := 1 - 20_000*GasPrice; balances["Alice"] != want {
+		t.Errorf("expected Alice to pay for the gas used, balance %f, want %f", balances["Alice"], want)
+	}
+
+	balances["Bob"] = 0
+	gas.Payer = "Bob"
+	if _, err := dr.ExecuteContract(context.Background(), "spin", "spin", nil, gas); err == nil || errors.Is(err, ErrOutOfGas) {
+		t.Errorf("expected a payer without funds to be refused before running, got %v", err)
+	}
+}
+
+func TestGasIsCountedInInstructions(t *testing.T) {
+	// malloc runs 6 instructions and execute 8, counting their ends.
+	for i := 0; i < 2; i++ {
+		_, used, err := ExecuteContractCode(context.Background(), echoModule, "ping", nil, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != 14 {
+			t.Errorf("run %d used %d gas, want 14", i, used)
+		}
+	}
+	if _, _, err := ExecuteContractCode(context.Background(), echoModule, "ping", nil, nil, 10); !errors.Is(err, ErrOutOfGas) {
+		t.Errorf("expected ErrOutOfGas below the call's cost, got %v", err)
+	}
+}
+
+func TestGasLimitIsCapped(t *testing.T) {
+	for requested, want := range map[uint64]uint64{
+		0:              DefaultGasLimit,
+		20_000:         20_000,
+		math.MaxUint64: MaxGasLimit,
+	} {
+		if got := GasLimit(requested); got != want {
+			t.Errorf("GasLimit(%d) = %d, want %d", requested, got, want)
+		}
+	}
+}
+
+// sizedModule is echoModule with execute replaced by body, a function
+// with no locals returning i64.
+func sizedModule(body ...byte) []byte {
+	code := []byte{0x0a, byte(15 + len(body)), 0x02,
+		0x0b, 0x00, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b, // malloc
+		byte(1 + len(body)), 0x00}
+	return append(append(append([]byte(nil), abiPreamble...), code...), body...)
+}
+
+func TestMemoryGrowthIsChargedPerPage(t *testing.T) {
+	// (drop (memory.grow (i32.const 10))) (i64.const 0)
+	grow := sizedModule(0x41, 0x0a, 0x40, 0x00, 0x1a, 0x42, 0x00, 0x0b)
+	_, used, err := ExecuteContractCode(context.Background(), grow, "grow", nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used < 10*MemoryPageGas {
+		t.Errorf("growing 10 pages used %d gas, want at least %d", used, 10*MemoryPageGas)
+	}
+	if _, _, err := ExecuteContractCode(context.Background(), grow, "grow", nil, nil, 9*MemoryPageGas); !errors.Is(err, ErrOutOfGas) {
+		t.Errorf("expected ErrOutOfGas growing past the limit, got %v", err)
+	}
+}
+
+func TestBulkMemoryIsChargedPerByte(t *testing.T) {
+	// (memory.fill (i32.const 0) (i32.const 0) (i32.const 65536)) (i64.const 0)
+	fill := sizedModule(0x41, 0x00, 0x41, 0x00, 0x41, 0x80, 0x80, 0x04, 0xfc, 0x0b, 0x00, 0x42, 0x00, 0x0b)
+	_, used, err := ExecuteContractCode(context.Background(), fill, "fill", nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used < 65_536*BulkGas {
+		t.Errorf("filling 64 KiB used %d gas, want at least %d", used, 65_536*BulkGas)
+	}
+	if _, _, err := ExecuteContractCode(context.Background(), fill, "fill", nil, nil, 50_000); !errors.Is(err, ErrOutOfGas) {
+		t.Errorf("expected ErrOutOfGas filling past the limit, got %v", err)
+	}
+}
+
+func TestContractMemoryIsCapped(t *testing.T) {
+	// echoModule declaring MaxMemoryPages+1 pages of memory instead of one.
+	pages := appendULEB(nil, MaxMemoryPages+1)
+	memory := append([]byte{0x05, byte(2 + len(pages)), 0x01, 0x00}, pages...)
+	big := bytes.Replace(echoModule, []byte{0x05, 0x03, 0x01, 0x00, 0x01}, memory, 1)
+	if _, _, err := ExecuteContractCode(context.Background(), big, "ping", nil, nil, 0); err == nil {
+		t.Error("expected a module declaring more than MaxMemoryPages to be refused")
+	}
+}
@@ -0,0 +1,523 @@
+// File: pkg/contract/meter.go
+package contract
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// gasExport is the name under which a metered module exports its gas
+// counter: a mutable i64 global holding the gas left, which the injected
+// charges decrement and which goes negative when the call runs out.
+const gasExport = "__gas"
+
+// WASM section IDs used by the instrumentation.
+const (
+	sectionImport = 2
+	sectionGlobal = 6
+	sectionExport = 7
+	sectionCode   = 10
+)
+
+// sectionRank orders the known sections as the binary format requires
+// them; the tag section (13) sits between memory and global.
+var sectionRank = map[byte]int{1: 1, 2: 2, 3: 3, 4: 4, 5: 5, 13: 6, 6: 7, 7: 8, 8: 9, 9: 10, 12: 11, 10: 12, 11: 13}
+
+// errUnmeterable is returned for code the instrumentation cannot meter.
+var errUnmeterable = errors.New("contract code cannot be metered")
+
+// wasmSection is one section of a module; custom sections keep their place.
+type wasmSection struct {
+	id   byte
+	body []byte
+}
+
+// instrumentGas returns code with gas metering injected, starting from
+// limit gas. Every function charges, on entry and at the head of every
+// loop, one gas per instruction up to the next such point, so a call uses
+// the same gas on every node. Instructions whose work grows with an
+// operand, memory.grow and the bulk memory and table instructions, also
+// charge for that operand before they run. A module already exporting
+// gasExport, or using instructions outside WebAssembly 2.0 without SIMD,
+// is refused.
+func instrumentGas(code []byte, limit uint64) ([]byte, error) {
+	sections, err := splitSections(code)
+	if err != nil {
+		return nil, err
+	}
+	global := uint32(0) // Index of the injected counter; a scratch i32 follows it.
+	for _, s := range sections {
+		switch s.id {
+		case sectionImport:
+			n, err := countImportedGlobals(s.body)
+			if err != nil {
+				return nil, err
+			}
+			global += n
+		case sectionGlobal:
+			n, _, err := readU32(s.body, 0)
+			if err != nil {
+				return nil, err
+			}
+			global += n
+		}
+	}
+
+	entry := []byte{0x7e, 0x01, 0x42} // Mutable i64, initialised by i64.const.
+	entry = appendSLEB(entry, int64(limit))
+	entry = append(entry, 0x0b)
+	export := appendName(nil, gasExport)
+	export = append(export, 0x03)
+	export = appendULEB(export, uint64(global))
+
+	sections, err = appendToVector(sections, sectionGlobal, entry)
+	if err != nil {
+		return nil, err
+	}
+	// Mutable i32, initialised by i32.const 0.
+	sections, err = appendToVector(sections, sectionGlobal, []byte{0x7f, 0x01, 0x41, 0x00, 0x0b})
+	if err != nil {
+		return nil, err
+	}
+	if exp := findSection(sections, sectionExport); exp != nil {
+		if names, err := exportNames(exp.body); err != nil {
+			return nil, err
+		} else if names[gasExport] {
+			return nil, fmt.Errorf("%w: it exports %q itself", errUnmeterable, gasExport)
+		}
+	}
+	sections, err = appendToVector(sections, sectionExport, export)
+	if err != nil {
+		return nil, err
+	}
+	if s := findSection(sections, sectionCode); s != nil {
+		if s.body, err = meterCode(s.body, global); err != nil {
+			return nil, err
+		}
+	}
+
+	out := append([]byte(nil), code[:8]...)
+	for _, s := range sections {
+		out = append(out, s.id)
+		out = appendULEB(out, uint64(len(s.body)))
+		out = append(out, s.body...)
+	}
+	return out, nil
+}
+
+// splitSections parses the sections following the module header.
+func splitSections(code []byte) ([]*wasmSection, error) {
+	if len(code) < 8 || !bytes.Equal(code[:8], emptyModule) {
+		return nil, fmt.Errorf("%w: not a WebAssembly 1.0 binary", errUnmeterable)
+	}
+	var sections []*wasmSection
+	for pos := 8; pos < len(code); {
+		id := code[pos]
+		size, next, err := readU32(code, pos+1)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(next)+uint64(size) > uint64(len(code)) {
+			return nil, fmt.Errorf("%w: section %d overruns the module", errUnmeterable, id)
+		}
+		sections = append(sections, &wasmSection{id: id, body: code[next : next+int(size)]})
+		pos = next + int(size)
+	}
+	return sections, nil
+}
+
+func findSection(sections []*wasmSection, id byte) *wasmSection {
+	for _, s := range sections {
+		if s.id == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// appendToVector adds entry to the vector making up section id, creating
+// the section in its required place if the module has none.
+func appendToVector(sections []*wasmSection, id byte, entry []byte) ([]*wasmSection, error) {
+	if s := findSection(sections, id); s != nil {
+		n, pos, err := readU32(s.body, 0)
+		if err != nil {
+			return nil, err
+		}
+		body := appendULEB(nil, uint64(n)+1)
+		body = append(body, s.body[pos:]...)
+		s.body = append(body, entry...)
+		return sections, nil
+	}
+	at := len(sections)
+	for i, s := range sections {
+		if rank, ok := sectionRank[s.id]; ok && rank > sectionRank[id] {
+			at = i
+			break
+		}
+	}
+	body := append(appendULEB(nil, 1), entry...)
+	sections = append(sections, nil)
+	copy(sections[at+1:], sections[at:])
+	sections[at] = &wasmSection{id: id, body: body}
+	return sections, nil
+}
+
+// countImportedGlobals returns how many of the imports in body are globals,
+// which come before the module's own globals in the index space.
+func countImportedGlobals(body []byte) (uint32, error) {
+	n, pos, err := readU32(body, 0)
+	if err != nil {
+		return 0, err
+	}
+	globals := uint32(0)
+	for i := uint32(0); i < n; i++ {
+		for j := 0; j < 2; j++ { // Module and field names.
+			if pos, err = skipName(body, pos); err != nil {
+				return 0, err
+			}
+		}
+		if pos >= len(body) {
+			return 0, errTruncated
+		}
+		kind := body[pos]
+		pos++
+		switch kind {
+		case 0x00: // Function: type index.
+			_, pos, err = readU32(body, pos)
+		case 0x01: // Table: reference type and limits.
+			pos, err = skipLimits(body, pos+1)
+		case 0x02: // Memory: limits.
+			pos, err = skipLimits(body, pos)
+		case 0x03: // Global: value type and mutability.
+			globals++
+			pos += 2
+		case 0x04: // Tag: attribute and type index.
+			_, pos, err = readU32(body, pos+1)
+		default:
+			return 0, fmt.Errorf("%w: unknown import kind %d", errUnmeterable, kind)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return globals, nil
+}
+
+// exportNames returns the names exported by an export section.
+func exportNames(body []byte) (map[string]bool, error) {
+	n, pos, err := readU32(body, 0)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, n)
+	for i := uint32(0); i < n; i++ {
+		size, next, err := readU32(body, pos)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(next)+uint64(size) > uint64(len(body)) {
+			return nil, errTruncated
+		}
+		names[string(body[next:next+int(size)])] = true
+		if _, pos, err = readU32(body, next+int(size)+1); err != nil { // Kind and index.
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// meterCode injects the gas charges into every function body of a code
+// section, with global the index of the gas counter and global+1 that of
+// the scratch global.
+func meterCode(body []byte, global uint32) ([]byte, error) {
+	n, pos, err := readU32(body, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := appendULEB(nil, uint64(n))
+	for i := uint32(0); i < n; i++ {
+		size, next, err := readU32(body, pos)
+		if err != nil {
+			return nil, err
+		}
+		end := uint64(next) + uint64(size)
+		if end > uint64(len(body)) {
+			return nil, errTruncated
+		}
+		fn, err := meterFunction(body[next:end], global)
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %w", i, err)
+		}
+		out = appendULEB(out, uint64(len(fn)))
+		out = append(out, fn...)
+		pos = int(end)
+	}
+	return out, nil
+}
+
+// meterFunction meters one function body: its local declarations followed
+// by its instructions.
+func meterFunction(fn []byte, global uint32) ([]byte, error) {
+	groups, pos, err := readU32(fn, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < groups; i++ {
+		if _, pos, err = readU32(fn, pos); err != nil {
+			return nil, err
+		}
+		pos++ // Value type.
+	}
+	if pos > len(fn) {
+		return nil, errTruncated
+	}
+	locals := pos
+
+	// Find where each instruction starts and which ones are loops. A
+	// metering point follows the function start and each loop's block type,
+	// and a sized point precedes each instruction charging for its operand.
+	type point struct {
+		at, cost int
+		unit     uint64 // Gas per unit of the operand at a sized point.
+	}
+	points := []point{{at: locals}}
+	last := 0 // The metering point counting the current instructions.
+	for pos < len(fn) {
+		op := fn[pos]
+		next, err := skipInstruction(fn, pos)
+		if err != nil {
+			return nil, err
+		}
+		if unit := sizedGas(fn, pos); unit > 0 {
+			points = append(points, point{at: pos, unit: unit})
+		}
+		points[last].cost++
+		if op == 0x03 {
+			points = append(points, point{at: next})
+			last = len(points) - 1
+		}
+		pos = next
+	}
+
+	out := append([]byte(nil), fn[:locals]...)
+	from := locals
+	for _, p := range points {
+		out = append(out, fn[from:p.at]...)
+		if p.unit > 0 {
+			out = appendSizedCharge(out, global, p.unit)
+		} else {
+			out = appendCharge(out, global, p.cost)
+		}
+		from = p.at
+	}
+	return append(out, fn[from:]...), nil
+}
+
+// appendCharge appends code that takes cost gas from the counter and traps
+// once it is negative.
+func appendCharge(out []byte, global uint32, cost int) []byte {
+	if cost == 0 {
+		return out
+	}
+	out = append(out, 0x23) // global.get
+	out = appendULEB(out, uint64(global))
+	out = append(out, 0x42) // i64.const
+	out = appendSLEB(out, int64(cost))
+	out = append(out, 0x7d, 0x24) // i64.sub, global.set
+	out = appendULEB(out, uint64(global))
+	out = append(out, 0x23) // global.get
+	out = appendULEB(out, uint64(global))
+	// i64.const 0, i64.lt_s, if, unreachable, end.
+	return append(out, 0x42, 0x00, 0x53, 0x04, 0x40, 0x00, 0x0b)
+}
+
+// sizedGas returns the gas per unit of operand of the instruction at pos,
+// or 0 if its cost does not depend on its operands. The operand, a page or
+// byte or element count, is the i32 on top of the stack.
+func sizedGas(code []byte, pos int) uint64 {
+	switch code[pos] {
+	case 0x40: // memory.grow
+		return MemoryPageGas
+	case 0xfc:
+		sub, _, err := readU32(code, pos+1)
+		if err != nil {
+			return 0
+		}
+		switch sub {
+		case 8, 10, 11, 12, 14, 15, 17: // memory.init, copy, fill; table.init, copy, grow, fill.
+			return BulkGas
+		}
+	}
+	return 0
+}
+
+// appendSizedCharge appends code that takes unit gas per unit of the i32 on
+// top of the stack from the counter, trapping once it is negative, and
+// leaves the stack as it was. The operand is kept in the scratch global,
+// global+1, meanwhile.
+func appendSizedCharge(out []byte, global uint32, unit uint64) []byte {
+	scratch := uint64(global) + 1
+	out = append(out, 0x24) // global.set
+	out = appendULEB(out, scratch)
+	out = append(out, 0x23) // global.get
+	out = appendULEB(out, uint64(global))
+	out = append(out, 0x23) // global.get
+	out = appendULEB(out, scratch)
+	out = append(out, 0xad, 0x42) // i64.extend_i32_u, i64.const
+	out = appendSLEB(out, int64(unit))
+	out = append(out, 0x7e, 0x7d, 0x24) // i64.mul, i64.sub, global.set
+	out = appendULEB(out, uint64(global))
+	out = append(out, 0x23) // global.get
+	out = appendULEB(out, uint64(global))
+	// i64.const 0, i64.lt_s, if, unreachable, end.
+	out = append(out, 0x42, 0x00, 0x53, 0x04, 0x40, 0x00, 0x0b)
+	out = append(out, 0x23) // global.get
+	return appendULEB(out, scratch)
+}
+
+// skipInstruction returns the offset of the instruction after the one at pos.
+func skipInstruction(code []byte, pos int) (int, error) {
+	op := code[pos]
+	pos++
+	var err error
+	u32 := func(n int) {
+		for ; n > 0 && err == nil; n-- {
+			_, pos, err = readU32(code, pos)
+		}
+	}
+	switch {
+	case op == 0x00, op == 0x01, op == 0x05, op == 0x0b, op == 0x0f, op == 0x1a, op == 0x1b,
+		op >= 0x45 && op <= 0xc4, op == 0xd1:
+		// No immediates.
+	case op >= 0x02 && op <= 0x04: // block, loop, if: block type.
+		_, pos, err = readSLEB(code, pos)
+	case op == 0x0c, op == 0x0d, op == 0x10, op == 0x12, op == 0xd2,
+		op >= 0x20 && op <= 0x26, op == 0x3f, op == 0x40:
+		u32(1)
+	case op == 0x11, op == 0x13: // call_indirect, return_call_indirect.
+		u32(2)
+	case op == 0x0e: // br_table: targets and default.
+		var n uint32
+		n, pos, err = readU32(code, pos)
+		u32(int(n) + 1)
+	case op == 0x1c: // select with value types.
+		var n uint32
+		n, pos, err = readU32(code, pos)
+		pos += int(n)
+	case op >= 0x28 && op <= 0x3e: // Loads and stores: alignment and offset.
+		var align uint32
+		align, pos, err = readU32(code, pos)
+		if align&0x40 != 0 { // Multi-memory index.
+			u32(1)
+		}
+		u32(1)
+	case op == 0x41, op == 0x42: // i32.const, i64.const.
+		_, pos, err = readSLEB(code, pos)
+	case op == 0x43:
+		pos += 4
+	case op == 0x44:
+		pos += 8
+	case op == 0xd0: // ref.null: heap type.
+		pos++
+	case op == 0xfc:
+		var sub uint32
+		sub, pos, err = readU32(code, pos)
+		switch {
+		case err != nil:
+		case sub <= 7: // Saturating truncations.
+		case sub == 8, sub == 10, sub == 12, sub == 14:
+			u32(2)
+		case sub == 9, sub == 11, sub == 13, sub >= 15 && sub <= 17:
+			u32(1)
+		default:
+			return 0, fmt.Errorf("%w: unsupported instruction 0xfc %d", errUnmeterable, sub)
+		}
+	default:
+		return 0, fmt.Errorf("%w: unsupported instruction 0x%02x", errUnmeterable, op)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if pos > len(code) {
+		return 0, errTruncated
+	}
+	return pos, nil
+}
+
+var errTruncated = fmt.Errorf("%w: truncated module", errUnmeterable)
+
+// readU32 decodes an unsigned LEB128 value of at most 32 bits at pos and
+// returns it with the offset after it.
+func readU32(b []byte, pos int) (uint32, int, error) {
+	v, n := binary.Uvarint(b[min(pos, len(b)):])
+	if n <= 0 || v > math.MaxUint32 {
+		return 0, 0, errTruncated
+	}
+	return uint32(v), pos + n, nil
+}
+
+// readSLEB skips a signed LEB128 value at pos.
+func readSLEB(b []byte, pos int) (int64, int, error) {
+	var v int64
+	var shift uint
+	for pos < len(b) && shift < 70 {
+		c := b[pos]
+		pos++
+		v |= int64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 64 && c&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v, pos, nil
+		}
+	}
+	return 0, 0, errTruncated
+}
+
+func skipName(b []byte, pos int) (int, error) {
+	n, pos, err := readU32(b, pos)
+	if err != nil {
+		return 0, err
+	}
+	if uint64(pos)+uint64(n) > uint64(len(b)) {
+		return 0, errTruncated
+	}
+	return pos + int(n), nil
+}
+
+// skipLimits skips a limits flag byte and its minimum and optional maximum.
+func skipLimits(b []byte, pos int) (int, error) {
+	if pos >= len(b) {
+		return 0, errTruncated
+	}
+	flags := b[pos]
+	_, pos, err := readU32(b, pos+1)
+	if err == nil && flags&0x01 != 0 {
+		_, pos, err = readU32(b, pos)
+	}
+	return pos, err
+}
+
+func appendULEB(b []byte, v uint64) []byte {
+	return binary.AppendUvarint(b, v)
+}
+
+func appendSLEB(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendName(b []byte, name string) []byte {
+	b = appendULEB(b, uint64(len(name)))
+	return append(b, name...)
+}
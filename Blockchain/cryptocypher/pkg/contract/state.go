@@ -0,0 +1,96 @@
+// File: pkg/contract/state.go
+package contract
+
+import (
+	"sort"
+	"sync"
+)
+
+// StateStore persists deployed contracts: their code and their key-value
+// state.
+type StateStore interface {
+	// ContractState returns the stored state of contract, empty if it has none.
+	ContractState(contract string) (map[string][]byte, error)
+	// SaveContractState replaces the stored state of contract with state.
+	SaveContractState(contract string, state map[string][]byte) error
+	// SaveContract stores the code and version of contract and replaces
+	// its state with state, in one step.
+	SaveContract(contract string, code []byte, version int, state map[string][]byte) error
+	// ContractCode returns the stored code and version of contract; the
+	// code is nil if none is stored.
+	ContractCode(contract string) ([]byte, int, error)
+	// ContractNames returns the names of the contracts with stored code.
+	ContractNames() ([]string, error)
+	// DeleteContract removes the stored code and state of contract.
+	DeleteContract(contract string) error
+}
+
+// memoryState is a StateStore that keeps contracts in memory only.
+type memoryState struct {
+	mu     sync.Mutex
+	states map[string]map[string][]byte
+	code   map[string]ContractDefinition
+}
+
+// NewMemoryStateStore returns a StateStore that is lost when the node exits.
+func NewMemoryStateStore() StateStore {
+	return &memoryState{
+		states: make(map[string]map[string][]byte),
+		code:   make(map[string]ContractDefinition),
+	}
+}
+
+func (ms *memoryState) ContractState(contract string) (map[string][]byte, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return copyState(ms.states[contract]), nil
+}
+
+func (ms *memoryState) SaveContractState(contract string, state map[string][]byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.states[contract] = copyState(state)
+	return nil
+}
+
+func (ms *memoryState) SaveContract(contract string, code []byte, version int, state map[string][]byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.code[contract] = ContractDefinition{Name: contract, Code: append([]byte(nil), code...), Version: version}
+	ms.states[contract] = copyState(state)
+	return nil
+}
+
+func (ms *memoryState) ContractCode(contract string) ([]byte, int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	def := ms.code[contract]
+	return def.Code, def.Version, nil
+}
+
+func (ms *memoryState) ContractNames() ([]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	names := make([]string, 0, len(ms.code))
+	for name := range ms.code {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (ms *memoryState) DeleteContract(contract string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.code, contract)
+	delete(ms.states, contract)
+	return nil
+}
+
+func copyState(state map[string][]byte) map[string][]byte {
+	out := make(map[string][]byte, len(state))
+	for k, v := range state {
+		out[k] = append([]byte(nil), v...)
+	}
+	return out
+}
@@ -0,0 +1,244 @@
+package contract
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// counterModule increments a little-endian i32 stored under "count". In WAT:
+//
+//	(import "env" "get_state" (func $get (param i32 i32 i32 i32) (result i32)))
+//	(import "env" "set_state" (func $set (param i32 i32 i32 i32)))
+//	(memory (export "memory") 1)
+//	(data (i32.const 0) "count")
+//	(func (export "execute") (param i32 i32) (result i64)
+//	  (drop (call $get (i32.const 0) (i32.const 5) (i32.const 16) (i32.const 4)))
+//	  (i32.store (i32.const 16) (i32.add (i32.load (i32.const 16)) (i32.const 1)))
+//	  (call $set (i32.const 0) (i32.const 5) (i32.const 16) (i32.const 4))
+//	  (i64.const 0))
+//
+// plus the malloc of echoModule.
+var counterModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// Types: malloc, execute, get_state, set_state.
+	0x01, 0x1b, 0x04,
+	0x60, 0x01, 0x7f, 0x01, 0x7f,
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e,
+	0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f,
+	0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x00,
+	// Imports: env.get_state, env.set_state.
+	0x02, 0x21, 0x02,
+	0x03, 'e', 'n', 'v', 0x09, 'g', 'e', 't', '_', 's', 't', 'a', 't', 'e', 0x00, 0x02,
+	0x03, 'e', 'n', 'v', 0x09, 's', 'e', 't', '_', 's', 't', 'a', 't', 'e', 0x00, 0x03,
+	// Functions.
+	0x03, 0x03, 0x02, 0x00, 0x01,
+	// Memory of one page.
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	// Heap pointer global.
+	0x06, 0x07, 0x01, 0x7f, 0x01, 0x41, 0x80, 0x08, 0x0b,
+	// Exports: memory, malloc, execute.
+	0x07, 0x1d, 0x03,
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	0x06, 'm', 'a', 'l', 'l', 'o', 'c', 0x00, 0x02,
+	0x07, 'e', 'x', 'e', 'c', 'u', 't', 'e', 0x00, 0x03,
+	// Code.
+	0x0a, 0x34, 0x02,
+	0x0b, 0x00, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b, // malloc
+	0x26, 0x00, // execute
+	0x41, 0x00, 0x41, 0x05, 0x41, 0x10, 0x41, 0x04, 0x10, 0x00, 0x1a,
+	0x41, 0x10, 0x41, 0x10, 0x28, 0x02, 0x00, 0x41, 0x01, 0x6a, 0x36, 0x02, 0x00,
+	0x41, 0x00, 0x41, 0x05, 0x41, 0x10, 0x41, 0x04, 0x10, 0x01,
+	0x42, 0x00, 0x0b,
+	// Data: "count" at offset 0.
+	0x0b, 0x0b, 0x01, 0x00, 0x41, 0x00, 0x0b, 0x05, 'c', 'o', 'u', 'n', 't',
+}
+
+func TestContractStatePersistsBetweenExecutions(t *testing.T) {
+	dr := NewDynamicRegistry()
+	if err := dr.RegisterContract(ContractDefinition{Name: "counter", Code: counterModule}); err != nil {
+		t.Fatal(err)
+	}
+	for want := uint32(1); want <= 2; want++ {
+		if _, err := dr.ExecuteContract(context.Background(), "counter", "increment", nil, Gas{}); err != nil {
+			t.Fatal(err)
+		}
+		state, err := dr.State.ContractState("counter")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := state["count"]; len(got) != 4 || binary.LittleEndian.Uint32(got) != want {
+			t.Errorf("after call %d: expected count %d, got %v", want, want, got)
+		}
+	}
+}
+
+// counterV2Module renames the counter's key from "count" to "total" when
+// it replaces counterModule:
+//
+//	(func (export "migrate") (param $from i32)
+//	  (drop (call $get (i32.const 0) (i32.const 5) (i32.const 16) (i32.const 4)))
+//	  (call $set (i32.const 8) (i32.const 5) (i32.const 16) (i32.const 4)))
+//	(data (i32.const 0) "count")
+//	(data (i32.const 8) "total")
+//
+// Its execute does nothing.
+var counterV2Module = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// Types: malloc, execute, get_state, set_state, migrate.
+	0x01, 0x1f, 0x05,
+	0x60, 0x01, 0x7f, 0x01, 0x7f,
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e,
+	0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f,
+	0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x00,
+	0x60, 0x01, 0x7f, 0x00,
+	// Imports: env.get_state, env.set_state.
+	0x02, 0x21, 0x02,
+	0x03, 'e', 'n', 'v', 0x09, 'g', 'e', 't', '_', 's', 't', 'a', 't', 'e', 0x00, 0x02,
+	0x03, 'e', 'n', 'v', 0x09, 's', 'e', 't', '_', 's', 't', 'a', 't', 'e', 0x00, 0x03,
+	// Functions.
+	0x03, 0x04, 0x03, 0x00, 0x01, 0x04,
+	// Memory of one page.
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	// Heap pointer global.
+	0x06, 0x07, 0x01, 0x7f, 0x01, 0x41, 0x80, 0x08, 0x0b,
+	// Exports: memory, malloc, execute, migrate.
+	0x07, 0x27, 0x04,
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	0x06, 'm', 'a', 'l', 'l', 'o', 'c', 0x00, 0x02,
+	0x07, 'e', 'x', 'e', 'c', 'u', 't', 'e', 0x00, 0x03,
+	0x07, 'm', 'i', 'g', 'r', 'a', 't', 'e', 0x00, 0x04,
+	// Code.
+	0x0a, 0x2a, 0x03,
+	0x0b, 0x00, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b, // malloc
+	0x04, 0x00, 0x42, 0x00, 0x0b, // execute
+	0x17, 0x00, // migrate
+	0x41, 0x00, 0x41, 0x05, 0x41, 0x10, 0x41, 0x04, 0x10, 0x00, 0x1a,
+	0x41, 0x08, 0x41, 0x05, 0x41, 0x10, 0x41, 0x04, 0x10, 0x01,
+	0x0b,
+	// Data: "count" at offset 0, "total" at offset 8.
+	0x0b, 0x15, 0x02,
+	0x00, 0x41, 0x00, 0x0b, 0x05, 'c', 'o', 'u', 'n', 't',
+	0x00, 0x41, 0x08, 0x0b, 0x05, 't', 'o', 't', 'a', 'l',
+}
+
+func TestUpgradeMigratesState(t *testing.T) {
+	dr := NewDynamicRegistry()
+	if err := dr.RegisterContract(ContractDefinition{Name: "counter", Code: counterModule}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := dr.ExecuteContract(context.Background(), "counter", "increment", nil, Gas{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := dr.UpgradeContract(context.Background(), ContractDefinition{Name: "counter", Code: counterV2Module, Version: 1}, 0); err == nil {
+		t.Error("expected an upgrade to the deployed version to be refused")
+	}
+	version, err := dr.UpgradeContract(context.Background(), ContractDefinition{Name: "counter", Code: counterV2Module}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("expected version 2, got %d", version)
+	}
+	def, err := dr.GetContract("counter")
+	if err != nil || def.Version != 2 {
+		t.Fatalf("expected the contract to keep its name at version 2, got %+v (err %v)", def, err)
+	}
+	state, err := dr.State.ContractState("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state["total"]; len(got) != 4 || binary.LittleEndian.Uint32(got) != 2 {
+		t.Errorf("expected the count to be migrated to total, got state %v", state)
+	}
+
+	// Code without a migrate export keeps the state as it is.
+	if _, err := dr.UpgradeContract(context.Background(), ContractDefinition{Name: "counter", Code: echoModule}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := dr.State.ContractState("counter"); len(after) != len(state) {
+		t.Errorf("expected state to carry over unchanged, got %v", after)
+	}
+}
+
+func TestUpgradeMigrationIsMetered(t *testing.T) {
+	dr := NewDynamicRegistry()
+	if err := dr.RegisterContract(ContractDefinition{Name: "counter", Code: counterModule}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := dr.UpgradeContract(context.Background(), ContractDefinition{Name: "counter", Code: counterV2Module}, 10)
+	if !errors.Is(err, ErrOutOfGas) {
+		t.Fatalf("expected the migration to run out of gas, got %v", err)
+	}
+	if def, _ := dr.GetContract("counter"); def.Version != 1 {
+		t.Errorf("expected a failed migration to keep version 1, got %d", def.Version)
+	}
+}
+
+func TestExecutionRunsCodeOfFinishedUpgrade(t *testing.T) {
+	dr := NewDynamicRegistry()
+	if err := dr.RegisterContract(ContractDefinition{Name: "spin", Code: echoModule}); err != nil {
+		t.Fatal(err)
+	}
+	// Hold executions as an upgrade does, and swap the code meanwhile.
+	dr.execMu.Lock()
+	done := make(chan error, 1)
+	go func() {
+		_, err := dr.ExecuteContract(context.Background(), "spin", "spin", nil, Gas{Limit: 10_000})
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	dr.mu.Lock()
+	dr.contracts["spin"] = ContractDefinition{Name: "spin", Code: loopModule, Version: 2}
+	dr.mu.Unlock()
+	dr.execMu.Unlock()
+	if err := <-done; !errors.Is(err, ErrOutOfGas) {
+		t.Errorf("expected the waiting call to run the upgraded code, got %v", err)
+	}
+}
+
+func TestContractsSurviveRestart(t *testing.T) {
+	dr := NewDynamicRegistry()
+	if err := dr.RegisterContract(ContractDefinition{Name: "counter", Code: counterModule}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dr.ExecuteContract(context.Background(), "counter", "increment", nil, Gas{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted node shares only the store.
+	restarted := NewDynamicRegistry()
+	restarted.State = dr.State
+	if err := restarted.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.RegisterContract(ContractDefinition{Name: "counter", Code: echoModule}); err == nil {
+		t.Error("expected the restored name to stay taken")
+	}
+	if _, err := restarted.ExecuteContract(context.Background(), "counter", "increment", nil, Gas{}); err != nil {
+		t.Fatal(err)
+	}
+	state, _ := restarted.State.ContractState("counter")
+	if got := state["count"]; len(got) != 4 || binary.LittleEndian.Uint32(got) != 2 {
+		t.Errorf("expected the restored counter to reach 2, got %v", got)
+	}
+}
+
+func TestRegisterStartsWithEmptyState(t *testing.T) {
+	dr := NewDynamicRegistry()
+	// State left behind without code, as by a node that kept only state.
+	if err := dr.State.SaveContractState("counter", map[string][]byte{"count": {7, 0, 0, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dr.RegisterContract(ContractDefinition{Name: "counter", Code: counterModule}); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := dr.State.ContractState("counter"); len(state) != 0 {
+		t.Errorf("expected a new contract to start with empty state, got %v", state)
+	}
+}
@@ -3,45 +3,291 @@ package contract
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
 )
 
-// ExecuteContractCode executes the WASM contract code with given parameters.
-// This example assumes the contract exports a function called "execute" that handles the logic.
-func ExecuteContractCode(ctx context.Context, code []byte, method string, params map[string]interface{}) (interface{}, error) {
-	// Create a new WASM runtime.
+// ErrWASMUnsupported is returned when deploying or executing a dynamic
+// contract on a node whose WASM runtime is unavailable.
+var ErrWASMUnsupported = errors.New("WASM contracts are not supported on this node")
+
+// MaxMemoryPages caps the memory of a contract instance, in 64 KiB pages:
+// a module declaring a larger minimum or maximum is refused, and
+// memory.grow beyond it fails.
+const MaxMemoryPages = 256
+
+// emptyModule is the smallest valid WASM binary: the magic number and version.
+var emptyModule = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+// wasmProbe checks that a runtime can be created and can compile a module.
+// Tests replace it to simulate an unavailable runtime.
+var wasmProbe = func() error {
+	ctx := context.Background()
 	runtime := wazero.NewRuntime(ctx)
 	defer runtime.Close(ctx)
+	_, err := runtime.CompileModule(ctx, emptyModule)
+	return err
+}
+
+var wasm struct {
+	mu      sync.Mutex
+	checked bool
+	err     error // Why the runtime is unavailable; nil if it works.
+}
+
+// CheckWASMSupport probes the WASM runtime and records the result for later
+// deploys and executions. It returns nil if WASM contracts can run, or an
+// error wrapping ErrWASMUnsupported. Call it once at startup; otherwise the
+// first deploy or execution runs the probe.
+func CheckWASMSupport() error {
+	err := probeWASM()
+	wasm.mu.Lock()
+	defer wasm.mu.Unlock()
+	wasm.checked = true
+	wasm.err = err
+	return err
+}
+
+// probeWASM runs wasmProbe, turning a failure or panic into ErrWASMUnsupported.
+func probeWASM() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: runtime panicked: %v", ErrWASMUnsupported, r)
+		}
+	}()
+	if err := wasmProbe(); err != nil {
+		return fmt.Errorf("%w: %v", ErrWASMUnsupported, err)
+	}
+	return nil
+}
+
+// wasmSupported returns the recorded probe result, probing first if needed.
+func wasmSupported() error {
+	wasm.mu.Lock()
+	checked, err := wasm.checked, wasm.err
+	wasm.mu.Unlock()
+	if !checked {
+		return CheckWASMSupport()
+	}
+	return err
+}
+
+// contractCall is the input handed to a WASM contract's execute function.
+type contractCall struct {
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// ExecuteContractCode runs method with params in the WASM contract code and
+// returns the contract's decoded result and the gas it used. Execution is
+// aborted with ErrOutOfGas once it has used gasLimit, which GasLimit
+// bounds. Gas is counted deterministically; see instrumentGas.
+//
+// The contract must follow this ABI:
+//   - export its linear memory as "memory";
+//   - export "malloc(size i32) i32", returning the offset of size free bytes;
+//   - export "execute(ptr i32, len i32) i64". The input at ptr is the JSON
+//     object {"method": ..., "params": {...}}. The result is packed as
+//     ptr<<32 | len and must point at a JSON value in memory, or have len 0
+//     for no result.
+//
+// The contract may import these host functions from module "env" to use
+// state, which holds its key-value store:
+//   - "get_state(key_ptr, key_len, val_ptr, val_cap i32) i32" copies up to
+//     val_cap bytes of the value stored under the key to val_ptr and
+//     returns the value's full length, or -1 if the key is not set;
+//   - "set_state(key_ptr, key_len, val_ptr, val_len i32)" stores a value.
+//
+// An upgraded contract may also export "migrate(from_version i32)"; see
+// MigrateContractCode.
+//
+// state is updated in place, even by a call that fails; nil runs the
+// contract with an empty, discarded state.
+func ExecuteContractCode(ctx context.Context, code []byte, method string, params map[string]interface{}, state map[string][]byte, gasLimit uint64) (interface{}, uint64, error) {
+	input, err := json.Marshal(contractCall{Method: method, Params: params})
+	if err != nil {
+		return nil, 0, fmt.Errorf("encoding contract input: %w", err)
+	}
+	return runContract(ctx, code, state, gasLimit, func(instance api.Module) (interface{}, error) {
+		return callExecute(ctx, instance, input)
+	})
+}
+
+// MigrateContractCode calls the "migrate" export of code, the new version of
+// a contract, with the version it replaces, so that it can rewrite state
+// into its own schema through get_state and set_state. Code without a
+// migrate export keeps state as it is. Gas is metered as for
+// ExecuteContractCode.
+func MigrateContractCode(ctx context.Context, code []byte, fromVersion int, state map[string][]byte, gasLimit uint64) (uint64, error) {
+	_, used, err := runContract(ctx, code, state, gasLimit, func(instance api.Module) (interface{}, error) {
+		migrate := instance.ExportedFunction("migrate")
+		if migrate == nil {
+			return nil, nil
+		}
+		if _, err := migrate.Call(ctx, uint64(fromVersion)); err != nil {
+			return nil, fmt.Errorf("contract migration error: %w", err)
+		}
+		return nil, nil
+	})
+	return used, err
+}
+
+// runContract instantiates code, metered with gasLimit gas (see
+// GasLimit), with the state host functions and passes the instance to
+// call. It reports the gas used; a call that runs out fails with
+// ErrOutOfGas, having used the whole limit. Running code is also aborted
+// when ctx is done.
+func runContract(ctx context.Context, code []byte, state map[string][]byte, gasLimit uint64, call func(api.Module) (interface{}, error)) (interface{}, uint64, error) {
+	if err := wasmSupported(); err != nil {
+		return nil, 0, err
+	}
+	gasLimit = GasLimit(gasLimit)
+	code, err := instrumentGas(code, gasLimit)
+	if err != nil {
+		return nil, 0, err
+	}
+	if state == nil {
+		state = make(map[string][]byte)
+	}
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true).WithMemoryLimitPages(MaxMemoryPages)
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	defer runtime.Close(ctx)
+	if err := instantiateStateHost(ctx, runtime, state); err != nil {
+		return nil, 0, fmt.Errorf("failed to provide state functions: %w", err)
+	}
 
 	// Compile the WASM module.
 	mod, err := runtime.CompileModule(ctx, code)
 	if err != nil {
-		return nil, fmt.Errorf("failed to compile module: %w", err)
+		return nil, 0, fmt.Errorf("failed to compile module: %w", err)
 	}
 
-	// Instantiate the module.
+	// Instantiate the module. The gas a failing start function used cannot
+	// be read back, so a failed instantiation uses the whole limit.
 	instance, err := runtime.InstantiateModule(ctx, mod, wazero.NewModuleConfig())
 	if err != nil {
-		return nil, fmt.Errorf("failed to instantiate module: %w", err)
+		return nil, gasLimit, fmt.Errorf("failed to instantiate module: %w", err)
 	}
 	defer instance.Close(ctx)
+	result, err := call(instance)
+	used := gasUsed(instance, gasLimit)
+	if err != nil {
+		return nil, used, outOfGas(instance, gasLimit, err)
+	}
+	return result, used, nil
+}
+
+// gasLeft returns the metering counter of instance, which is negative once
+// the call ran out of gas.
+func gasLeft(instance api.Module) int64 {
+	return int64(instance.ExportedGlobal(gasExport).Get())
+}
+
+// gasUsed returns how much of gasLimit instance has used.
+func gasUsed(instance api.Module, gasLimit uint64) uint64 {
+	return gasLimit - uint64(min(max(gasLeft(instance), 0), int64(gasLimit)))
+}
+
+// outOfGas turns err, a failed call on instance, into ErrOutOfGas if the
+// call used up its gas.
+func outOfGas(instance api.Module, gasLimit uint64, err error) error {
+	if gasLeft(instance) < 0 {
+		return fmt.Errorf("%w after %d gas", ErrOutOfGas, gasLimit)
+	}
+	return err
+}
+
+// chargeHost takes the gas of a host call moving size bytes from the
+// counter of the calling module m, aborting it once the gas is used up.
+func chargeHost(m api.Module, size uint32) {
+	counter := m.ExportedGlobal(gasExport).(api.MutableGlobal)
+	left := int64(counter.Get()) - int64(HostCallGas+uint64(size))
+	counter.Set(uint64(left))
+	if left < 0 {
+		panic(ErrOutOfGas)
+	}
+}
 
-	// Assume the contract exports a function "execute".
-	// In a real scenario, you'd pass arguments (like method and params) appropriately.
+// callExecute copies input into the instance's memory, calls its execute
+// export and decodes the result.
+func callExecute(ctx context.Context, instance api.Module, input []byte) (interface{}, error) {
+	mem := instance.Memory()
+	if mem == nil {
+		return nil, fmt.Errorf("contract does not export its memory")
+	}
+	malloc := instance.ExportedFunction("malloc")
+	if malloc == nil {
+		return nil, fmt.Errorf("function 'malloc' not found in contract")
+	}
 	fn := instance.ExportedFunction("execute")
 	if fn == nil {
 		return nil, fmt.Errorf("function 'execute' not found in contract")
 	}
 
-	// Here we call the function without arguments for demonstration purposes.
-	// Adapt this call to match your contract's expected signature.
-	results, err := fn.Call(ctx)
+	// Copy the input into guest memory.
+	results, err := malloc.Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("contract allocation error: %w", err)
+	}
+	ptr := uint32(results[0])
+	if !mem.Write(ptr, input) {
+		return nil, fmt.Errorf("malloc returned %d, outside contract memory", ptr)
+	}
+
+	results, err = fn.Call(ctx, uint64(ptr), uint64(len(input)))
 	if err != nil {
 		return nil, fmt.Errorf("contract execution error: %w", err)
 	}
+	outPtr, outLen := uint32(results[0]>>32), uint32(results[0])
+	if outLen == 0 {
+		return nil, nil
+	}
+	out, ok := mem.Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("contract result at %d+%d is outside its memory", outPtr, outLen)
+	}
+	var result interface{}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("decoding contract result: %w", err)
+	}
+	return result, nil
+}
 
-	// For example, return the first result.
-	return results[0], nil
+// instantiateStateHost provides the "env" module through which contracts
+// read and write state. A guest passing memory ranges it does not own is
+// aborted.
+func instantiateStateHost(ctx context.Context, runtime wazero.Runtime, state map[string][]byte) error {
+	read := func(m api.Module, ptr, length uint32) []byte {
+		data, ok := m.Memory().Read(ptr, length)
+		if !ok {
+			panic(fmt.Errorf("range %d+%d is outside contract memory", ptr, length))
+		}
+		return data
+	}
+	_, err := runtime.NewHostModuleBuilder("env").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, m api.Module, keyPtr, keyLen, valPtr, valCap uint32) int32 {
+			chargeHost(m, keyLen+valCap)
+			value, ok := state[string(read(m, keyPtr, keyLen))]
+			if !ok {
+				return -1
+			}
+			copy(read(m, valPtr, valCap), value)
+			return int32(len(value))
+		}).
+		Export("get_state").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, m api.Module, keyPtr, keyLen, valPtr, valLen uint32) {
+			chargeHost(m, keyLen+valLen)
+			key := string(read(m, keyPtr, keyLen))
+			state[key] = append([]byte(nil), read(m, valPtr, valLen)...)
+		}).
+		Export("set_state").
+		Instantiate(ctx)
+	return err
 }
@@ -0,0 +1,158 @@
+package contract
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// withProbe runs CheckWASMSupport with probe in place of the real check and
+// restores the real result afterwards.
+func withProbe(t *testing.T, probe func() error) {
+	t.Helper()
+	saved := wasmProbe
+	wasmProbe = probe
+	t.Cleanup(func() {
+		wasmProbe = saved
+		CheckWASMSupport()
+	})
+	CheckWASMSupport()
+}
+
+func TestUnavailableRuntimeRefusesDynamicContracts(t *testing.T) {
+	for name, probe := range map[string]func() error{
+		"error": func() error { return errors.New("runtime failed to initialize") },
+		"panic": func() error { panic("no compiler for this platform") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			withProbe(t, probe)
+
+			err := NewDynamicRegistry().RegisterContract(ContractDefinition{Name: "c", Code: emptyModule})
+			if !errors.Is(err, ErrWASMUnsupported) {
+				t.Errorf("deploy: expected ErrWASMUnsupported, got %v", err)
+			}
+			_, _, err = ExecuteContractCode(context.Background(), emptyModule, "execute", nil, nil, 0)
+			if !errors.Is(err, ErrWASMUnsupported) {
+				t.Errorf("execute: expected ErrWASMUnsupported, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWASMSupportDetected(t *testing.T) {
+	if err := CheckWASMSupport(); err != nil {
+		t.Fatalf("expected the WASM runtime to be available: %v", err)
+	}
+	if err := NewDynamicRegistry().RegisterContract(ContractDefinition{Name: "c", Code: emptyModule}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// echoModule returns its input unchanged. In WAT:
+//
+//	(memory (export "memory") 1)
+//	(global $heap (mut i32) (i32.const 1024))
+//	(func (export "malloc") (param $size i32) (result i32)
+//	  global.get $heap
+//	  (global.set $heap (i32.add (global.get $heap) (local.get $size))))
+//	(func (export "execute") (param $ptr i32) (param $len i32) (result i64)
+//	  (i64.or (i64.shl (i64.extend_i32_u (local.get $ptr)) (i64.const 32))
+//	          (i64.extend_i32_u (local.get $len))))
+var echoModule = append(abiPreamble,
+	0x0a, 0x1a, 0x02,
+	0x0b, 0x00, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b, // malloc
+	0x0c, 0x00, 0x20, 0x00, 0xad, 0x42, 0x20, 0x86, 0x20, 0x01, 0xad, 0x84, 0x0b, // execute
+)
+
+// abiPreamble holds every section of a contract following the ABI except
+// the code: memory, the heap pointer, and the type and exports of malloc
+// and execute.
+var abiPreamble = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// Types: (i32) -> i32, (i32, i32) -> i64.
+	0x01, 0x0c, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e,
+	// Functions.
+	0x03, 0x03, 0x02, 0x00, 0x01,
+	// Memory of one page.
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	// Heap pointer global.
+	0x06, 0x07, 0x01, 0x7f, 0x01, 0x41, 0x80, 0x08, 0x0b,
+	// Exports: memory, malloc, execute.
+	0x07, 0x1d, 0x03,
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	0x06, 'm', 'a', 'l', 'l', 'o', 'c', 0x00, 0x00,
+	0x07, 'e', 'x', 'e', 'c', 'u', 't', 'e', 0x00, 0x01,
+}
+
+func TestExecuteContractCodePassesInput(t *testing.T) {
+	params := map[string]interface{}{"a": 10.0, "b": "x"}
+	result, _, err := ExecuteContractCode(context.Background(), echoModule, "add", params, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"method": "add", "params": params}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("expected the contract to echo %v, got %v", want, result)
+	}
+
+	if _, _, err := ExecuteContractCode(context.Background(), emptyModule, "add", params, nil, 0); err == nil {
+		t.Error("expected a module without the ABI exports to be rejected")
+	}
+}
+
+func TestDeployedContractExecutes(t *testing.T) {
+	dr := NewDynamicRegistry()
+	if err := dr.RegisterContract(ContractDefinition{Name: "echo", Code: echoModule}); err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]interface{}{"x": 1.0}
+	result, err := ExecuteAnyContract(context.Background(), dr, "echo", "ping", params, Gas{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"method": "ping", "params": params}; !reflect.DeepEqual(result, want) {
+		t.Errorf("expected %v, got %v", want, result)
+	}
+	if _, err := ExecuteAnyContract(context.Background(), dr, "missing", "ping", nil, Gas{}); err == nil {
+		t.Error("expected an unknown contract to be rejected")
+	}
+}
+
+func TestUpdateAndUnregisterContract(t *testing.T) {
+	dr := NewDynamicRegistry()
+	// A buggy deployment without the ABI exports cannot be executed...
+	if err := dr.RegisterContract(ContractDefinition{Name: "fixable", Code: emptyModule}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dr.ExecuteContract(context.Background(), "fixable", "ping", nil, Gas{}); err == nil {
+		t.Fatal("expected the buggy contract to fail")
+	}
+	// ...until its code is replaced.
+	if err := dr.Update(ContractDefinition{Name: "fixable", Code: echoModule}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := dr.ExecuteContract(context.Background(), "fixable", "ping", nil, Gas{})
+	if err != nil {
+		t.Fatalf("updated contract failed: %v", err)
+	}
+	if want := map[string]interface{}{"method": "ping", "params": nil}; !reflect.DeepEqual(result, want) {
+		t.Errorf("expected %v, got %v", want, result)
+	}
+	if err := dr.Update(ContractDefinition{Name: "missing", Code: echoModule}); err == nil {
+		t.Error("expected updating an unknown contract to fail")
+	}
+
+	if err := dr.Unregister("fixable"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dr.ExecuteContract(context.Background(), "fixable", "ping", nil, Gas{}); err == nil {
+		t.Error("expected an unregistered contract not to execute")
+	}
+	if err := dr.Unregister("fixable"); err == nil {
+		t.Error("expected unregistering twice to fail")
+	}
+	if err := dr.RegisterContract(ContractDefinition{Name: "fixable", Code: echoModule}); err != nil {
+		t.Errorf("expected the name to be free again: %v", err)
+	}
+}
@@ -82,27 +82,36 @@ func outerDecrypt(outerCiphertext, outerKey []byte) (string, int, error) {
 
 // --- Exported Functions ---
 
-// Encrypt encrypts the given plaintext using the simplified two-layer scheme.
-// It preserves the function signature so that existing code remains unchanged.
-func Encrypt(plainText []byte) (string, error) {
-	// Convert plaintext to string.
-	plaintextStr := string(plainText)
+// KeySize is the length in bytes of the keys taken by EncryptWithKey and DecryptWithKey.
+const KeySize = chacha20poly1305.KeySize
+
+// Parameters of the custom inner layer, and the secret behind the shared
+// key of the deprecated Encrypt and Decrypt.
+const (
+	matrixSecret        = "MatrixSecretForSubstitution"
+	dictSecret          = "DictionarySecretForUnknownSymbols"
+	transformSecret     = "TransformSecretForChunks"
+	legacyOuterSecret   = "OuterLayerSecretForChaCha20Poly1305"
+	customLayerChunkLen = 4
+)
 
-	// Parameters (you can later make these configurable).
-	matrixSecret := "MatrixSecretForSubstitution"
-	dictSecret := "DictionarySecretForUnknownSymbols"
-	transformSecret := "TransformSecretForChunks"
-	outerSecret := "OuterLayerSecretForChaCha20Poly1305"
-	chunkSize := 4
+// KeyFromSecret derives a key for EncryptWithKey and DecryptWithKey from a
+// caller secret such as a passphrase.
+func KeyFromSecret(secret string) []byte {
+	return deriveKey(secret)
+}
 
+// EncryptWithKey encrypts plainText under key, which must be KeySize bytes,
+// and returns the hex-encoded ciphertext.
+func EncryptWithKey(plainText, key []byte) (string, error) {
+	if len(key) != KeySize {
+		return "", fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
 	// Custom inner layer: simplified as identity.
-	customOut := applyCustomLayer(plaintextStr, matrixSecret, dictSecret, transformSecret, chunkSize)
-
-	// Derive the outer key.
-	outerKey := deriveKey(outerSecret)
+	customOut := applyCustomLayer(string(plainText), matrixSecret, dictSecret, transformSecret, customLayerChunkLen)
 
 	// Apply outer encryption.
-	cipherBytes, err := outerEncrypt(customOut, outerKey)
+	cipherBytes, err := outerEncrypt(customOut, key)
 	if err != nil {
 		return "", err
 	}
@@ -110,16 +119,12 @@ func Encrypt(plainText []byte) (string, error) {
 	return fmt.Sprintf("%x", cipherBytes), nil
 }
 
-// Decrypt decrypts the given hex-encoded ciphertext using the simplified scheme.
-func Decrypt(cipherHex string) ([]byte, error) {
-	outerSecret := "OuterLayerSecretForChaCha20Poly1305"
-	matrixSecret := "MatrixSecretForSubstitution"
-	dictSecret := "DictionarySecretForUnknownSymbols"
-	transformSecret := "TransformSecretForChunks"
-	chunkSize := 4
-
-	outerKey := deriveKey(outerSecret)
-
+// DecryptWithKey decrypts a hex-encoded ciphertext produced by
+// EncryptWithKey with the same key. A different key fails authentication.
+func DecryptWithKey(cipherHex string, key []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
 	// Decode the hex-encoded ciphertext.
 	cipherBytes, err := hex.DecodeString(cipherHex)
 	if err != nil {
@@ -127,12 +132,28 @@ func Decrypt(cipherHex string) ([]byte, error) {
 	}
 
 	// Outer decryption.
-	customText, origLen, err := outerDecrypt(cipherBytes, outerKey)
+	customText, origLen, err := outerDecrypt(cipherBytes, key)
 	if err != nil {
 		return nil, err
 	}
 
 	// Reverse the custom layer (identity function).
-	plainTextStr := reverseCustomLayer(customText, matrixSecret, dictSecret, transformSecret, chunkSize, origLen)
+	plainTextStr := reverseCustomLayer(customText, matrixSecret, dictSecret, transformSecret, customLayerChunkLen, origLen)
 	return []byte(plainTextStr), nil
 }
+
+// Encrypt encrypts the given plaintext using the simplified two-layer scheme.
+// It preserves the function signature so that existing code remains unchanged.
+//
+// Deprecated: every caller shares one key compiled into the source. Use
+// EncryptWithKey.
+func Encrypt(plainText []byte) (string, error) {
+	return EncryptWithKey(plainText, deriveKey(legacyOuterSecret))
+}
+
+// Decrypt decrypts the given hex-encoded ciphertext using the simplified scheme.
+//
+// Deprecated: use DecryptWithKey with the key the data was encrypted under.
+func Decrypt(cipherHex string) ([]byte, error) {
+	return DecryptWithKey(cipherHex, deriveKey(legacyOuterSecret))
+}
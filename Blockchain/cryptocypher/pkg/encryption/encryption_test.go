@@ -0,0 +1,81 @@
+package encryption_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"cryptocypher/pkg/encryption"
+)
+
+func FuzzEncryptDecrypt(f *testing.F) {
+	f.Add([]byte(""))
+	f.Add([]byte("EncryptedTextDataXYZ"))
+	f.Add([]byte{0x00, 0xff, 0x80, '\n'})
+	f.Fuzz(func(t *testing.T, plaintext []byte) {
+		cipherHex, err := encryption.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		decrypted, err := encryption.Decrypt(cipherHex)
+		if err != nil {
+			t.Fatalf("decrypt: %v", err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("round trip mismatch: got %x, want %x", decrypted, plaintext)
+		}
+	})
+}
+
+func FuzzDecryptTampered(f *testing.F) {
+	f.Add([]byte("hello"), uint(0), byte(1))
+	f.Add([]byte(""), uint(20), byte(0x80))
+	f.Fuzz(func(t *testing.T, plaintext []byte, pos uint, flip byte) {
+		if flip == 0 {
+			t.Skip("no mutation")
+		}
+		cipherHex, err := encryption.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		cipherBytes, err := hex.DecodeString(cipherHex)
+		if err != nil {
+			t.Fatalf("ciphertext is not hex: %v", err)
+		}
+		cipherBytes[pos%uint(len(cipherBytes))] ^= flip
+		if _, err := encryption.Decrypt(hex.EncodeToString(cipherBytes)); err == nil {
+			t.Fatalf("tampered ciphertext decrypted without error")
+		}
+	})
+}
+
+func TestKeysAreNotInterchangeable(t *testing.T) {
+	alice := encryption.KeyFromSecret("alice's secret")
+	bob := make([]byte, encryption.KeySize)
+	bob[0] = 1
+
+	for _, key := range [][]byte{alice, bob} {
+		cipherHex, err := encryption.EncryptWithKey([]byte("media for one receiver"), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := encryption.DecryptWithKey(cipherHex, key)
+		if err != nil || string(plain) != "media for one receiver" {
+			t.Errorf("round trip failed: %q (err %v)", plain, err)
+		}
+	}
+
+	cipherHex, err := encryption.EncryptWithKey([]byte("for alice"), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encryption.DecryptWithKey(cipherHex, bob); err == nil {
+		t.Error("expected decryption under another key to fail")
+	}
+	if _, err := encryption.Decrypt(cipherHex); err == nil {
+		t.Error("expected the legacy shared key not to decrypt a per-key ciphertext")
+	}
+	if _, err := encryption.EncryptWithKey([]byte("x"), []byte("short")); err == nil {
+		t.Error("expected a key of the wrong size to be rejected")
+	}
+}
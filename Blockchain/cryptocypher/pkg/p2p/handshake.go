@@ -0,0 +1,125 @@
+// File: pkg/p2p/handshake.go
+package p2p
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// handshakeTimeout bounds how long either side waits for the peer's HELLO.
+const handshakeTimeout = 10 * time.Second
+
+// Hello is exchanged by both sides before any other message on a connection.
+type Hello struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	NodeID          string `json:"node_id"`
+	ListenAddress   string `json:"listen_address"` // Address peers should dial, not the ephemeral source address.
+	Height          int    `json:"height"`         // Index of the sender's tip, or -1 for an empty chain.
+}
+
+// hello describes this node.
+func (n *Node) hello() Hello {
+	height := -1
+	if tip := n.Blockchain.Tip(); tip != nil {
+		height = tip.Index
+	}
+	return Hello{
+		ProtocolVersion: ProtocolVersion,
+		NodeID:          n.ID,
+		ListenAddress:   n.Address,
+		Height:          height,
+	}
+}
+
+// sendHello writes this node's HELLO to conn.
+func (n *Node) sendHello(conn net.Conn) error {
+	data, err := json.Marshal(n.hello())
+	if err != nil {
+		return err
+	}
+	return writeMessage(conn, Message{Command: "HELLO", Data: data}, n.messageLimit())
+}
+
+// readHello reads the peer's HELLO and checks that it speaks our protocol
+// version and, on an encrypted connection, that its node ID is the static
+// key the peer proved in the Noise handshake.
+func (n *Node) readHello(conn net.Conn, reader *bufio.Reader) (*Hello, error) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	msg, err := readMessage(reader, n.messageLimit())
+	if err != nil {
+		return nil, fmt.Errorf("reading HELLO: %w", err)
+	}
+	if msg.Command != "HELLO" {
+		return nil, fmt.Errorf("expected HELLO, got %s", msg.Command)
+	}
+	var h Hello
+	if err := json.Unmarshal(msg.Data, &h); err != nil {
+		return nil, fmt.Errorf("malformed HELLO: %w", err)
+	}
+	if h.ProtocolVersion != ProtocolVersion {
+		return &h, fmt.Errorf("protocol version %d is not supported (we speak %d)", h.ProtocolVersion, ProtocolVersion)
+	}
+	if sc, ok := conn.(*secureConn); ok && h.NodeID != hex.EncodeToString(sc.PeerStatic()) {
+		return &h, fmt.Errorf("node ID %q does not match the peer's handshake key", h.NodeID)
+	}
+	return &h, nil
+}
+
+// dialPeer connects to addr, secures the connection and completes the handshake. The returned reader
+// must be used for all further reads from the connection.
+func (n *Node) dialPeer(addr string) (net.Conn, *bufio.Reader, *Hello, error) {
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		n.markUnreachable(addr)
+		return nil, nil, nil, err
+	}
+	conn, err := n.secure(raw, true)
+	if err != nil {
+		raw.Close()
+		n.markUnreachable(addr)
+		fmt.Printf("Handshake with %s failed: %v\n", addr, err)
+		return nil, nil, nil, err
+	}
+	if err := n.sendHello(conn); err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	h, err := n.readHello(conn, reader)
+	if err != nil {
+		conn.Close()
+		n.markUnreachable(addr)
+		fmt.Printf("Handshake with %s failed: %v\n", addr, err)
+		return nil, nil, nil, err
+	}
+	n.markConnected(addr, h.NodeID)
+	return conn, reader, h, nil
+}
+
+// acceptHello runs the inbound side of the handshake and records the
+// peer's advertised listen address. An address not recently confirmed by
+// dialing it is verified in the background first; see verifyListenAddress.
+func (n *Node) acceptHello(conn net.Conn, reader *bufio.Reader) error {
+	h, err := n.readHello(conn, reader)
+	if h != nil {
+		// Reply even on a version mismatch so the dialer learns why we hang up.
+		n.sendHello(conn)
+	}
+	if err != nil {
+		return err
+	}
+	if h.ListenAddress == "" || h.ListenAddress == n.Address {
+		return nil
+	}
+	if n.listensAt(h.ListenAddress, h.NodeID) {
+		n.markConnected(h.ListenAddress, h.NodeID)
+	} else {
+		go n.verifyListenAddress(h)
+	}
+	return nil
+}
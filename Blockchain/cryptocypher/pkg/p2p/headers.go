@@ -0,0 +1,168 @@
+// File: pkg/p2p/headers.go
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+// sendHeaders answers GET_HEADERS with the light headers of our chain.
+func (n *Node) sendHeaders(conn net.Conn) {
+	data, err := json.Marshal(n.Blockchain.ExtractHeaders())
+	if err != nil {
+		fmt.Println("Error marshalling headers:", err)
+		return
+	}
+	n.sendMessage(conn, Message{Command: "HEADERS_RESPONSE", Data: data})
+}
+
+// handleHeaders adopts a received header chain if it links up from genesis,
+// meets its difficulty and has a higher cumulative difficulty than the
+// headers we hold.
+func (n *Node) handleHeaders(data json.RawMessage) error {
+	if err := checkChainLength(data, n.chainLimit()); err != nil {
+		return err
+	}
+	var headers []blockchain.LightBlockHeader
+	if err := json.Unmarshal(data, &headers); err != nil {
+		return fmt.Errorf("malformed headers: %w", err)
+	}
+	return n.LoadHeaders(headers)
+}
+
+// LoadHeaders gives a light client the header chain headers, such as one
+// saved by an earlier run, under the same rules as a chain received from a
+// peer: it must link up from genesis, hash correctly, meet its difficulty,
+// match the chain's genesis hash and checkpoints and outweigh the headers
+// already held. Without a configured genesis hash the genesis of the first
+// header chain held is pinned instead.
+func (n *Node) LoadHeaders(headers []blockchain.LightBlockHeader) error {
+	if len(headers) == 0 {
+		return errors.New("empty header chain")
+	}
+	if headers[0].Index != 0 {
+		return fmt.Errorf("header chain starts at %d, not genesis", headers[0].Index)
+	}
+	cfg := n.chainConfig()
+	h, err := cfg.Hasher()
+	if err != nil {
+		return err
+	}
+	if err := blockchain.VerifyHeaderRangeWith(headers, headers[0].Hash, headers[len(headers)-1].Hash, h); err != nil {
+		return fmt.Errorf("invalid header chain: %w", err)
+	}
+	if err := cfg.VerifyCheckpoints(headers); err != nil {
+		return fmt.Errorf("invalid header chain: %w", err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.headers) > 0 && headers[0].Hash != n.headers[0].Hash {
+		return fmt.Errorf("invalid header chain: genesis %s differs from %s", headers[0].Hash, n.headers[0].Hash)
+	}
+	if headerWork(headers) <= headerWork(n.headers) {
+		return ErrWeakerChain
+	}
+	n.headers = headers
+	return nil
+}
+
+// headerWork is CumulativeWork for a header chain.
+func headerWork(headers []blockchain.LightBlockHeader) float64 {
+	total := 0.0
+	for _, h := range headers {
+		total += blockchain.Work(h.Difficulty)
+	}
+	return total
+}
+
+// Headers returns the header chain a light client has synced.
+func (n *Node) Headers() []blockchain.LightBlockHeader {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]blockchain.LightBlockHeader(nil), n.headers...)
+}
+
+// FetchHeaders requests peerAddr's header chain with GET_HEADERS.
+func (n *Node) FetchHeaders(peerAddr string) ([]blockchain.LightBlockHeader, error) {
+	conn, reader, _, err := n.dialPeer(peerAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+	n.sendMessage(conn, Message{Command: "GET_HEADERS"})
+	resp, err := readMessage(reader, n.messageLimit())
+	if err != nil {
+		return nil, err
+	}
+	if resp.Command != "HEADERS_RESPONSE" {
+		return nil, fmt.Errorf("unexpected response to GET_HEADERS: %s", resp.Command)
+	}
+	var headers []blockchain.LightBlockHeader
+	if err := json.Unmarshal(resp.Data, &headers); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// sendBlock answers GET_BLOCK with the block whose hash is requested, or
+// with a null block if we do not have it.
+func (n *Node) sendBlock(data json.RawMessage, conn net.Conn) {
+	var hash string
+	if err := json.Unmarshal(data, &hash); err != nil {
+		fmt.Println("Error unmarshalling block request:", err)
+		return
+	}
+	b, err := blockchain.GetBlockFromChain(n.Blockchain, hash)
+	if err != nil {
+		b = nil
+	}
+	blockData, err := json.Marshal(b)
+	if err != nil {
+		fmt.Println("Error marshalling block:", err)
+		return
+	}
+	n.sendMessage(conn, Message{Command: "BLOCK_RESPONSE", Data: blockData})
+}
+
+// FetchBlock requests the full block with the given hash from peerAddr
+// with GET_BLOCK, so a light client can retrieve a block without the whole
+// chain. The returned block is checked to hash to the requested hash.
+func (n *Node) FetchBlock(peerAddr, hash string) (*blockchain.Block, error) {
+	conn, reader, _, err := n.dialPeer(peerAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+	request, err := json.Marshal(hash)
+	if err != nil {
+		return nil, err
+	}
+	n.sendMessage(conn, Message{Command: "GET_BLOCK", Data: request})
+	resp, err := readMessage(reader, n.messageLimit())
+	if err != nil {
+		return nil, err
+	}
+	if resp.Command != "BLOCK_RESPONSE" {
+		return nil, fmt.Errorf("unexpected response to GET_BLOCK: %s", resp.Command)
+	}
+	var b *blockchain.Block
+	if err := json.Unmarshal(resp.Data, &b); err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, fmt.Errorf("peer %s does not have block %s", peerAddr, hash)
+	}
+	if b.Hash != hash || blockchain.CalculateHash(b) != hash {
+		return nil, fmt.Errorf("peer %s sent a block that does not hash to %s", peerAddr, hash)
+	}
+	return b, nil
+}
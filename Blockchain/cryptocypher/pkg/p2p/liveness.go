@@ -0,0 +1,117 @@
+// File: pkg/p2p/liveness.go
+package p2p
+
+import (
+	"fmt"
+	"time"
+)
+
+// A peer counts as connected for this many discovery intervals after its
+// last completed handshake. Discovery dials every peer once per interval,
+// so a reachable peer is refreshed well before it expires.
+const livenessIntervals = 3
+
+// markConnected records a completed handshake with the node id listening
+// on addr.
+func (n *Node) markConnected(addr, id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.lastSeen == nil {
+		n.lastSeen = make(map[string]time.Time)
+		n.peerIDs = make(map[string]string)
+	}
+	n.lastSeen[addr] = time.Now()
+	n.peerIDs[addr] = id
+}
+
+// markUnreachable forgets addr after a failed dial.
+func (n *Node) markUnreachable(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.lastSeen, addr)
+	delete(n.peerIDs, addr)
+}
+
+// listensAt reports whether a handshake over a connection we dialed to
+// addr, within the liveness window, found the node id there.
+func (n *Node) listensAt(addr, id string) bool {
+	window := livenessIntervals * n.DiscoveryInterval
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	seen, ok := n.lastSeen[addr]
+	return ok && time.Since(seen) <= window && n.peerIDs[addr] == id
+}
+
+// verifyListenAddress dials the listen address h advertised by an inbound
+// peer and records it only if the same node answers there, so a peer
+// cannot be counted, or learned, under an address it does not listen on.
+// One dial per address is in flight at a time.
+func (n *Node) verifyListenAddress(h *Hello) {
+	addr := h.ListenAddress
+	n.mu.Lock()
+	if n.verifying == nil {
+		n.verifying = make(map[string]struct{})
+	}
+	if _, busy := n.verifying[addr]; busy {
+		n.mu.Unlock()
+		return
+	}
+	n.verifying[addr] = struct{}{}
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.verifying, addr)
+		n.mu.Unlock()
+	}()
+
+	conn, _, back, err := n.dialPeer(addr)
+	if err != nil {
+		fmt.Printf("Not recording %s: dialing it back failed: %v\n", addr, err)
+		return
+	}
+	conn.Close()
+	if back.NodeID != h.NodeID {
+		fmt.Printf("Not recording %s: node %s claimed it, but %s listens there\n", addr, h.NodeID, back.NodeID)
+		return
+	}
+	if n.Peers.Add(addr) {
+		fmt.Println("Learned peer from handshake:", addr)
+	}
+}
+
+// markSynced records that the chain of at least one peer has been
+// received and either adopted or found no better than ours.
+func (n *Node) markSynced() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.synced = true
+}
+
+// ConnectedPeers returns the number of peers that completed a handshake,
+// in either direction, within the last few discovery intervals.
+func (n *Node) ConnectedPeers() int {
+	window := livenessIntervals * n.DiscoveryInterval
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	count := 0
+	for addr, seen := range n.lastSeen {
+		if time.Since(seen) <= window {
+			count++
+		} else {
+			delete(n.lastSeen, addr)
+		}
+	}
+	return count
+}
+
+// ReadyToMine reports whether the node is connected to at least minPeers
+// peers or has completed an initial chain sync, so that an isolated node
+// does not mine a private fork. minPeers of zero or less always allows mining.
+func (n *Node) ReadyToMine(minPeers int) bool {
+	if minPeers <= 0 || n.ConnectedPeers() >= minPeers {
+		return true
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.synced
+}
@@ -0,0 +1,206 @@
+// File: pkg/p2p/noise.go
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/crypto/curve25519"
+)
+
+// noiseSuite is the cipher suite used for the transport handshake.
+var noiseSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2s)
+
+// noisePrologue binds the handshake to this protocol so a transcript cannot
+// be replayed against another one.
+var noisePrologue = []byte(fmt.Sprintf("cryptocypher-p2p/%d", ProtocolVersion))
+
+const (
+	noiseMaxFrame = 65535 // Largest Noise message, including the tag.
+	noiseTagSize  = 16
+)
+
+// newIdentityKey generates the node's static Noise key pair.
+func newIdentityKey() noise.DHKey {
+	key, err := noiseSuite.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("cannot generate identity key: %v", err))
+	}
+	return key
+}
+
+// LoadIdentityKey reads the static Noise key pair kept hex-encoded at path,
+// creating it if the file does not exist, so that a node keeps its
+// identity across restarts.
+func LoadIdentityKey(path string) (noise.DHKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		key := newIdentityKey()
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Private)+"\n"), 0600); err != nil {
+			return noise.DHKey{}, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return noise.DHKey{}, err
+	}
+	private, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(private) != curve25519.ScalarSize {
+		return noise.DHKey{}, fmt.Errorf("malformed identity key in %s", path)
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return noise.DHKey{}, err
+	}
+	return noise.DHKey{Private: private, Public: public}, nil
+}
+
+// nodeID is the identifier a node with static key key advertises in its
+// HELLO: the hex-encoded public key, which the Noise handshake proves the
+// peer holds.
+func nodeID(key noise.DHKey) string {
+	return hex.EncodeToString(key.Public)
+}
+
+// SetIdentityKey makes key the node's static Noise key and derives its ID
+// from it.
+func (n *Node) SetIdentityKey(key noise.DHKey) {
+	n.IdentityKey = key
+	n.ID = nodeID(key)
+}
+
+// secureConn encrypts everything written to the underlying connection and
+// decrypts everything read from it. Each Noise message travels as a 2-byte
+// big-endian length followed by the ciphertext.
+type secureConn struct {
+	net.Conn
+	send, recv *noise.CipherState
+	peerStatic []byte
+
+	wmu     sync.Mutex
+	readBuf []byte
+}
+
+// PeerStatic returns the remote node's static public key, authenticated by the handshake.
+func (c *secureConn) PeerStatic() []byte {
+	return c.peerStatic
+}
+
+func (c *secureConn) Read(p []byte) (int, error) {
+	for len(c.readBuf) == 0 {
+		frame, err := readNoiseFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		plain, err := c.recv.Decrypt(nil, nil, frame)
+		if err != nil {
+			return 0, fmt.Errorf("decrypting frame: %w", err)
+		}
+		c.readBuf = plain
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *secureConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), noiseMaxFrame-noiseTagSize)]
+		ciphertext, err := c.send.Encrypt(nil, nil, chunk)
+		if err != nil {
+			return written, err
+		}
+		if err := writeNoiseFrame(c.Conn, ciphertext); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// secure runs a Noise XX handshake over conn using the node's identity key
+// and returns the encrypted connection. Unless Plaintext is set, every
+// connection is secured before the HELLO exchange.
+func (n *Node) secure(conn net.Conn, initiator bool) (net.Conn, error) {
+	if n.Plaintext {
+		return conn, nil
+	}
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	hs, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   noiseSuite,
+		Random:        rand.Reader,
+		Pattern:       noise.HandshakeXX,
+		Initiator:     initiator,
+		Prologue:      noisePrologue,
+		StaticKeypair: n.IdentityKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// XX: -> e; <- e, ee, s, es; -> s, se. The initiator writes first.
+	var cs1, cs2 *noise.CipherState
+	for i := 0; cs1 == nil; i++ {
+		if (i%2 == 0) == initiator {
+			var msg []byte
+			msg, cs1, cs2, err = hs.WriteMessage(nil, nil)
+			if err == nil {
+				err = writeNoiseFrame(conn, msg)
+			}
+		} else {
+			var msg []byte
+			msg, err = readNoiseFrame(conn)
+			if err == nil {
+				_, cs1, cs2, err = hs.ReadMessage(nil, msg)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("noise handshake: %w", err)
+		}
+	}
+
+	sc := &secureConn{Conn: conn, peerStatic: hs.PeerStatic()}
+	if initiator {
+		sc.send, sc.recv = cs1, cs2
+	} else {
+		sc.send, sc.recv = cs2, cs1
+	}
+	return sc, nil
+}
+
+func readNoiseFrame(r io.Reader) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func writeNoiseFrame(w io.Writer, frame []byte) error {
+	buf := make([]byte, 2+len(frame))
+	binary.BigEndian.PutUint16(buf, uint16(len(frame)))
+	copy(buf[2:], frame)
+	_, err := w.Write(buf)
+	return err
+}
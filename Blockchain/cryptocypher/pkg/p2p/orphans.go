@@ -0,0 +1,121 @@
+// File: pkg/p2p/orphans.go
+package p2p
+
+import (
+	"fmt"
+	"time"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+// maxOrphanBlocks bounds how many blocks wait for their parent, so a peer
+// cannot fill memory with blocks that never connect.
+const maxOrphanBlocks = 128
+
+// orphanTTL is how long a block waits for its parent before it is dropped.
+const orphanTTL = 10 * time.Minute
+
+// orphan is a block buffered until its parent arrives.
+type orphan struct {
+	block    *blockchain.Block
+	received time.Time
+}
+
+// addOrphan buffers b until the block it builds on arrives. AddSideBlock
+// has already checked its hash, difficulty and size before finding the
+// parent unknown, so only blocks with valid proof of work get here. Blocks
+// that have waited longer than orphanTTL, and those no higher than the
+// active tip at height tip, are dropped first; when the buffer is still
+// full the oldest block makes room. b itself is refused if it is no higher
+// than the tip, since it could only join a branch that is already behind.
+func (n *Node) addOrphan(b *blockchain.Block, tip int) error {
+	if b.Index <= tip {
+		return fmt.Errorf("block %d is not above the tip at %d", b.Index, tip)
+	}
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, o := range n.orphans[b.PrevHash] {
+		if o.block.Hash == b.Hash {
+			return nil
+		}
+	}
+	n.dropOrphansLocked(func(o orphan) bool {
+		return o.block.Index <= tip || now.Sub(o.received) > orphanTTL
+	})
+	if n.orphanN >= maxOrphanBlocks {
+		var oldest *orphan
+		for _, children := range n.orphans {
+			for i := range children {
+				if oldest == nil || children[i].received.Before(oldest.received) {
+					oldest = &children[i]
+				}
+			}
+		}
+		victim := oldest.block
+		n.dropOrphansLocked(func(o orphan) bool { return o.block == victim })
+	}
+	if n.orphans == nil {
+		n.orphans = make(map[string][]orphan)
+	}
+	n.orphans[b.PrevHash] = append(n.orphans[b.PrevHash], orphan{block: b, received: now})
+	n.orphanN++
+	return nil
+}
+
+// dropOrphansLocked removes the buffered blocks for which drop is true.
+// The caller must hold n.mu.
+func (n *Node) dropOrphansLocked(drop func(orphan) bool) {
+	for parent, children := range n.orphans {
+		kept := children[:0]
+		for _, o := range children {
+			if drop(o) {
+				n.orphanN--
+				continue
+			}
+			kept = append(kept, o)
+		}
+		if len(kept) == 0 {
+			delete(n.orphans, parent)
+		} else {
+			n.orphans[parent] = kept
+		}
+	}
+}
+
+// takeOrphans removes and returns the buffered blocks whose parent is hash.
+func (n *Node) takeOrphans(hash string) []*blockchain.Block {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	children := n.orphans[hash]
+	delete(n.orphans, hash)
+	n.orphanN -= len(children)
+	blocks := make([]*blockchain.Block, len(children))
+	for i, o := range children {
+		blocks[i] = o.block
+	}
+	return blocks
+}
+
+// connectOrphans processes the buffered descendants of the block hash,
+// parents before children, now that hash is known, whether on the active
+// chain or a competing branch. It reports whether the active chain changed.
+func (n *Node) connectOrphans(hash string) bool {
+	extended := false
+	for queue := []string{hash}; len(queue) > 0; queue = queue[1:] {
+		for _, b := range n.takeOrphans(queue[0]) {
+			outcome, err := n.processBlock(b)
+			if err != nil {
+				fmt.Printf("Buffered block %d dropped: %v\n", b.Index, err)
+				continue
+			}
+			if outcome == blockBuffered {
+				continue
+			}
+			fmt.Printf("Attached buffered block %d (%s).\n", b.Index, b.Hash)
+			extended = extended || outcome == blockExtended
+			queue = append(queue, b.Hash)
+		}
+	}
+	return extended
+}
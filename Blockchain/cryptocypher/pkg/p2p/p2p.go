@@ -3,13 +3,50 @@ package p2p
 
 import (
 	"bufio"
+	"bytes"
+
+	"encoding/binary"
+
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand"
 	"net"
-	"strings"
+	"sync"
 	"time"
 
 	"cryptocypher/pkg/blockchain"
+
+	"github.com/flynn/noise"
+)
+
+// Default peer discovery timing; see Node.DiscoveryInterval.
+const (
+	DefaultDiscoveryInterval = 30 * time.Second
+	DefaultDiscoveryJitter   = 5 * time.Second
+)
+
+// DefaultMaxInboundConns is the number of inbound connections a node
+// serves at once; see Node.MaxInboundConns.
+const DefaultMaxInboundConns = 128
+
+// Default bounds on what a peer may send; see Node.MaxMessageSize and
+// Node.MaxChainBlocks.
+const (
+	DefaultMaxMessageSize = 8 << 20
+	DefaultMaxChainBlocks = 100000
+)
+
+// ProtocolVersion is the version of the wire protocol spoken by this node.
+const ProtocolVersion = 1
+
+// Outcomes reported by the message handlers that are not malformed input.
+var (
+	ErrEmptyChain  = errors.New("local chain is empty")
+	ErrWeakerChain = errors.New("received chain is not stronger than the local chain")
+	ErrStaleBlock  = errors.New("block does not extend the current tip")
 )
 
 // Message defines the structure for P2P messages.
@@ -20,20 +57,108 @@ type Message struct {
 
 // Node represents a peer in the network.
 type Node struct {
-	Address    string                 // Address to listen on (e.g. "localhost:8000")
-	Peers      []string               // List of known peer addresses
-	Blockchain *blockchain.Blockchain // Pointer to our blockchain
+	ID         string                      // Hex-encoded public IdentityKey; see SetIdentityKey
+	Address    string                      // Address to listen on (e.g. "localhost:8000")
+	Peers      *PeerStore                  // Known peer addresses
+	Blockchain *blockchain.Blockchain      // Pointer to our blockchain
+	TxPool     *blockchain.TransactionPool // Pending transactions shared with the miner
+
+	// LightClient makes the node sync only block headers from its peers,
+	// with GET_HEADERS instead of GET_CHAIN. See Headers.
+	LightClient bool
+
+	// DiscoveryInterval is the mean delay between GET_PEERS rounds and
+	// DiscoveryJitter the maximum random deviation from it, so that nodes
+	// started together do not query the network in lockstep.
+	DiscoveryInterval time.Duration
+	DiscoveryJitter   time.Duration
+
+	// MaxInboundConns bounds the number of inbound connections served
+	// concurrently. Connections accepted beyond it are closed immediately.
+	// Zero or less means unlimited.
+	MaxInboundConns int
+
+	// MaxMessageSize bounds the size in bytes of a single message; a peer
+	// sending a larger one is disconnected. MaxChainBlocks bounds the
+	// number of blocks accepted in a chain update. Zero or less means the
+	// defaults.
+	MaxMessageSize int
+	MaxChainBlocks int
+
+	// IdentityKey is the static key that authenticates this node in the
+	// Noise handshake. Plaintext disables the handshake; both ends of a
+	// connection must agree, so only set it when explicitly configured.
+	IdentityKey noise.DHKey
+	Plaintext   bool
+
+	mu        sync.Mutex
+	listener  net.Listener                  // Set while Start is accepting connections.
+	inbound   chan struct{}                 // Semaphore of inbound connection slots; nil if unlimited.
+	seenTx    map[string]struct{}           // Hashes of gossiped transactions, so each is relayed once.
+	seenTxs   []string                      // seenTx keys, oldest first, for eviction.
+	lastSeen  map[string]time.Time          // Last completed handshake per peer listen address.
+	peerIDs   map[string]string             // Node ID found by that handshake, per listen address.
+	verifying map[string]struct{}           // Advertised listen addresses being dialed back.
+	synced    bool                          // Set once a peer's chain has been received.
+	headers   []blockchain.LightBlockHeader // Header chain synced by a light client.
+	orphans   map[string][]orphan           // Blocks waiting for their parent, by PrevHash.
+	orphanN   int                           // Number of blocks in orphans.
+	quit      chan struct{}                 // Closed by Stop.
+	stopOnce  sync.Once
 }
 
-// NewNode initializes a new node.
-func NewNode(address string, peers []string, bc *blockchain.Blockchain) *Node {
+// NewNode initializes a new node. Known peers are loaded from peerFile, if
+// set and present, and the seed peers are pinned among them. Peer list
+// changes are saved back to peerFile.
+func NewNode(address string, peers []string, bc *blockchain.Blockchain, peerFile string) *Node {
+	store, err := NewPeerStore(address, peerFile, DefaultMaxPeers)
+	if err != nil {
+		fmt.Println("Error loading peer list:", err)
+	}
+	for _, peer := range peers {
+		store.Pin(peer)
+	}
+	identity := newIdentityKey()
 	return &Node{
+		ID:         nodeID(identity),
 		Address:    address,
-		Peers:      peers,
+		Peers:      store,
 		Blockchain: bc,
+		TxPool:     &blockchain.TransactionPool{},
+
+		DiscoveryInterval: DefaultDiscoveryInterval,
+		DiscoveryJitter:   DefaultDiscoveryJitter,
+		MaxInboundConns:   DefaultMaxInboundConns,
+		MaxMessageSize:    DefaultMaxMessageSize,
+		MaxChainBlocks:    DefaultMaxChainBlocks,
+		IdentityKey:       identity,
+		quit:              make(chan struct{}),
 	}
 }
 
+// maxSeenTx bounds the gossip dedup cache; the oldest hashes are forgotten first.
+const maxSeenTx = 10000
+
+// markTxSeen records hash in the gossip dedup cache and reports whether it
+// was new.
+func (n *Node) markTxSeen(hash string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.seenTx == nil {
+		n.seenTx = make(map[string]struct{})
+	}
+	if _, ok := n.seenTx[hash]; ok {
+		return false
+	}
+	if len(n.seenTxs) >= maxSeenTx {
+		delete(n.seenTx, n.seenTxs[0])
+		n.seenTxs = n.seenTxs[1:]
+	}
+	n.seenTx[hash] = struct{}{}
+	n.seenTxs = append(n.seenTxs, hash)
+	return true
+}
+
 // Start launches the TCP server to listen for incoming connections.
 func (n *Node) Start() {
 	ln, err := net.Listen("tcp", n.Address)
@@ -43,6 +168,22 @@ func (n *Node) Start() {
 	}
 	defer ln.Close()
 
+	n.mu.Lock()
+	select {
+	case <-n.quit:
+		// Stopped before we started listening.
+		n.mu.Unlock()
+		return
+	default:
+	}
+	n.listener = ln
+	var inbound chan struct{}
+	if n.MaxInboundConns > 0 {
+		inbound = make(chan struct{}, n.MaxInboundConns)
+	}
+	n.inbound = inbound
+	n.mu.Unlock()
+
 	fmt.Println("P2P node listening on", n.Address)
 	// Start periodic peer discovery.
 	go n.periodicPeerDiscovery()
@@ -51,27 +192,82 @@ func (n *Node) Start() {
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			select {
+			case <-n.quit:
+				return
+			default:
+			}
 			fmt.Println("Error accepting connection:", err)
 			continue
 		}
-		go n.handleConnection(conn)
+		if inbound == nil {
+			go n.handleConnection(conn)
+			continue
+		}
+		select {
+		case inbound <- struct{}{}:
+			go func() {
+				defer func() { <-inbound }()
+				n.handleConnection(conn)
+			}()
+		default:
+			fmt.Printf("Refusing connection from %s: %d inbound connections already open\n", conn.RemoteAddr(), n.MaxInboundConns)
+			conn.Close()
+		}
 	}
 }
 
+// Stop closes the listener, ends peer discovery and saves the peer list.
+// It is safe to call more than once and before Start.
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.quit != nil {
+			close(n.quit)
+		}
+		if n.listener != nil {
+			n.listener.Close()
+		}
+		if n.Peers != nil {
+			if err := n.Peers.Flush(); err != nil {
+				fmt.Println("Error saving peer list:", err)
+			}
+		}
+	})
+}
+
 // periodicPeerDiscovery periodically requests peer lists from known peers.
 func (n *Node) periodicPeerDiscovery() {
 	for {
-		time.Sleep(30 * time.Second) // Adjust interval as needed.
+		select {
+		case <-n.quit:
+			return
+		case <-time.After(NextInterval(n.DiscoveryInterval, n.DiscoveryJitter)):
+		}
 		n.broadcastGetPeers()
 	}
 }
 
+// NextInterval returns base shifted by a uniformly random offset in
+// [-jitter, +jitter], never going below zero.
+func NextInterval(base, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return base
+	}
+	next := base - jitter + time.Duration(mathrand.Int63n(int64(2*jitter)+1))
+	if next < 0 {
+		return 0
+	}
+	return next
+}
+
 // broadcastGetPeers sends a GET_PEERS command to all known peers.
 func (n *Node) broadcastGetPeers() {
 	msg := Message{Command: "GET_PEERS"}
-	for _, addr := range n.Peers {
+	for _, addr := range n.Peers.All() {
 		go func(peerAddr string) {
-			conn, err := net.Dial("tcp", peerAddr)
+			conn, _, _, err := n.dialPeer(peerAddr)
 			if err != nil {
 				// Could not connect; skip.
 				return
@@ -83,37 +279,141 @@ func (n *Node) broadcastGetPeers() {
 }
 
 // handleConnection processes an incoming connection.
-func (n *Node) handleConnection(conn net.Conn) {
-	defer conn.Close()
+func (n *Node) handleConnection(raw net.Conn) {
+	defer raw.Close()
+	conn, err := n.secure(raw, false)
+	if err != nil {
+		fmt.Printf("Closing connection from %s: %v\n", raw.RemoteAddr(), err)
+		return
+	}
 	reader := bufio.NewReader(conn)
+	if err := n.acceptHello(conn, reader); err != nil {
+		fmt.Printf("Closing connection from %s: %v\n", conn.RemoteAddr(), err)
+		return
+	}
 
 	for {
 		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-		line, err := reader.ReadString('\n')
+		msg, err := readMessage(reader, n.messageLimit())
 		if err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				// The frame was read in full, so the stream is still in sync.
+				fmt.Println("Error unmarshalling message:", err)
+				continue
+			}
 			return
 		}
-		line = strings.TrimSpace(line)
-		var msg Message
-		if err := json.Unmarshal([]byte(line), &msg); err != nil {
-			fmt.Println("Error unmarshalling message:", err)
-			continue
-		}
 		n.handleMessage(msg, conn)
 	}
 }
 
+// messageLimit returns the configured MaxMessageSize, or the default.
+func (n *Node) messageLimit() int {
+	if n.MaxMessageSize > 0 {
+		return n.MaxMessageSize
+	}
+	return DefaultMaxMessageSize
+}
+
+// chainLimit returns the configured MaxChainBlocks, or the default.
+func (n *Node) chainLimit() int {
+	if n.MaxChainBlocks > 0 {
+		return n.MaxChainBlocks
+	}
+	return DefaultMaxChainBlocks
+}
+
+// chainConfig returns the configuration of the node's chain, or the default
+// one if none is set.
+func (n *Node) chainConfig() *blockchain.ChainConfig {
+	if n.Blockchain.Config == nil {
+		return blockchain.DefaultChainConfig()
+	}
+	return n.Blockchain.Config
+}
+
+// readMessage reads the next message: a 4-byte big-endian length followed
+// by that many bytes of JSON. A frame longer than limit bytes is refused
+// before its payload is read, so a peer cannot make us allocate arbitrary
+// amounts of memory.
+func readMessage(r io.Reader, limit int) (Message, error) {
+	var msg Message
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return msg, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if uint64(size) > uint64(limit) {
+		return msg, fmt.Errorf("message of %d bytes exceeds the %d byte limit", size, limit)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return msg, err
+	}
+	err := json.Unmarshal(payload, &msg)
+	return msg, err
+}
+
+// writeMessage writes msg with the framing expected by readMessage,
+// refusing messages longer than limit bytes.
+func writeMessage(w io.Writer, msg Message, limit int) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if len(payload) > limit || uint64(len(payload)) > math.MaxUint32 {
+		return fmt.Errorf("message of %d bytes exceeds the %d byte limit", len(payload), limit)
+	}
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err = w.Write(frame)
+	return err
+}
+
+// checkChainLength fails if the JSON array data holds more than limit
+// elements. It only scans the array, so an oversized chain is refused
+// before any block is decoded.
+func checkChainLength(data json.RawMessage, limit int) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		// Not an array; leave the error to the caller's decoding.
+		return nil
+	}
+	count := 0
+	for dec.More() {
+		if count++; count > limit {
+			return fmt.Errorf("chain exceeds the %d block limit", limit)
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
 // handleMessage routes the message based on its command.
 func (n *Node) handleMessage(msg Message, conn net.Conn) {
+	var err error
 	switch msg.Command {
 	case "GET_CHAIN":
-		n.sendChain(conn)
+		n.sendChain(msg.Data, conn)
 	case "GET_CHAIN_RESPONSE":
-		n.handleChainUpdate(msg.Data)
+		err = n.handleChainUpdate(msg.Data)
+	case "GET_HEADERS":
+		n.sendHeaders(conn)
+	case "HEADERS_RESPONSE":
+		err = n.handleHeaders(msg.Data)
+	case "GET_BLOCK":
+		n.sendBlock(msg.Data, conn)
 	case "CHAIN_UPDATE":
-		n.handleChainUpdate(msg.Data)
+		err = n.handleChainUpdate(msg.Data)
 	case "NEW_BLOCK":
-		n.handleNewBlock(msg.Data)
+		err = n.handleNewBlock(msg.Data)
+	case "NEW_TRANSACTION":
+		err = n.handleNewTransaction(msg.Data)
 	case "HEARTBEAT":
 		n.sendHeartbeatAck(conn)
 	case "HEARTBEAT_ACK":
@@ -121,35 +421,231 @@ func (n *Node) handleMessage(msg Message, conn net.Conn) {
 	case "GET_PEERS":
 		n.handleGetPeers(conn)
 	case "PEER_LIST":
-		n.handlePeerList(msg.Data)
+		err = n.handlePeerList(msg.Data)
+	case "GET_MEMPOOL":
+		n.sendMempool(conn)
+	case "GET_TRANSACTIONS":
+		n.sendTransactions(msg.Data, conn)
 	default:
 		fmt.Printf("Received unknown command: %s\n", msg.Command)
 	}
+	if err != nil {
+		fmt.Printf("%s not applied: %v\n", msg.Command, err)
+	}
 }
 
-// sendChain sends the current blockchain as a JSON blob.
-func (n *Node) sendChain(conn net.Conn) {
-	chainBytes, err := json.Marshal(n.Blockchain.Blocks)
+// chainRequest is the payload of GET_CHAIN: the height of the first block
+// wanted. A request without one starts at genesis.
+type chainRequest struct {
+	From int `json:"from"`
+}
+
+// chainPageOverhead is room left in a GET_CHAIN_RESPONSE for the message
+// envelope around the blocks.
+const chainPageOverhead = 256
+
+// sendChain answers GET_CHAIN with a page of the chain: the blocks from the
+// requested height on, as many as fit in one message, read through the
+// store for blocks evicted from memory. A page is never empty unless the
+// chain ends before the requested height, which tells the requester it has
+// the whole chain.
+func (n *Node) sendChain(data json.RawMessage, conn net.Conn) {
+	var req chainRequest
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil || req.From < 0 {
+			fmt.Println("Invalid chain request:", string(data))
+			return
+		}
+	}
+	page := []json.RawMessage{}
+	size, budget := 2, n.messageLimit()-chainPageOverhead
+	if tip := n.Blockchain.Tip(); tip != nil {
+		for i := req.From; i <= tip.Index; i++ {
+			b, err := n.Blockchain.GetBlockByIndex(i)
+			if err != nil {
+				fmt.Printf("Error reading block %d: %v\n", i, err)
+				return
+			}
+			encoded, err := json.Marshal(b)
+			if err != nil {
+				fmt.Println("Error marshalling block:", err)
+				return
+			}
+			// The first block goes out even if too large, so the failure
+			// is reported rather than ending the chain early.
+			if len(page) > 0 && size+len(encoded)+1 > budget {
+				break
+			}
+			page = append(page, encoded)
+			size += len(encoded) + 1
+		}
+	}
+	chainBytes, err := json.Marshal(page)
 	if err != nil {
 		fmt.Println("Error marshalling blockchain:", err)
 		return
 	}
-	responseMsg := Message{
-		Command: "GET_CHAIN_RESPONSE",
-		Data:    chainBytes,
+	n.sendMessage(conn, Message{Command: "GET_CHAIN_RESPONSE", Data: chainBytes})
+}
+
+// requestChain pulls the chain of the peer at the other end of conn from
+// genesis, one GET_CHAIN page at a time, until the peer sends an empty
+// page. Each page must continue the previous one, and the whole chain is
+// bounded by MaxChainBlocks.
+func (n *Node) requestChain(conn net.Conn, reader io.Reader) ([]*blockchain.Block, error) {
+	var chain []*blockchain.Block
+	for {
+		req, err := json.Marshal(chainRequest{From: len(chain)})
+		if err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		if err := writeMessage(conn, Message{Command: "GET_CHAIN", Data: req}, n.messageLimit()); err != nil {
+			return nil, err
+		}
+		resp, err := readMessage(reader, n.messageLimit())
+		if err != nil {
+			return nil, err
+		}
+		if resp.Command != "GET_CHAIN_RESPONSE" {
+			return nil, fmt.Errorf("unexpected response to GET_CHAIN: %s", resp.Command)
+		}
+		if err := checkChainLength(resp.Data, n.chainLimit()-len(chain)); err != nil {
+			return nil, fmt.Errorf("chain exceeds the %d block limit", n.chainLimit())
+		}
+		var page []*blockchain.Block
+		if err := json.Unmarshal(resp.Data, &page); err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			return chain, nil
+		}
+		if page[0] == nil || page[0].Index != len(chain) {
+			return nil, fmt.Errorf("chain page does not start at height %d", len(chain))
+		}
+		chain = append(chain, page...)
 	}
-	n.sendMessage(conn, responseMsg)
 }
 
-// sendMessage writes a JSON message to a connection.
-func (n *Node) sendMessage(conn net.Conn, msg Message) {
-	bytes, err := json.Marshal(msg)
+// sendMempool answers GET_MEMPOOL with the hashes of all pending transactions.
+func (n *Node) sendMempool(conn net.Conn) {
+	pending := n.TxPool.Pending()
+	hashes := make([]string, 0, len(pending))
+	for _, tx := range pending {
+		hashes = append(hashes, tx.CalculateHash())
+	}
+	data, err := json.Marshal(hashes)
 	if err != nil {
-		fmt.Println("Error marshalling message:", err)
+		fmt.Println("Error marshalling mempool:", err)
+		return
+	}
+	n.sendMessage(conn, Message{Command: "MEMPOOL", Data: data})
+}
+
+// sendTransactions answers GET_TRANSACTIONS with the requested pending transactions.
+// Hashes that are no longer pending are silently skipped.
+func (n *Node) sendTransactions(data json.RawMessage, conn net.Conn) {
+	var hashes []string
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		fmt.Println("Error unmarshalling transaction request:", err)
 		return
 	}
-	// Append newline as a delimiter.
-	conn.Write(append(bytes, '\n'))
+	wanted := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		wanted[h] = true
+	}
+	txs := []*blockchain.Transaction{}
+	for _, tx := range n.TxPool.Pending() {
+		if wanted[tx.CalculateHash()] {
+			txs = append(txs, tx)
+		}
+	}
+	txData, err := json.Marshal(txs)
+	if err != nil {
+		fmt.Println("Error marshalling transactions:", err)
+		return
+	}
+	n.sendMessage(conn, Message{Command: "TRANSACTIONS", Data: txData})
+}
+
+// FetchChain requests peerAddr's full chain with paged GET_CHAIN requests.
+func (n *Node) FetchChain(peerAddr string) ([]*blockchain.Block, error) {
+	conn, reader, _, err := n.dialPeer(peerAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return n.requestChain(conn, reader)
+}
+
+// SyncMempool pulls the pending transactions of peerAddr into the local pool.
+// Only transactions not already known locally are fetched. It returns the
+// number of transactions added.
+func (n *Node) SyncMempool(peerAddr string) (int, error) {
+	conn, reader, _, err := n.dialPeer(peerAddr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+	n.sendMessage(conn, Message{Command: "GET_MEMPOOL"})
+	resp, err := readMessage(reader, n.messageLimit())
+	if err != nil {
+		return 0, err
+	}
+	if resp.Command != "MEMPOOL" {
+		return 0, fmt.Errorf("unexpected response to GET_MEMPOOL: %s", resp.Command)
+	}
+	var hashes []string
+	if err := json.Unmarshal(resp.Data, &hashes); err != nil {
+		return 0, err
+	}
+	unknown := []string{}
+	for _, h := range hashes {
+		if !n.TxPool.Has(h) {
+			unknown = append(unknown, h)
+		}
+	}
+	if len(unknown) == 0 {
+		return 0, nil
+	}
+
+	request, err := json.Marshal(unknown)
+	if err != nil {
+		return 0, err
+	}
+	n.sendMessage(conn, Message{Command: "GET_TRANSACTIONS", Data: request})
+	resp, err = readMessage(reader, n.messageLimit())
+	if err != nil {
+		return 0, err
+	}
+	if resp.Command != "TRANSACTIONS" {
+		return 0, fmt.Errorf("unexpected response to GET_TRANSACTIONS: %s", resp.Command)
+	}
+	var txs []*blockchain.Transaction
+	if err := json.Unmarshal(resp.Data, &txs); err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, tx := range txs {
+		if n.TxPool.Has(tx.CalculateHash()) {
+			continue
+		}
+		if err := n.TxPool.AddTransaction(tx); err != nil {
+			fmt.Printf("Rejected transaction from peer %s: %v\n", peerAddr, err)
+			continue
+		}
+		added++
+	}
+	return added, nil
+}
+
+// sendMessage writes a framed JSON message to a connection.
+func (n *Node) sendMessage(conn net.Conn, msg Message) {
+	if err := writeMessage(conn, msg, n.messageLimit()); err != nil {
+		fmt.Println("Error sending message:", err)
+	}
 }
 
 // sendHeartbeatAck responds to a heartbeat with an acknowledgment.
@@ -161,46 +657,148 @@ func (n *Node) sendHeartbeatAck(conn net.Conn) {
 }
 
 // handleChainUpdate processes a received chain update.
-func (n *Node) handleChainUpdate(data json.RawMessage) {
+func (n *Node) handleChainUpdate(data json.RawMessage) error {
+	if err := checkChainLength(data, n.chainLimit()); err != nil {
+		return err
+	}
 	var incomingChain []*blockchain.Block
 	if err := json.Unmarshal(data, &incomingChain); err != nil {
-		fmt.Println("Error unmarshalling chain update:", err)
-		return
+		return fmt.Errorf("malformed chain update: %w", err)
 	}
+	return n.applyChain(incomingChain)
+}
 
-	if blockchain.IsValidChain(incomingChain) {
-		if n.Blockchain.ReplaceChain(incomingChain) {
-			fmt.Println("Local chain replaced with received chain (higher cumulative difficulty).")
-		} else {
-			fmt.Println("Received chain valid but not stronger than the current chain.")
-		}
-	} else {
-		fmt.Println("Received invalid chain update.")
+// applyChain replaces the local chain with incomingChain if it is valid and
+// heavier, and returns ErrWeakerChain if it is valid but not heavier.
+func (n *Node) applyChain(incomingChain []*blockchain.Block) error {
+	// The chain's ledger, if attached, is rebuilt from the accepted chain.
+	replaced, err := n.Blockchain.TryReplaceChain(incomingChain)
+	if err != nil {
+		return fmt.Errorf("invalid chain update: %w", err)
+	}
+	if !replaced {
+		return ErrWeakerChain
+	}
+	fmt.Println("Local chain replaced with received chain (higher cumulative difficulty).")
+	return nil
+}
+
+// handleNewTransaction adds a gossiped transaction to the pool and relays
+// it to our peers. Transactions seen before are ignored, which stops a
+// transaction from circulating forever.
+func (n *Node) handleNewTransaction(data json.RawMessage) error {
+	var tx *blockchain.Transaction
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return fmt.Errorf("malformed transaction: %w", err)
+	}
+	if tx == nil {
+		return errors.New("empty transaction")
+	}
+	if tx.IsCoinbase() {
+		return blockchain.ErrForgedCoinbase
+	}
+	if err := blockchain.VerifySignedTransaction(tx); err != nil {
+		return err
+	}
+	hash := tx.CalculateHash()
+	if !n.markTxSeen(hash) {
+		return nil
+	}
+	if err := n.TxPool.AddTransaction(tx); err != nil && !errors.Is(err, blockchain.ErrDuplicateTransaction) {
+		return err
 	}
+	n.BroadcastTransaction(tx)
+	return nil
 }
 
 // handleNewBlock processes a received new block announcement.
-func (n *Node) handleNewBlock(data json.RawMessage) {
+func (n *Node) handleNewBlock(data json.RawMessage) error {
 	var newBlock *blockchain.Block
 	if err := json.Unmarshal(data, &newBlock); err != nil {
-		fmt.Println("Error unmarshalling new block:", err)
-		return
+		return fmt.Errorf("malformed block: %w", err)
+	}
+
+	if newBlock == nil {
+		return fmt.Errorf("empty block message")
 	}
 
-	lastBlock := n.Blockchain.Blocks[len(n.Blockchain.Blocks)-1]
-	if newBlock.PrevHash == lastBlock.Hash && newBlock.Hash == blockchain.CalculateHash(newBlock) {
-		n.Blockchain.Blocks = append(n.Blockchain.Blocks, newBlock)
+	outcome, err := n.processBlock(newBlock)
+	if err != nil || outcome == blockBuffered {
+		return err
+	}
+	// Blocks that arrived before this one may now connect.
+	if n.connectOrphans(newBlock.Hash) || outcome == blockExtended {
 		fmt.Println("New block added to the chain.")
 		n.BroadcastChainUpdate()
-	} else {
-		fmt.Println("Received block is invalid or does not extend the current chain.")
 	}
+	return nil
+}
+
+// blockOutcome says what processBlock did with a block it accepted.
+type blockOutcome int
+
+const (
+	blockBuffered blockOutcome = iota // Its parent is unknown; it waits as an orphan.
+	blockRecorded                     // It was kept on a competing branch.
+	blockExtended                     // The active chain changed.
+)
+
+// processBlock appends newBlock if it extends the tip. A block on another
+// branch is recorded as a competing block, and the node switches to that
+// branch once it is the heaviest. A block whose parent we have not seen yet
+// is buffered as an orphan.
+func (n *Node) processBlock(newBlock *blockchain.Block) (blockOutcome, error) {
+	lastBlock := n.Blockchain.Tip()
+	if lastBlock == nil {
+		// Without a genesis there is nothing to extend; wait for a full chain.
+		return blockBuffered, ErrEmptyChain
+	}
+	if newBlock.PrevHash != lastBlock.Hash {
+		// Keep competing blocks so operators can see forks.
+		err := n.Blockchain.AddSideBlock(newBlock)
+		if errors.Is(err, blockchain.ErrUnknownParent) {
+			if err := n.addOrphan(newBlock, lastBlock.Index); err != nil {
+				return blockBuffered, fmt.Errorf("block %d: %w", newBlock.Index, err)
+			}
+			fmt.Printf("Buffered block %d (%s) until its parent arrives.\n", newBlock.Index, newBlock.Hash)
+			return blockBuffered, nil
+		}
+		if err != nil {
+			return blockBuffered, fmt.Errorf("block %d: %w", newBlock.Index, err)
+		}
+		fmt.Printf("Recorded competing block %d (%s).\n", newBlock.Index, newBlock.Hash)
+		// The branch it extends may now outweigh ours.
+		switched, err := n.Blockchain.SelectHeaviestFork()
+		if err != nil {
+			return blockRecorded, fmt.Errorf("block %d: %w", newBlock.Index, err)
+		}
+		if !switched {
+			return blockRecorded, nil
+		}
+		fmt.Printf("Switched to the heavier branch ending in block %d.\n", newBlock.Index)
+		return blockExtended, nil
+	}
+	if newBlock.Index != lastBlock.Index+1 {
+		return blockBuffered, fmt.Errorf("block %d: expected index %d", newBlock.Index, lastBlock.Index+1)
+	}
+	cfg := n.chainConfig()
+	if err := blockchain.ValidateCoinbase(newBlock, cfg.Reward.RewardAt(newBlock.Index)); err != nil {
+		return blockBuffered, err
+	}
+	added, err := n.Blockchain.AddBlockIfTip(newBlock, lastBlock.Hash)
+	if err != nil {
+		return blockBuffered, fmt.Errorf("block %d: %w", newBlock.Index, err)
+	}
+	if !added {
+		return blockBuffered, fmt.Errorf("block %d: %w", newBlock.Index, ErrStaleBlock)
+	}
+	return blockExtended, nil
 }
 
 // handleGetPeers responds to a GET_PEERS request by sending the current peer list.
 func (n *Node) handleGetPeers(conn net.Conn) {
 	// Send current peers as JSON array.
-	peerListBytes, err := json.Marshal(n.Peers)
+	peerListBytes, err := json.Marshal(n.Peers.All())
 	if err != nil {
 		fmt.Println("Error marshalling peer list:", err)
 		return
@@ -213,79 +811,115 @@ func (n *Node) handleGetPeers(conn net.Conn) {
 }
 
 // handlePeerList processes a received peer list and updates the local peer list.
-func (n *Node) handlePeerList(data json.RawMessage) {
+func (n *Node) handlePeerList(data json.RawMessage) error {
 	var receivedPeers []string
 	if err := json.Unmarshal(data, &receivedPeers); err != nil {
-		fmt.Println("Error unmarshalling peer list:", err)
-		return
+		return fmt.Errorf("malformed peer list: %w", err)
 	}
 	updated := false
 	for _, peer := range receivedPeers {
-		if peer != n.Address && !contains(n.Peers, peer) {
-			n.Peers = append(n.Peers, peer)
+		if n.Peers.Add(peer) {
 			updated = true
 		}
 	}
 	if updated {
-		fmt.Println("Updated peer list:", n.Peers)
+		fmt.Println("Updated peer list:", n.Peers.All())
 	}
-}
-
-// Utility function: checks if a slice contains a string.
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
-	}
-	return false
+	return nil
 }
 
 // connectToPeers initiates connections to each known peer.
 func (n *Node) connectToPeers() {
-	for _, peerAddr := range n.Peers {
+	for _, peerAddr := range n.Peers.All() {
 		go func(addr string) {
-			conn, err := net.Dial("tcp", addr)
+			conn, reader, _, err := n.dialPeer(addr)
 			if err != nil {
 				fmt.Printf("Could not connect to peer %s: %v\n", addr, err)
 				return
 			}
 			defer conn.Close()
 
-			// Send a GET_CHAIN message.
-			msg := Message{Command: "GET_CHAIN"}
-			n.sendMessage(conn, msg)
-
-			// Also request peer list.
-			getPeersMsg := Message{Command: "GET_PEERS"}
-			n.sendMessage(conn, getPeersMsg)
-
-			reader := bufio.NewReader(conn)
-			responseLine, err := reader.ReadString('\n')
-			if err != nil {
-				fmt.Printf("Error reading from peer %s: %v\n", addr, err)
-				return
-			}
-			var respMsg Message
-			if err := json.Unmarshal([]byte(responseLine), &respMsg); err != nil {
-				fmt.Printf("Error unmarshalling response from peer %s: %v\n", addr, err)
+			// Pull the chain page by page, or only its headers in light mode.
+			if n.LightClient {
+				n.sendMessage(conn, Message{Command: "GET_HEADERS"})
+				respMsg, err := readMessage(reader, n.messageLimit())
+				if err != nil {
+					fmt.Printf("Error reading from peer %s: %v\n", addr, err)
+					return
+				}
+				if respMsg.Command != "HEADERS_RESPONSE" {
+					fmt.Printf("Unexpected response from peer %s: %s\n", addr, respMsg.Command)
+				} else if err := n.handleHeaders(respMsg.Data); err == nil || errors.Is(err, ErrWeakerChain) {
+					n.markSynced()
+				} else {
+					fmt.Printf("Headers from peer %s not applied: %v\n", addr, err)
+				}
+			} else if chain, err := n.requestChain(conn, reader); err != nil {
+				fmt.Printf("Error fetching chain from peer %s: %v\n", addr, err)
 				return
+			} else if err := n.applyChain(chain); err == nil || errors.Is(err, ErrWeakerChain) {
+				n.markSynced()
+			} else {
+				fmt.Printf("Chain from peer %s not applied: %v\n", addr, err)
 			}
 
-			if respMsg.Command == "GET_CHAIN_RESPONSE" {
-				n.handleChainUpdate(respMsg.Data)
+			// Also request the peer list.
+			n.sendMessage(conn, Message{Command: "GET_PEERS"})
+			if respMsg, err := readMessage(reader, n.messageLimit()); err != nil {
+				fmt.Printf("Error reading from peer %s: %v\n", addr, err)
 			} else if respMsg.Command == "PEER_LIST" {
-				n.handlePeerList(respMsg.Data)
-			} else {
-				fmt.Printf("Unexpected response from peer %s: %s\n", addr, respMsg.Command)
+				n.handleMessage(respMsg, conn)
+			}
+
+			// Populate our pool with the peer's pending transactions.
+			if added, err := n.SyncMempool(addr); err != nil {
+				fmt.Printf("Error syncing mempool from peer %s: %v\n", addr, err)
+			} else if added > 0 {
+				fmt.Printf("Pulled %d pending transaction(s) from peer %s\n", added, addr)
 			}
 		}(peerAddr)
 	}
 }
 
-// BroadcastChainUpdate sends the full blockchain to all known peers as a CHAIN_UPDATE message.
+// BroadcastTransaction sends tx to all known peers as a NEW_TRANSACTION message.
+// The transaction is marked as seen so that it is not relayed again when
+// peers gossip it back.
+func (n *Node) BroadcastTransaction(tx *blockchain.Transaction) {
+	n.markTxSeen(tx.CalculateHash())
+	txBytes, err := json.Marshal(tx)
+	if err != nil {
+		fmt.Println("Error marshalling transaction:", err)
+		return
+	}
+	msg := Message{
+		Command: "NEW_TRANSACTION",
+		Data:    txBytes,
+	}
+	for _, addr := range n.Peers.All() {
+		go func(peerAddr string) {
+			conn, _, _, err := n.dialPeer(peerAddr)
+			if err != nil {
+				fmt.Printf("Could not connect to peer %s: %v\n", peerAddr, err)
+				return
+			}
+			defer conn.Close()
+			n.sendMessage(conn, msg)
+		}(addr)
+	}
+}
+
+// BroadcastChainUpdate sends the full blockchain, from genesis and with
+// the blocks evicted from memory read back from the store, to all known
+// peers as a CHAIN_UPDATE message. A chain too large for one message
+// cannot be sent this way; peers catch up with paged GET_CHAIN requests
+// instead.
 func (n *Node) BroadcastChainUpdate() {
-	chainBytes, err := json.Marshal(n.Blockchain.Blocks)
+	chain, err := n.Blockchain.FullChain()
+	if err != nil {
+		fmt.Println("Error reading blockchain:", err)
+		return
+	}
+	chainBytes, err := json.Marshal(chain)
 	if err != nil {
 		fmt.Println("Error marshalling blockchain:", err)
 		return
@@ -294,9 +928,9 @@ func (n *Node) BroadcastChainUpdate() {
 		Command: "CHAIN_UPDATE",
 		Data:    chainBytes,
 	}
-	for _, addr := range n.Peers {
+	for _, addr := range n.Peers.All() {
 		go func(peerAddr string) {
-			conn, err := net.Dial("tcp", peerAddr)
+			conn, _, _, err := n.dialPeer(peerAddr)
 			if err != nil {
 				fmt.Printf("Could not connect to peer %s: %v\n", peerAddr, err)
 				return
@@ -0,0 +1,873 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"cryptocypher/pkg/blockchain"
+	"cryptocypher/pkg/wallet"
+)
+
+// freeAddress returns a loopback address with a currently unused port.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startTestNode starts a node on a free port and waits until it accepts connections.
+func startTestNode(t *testing.T, bc *blockchain.Blockchain) *Node {
+	t.Helper()
+	n := NewNode(freeAddress(t), []string{}, bc, "")
+	go n.Start()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", n.Address)
+		if err == nil {
+			conn.Close()
+			return n
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("node %s did not start: %v", n.Address, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSyncMempoolFromPeer(t *testing.T) {
+	shared := blockchain.NewTransaction("Alice", "Bob", 1, 1)
+	onlyRemote := blockchain.NewTransaction("Bob", "Charlie", 2, 1)
+
+	peer := startTestNode(t, blockchain.NewBlockchain())
+	peer.TxPool.AddTransaction(shared)
+	peer.TxPool.AddTransaction(onlyRemote)
+
+	joining := NewNode(freeAddress(t), []string{peer.Address}, blockchain.NewBlockchain(), "")
+	joining.TxPool.AddTransaction(shared)
+
+	added, err := joining.SyncMempool(peer.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Errorf("expected 1 new transaction, got %d", added)
+	}
+	if len(joining.TxPool.Transactions) != 2 {
+		t.Fatalf("expected 2 pooled transactions, got %d", len(joining.TxPool.Transactions))
+	}
+	if !joining.TxPool.Has(onlyRemote.CalculateHash()) {
+		t.Error("peer's pending transaction was not pulled")
+	}
+
+	// A second sync finds nothing new.
+	if added, err := joining.SyncMempool(peer.Address); err != nil || added != 0 {
+		t.Errorf("expected no-op resync, got %d (err %v)", added, err)
+	}
+}
+
+func TestNextIntervalWithinJitter(t *testing.T) {
+	base, jitter := 30*time.Second, 5*time.Second
+	for i := 0; i < 1000; i++ {
+		next := NextInterval(base, jitter)
+		if next < base-jitter || next > base+jitter {
+			t.Fatalf("interval %v outside %v±%v", next, base, jitter)
+		}
+	}
+	if next := NextInterval(base, 0); next != base {
+		t.Errorf("expected exact interval without jitter, got %v", next)
+	}
+	if next := NextInterval(time.Second, time.Hour); next < 0 {
+		t.Errorf("interval went negative: %v", next)
+	}
+}
+
+func TestHandleNewBlockRejectsForgedBlocks(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	bc.AddBlock(genesis)
+	n := NewNode(freeAddress(t), []string{}, bc, "")
+
+	// A malicious peer claims high difficulty without doing the work.
+	forged := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Mallory", 12.5)
+	forged.Difficulty = 16
+	forged.Hash = blockchain.CalculateHash(forged)
+
+	// An honestly mined block at the wrong height.
+	skipped := blockchain.CreateBlock(5, genesis.Hash, "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Mallory", 12.5)
+
+	for _, b := range []*blockchain.Block{forged, skipped} {
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.handleNewBlock(data); err == nil {
+			t.Errorf("expected an error for block %d", b.Index)
+		}
+		if len(bc.Blocks) != 1 {
+			t.Fatalf("block %d was accepted", b.Index)
+		}
+	}
+
+	// A node with no chain yet must not panic.
+	empty := NewNode(freeAddress(t), []string{}, blockchain.NewBlockchain(), "")
+	data, _ := json.Marshal(forged)
+	if err := empty.handleNewBlock(data); !errors.Is(err, ErrEmptyChain) {
+		t.Errorf("expected ErrEmptyChain, got %v", err)
+	}
+	if len(empty.Blockchain.Blocks) != 0 {
+		t.Error("block accepted onto an empty chain")
+	}
+}
+
+func TestPeerBlocksMoveBalances(t *testing.T) {
+	alice, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := blockchain.NewBlockchain()
+	bc.Config.GenesisAlloc = blockchain.GenesisAlloc{alice.Address: 10}
+	bc.AttachLedger(blockchain.NewLedger())
+	genesis := blockchain.NewGenesisBlock(bc.Config.GenesisAlloc, 1)
+	bc.AddBlock(genesis)
+	n := NewNode(freeAddress(t), []string{}, bc, "")
+
+	// send delivers a block on the tip paying amount from Alice to Bob.
+	send := func(amount float64, nonce int) error {
+		tx := blockchain.NewTransaction(alice.Address, "Bob", amount, nonce)
+		if err := alice.SignTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+		tip := bc.Tip()
+		b := blockchain.CreateBlock(tip.Index+1, tip.Hash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{Transactions: []*blockchain.Transaction{tx}}, 1, "Miner1", 12.5)
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n.handleNewBlock(data)
+	}
+
+	if err := send(50, 1); !errors.Is(err, blockchain.ErrInsufficientFunds) {
+		t.Errorf("expected ErrInsufficientFunds for an unfunded transfer, got %v", err)
+	}
+	if len(bc.Blocks) != 1 {
+		t.Fatal("block with an unfunded transfer was accepted")
+	}
+	if err := send(4, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := bc.Balance("Bob"); got != 4 {
+		t.Errorf("expected the accepted block to pay Bob 4, got %v", got)
+	}
+	if got := bc.Balance(alice.Address); got != 6 {
+		t.Errorf("expected Alice to keep 6, got %v", got)
+	}
+}
+
+func TestMessageFramingWithNewlines(t *testing.T) {
+	block := &blockchain.Block{Index: 7, TextData: "line one\nline two\n", Receivers: []string{}}
+	data, err := json.MarshalIndent(block, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := Message{Command: "NEW_BLOCK", Data: data}
+
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, sent, DefaultMaxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMessage(&buf, Message{Command: "HEARTBEAT"}, DefaultMaxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readMessage(&buf, DefaultMaxMessageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded blockchain.Block
+	if err := json.Unmarshal(got.Data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got.Command != sent.Command || decoded.TextData != block.TextData {
+		t.Errorf("message changed in transit: %+v", got)
+	}
+	next, err := readMessage(&buf, DefaultMaxMessageSize)
+	if err != nil || next.Command != "HEARTBEAT" {
+		t.Errorf("expected the following frame intact, got %+v (err %v)", next, err)
+	}
+}
+
+func TestHandshakeBetweenNodes(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	bc.AddBlock(blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5))
+	listener := startTestNode(t, bc)
+	dialer := startTestNode(t, blockchain.NewBlockchain())
+
+	conn, _, hello, err := dialer.dialPeer(listener.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if hello.ProtocolVersion != ProtocolVersion || hello.ListenAddress != listener.Address ||
+		hello.NodeID != listener.ID || hello.Height != 0 {
+		t.Errorf("unexpected HELLO from listener: %+v", hello)
+	}
+
+	// The listener learns the dialer's advertised address, not its ephemeral
+	// port, once it has dialed it back.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		listener.mu.Lock()
+		known := slices.Contains(listener.Peers.All(), dialer.Address)
+		listener.mu.Unlock()
+		if known {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("listener did not record %s, peers %v", dialer.Address, listener.Peers.All())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// dialSecure connects to addr and completes only the Noise handshake, as a
+// fresh node would, leaving the HELLO exchange to the caller.
+func dialSecure(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewNode("127.0.0.1:1", []string{}, blockchain.NewBlockchain(), "")
+	conn, err := client.secure(raw, true)
+	if err != nil {
+		raw.Close()
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestNoiseHandshakeEncryptsTraffic(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	bc.AddBlock(blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5))
+	listener := startTestNode(t, bc)
+	dialer := NewNode(freeAddress(t), []string{}, blockchain.NewBlockchain(), "")
+
+	conn, reader, _, err := dialer.dialPeer(listener.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sc, ok := conn.(*secureConn)
+	if !ok {
+		t.Fatalf("expected an encrypted connection, got %T", conn)
+	}
+	if !bytes.Equal(sc.PeerStatic(), listener.IdentityKey.Public) {
+		t.Error("handshake did not authenticate the listener's identity key")
+	}
+
+	if err := writeMessage(conn, Message{Command: "GET_CHAIN"}, DefaultMaxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply, err := readMessage(reader, DefaultMaxMessageSize)
+	if err != nil || reply.Command != "GET_CHAIN_RESPONSE" {
+		t.Fatalf("expected GET_CHAIN_RESPONSE, got %+v (err %v)", reply, err)
+	}
+	var blocks []*blockchain.Block
+	if err := json.Unmarshal(reply.Data, &blocks); err != nil || len(blocks) != 1 {
+		t.Errorf("expected the listener's chain, got %d blocks (err %v)", len(blocks), err)
+	}
+
+	// A plaintext peer cannot get past the handshake.
+	plain := NewNode(freeAddress(t), []string{}, blockchain.NewBlockchain(), "")
+	plain.Plaintext = true
+	if _, _, _, err := plain.dialPeer(listener.Address); err == nil {
+		t.Error("expected a plaintext dialer to be rejected")
+	}
+}
+
+func TestHelloMustMatchHandshakeKey(t *testing.T) {
+	listener := startTestNode(t, blockchain.NewBlockchain())
+	conn := dialSecure(t, listener.Address)
+	defer conn.Close()
+	// Claim the listener's own identity over a handshake made with another key.
+	data, _ := json.Marshal(Hello{ProtocolVersion: ProtocolVersion, NodeID: listener.ID, ListenAddress: "127.0.0.1:1"})
+	if err := writeMessage(conn, Message{Command: "HELLO", Data: data}, DefaultMaxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(conn)
+	if reply, err := readMessage(reader, DefaultMaxMessageSize); err != nil || reply.Command != "HELLO" {
+		t.Fatalf("expected the listener's HELLO, got %+v (err %v)", reply, err)
+	}
+	if _, err := readMessage(reader, DefaultMaxMessageSize); err != io.EOF {
+		t.Errorf("expected the listener to close the connection, got %v", err)
+	}
+	if slices.Contains(listener.Peers.All(), "127.0.0.1:1") {
+		t.Error("peer with a mismatched identity was added to the peer list")
+	}
+}
+
+func TestIdentityKeyPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.key")
+	first, err := LoadIdentityKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadIdentityKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Private, again.Private) || !bytes.Equal(first.Public, again.Public) {
+		t.Error("identity key changed across loads")
+	}
+	if _, err := noiseSuite.DH(first.Private, newIdentityKey().Public); err != nil {
+		t.Errorf("loaded key is unusable: %v", err)
+	}
+}
+
+func TestHandshakeVersionMismatch(t *testing.T) {
+	listener := startTestNode(t, blockchain.NewBlockchain())
+	conn := dialSecure(t, listener.Address)
+	defer conn.Close()
+	data, _ := json.Marshal(Hello{ProtocolVersion: ProtocolVersion + 1, ListenAddress: "127.0.0.1:1"})
+	if err := writeMessage(conn, Message{Command: "HELLO", Data: data}, DefaultMaxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(conn)
+	if reply, err := readMessage(reader, DefaultMaxMessageSize); err != nil || reply.Command != "HELLO" {
+		t.Fatalf("expected the listener's HELLO, got %+v (err %v)", reply, err)
+	}
+	if _, err := readMessage(reader, DefaultMaxMessageSize); err != io.EOF {
+		t.Errorf("expected the listener to close the connection, got %v", err)
+	}
+	listener.mu.Lock()
+	defer listener.mu.Unlock()
+	if slices.Contains(listener.Peers.All(), "127.0.0.1:1") {
+		t.Error("incompatible peer was added to the peer list")
+	}
+}
+
+func TestOrphanBlockConnectsWhenParentArrives(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	bc.AddBlock(genesis)
+	n := NewNode(freeAddress(t), []string{}, bc, "")
+
+	parent := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	child := blockchain.CreateBlock(2, parent.Hash, "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+
+	data, _ := json.Marshal(child)
+	if err := n.handleNewBlock(data); err != nil {
+		t.Fatalf("child delivered before its parent: %v", err)
+	}
+	if len(bc.Blocks) != 1 {
+		t.Fatalf("orphan was appended without its parent")
+	}
+	data, _ = json.Marshal(parent)
+	if err := n.handleNewBlock(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(bc.Blocks) != 3 || bc.Blocks[1].Hash != parent.Hash || bc.Blocks[2].Hash != child.Hash {
+		t.Fatalf("expected parent and child on the chain, got %d blocks", len(bc.Blocks))
+	}
+	if n.orphanN != 0 {
+		t.Errorf("expected the orphan buffer to be empty, holds %d", n.orphanN)
+	}
+}
+
+func TestOrphanBufferIsBounded(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	bc.AddBlock(genesis)
+	n := NewNode(freeAddress(t), []string{}, bc, "")
+
+	if err := n.addOrphan(&blockchain.Block{Index: 0, PrevHash: "missing"}, 0); err == nil {
+		t.Error("expected an orphan no higher than the tip to be refused")
+	}
+	for i := 0; i <= maxOrphanBlocks; i++ {
+		b := &blockchain.Block{Index: 2, Hash: fmt.Sprintf("orphan-%d", i), PrevHash: fmt.Sprintf("missing-%d", i)}
+		if err := n.addOrphan(b, 0); err != nil {
+			t.Fatalf("orphan %d refused: %v", i, err)
+		}
+	}
+	if n.orphanN != maxOrphanBlocks {
+		t.Errorf("expected the buffer to stay at %d blocks, holds %d", maxOrphanBlocks, n.orphanN)
+	}
+	if len(n.takeOrphans("missing-0")) != 0 {
+		t.Error("expected the oldest orphan to make room")
+	}
+	if len(n.takeOrphans(fmt.Sprintf("missing-%d", maxOrphanBlocks))) != 1 {
+		t.Error("expected the newest orphan to be buffered")
+	}
+
+	// Once the tip passes them, buffered blocks are dropped.
+	if err := n.addOrphan(&blockchain.Block{Index: 5, Hash: "high", PrevHash: "missing-high"}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n.orphanN != 1 {
+		t.Errorf("expected only the block above the tip to remain, holds %d", n.orphanN)
+	}
+}
+
+func TestOrphanOfCompetingBlockConnects(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	mine := func(index int, prevHash, miner string) *blockchain.Block {
+		return blockchain.CreateBlock(index, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, miner, 12.5)
+	}
+	genesis := mine(0, "", "Miner1")
+	bc.AddBlock(genesis)
+	bc.AddBlock(mine(1, genesis.Hash, "MinerA"))
+	n := NewNode(freeAddress(t), []string{}, bc, "")
+
+	// The competing branch arrives child first; its parent only ties with
+	// the active tip, so connecting the child is what makes it win.
+	b1 := mine(1, genesis.Hash, "MinerB")
+	b2 := mine(2, b1.Hash, "MinerB")
+	for _, b := range []*blockchain.Block{b2, b1} {
+		data, _ := json.Marshal(b)
+		if err := n.handleNewBlock(data); err != nil {
+			t.Fatalf("block %d: %v", b.Index, err)
+		}
+	}
+	if tip := bc.Tip(); tip.Hash != b2.Hash {
+		t.Fatalf("expected the competing branch to win once connected, tip is block %d by %v", tip.Index, tip.Transactions[0].Recipient)
+	}
+	if n.orphanN != 0 {
+		t.Errorf("expected the orphan buffer to be empty, holds %d", n.orphanN)
+	}
+}
+
+func TestHandlersReportErrors(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	genesis := blockchain.CreateBlock(0, "", "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	bc.AddBlock(genesis)
+	block1 := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	bc.AddBlock(block1)
+	n := NewNode(freeAddress(t), []string{}, bc, "")
+
+	malformed := json.RawMessage(`{"not": "a list"`)
+	if err := n.handleChainUpdate(malformed); err == nil {
+		t.Error("expected an error for a malformed chain")
+	}
+	if err := n.handleNewBlock(malformed); err == nil {
+		t.Error("expected an error for a malformed block")
+	}
+	if err := n.handlePeerList(malformed); err == nil {
+		t.Error("expected an error for a malformed peer list")
+	}
+
+	weaker, _ := json.Marshal([]*blockchain.Block{genesis})
+	if err := n.handleChainUpdate(weaker); !errors.Is(err, ErrWeakerChain) {
+		t.Errorf("expected ErrWeakerChain, got %v", err)
+	}
+	broken := *block1
+	broken.PrevHash = "elsewhere"
+	invalid, _ := json.Marshal([]*blockchain.Block{genesis, &broken})
+	if err := n.handleChainUpdate(invalid); err == nil || errors.Is(err, ErrWeakerChain) {
+		t.Errorf("expected a validation error, got %v", err)
+	}
+	known, _ := json.Marshal(block1)
+	if err := n.handleNewBlock(known); err == nil {
+		t.Error("expected an error when re-delivering the tip")
+	}
+
+	peers, _ := json.Marshal([]string{"10.0.0.1:8000"})
+	if err := n.handlePeerList(peers); err != nil {
+		t.Errorf("valid peer list rejected: %v", err)
+	}
+}
+
+func TestInboundConnectionLimit(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	n := NewNode(freeAddress(t), []string{}, bc, "")
+	n.MaxInboundConns = 2
+	go n.Start()
+	defer n.Stop()
+
+	// Wait until the node is listening with all slots free.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		n.mu.Lock()
+		inbound := n.inbound
+		n.mu.Unlock()
+		if inbound != nil && len(inbound) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("node did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client := NewNode(freeAddress(t), []string{}, bc, "")
+	for i := 0; i < n.MaxInboundConns; i++ {
+		conn, _, _, err := client.dialPeer(n.Address)
+		if err != nil {
+			t.Fatalf("connection %d within the limit refused: %v", i, err)
+		}
+		defer conn.Close()
+	}
+	if conn, _, _, err := client.dialPeer(n.Address); err == nil {
+		conn.Close()
+		t.Fatal("connection beyond the limit was accepted")
+	}
+}
+
+func TestNewTransactionGossip(t *testing.T) {
+	receiver := startTestNode(t, blockchain.NewBlockchain())
+	defer receiver.Stop()
+	sender := NewNode(freeAddress(t), []string{receiver.Address}, blockchain.NewBlockchain(), "")
+
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := blockchain.NewTransaction(w.Address, "Bob", 1, 1)
+	if err := w.SignTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.handleNewTransaction(data); err != nil {
+		t.Fatal(err)
+	}
+	// A second delivery of the same transaction is not added or relayed again.
+	if err := sender.handleNewTransaction(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.TxPool.Transactions) != 1 {
+		t.Errorf("expected 1 pending transaction on the sender, got %d", len(sender.TxPool.Transactions))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !receiver.TxPool.Has(tx.CalculateHash()) {
+		if time.Now().After(deadline) {
+			t.Fatal("transaction did not reach the peer's pool")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tx.Amount = 2 // Invalidates the signature.
+	forged, _ := json.Marshal(tx)
+	if err := receiver.handleNewTransaction(forged); !errors.Is(err, blockchain.ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for a tampered transaction, got %v", err)
+	}
+}
+
+func TestMiningWaitsForPeers(t *testing.T) {
+	miner := startTestNode(t, blockchain.NewBlockchain())
+	if !miner.ReadyToMine(0) {
+		t.Error("expected a threshold of zero to allow mining")
+	}
+	if miner.ReadyToMine(1) {
+		t.Fatal("expected mining to be suppressed with no connected peers")
+	}
+
+	// The miner counts the peer once it has dialed back its listen address.
+	peer := startTestNode(t, blockchain.NewBlockchain())
+	conn, _, _, err := peer.dialPeer(miner.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for !miner.ReadyToMine(1) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected mining to proceed once a peer connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if miner.ReadyToMine(2) {
+		t.Error("expected a threshold of two to keep mining suppressed")
+	}
+	if !peer.ReadyToMine(1) {
+		t.Error("expected the dialer to count the miner as connected")
+	}
+}
+
+func TestLightNodeSyncsHeadersOnly(t *testing.T) {
+	full := blockchain.NewBlockchain()
+	genesis := blockchain.NewGenesisBlock(nil, 1)
+	full.AddBlock(genesis)
+	full.AddBlock(blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "large media payload", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5))
+	peer := startTestNode(t, full)
+	defer peer.Stop()
+
+	light := NewNode(freeAddress(t), []string{peer.Address}, blockchain.NewBlockchain(), "")
+	light.LightClient = true
+	go light.Start()
+	defer light.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(light.Headers()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("light node did not sync headers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	headers := light.Headers()
+	if want := full.ExtractHeaders(); !slices.Equal(headers, want) {
+		t.Errorf("expected headers %v, got %v", want, headers)
+	}
+	if len(light.Blockchain.Blocks) != 0 {
+		t.Errorf("light node downloaded %d full blocks", len(light.Blockchain.Blocks))
+	}
+
+	// A shorter or broken header chain is not adopted.
+	data, _ := json.Marshal(headers[:1])
+	if err := light.handleHeaders(data); !errors.Is(err, ErrWeakerChain) {
+		t.Errorf("expected ErrWeakerChain for a shorter header chain, got %v", err)
+	}
+	broken := slices.Clone(headers)
+	broken[1].PrevHash = "forged"
+	data, _ = json.Marshal(broken)
+	if err := light.handleHeaders(data); err == nil || errors.Is(err, ErrWeakerChain) {
+		t.Errorf("expected an unlinked header chain to be rejected, got %v", err)
+	}
+}
+
+func TestHeaderChainIsPinned(t *testing.T) {
+	chain := func(length int) []blockchain.LightBlockHeader {
+		bc := blockchain.NewBlockchain()
+		prev := blockchain.NewGenesisBlock(nil, 1)
+		bc.AddBlock(prev)
+		for i := 1; i < length; i++ {
+			prev = blockchain.CreateBlock(i, prev.Hash, "one-to-one", []string{}, "", "", "",
+				&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+			bc.AddBlock(prev)
+		}
+		return bc.ExtractHeaders()
+	}
+	ours, theirs := chain(2), chain(3)
+
+	// Without a configured genesis the first header chain held is pinned.
+	light := NewNode(freeAddress(t), []string{}, blockchain.NewBlockchain(), "")
+	if err := light.LoadHeaders(ours); err != nil {
+		t.Fatal(err)
+	}
+	if err := light.LoadHeaders(theirs); err == nil {
+		t.Error("expected a heavier header chain from another genesis to be rejected")
+	}
+
+	pinned := blockchain.NewBlockchain()
+	pinned.Config = blockchain.DefaultChainConfig()
+	pinned.Config.GenesisHash = ours[0].Hash
+	light = NewNode(freeAddress(t), []string{}, pinned, "")
+	if err := light.LoadHeaders(theirs); !errors.Is(err, blockchain.ErrCheckpointMismatch) {
+		t.Errorf("expected ErrCheckpointMismatch for another genesis, got %v", err)
+	}
+	pinned.Config.GenesisHash = ""
+	pinned.Config.Checkpoints = map[int]string{1: ours[1].Hash}
+	if err := light.LoadHeaders(theirs); !errors.Is(err, blockchain.ErrCheckpointMismatch) {
+		t.Errorf("expected ErrCheckpointMismatch at a checkpoint, got %v", err)
+	}
+	if err := light.LoadHeaders(ours); err != nil {
+		t.Errorf("expected the checkpointed header chain to load, got %v", err)
+	}
+}
+
+func TestFetchBlockByHash(t *testing.T) {
+	full := blockchain.NewBlockchain()
+	genesis := blockchain.NewGenesisBlock(nil, 1)
+	full.AddBlock(genesis)
+	wanted := blockchain.CreateBlock(1, genesis.Hash, "one-to-one", []string{}, "payload", "", "",
+		&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+	full.AddBlock(wanted)
+	peer := startTestNode(t, full)
+	defer peer.Stop()
+
+	light := NewNode(freeAddress(t), []string{}, blockchain.NewBlockchain(), "")
+	b, err := light.FetchBlock(peer.Address, wanted.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Hash != wanted.Hash || b.Index != 1 || b.TextData != "payload" {
+		t.Errorf("expected block %s, got %+v", wanted.Hash, b)
+	}
+
+	if _, err := light.FetchBlock(peer.Address, "unknown"); err == nil {
+		t.Error("expected an error for a block the peer does not have")
+	}
+}
+
+func TestOversizedMessageDropsConnection(t *testing.T) {
+	listener := NewNode(freeAddress(t), []string{}, blockchain.NewBlockchain(), "")
+	listener.MaxMessageSize = 1 << 10
+	go listener.Start()
+	defer listener.Stop()
+	dialer := NewNode(freeAddress(t), []string{}, blockchain.NewBlockchain(), "")
+	var conn net.Conn
+	var err error
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if conn, _, _, err = dialer.dialPeer(listener.Address); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Announce a 1 GiB frame; the listener must hang up instead of
+	// allocating it or waiting for the payload.
+	if _, err := conn.Write([]byte{0x40, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the listener to close the connection, got %v", err)
+	}
+
+	// The listener keeps serving other peers.
+	if _, err := dialer.FetchChain(listener.Address); err != nil {
+		t.Errorf("listener stopped serving after an oversized message: %v", err)
+	}
+
+	n := NewNode(freeAddress(t), []string{}, blockchain.NewBlockchain(), "")
+	n.MaxChainBlocks = 2
+	long, _ := json.Marshal([]*blockchain.Block{{}, {}, {}})
+	if err := n.handleChainUpdate(long); err == nil || !strings.Contains(err.Error(), "block limit") {
+		t.Errorf("expected a chain over the block limit to be refused, got %v", err)
+	}
+}
+
+func TestChainSyncIsPaged(t *testing.T) {
+	bc := blockchain.NewBlockchain()
+	prevHash := ""
+	for i := 0; i < 5; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, strings.Repeat("x", 512), "", "",
+			&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		bc.AddBlock(b)
+		prevHash = b.Hash
+	}
+	encoded, err := json.Marshal(bc.Blocks[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The whole chain does not fit in one message, two blocks do.
+	peer := NewNode(freeAddress(t), []string{}, bc, "")
+	peer.MaxMessageSize = 2*len(encoded) + chainPageOverhead + 64
+	go peer.Start()
+	defer peer.Stop()
+
+	dialer := NewNode(freeAddress(t), []string{}, blockchain.NewBlockchain(), "")
+	var chain []*blockchain.Block
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if chain, err = dialer.FetchChain(peer.Address); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 5 || chain[4].Hash != prevHash {
+		t.Fatalf("expected all 5 blocks, got %d", len(chain))
+	}
+
+	dialer.MaxChainBlocks = 3
+	if _, err := dialer.FetchChain(peer.Address); err == nil || !strings.Contains(err.Error(), "block limit") {
+		t.Errorf("expected a chain over the block limit to be refused, got %v", err)
+	}
+}
+
+func TestChainUpdateSendsEvictedBlocks(t *testing.T) {
+	db, err := blockchain.OpenDBPath(filepath.Join(t.TempDir(), "chain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	bc := blockchain.NewBlockchain()
+	bc.Store = db
+	bc.CacheWindow = 2
+	prevHash := ""
+	for i := 0; i < 5; i++ {
+		b := blockchain.CreateBlock(i, prevHash, "one-to-one", []string{}, "", "", "",
+			&blockchain.TransactionPool{}, 1, "Miner1", 12.5)
+		bc.AddBlock(b)
+		prevHash = b.Hash
+	}
+	sender := startTestNode(t, bc)
+	defer sender.Stop()
+
+	peerChain := blockchain.NewBlockchain()
+	genesis, err := bc.GetBlockByIndex(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerChain.AddBlock(genesis)
+	peer := startTestNode(t, peerChain)
+	defer peer.Stop()
+
+	sender.Peers.Add(peer.Address)
+	sender.BroadcastChainUpdate()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if tip := peerChain.Tip(); tip.Hash == prevHash {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("peer did not take the whole chain, its tip is at %d", peerChain.Tip().Index)
+		}
+	}
+}
+
+func TestInboundAddressIsVerified(t *testing.T) {
+	n := startTestNode(t, blockchain.NewBlockchain())
+	liar := NewNode(freeAddress(t), []string{}, blockchain.NewBlockchain(), "")
+	liar.Address = freeAddress(t) // Advertised, but nothing listens there.
+	conn, _, _, err := liar.dialPeer(n.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// An impostor advertising a real node's address is refused too.
+	honest := startTestNode(t, blockchain.NewBlockchain())
+	impostor := NewNode(honest.Address, []string{}, blockchain.NewBlockchain(), "")
+	conn, _, _, err = impostor.dialPeer(n.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	if n.Peers.Has(liar.Address) || n.Peers.Has(honest.Address) {
+		t.Errorf("expected unverified addresses to be ignored, got peers %v", n.Peers.All())
+	}
+	if n.listensAt(honest.Address, impostor.ID) {
+		t.Error("expected the impostor's ID not to be recorded at the honest address")
+	}
+}
@@ -0,0 +1,207 @@
+// File: pkg/p2p/peerstore.go
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultMaxPeers is the peer store capacity used by NewNode.
+const DefaultMaxPeers = 1000
+
+// peerSaveDelay is how long changes to a PeerStore are collected before
+// they are written, so a burst of gossiped peers costs one write.
+const peerSaveDelay = 5 * time.Second
+
+// PeerStore is a bounded, deduplicated set of peer addresses. When full, the
+// least recently added or refreshed peer that is not pinned is evicted. If
+// a path is set the store is written there as JSON peerSaveDelay after a
+// change, or on Flush.
+type PeerStore struct {
+	mu     sync.Mutex
+	self   string              // Our own address; never stored.
+	path   string              // JSON file backing the store; empty disables persistence.
+	max    int                 // Capacity; 0 means unlimited.
+	peers  []string            // Oldest first.
+	pinned map[string]struct{} // Peers never evicted, such as seeds.
+	dirty  bool                // Set when peers has changes not yet written.
+	save   *time.Timer         // Pending write of a dirty store.
+}
+
+// NewPeerStore returns a store excluding self, holding at most max peers,
+// loaded from path if that file exists.
+func NewPeerStore(self, path string, max int) (*PeerStore, error) {
+	ps := &PeerStore{self: self, path: path, max: max}
+	if path == "" {
+		return ps, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return ps, err
+	}
+	var saved []string
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return ps, fmt.Errorf("failed to parse peer file %s: %v", path, err)
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, addr := range saved {
+		ps.addLocked(addr)
+	}
+	return ps, nil
+}
+
+// Add stores addr and reports whether it was new. Adding a known peer
+// marks it as recently seen so it is evicted last.
+func (ps *PeerStore) Add(addr string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	added := ps.addLocked(addr)
+	ps.changedLocked()
+	return added
+}
+
+// Pin stores addr as Add does and keeps it from ever being evicted, as
+// for seed peers. It can still be removed with Remove.
+func (ps *PeerStore) Pin(addr string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if addr == "" || addr == ps.self {
+		return false
+	}
+	if ps.pinned == nil {
+		ps.pinned = make(map[string]struct{})
+	}
+	ps.pinned[addr] = struct{}{}
+	added := ps.addLocked(addr)
+	ps.changedLocked()
+	return added
+}
+
+// addLocked adds addr without persisting. When the store is full the
+// oldest unpinned peer makes room; if every peer is pinned, addr is not
+// stored. The caller must hold ps.mu.
+func (ps *PeerStore) addLocked(addr string) bool {
+	if addr == "" || addr == ps.self {
+		return false
+	}
+	known := ps.removeLocked(addr)
+	if ps.max > 0 && len(ps.peers) >= ps.max {
+		i := slices.IndexFunc(ps.peers, func(p string) bool {
+			_, pin := ps.pinned[p]
+			return !pin
+		})
+		if i < 0 {
+			return false
+		}
+		ps.peers = slices.Delete(ps.peers, i, i+1)
+	}
+	ps.peers = append(ps.peers, addr)
+	return !known
+}
+
+// Remove deletes addr and reports whether it was present.
+func (ps *PeerStore) Remove(addr string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	removed := ps.removeLocked(addr)
+	if removed {
+		delete(ps.pinned, addr)
+		ps.changedLocked()
+	}
+	return removed
+}
+
+// removeLocked deletes addr without persisting. The caller must hold ps.mu.
+func (ps *PeerStore) removeLocked(addr string) bool {
+	for i, p := range ps.peers {
+		if p == addr {
+			ps.peers = append(ps.peers[:i:i], ps.peers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// All returns a copy of the stored peers, oldest first.
+func (ps *PeerStore) All() []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return append([]string{}, ps.peers...)
+}
+
+// Has reports whether addr is stored.
+func (ps *PeerStore) Has(addr string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, p := range ps.peers {
+		if p == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of stored peers.
+func (ps *PeerStore) Len() int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return len(ps.peers)
+}
+
+// Flush writes pending changes to the store's file now rather than after
+// peerSaveDelay. Call it before exiting so no change is lost.
+func (ps *PeerStore) Flush() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.save != nil {
+		ps.save.Stop()
+		ps.save = nil
+	}
+	if !ps.dirty {
+		return nil
+	}
+	if err := ps.saveLocked(); err != nil {
+		return err
+	}
+	ps.dirty = false
+	return nil
+}
+
+// changedLocked schedules a write of the store, unless one is already
+// pending. The caller must hold ps.mu.
+func (ps *PeerStore) changedLocked() {
+	if ps.path == "" {
+		return
+	}
+	ps.dirty = true
+	if ps.save == nil {
+		ps.save = time.AfterFunc(peerSaveDelay, func() {
+			if err := ps.Flush(); err != nil {
+				fmt.Println("Error saving peer list:", err)
+			}
+		})
+	}
+}
+
+// saveLocked writes the store to its file, replacing it atomically.
+// The caller must hold ps.mu.
+func (ps *PeerStore) saveLocked() error {
+	data, err := json.MarshalIndent(ps.peers, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := ps.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ps.path)
+}
@@ -0,0 +1,94 @@
+package p2p
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPeerStoreCapEvictsOldest(t *testing.T) {
+	ps, err := NewPeerStore("self:1", "", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"a:1", "b:1", "c:1"} {
+		ps.Add(addr)
+	}
+	// Refreshing a:1 makes b:1 the oldest.
+	if ps.Add("a:1") {
+		t.Error("re-adding a known peer reported it as new")
+	}
+	ps.Add("d:1")
+	if got, want := ps.All(), []string{"c:1", "a:1", "d:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after eviction, got %v", want, got)
+	}
+}
+
+func TestPeerStoreExcludesSelfAndPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	ps, err := NewPeerStore("self:1", path, DefaultMaxPeers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Add("self:1") || ps.Add("") {
+		t.Error("self or empty address was stored")
+	}
+	ps.Add("a:1")
+	ps.Add("b:1")
+	ps.Remove("a:1")
+	if err := ps.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewNode("self:1", []string{"c:1", "self:1"}, nil, path)
+	if got, want := reloaded.Peers.All(), []string{"b:1", "c:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after restart, got %v", want, got)
+	}
+}
+
+func TestPeerStoreBatchesWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	ps, err := NewPeerStore("self:1", path, DefaultMaxPeers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"a:1", "b:1", "c:1"} {
+		ps.Add(addr)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no write before the save delay, got %v", err)
+	}
+	if err := ps.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewPeerStore("self:1", path, DefaultMaxPeers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reloaded.All(), []string{"a:1", "b:1", "c:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after a flush, got %v", want, got)
+	}
+}
+
+func TestPinnedPeersAreNotEvicted(t *testing.T) {
+	ps, err := NewPeerStore("self:1", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.Pin("seed:1")
+	for _, addr := range []string{"a:1", "b:1", "c:1"} {
+		ps.Add(addr)
+	}
+	if got, want := ps.All(), []string{"seed:1", "c:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the seed to survive eviction, got %v", got)
+	}
+
+	ps.Pin("seed:2")
+	if ps.Add("d:1") {
+		t.Error("expected no room once every stored peer is pinned")
+	}
+	if !ps.Remove("seed:1") || !ps.Add("d:1") {
+		t.Error("expected a removed seed to free its slot")
+	}
+}
@@ -0,0 +1,119 @@
+// File: pkg/wallet/keystore.go
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+
+	"cryptocypher/pkg/blockchain"
+	"cryptocypher/pkg/encryption"
+)
+
+// ErrBadPassphrase is returned by LoadWallet when the passphrase does not
+// decrypt the wallet file.
+var ErrBadPassphrase = errors.New("wrong passphrase or corrupted wallet file")
+
+// scrypt parameters for deriving the file key from a passphrase.
+const (
+	scryptN        = 1 << 15
+	scryptR        = 8
+	scryptP        = 1
+	keystoreSalt   = 16
+	keystoreFormat = 1
+)
+
+// keystoreFile is the on-disk form of a saved wallet.
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	Address    string `json:"address"`
+	Salt       []byte `json:"salt"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Ciphertext string `json:"ciphertext"` // PKCS8 private key, encrypted by the encryption package.
+}
+
+// Save writes the wallet's private key to path, PKCS8-encoded and encrypted
+// under a key derived from passphrase with scrypt. The file is readable by
+// its owner only.
+func (w *Wallet) Save(path string, passphrase string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(w.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("encoding private key: %w", err)
+	}
+	salt := make([]byte, keystoreSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	ks := keystoreFile{Version: keystoreFormat, Address: w.Address, Salt: salt, N: scryptN, R: scryptR, P: scryptP}
+	key, err := ks.key(passphrase)
+	if err != nil {
+		return err
+	}
+	if ks.Ciphertext, err = encryption.EncryptWithKey(der, key); err != nil {
+		return fmt.Errorf("encrypting private key: %w", err)
+	}
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// LoadWallet reads a wallet written by Save. It fails with ErrBadPassphrase
+// if passphrase is not the one the wallet was saved with.
+func LoadWallet(path string, passphrase string) (*Wallet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ks keystoreFile
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, fmt.Errorf("parsing wallet file: %w", err)
+	}
+	if ks.Version != keystoreFormat {
+		return nil, fmt.Errorf("unsupported wallet file version %d", ks.Version)
+	}
+	key, err := ks.key(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	der, err := encryption.DecryptWithKey(ks.Ciphertext, key)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	privKey, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || privKey.Curve != elliptic.P256() {
+		return nil, errors.New("wallet file does not hold a P-256 key")
+	}
+	w := &Wallet{
+		PrivateKey: privKey,
+		PublicKey:  &privKey.PublicKey,
+		Address:    blockchain.AddressFromPublicKey(elliptic.Marshal(privKey.Curve, privKey.X, privKey.Y)),
+	}
+	if ks.Address != "" && ks.Address != w.Address {
+		return nil, fmt.Errorf("wallet file address %s does not match its key", ks.Address)
+	}
+	return w, nil
+}
+
+// key derives the file encryption key from passphrase.
+func (ks *keystoreFile) key(passphrase string) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), ks.Salt, ks.N, ks.R, ks.P, encryption.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,46 @@
+// File: pkg/wallet/mnemonic.go
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// mnemonicEntropyBits is the entropy behind the mnemonic of a wallet made
+// by NewWallet, which gives 12 words.
+const mnemonicEntropyBits = 128
+
+// ErrNoMnemonic is returned by Mnemonic for wallets that were not created
+// from a mnemonic, such as those loaded from a key file.
+var ErrNoMnemonic = errors.New("wallet has no mnemonic")
+
+// NewWalletFromMnemonic recovers the wallet backed up as mnemonic, a BIP39
+// English phrase of 12 to 24 words. The words and their checksum are
+// validated. The key is derived from the phrase's BIP39 seed with an empty
+// passphrase, so the same phrase gives the same address on every machine;
+// it is not a BIP32 hierarchical wallet.
+func NewWalletFromMnemonic(mnemonic string) (*Wallet, error) {
+	mnemonic = strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	if _, err := bip39.EntropyFromMnemonic(mnemonic); err != nil {
+		return nil, fmt.Errorf("invalid mnemonic: %w", err)
+	}
+	w, err := NewWalletFromReader(bytes.NewReader(bip39.NewSeed(mnemonic, "")))
+	if err != nil {
+		return nil, err
+	}
+	w.mnemonic = mnemonic
+	return w, nil
+}
+
+// Mnemonic returns the phrase the wallet can be recovered from with
+// NewWalletFromMnemonic, or ErrNoMnemonic.
+func (w *Wallet) Mnemonic() (string, error) {
+	if w.mnemonic == "" {
+		return "", ErrNoMnemonic
+	}
+	return w.mnemonic, nil
+}
@@ -2,11 +2,15 @@
 package wallet
 
 import (
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/tyler-smith/go-bip39"
 
 	"cryptocypher/pkg/blockchain"
 )
@@ -15,27 +19,70 @@ import (
 type Wallet struct {
 	PrivateKey *ecdsa.PrivateKey
 	PublicKey  *ecdsa.PublicKey
-	Address    string // You can derive an address from the public key.
+	Address    string // Checksummed address derived from the public key.
+
+	mnemonic string // Phrase the key was derived from, if any.
 }
 
-// NewWallet generates a new wallet.
+// NewWallet generates a new wallet from a fresh 12-word mnemonic, which
+// Mnemonic returns for backing it up.
 func NewWallet() (*Wallet, error) {
-	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	entropy, err := bip39.NewEntropy(mnemonicEntropyBits)
+	if err != nil {
+		return nil, err
+	}
+	mnemonic, err := bip39.NewMnemonic(entropy)
 	if err != nil {
 		return nil, err
 	}
+	return NewWalletFromMnemonic(mnemonic)
+}
+
+// NewWalletFromReader derives a wallet from the bytes read from r, so the
+// same input always yields the same keypair. NewWalletFromMnemonic feeds it
+// a BIP39 seed; r must be as unpredictable as one, since anyone who can
+// reproduce its bytes holds the key. ecdsa.GenerateKey is not used because
+// it ignores custom readers.
+func NewWalletFromReader(r io.Reader) (*Wallet, error) {
+	curve := elliptic.P256()
+	// Reduce 64 extra bits modulo n-1 and add one, as in FIPS 186-4 B.4.1,
+	// so the scalar is uniform in [1, n-1].
+	seed := make([]byte, curve.Params().BitSize/8+8)
+	if _, err := io.ReadFull(r, seed); err != nil {
+		return nil, fmt.Errorf("reading key seed: %w", err)
+	}
+	nMinusOne := new(big.Int).Sub(curve.Params().N, big.NewInt(1))
+	d := new(big.Int).SetBytes(seed)
+	d.Mod(d, nMinusOne)
+	d.Add(d, big.NewInt(1))
+
+	ecdhKey, err := ecdh.P256().NewPrivateKey(d.FillBytes(make([]byte, 32)))
+	if err != nil {
+		return nil, err
+	}
+	x, y := elliptic.Unmarshal(curve, ecdhKey.PublicKey().Bytes())
+	privKey := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+		D:         d,
+	}
 	pubKey := &privKey.PublicKey
-	// For simplicity, let's use the hex encoding of the public key as the address.
-	address := hex.EncodeToString(elliptic.Marshal(elliptic.P256(), pubKey.X, pubKey.Y))
 	return &Wallet{
 		PrivateKey: privKey,
 		PublicKey:  pubKey,
-		Address:    address,
+		Address:    blockchain.AddressFromPublicKey(elliptic.Marshal(curve, pubKey.X, pubKey.Y)),
 	}, nil
 }
 
-// SignTransaction signs the given transaction using the wallet's private key.
+// PublicKeyHex returns the hex encoding of the wallet's uncompressed public
+// key, which verifiers need alongside the address.
+func (w *Wallet) PublicKeyHex() string {
+	return hex.EncodeToString(elliptic.Marshal(w.PublicKey.Curve, w.PublicKey.X, w.PublicKey.Y))
+}
+
+// SignTransaction signs the given transaction using the wallet's private key
+// and attaches the public key the signature verifies against.
 func (w *Wallet) SignTransaction(tx *blockchain.Transaction) error {
+	tx.PublicKey = w.PublicKeyHex()
 	sig, err := blockchain.SignTransaction(tx, w.PrivateKey)
 	if err != nil {
 		return err
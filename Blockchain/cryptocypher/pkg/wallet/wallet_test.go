@@ -0,0 +1,155 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cryptocypher/pkg/blockchain"
+)
+
+func TestNewWalletFromReaderIsDeterministic(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, 40)
+	first, err := NewWalletFromReader(bytes.NewReader(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewWalletFromReader(bytes.NewReader(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Address != second.Address {
+		t.Fatalf("same seed gave different addresses: %s and %s", first.Address, second.Address)
+	}
+
+	other, err := NewWalletFromReader(bytes.NewReader(bytes.Repeat([]byte{8}, 40)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Address == first.Address {
+		t.Error("different seeds gave the same address")
+	}
+
+	// The derived key must sign transactions that verify against the address.
+	tx := blockchain.NewTransaction(first.Address, "Bob", 1, 1)
+	if err := first.SignTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := blockchain.VerifySignedTransaction(tx); err != nil {
+		t.Errorf("signature from a seeded wallet does not verify: %v", err)
+	}
+
+	if _, err := NewWalletFromReader(bytes.NewReader(seed[:8])); err == nil {
+		t.Error("expected an error for a short seed")
+	}
+}
+
+func TestSignatureRequiresKeyOfSenderAddress(t *testing.T) {
+	alice, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blockchain.ValidateAddress(alice.Address); err != nil {
+		t.Fatalf("wallet address %s: %v", alice.Address, err)
+	}
+
+	// Mallory signs a transaction spending from Alice's address.
+	tx := blockchain.NewTransaction(alice.Address, "Bob", 1, 1)
+	if err := mallory.SignTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := blockchain.VerifySignedTransaction(tx); err == nil {
+		t.Error("a key that does not derive to the sender address was accepted")
+	}
+}
+
+func TestSaveAndLoadWallet(t *testing.T) {
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	if err := w.Save(path, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadWallet(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Address != w.Address || loaded.PrivateKey.D.Cmp(w.PrivateKey.D) != 0 {
+		t.Fatalf("loaded wallet %s differs from saved wallet %s", loaded.Address, w.Address)
+	}
+	tx := blockchain.NewTransaction(loaded.Address, "Bob", 1, 1)
+	if err := loaded.SignTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := blockchain.VerifySignedTransaction(tx); err != nil {
+		t.Errorf("signature from a loaded wallet does not verify: %v", err)
+	}
+}
+
+func TestLoadWalletBadPassphrase(t *testing.T) {
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	if err := w.Save(path, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadWallet(path, "battery staple"); !errors.Is(err, ErrBadPassphrase) {
+		t.Fatalf("expected ErrBadPassphrase, got %v", err)
+	}
+}
+
+func TestRecoverWalletFromMnemonic(t *testing.T) {
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnemonic, err := w.Mnemonic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if words := strings.Fields(mnemonic); len(words) != 12 {
+		t.Fatalf("mnemonic has %d words, want 12: %q", len(words), mnemonic)
+	}
+
+	recovered, err := NewWalletFromMnemonic("  " + strings.ToUpper(mnemonic) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered.Address != w.Address {
+		t.Fatalf("recovered address %s, want %s", recovered.Address, w.Address)
+	}
+
+	// A phrase of valid words must still carry the right checksum.
+	valid := strings.Repeat("abandon ", 11) + "about"
+	if _, err := NewWalletFromMnemonic(valid); err != nil {
+		t.Fatalf("valid mnemonic rejected: %v", err)
+	}
+	if _, err := NewWalletFromMnemonic(strings.Repeat("abandon ", 11) + "abandon"); err == nil {
+		t.Error("mnemonic with a bad checksum was accepted")
+	}
+	if _, err := NewWalletFromMnemonic(strings.Repeat("abandon ", 11) + "notaword"); err == nil {
+		t.Error("mnemonic with an unknown word was accepted")
+	}
+
+	if _, err := recovered.Mnemonic(); err != nil {
+		t.Errorf("recovered wallet has no mnemonic: %v", err)
+	}
+	seeded, err := NewWalletFromReader(bytes.NewReader(bytes.Repeat([]byte{7}, 40)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seeded.Mnemonic(); !errors.Is(err, ErrNoMnemonic) {
+		t.Errorf("expected ErrNoMnemonic, got %v", err)
+	}
+}